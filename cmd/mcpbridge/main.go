@@ -0,0 +1,62 @@
+package main
+
+// mcpbridge starts an MCP server via stdio and announces its tools as NatsTools
+//
+// usage: mcpbridge -name filesystem -prefix fs_ -- npx -y @modelcontextprotocol/server-filesystem /data
+//
+// NATS_SERVER_URL, NATS_MANAGER_USERNAME and NATS_MANAGER_PASSWORD are read from the environment
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/vaudience/aigency2/models"
+	nuts "github.com/vaudience/go-nuts"
+)
+
+func main() {
+	serverName := flag.String("name", "mcp", "name of the mcp server used in logs")
+	toolNamePrefix := flag.String("prefix", "", "prefix prepended to all bridged tool names")
+	version := flag.String("version", "", "tool version to announce (defaults to the mcp server version)")
+	isPublic := flag.Bool("public", false, "announce the bridged tools as public")
+	ownerOrgID := flag.String("org", "", "owner organization id of the bridged tools")
+	callTimeout := flag.Duration("timeout", models.MCP_BRIDGE_DEFAULT_CALL_TIMEOUT, "timeout for a single mcp tool call")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		nuts.L.Fatalf("[mcpbridge] missing mcp server command, usage: mcpbridge [flags] -- command [args...]")
+	}
+
+	viper.AutomaticEnv()
+	bridge := models.NewMCPBridge(models.MCPBridgeConfig{
+		ServerName:          *serverName,
+		Command:             flag.Arg(0),
+		Args:                flag.Args()[1:],
+		ToolNamePrefix:      *toolNamePrefix,
+		Version:             *version,
+		IsPublic:            *isPublic,
+		OwnerOrganizationID: *ownerOrgID,
+		CallTimeout:         *callTimeout,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	err := bridge.Start(ctx)
+	cancel()
+	if err != nil {
+		nuts.L.Fatalf("[mcpbridge] failed to start mcp server(%s): %v", *serverName, err)
+	}
+	err = bridge.ConnectToNATS(viper.GetString("NATS_SERVER_URL"), viper.GetString("NATS_MANAGER_USERNAME"), viper.GetString("NATS_MANAGER_PASSWORD"))
+	if err != nil {
+		bridge.Close()
+		nuts.L.Fatalf("[mcpbridge] failed to connect to nats: %v", err)
+	}
+	nuts.L.Infof("[mcpbridge] bridging (%d) tools of mcp server(%s)", len(bridge.GetTools()), *serverName)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+	bridge.Close()
+}
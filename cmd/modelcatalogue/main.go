@@ -0,0 +1,129 @@
+package main
+
+// modelcatalogue lints, exports and diffs model catalogues (services, models, features, cost templates, constraints and parameter definitions)
+//
+// usage:
+//
+//	modelcatalogue lint catalogue.yaml
+//	modelcatalogue export [-format yaml|json] [-out catalogue.yaml] dump.json
+//	modelcatalogue diff current.yaml desired.yaml
+//
+// export accepts a catalogue or the services with their models as listed by the api (a json array of AIModelServiceWithModels)
+// and writes the sorted catalogue without the fields maintained by the system, ready for review in git.
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/vaudience/aigency2/models"
+	nuts "github.com/vaudience/go-nuts"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	command, args := os.Args[1], os.Args[2:]
+	switch command {
+	case "lint":
+		lint(args)
+	case "export":
+		export(args)
+	case "diff":
+		diff(args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: modelcatalogue lint <catalogue> | export [-format yaml|json] [-out file] <input> | diff <current> <desired>")
+	os.Exit(2)
+}
+
+func lint(args []string) {
+	if len(args) != 1 {
+		usage()
+	}
+	catalogue, err := models.LoadModelCatalogueFile(args[0])
+	if err != nil {
+		nuts.L.Fatalf("[modelcatalogue.lint] failed to load catalogue(%s): %v", args[0], err)
+	}
+	issues := catalogue.Validate()
+	for _, issue := range issues {
+		fmt.Println(issue.String())
+	}
+	if len(issues) > 0 {
+		fmt.Fprintf(os.Stderr, "%s: (%d) issues\n", args[0], len(issues))
+		os.Exit(1)
+	}
+	fmt.Printf("%s: (%d) services and (%d) models are valid\n", args[0], len(catalogue.Services), len(catalogue.Models))
+}
+
+func export(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", string(models.ModelCatalogueFormatYAML), "output format, yaml or json")
+	out := flags.String("out", "", "output file, stdout if empty")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		usage()
+	}
+	catalogue, err := loadExportInput(flags.Arg(0))
+	if err != nil {
+		nuts.L.Fatalf("[modelcatalogue.export] failed to load (%s): %v", flags.Arg(0), err)
+	}
+	data, err := catalogue.Marshal(models.ModelCatalogueFormat(*format))
+	if err != nil {
+		nuts.L.Fatalf("[modelcatalogue.export] failed to marshal catalogue: %v", err)
+	}
+	if *out == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	err = os.WriteFile(*out, data, 0644)
+	if err != nil {
+		nuts.L.Fatalf("[modelcatalogue.export] failed to write (%s): %v", *out, err)
+	}
+}
+
+// loadExportInput reads a catalogue, falling back to a json list of services with their models
+func loadExportInput(path string) (catalogue *models.ModelCatalogue, err error) {
+	catalogue, err = models.LoadModelCatalogueFile(path)
+	if err == nil {
+		return catalogue, nil
+	}
+	data, readErr := os.ReadFile(path)
+	if readErr != nil {
+		return nil, readErr
+	}
+	servicesWithModels := make([]models.AIModelServiceWithModels, 0)
+	if json.Unmarshal(data, &servicesWithModels) != nil {
+		return nil, err
+	}
+	return models.NewModelCatalogueFromServicesWithModels(servicesWithModels), nil
+}
+
+func diff(args []string) {
+	if len(args) != 2 {
+		usage()
+	}
+	current, err := models.LoadModelCatalogueFile(args[0])
+	if err != nil {
+		nuts.L.Fatalf("[modelcatalogue.diff] failed to load catalogue(%s): %v", args[0], err)
+	}
+	desired, err := models.LoadModelCatalogueFile(args[1])
+	if err != nil {
+		nuts.L.Fatalf("[modelcatalogue.diff] failed to load catalogue(%s): %v", args[1], err)
+	}
+	catalogueDiff, err := models.DiffModelCatalogues(current, desired)
+	if err != nil {
+		nuts.L.Fatalf("[modelcatalogue.diff] failed to diff catalogues: %v", err)
+	}
+	if catalogueDiff.IsEmpty() {
+		fmt.Println("no changes")
+		return
+	}
+	fmt.Print(catalogueDiff.String())
+}
@@ -0,0 +1,347 @@
+package models
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	nuts "github.com/vaudience/go-nuts"
+)
+
+// minimal client for the Model Context Protocol (https://modelcontextprotocol.io)
+// talking newline delimited JSON-RPC 2.0 to an MCP server process via stdio
+
+const (
+	MCP_PROTOCOL_VERSION = "2024-11-05"
+	MCP_JSONRPC_VERSION  = "2.0"
+	MCP_CLIENT_NAME      = "aigency2-mcp-bridge"
+	MCP_CLIENT_VERSION   = "0.1.0"
+)
+
+var (
+	ErrMCPClientNotStarted = errors.New("mcp client not started")
+	ErrMCPClientClosed     = errors.New("mcp client closed")
+)
+
+type MCPRPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (rpcErr *MCPRPCError) Error() string {
+	return fmt.Sprintf("mcp error(%d): %s", rpcErr.Code, rpcErr.Message)
+}
+
+type mcpRPCMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"` // a number or a string, see mcpResponseID
+	Method  string          `json:"method,omitempty"`
+	Params  any             `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *MCPRPCError    `json:"error,omitempty"`
+}
+
+type MCPServerInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type MCPInitializeResult struct {
+	ProtocolVersion string         `json:"protocolVersion"`
+	Capabilities    map[string]any `json:"capabilities"`
+	ServerInfo      MCPServerInfo  `json:"serverInfo"`
+	Instructions    string         `json:"instructions"`
+}
+
+type MCPToolDefinition struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+type mcpListToolsResult struct {
+	Tools      []MCPToolDefinition `json:"tools"`
+	NextCursor string              `json:"nextCursor"`
+}
+
+type MCPResourceContent struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Blob     string `json:"blob"`
+}
+
+type MCPContent struct {
+	Type     string              `json:"type"` // text, image, audio, resource
+	Text     string              `json:"text"`
+	Data     string              `json:"data"` // base64 for image and audio
+	MimeType string              `json:"mimeType"`
+	Resource *MCPResourceContent `json:"resource"`
+}
+
+type MCPCallToolResult struct {
+	Content []MCPContent `json:"content"`
+	IsError bool         `json:"isError"`
+}
+
+type MCPStdioClient struct {
+	Command    string
+	Args       []string
+	Env        []string
+	ServerInfo MCPServerInfo
+	cmd        *exec.Cmd
+	stdin      io.WriteCloser
+	writeLock  sync.Mutex
+	safety     sync.Mutex
+	pending    map[int64]chan *mcpRPCMessage
+	nextID     atomic.Int64
+	closed     chan struct{}
+	closeOnce  sync.Once
+	readDone   chan struct{} // closed when the readLoop stopped reading stdout
+}
+
+func NewMCPStdioClient(command string, args []string, env []string) *MCPStdioClient {
+	return &MCPStdioClient{
+		Command:  command,
+		Args:     args,
+		Env:      env,
+		pending:  make(map[int64]chan *mcpRPCMessage),
+		closed:   make(chan struct{}),
+		readDone: make(chan struct{}),
+	}
+}
+
+// Start launches the MCP server process and runs the initialize handshake
+func (client *MCPStdioClient) Start(ctx context.Context) (err error) {
+	var logName string = "[MCPStdioClient.Start] "
+	client.cmd = exec.Command(client.Command, client.Args...)
+	client.cmd.Env = append(os.Environ(), client.Env...)
+	client.cmd.Stderr = os.Stderr
+	client.stdin, err = client.cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := client.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = client.cmd.Start()
+	if err != nil {
+		return err
+	}
+	go client.readLoop(stdout)
+
+	var initResult MCPInitializeResult
+	err = client.call(ctx, "initialize", map[string]any{
+		"protocolVersion": MCP_PROTOCOL_VERSION,
+		"capabilities":    map[string]any{},
+		"clientInfo": map[string]any{
+			"name":    MCP_CLIENT_NAME,
+			"version": MCP_CLIENT_VERSION,
+		},
+	}, &initResult)
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("mcp initialize failed: %w", err)
+	}
+	client.ServerInfo = initResult.ServerInfo
+	err = client.notify("notifications/initialized", nil)
+	if err != nil {
+		client.Close()
+		return err
+	}
+	nuts.L.Debugf("%sconnected to mcp server(%s@%s) protocol(%s)", logName, initResult.ServerInfo.Name, initResult.ServerInfo.Version, initResult.ProtocolVersion)
+	return nil
+}
+
+func (client *MCPStdioClient) ListTools(ctx context.Context) (tools []MCPToolDefinition, err error) {
+	tools = make([]MCPToolDefinition, 0)
+	cursor := ""
+	for {
+		params := map[string]any{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		var page mcpListToolsResult
+		err = client.call(ctx, "tools/list", params, &page)
+		if err != nil {
+			return tools, err
+		}
+		tools = append(tools, page.Tools...)
+		if page.NextCursor == "" {
+			return tools, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func (client *MCPStdioClient) CallTool(ctx context.Context, name string, arguments map[string]any) (result *MCPCallToolResult, err error) {
+	result = &MCPCallToolResult{}
+	err = client.call(ctx, "tools/call", map[string]any{
+		"name":      name,
+		"arguments": arguments,
+	}, result)
+	return result, err
+}
+
+func (client *MCPStdioClient) Close() {
+	client.closeOnce.Do(func() {
+		close(client.closed)
+		if client.stdin != nil {
+			client.stdin.Close()
+		}
+		if client.cmd != nil && client.cmd.Process != nil {
+			client.cmd.Process.Kill()
+			// Wait closes stdout, it must not be called before the readLoop is done with it
+			<-client.readDone
+			client.cmd.Wait()
+		}
+	})
+}
+
+func (client *MCPStdioClient) call(ctx context.Context, method string, params any, result any) error {
+	if client.stdin == nil {
+		return ErrMCPClientNotStarted
+	}
+	id := client.nextID.Add(1)
+	responseChannel := make(chan *mcpRPCMessage, 1)
+	client.safety.Lock()
+	client.pending[id] = responseChannel
+	client.safety.Unlock()
+	defer func() {
+		client.safety.Lock()
+		delete(client.pending, id)
+		client.safety.Unlock()
+	}()
+
+	err := client.write(mcpRPCMessage{JSONRPC: MCP_JSONRPC_VERSION, ID: json.RawMessage(strconv.FormatInt(id, 10)), Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-client.closed:
+		// the readLoop delivers the last responses before it closes the client
+		select {
+		case response := <-responseChannel:
+			return decodeMCPResponse(response, result)
+		default:
+			return ErrMCPClientClosed
+		}
+	case response := <-responseChannel:
+		return decodeMCPResponse(response, result)
+	}
+}
+
+func decodeMCPResponse(response *mcpRPCMessage, result any) error {
+	if response.Error != nil {
+		return response.Error
+	}
+	if result == nil || len(response.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+// mcpResponseID returns the id of a response to one of our requests, some servers send it back as a string
+func mcpResponseID(rawID json.RawMessage) (id int64, isValid bool) {
+	if json.Unmarshal(rawID, &id) == nil {
+		return id, true
+	}
+	text := ""
+	if json.Unmarshal(rawID, &text) != nil {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(text, 10, 64)
+	return id, err == nil
+}
+
+// hasMCPID tells if the message has an id, requests without one are notifications
+func hasMCPID(rawID json.RawMessage) bool {
+	return len(rawID) > 0 && string(rawID) != "null"
+}
+
+func (client *MCPStdioClient) notify(method string, params any) error {
+	return client.write(mcpRPCMessage{JSONRPC: MCP_JSONRPC_VERSION, Method: method, Params: params})
+}
+
+func (client *MCPStdioClient) write(msg mcpRPCMessage) error {
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	client.writeLock.Lock()
+	defer client.writeLock.Unlock()
+	_, err = client.stdin.Write(append(msgBytes, '\n'))
+	return err
+}
+
+func (client *MCPStdioClient) readLoop(stdout io.Reader) {
+	var logName string = "[MCPStdioClient.readLoop] "
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var msg mcpRPCMessage
+		err := json.Unmarshal(line, &msg)
+		if err != nil {
+			nuts.L.Debugf("%signoring invalid message from mcp server(%s): %v", logName, client.Command, err)
+			continue
+		}
+		if msg.Method != "" {
+			client.handleServerMessage(&msg)
+			continue
+		}
+		id, isValid := mcpResponseID(msg.ID)
+		if !isValid {
+			nuts.L.Debugf("%signoring response with unknown id(%s) from mcp server(%s)", logName, msg.ID, client.Command)
+			continue
+		}
+		client.safety.Lock()
+		responseChannel, ok := client.pending[id]
+		client.safety.Unlock()
+		if ok {
+			// a repeated response must not block the readLoop, the channel holds the first one
+			select {
+			case responseChannel <- &msg:
+			default:
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		nuts.L.Errorf("%sreading from mcp server(%s) failed: %v", logName, client.Command, err)
+	}
+	close(client.readDone)
+	client.Close()
+}
+
+// handleServerMessage answers requests the server sends to us. we only support ping, everything else is rejected
+func (client *MCPStdioClient) handleServerMessage(msg *mcpRPCMessage) {
+	if !hasMCPID(msg.ID) {
+		// notifications (logging, list_changed, progress) are not relevant for the bridge
+		return
+	}
+	response := mcpRPCMessage{JSONRPC: MCP_JSONRPC_VERSION, ID: msg.ID}
+	if msg.Method == "ping" {
+		response.Result = json.RawMessage("{}")
+	} else {
+		response.Error = &MCPRPCError{Code: -32601, Message: "method not supported by client: " + msg.Method}
+	}
+	err := client.write(response)
+	if err != nil {
+		nuts.L.Debugf("[MCPStdioClient.handleServerMessage] failed to answer server request(%s): %v", msg.Method, err)
+	}
+}
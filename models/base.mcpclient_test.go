@@ -0,0 +1,202 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// buildMCPStub compiles testdata/mcpstub and returns the path of the binary
+func buildMCPStub(t *testing.T) string {
+	t.Helper()
+	goBinary, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not available to build the mcp stub server")
+	}
+	binary := filepath.Join(t.TempDir(), "mcpstub")
+	output, err := exec.Command(goBinary, "build", "-o", binary, "./testdata/mcpstub").CombinedOutput()
+	if err != nil {
+		t.Fatalf("building mcp stub failed: %v\n%s", err, output)
+	}
+	return binary
+}
+
+func startMCPStubClient(t *testing.T) *MCPStdioClient {
+	t.Helper()
+	client := NewMCPStdioClient(buildMCPStub(t), nil, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+func TestMCPStdioClientInitializeAndListToolsPaginated(t *testing.T) {
+	client := startMCPStubClient(t)
+	if client.ServerInfo.Name != "mcpstub" || client.ServerInfo.Version != "1.2.3" {
+		t.Fatalf("ServerInfo = %+v", client.ServerInfo)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tools, err := client.ListTools(ctx)
+	if err != nil {
+		t.Fatalf("ListTools() error = %v", err)
+	}
+	names := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		names = append(names, tool.Name)
+	}
+	if want := []string{"echo", "fail", "image"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("ListTools() names = %v, want %v (both pages)", names, want)
+	}
+}
+
+func TestMCPStdioClientCallTool(t *testing.T) {
+	client := startMCPStubClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		name        string
+		tool        string
+		arguments   map[string]any
+		wantText    string
+		wantIsError bool
+		wantRPCCode int
+	}{
+		{name: "text result", tool: "echo", arguments: map[string]any{"text": "hello"}, wantText: "hello"},
+		{name: "tool error", tool: "fail", arguments: map[string]any{"reason": "broken"}, wantText: "broken", wantIsError: true},
+		{name: "unknown tool", tool: "missing", arguments: map[string]any{}, wantRPCCode: -32602},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := client.CallTool(ctx, tt.tool, tt.arguments)
+			if tt.wantRPCCode != 0 {
+				var rpcErr *MCPRPCError
+				if !errors.As(err, &rpcErr) || rpcErr.Code != tt.wantRPCCode {
+					t.Fatalf("CallTool() error = %v, want rpc error code %d", err, tt.wantRPCCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CallTool() error = %v", err)
+			}
+			if result.IsError != tt.wantIsError {
+				t.Errorf("IsError = %v, want %v", result.IsError, tt.wantIsError)
+			}
+			if len(result.Content) != 1 || result.Content[0].Type != "text" || result.Content[0].Text != tt.wantText {
+				t.Errorf("Content = %+v, want one text content %q", result.Content, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestMCPStdioClientNotStartedAndClosed(t *testing.T) {
+	ctx := context.Background()
+	if _, err := NewMCPStdioClient("unused", nil, nil).CallTool(ctx, "echo", nil); !errors.Is(err, ErrMCPClientNotStarted) {
+		t.Fatalf("CallTool() before Start error = %v, want %v", err, ErrMCPClientNotStarted)
+	}
+	client := startMCPStubClient(t)
+	client.Close()
+	if _, err := client.CallTool(ctx, "echo", map[string]any{"text": "x"}); err == nil {
+		t.Fatal("CallTool() after Close succeeded, want error")
+	}
+}
+
+func TestMCPStdioClientAcceptsStringIDs(t *testing.T) {
+	client := startMCPStubClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := client.CallTool(ctx, "string_id", nil)
+	if err != nil || len(result.Content) != 1 || result.Content[0].Text != "string_id" {
+		t.Fatalf("CallTool() answered with a string id = %+v, %v, want the result", result, err)
+	}
+	result, err = client.CallTool(ctx, "ping", nil)
+	if err != nil {
+		t.Fatalf("CallTool() error = %v", err)
+	}
+	if want := `{"jsonrpc":"2.0","id":"server-ping-1","result":{}}`; len(result.Content) != 1 || result.Content[0].Text != want {
+		t.Fatalf("answer to the server ping = %+v, want %s", result.Content, want)
+	}
+}
+
+func TestMCPStdioClientKeepsTheResponseBeforeTheServerExits(t *testing.T) {
+	binary := buildMCPStub(t)
+	for n := 0; n < 10; n++ {
+		client := NewMCPStdioClient(binary, nil, nil)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := client.Start(ctx); err != nil {
+			cancel()
+			t.Fatalf("Start() error = %v", err)
+		}
+		result, err := client.CallTool(ctx, "exit", nil)
+		cancel()
+		if err != nil || len(result.Content) != 1 || result.Content[0].Text != "exit" {
+			t.Fatalf("CallTool() of a server exiting after its answer = %+v, %v, want the answer", result, err)
+		}
+		done := make(chan struct{})
+		go func() {
+			client.Close()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Close() did not return")
+		}
+	}
+}
+
+func TestConvertJSONSchemaToNatsToolParameters(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema map[string]any
+		want   []NatsToolParameter
+	}{
+		{
+			name:   "no properties",
+			schema: map[string]any{"type": "object"},
+			want:   []NatsToolParameter{},
+		},
+		{
+			name: "types, required, enum and default in name order",
+			schema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"query": map[string]any{"type": "string", "description": "search query"},
+					"limit": map[string]any{"type": "integer", "default": 10.0},
+					"exact": map[string]any{"type": "boolean"},
+					"tags":  map[string]any{"type": "array"},
+					"opts":  map[string]any{"type": "object"},
+					"score": map[string]any{"type": []any{"null", "number"}},
+					"sort":  map[string]any{"enum": []any{"asc", "desc", 1.0}},
+				},
+				"required": []any{"query", "sort"},
+			},
+			want: []NatsToolParameter{
+				{Name: "exact", Aliases: []string{}, VarType: NatsToolParameterTypeBoolean, Enum: []string{}},
+				{Name: "limit", Aliases: []string{}, VarType: NatsToolParameterTypeNumber, Enum: []string{}, DefaultValue: 10.0},
+				{Name: "opts", Aliases: []string{}, VarType: NatsToolParameterTypeObject, Enum: []string{}},
+				{Name: "query", Aliases: []string{}, Description: "search query", VarType: NatsToolParameterTypeString, Required: true, Enum: []string{}},
+				{Name: "score", Aliases: []string{}, VarType: NatsToolParameterTypeNumber, Enum: []string{}},
+				{Name: "sort", Aliases: []string{}, VarType: NatsToolParameterTypeString, Required: true, Enum: []string{"asc", "desc", "1"}},
+				{Name: "tags", Aliases: []string{}, VarType: NatsToolParameterTypeArray, Enum: []string{}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ConvertJSONSchemaToNatsToolParameters(tt.schema)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ConvertJSONSchemaToNatsToolParameters()\n got  %+v\n want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -20,17 +20,20 @@ const (
 )
 
 type AgentWriteDto struct {
-	Name                *string          `json:"name" validate:"omitempty,min=1,max=255" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
-	Description         *string          `json:"description" validate:"omitempty,max=1024" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
-	ModelID             *string          `json:"model_id" validate:"omitempty,min=1,max=64" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
-	AvatarURL           *string          `json:"avatar_url" validate:"omitempty,url" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
-	SystemMessages      *[]string        `json:"system_messages" validate:"omitempty,unique,dive,min=1,max=1024" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
-	InitialUserMessages *[]string        `json:"initial_user_messages" validate:"omitempty,unique,dive,min=1,max=1024" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
-	AttachedFileIDs     *[]string        `json:"attached_file_ids" validate:"omitempty,unique,dive,min=1,max=64" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
-	AssignedTools       *[]string        `json:"assigned_tools" validate:"omitempty,unique,dive,min=1,max=64" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
-	IsPublic            *bool            `json:"is_public" writexs:"system,admin,owner" readxs:"admin,owner"`
-	ModelHostLocation   *HostingLocation `json:"model_host_location" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
-	MetaData            *map[string]any  `json:"meta_data" writexs:"system,admin,owner" readxs:"system,admin,owner"`
+	Name                 *string                `json:"name" validate:"omitempty,min=1,max=255" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
+	Description          *string                `json:"description" validate:"omitempty,max=1024" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
+	ModelID              *string                `json:"model_id" validate:"omitempty,min=1,max=64" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
+	AvatarURL            *string                `json:"avatar_url" validate:"omitempty,url" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
+	SystemMessages       *[]string              `json:"system_messages" validate:"omitempty,unique,dive,min=1,max=1024" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
+	InitialUserMessages  *[]string              `json:"initial_user_messages" validate:"omitempty,unique,dive,min=1,max=1024" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
+	AttachedFileIDs      *[]string              `json:"attached_file_ids" validate:"omitempty,unique,dive,min=1,max=64" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
+	AssignedTools        *[]string              `json:"assigned_tools" validate:"omitempty,unique,dive,min=1,max=64" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
+	IsPublic             *bool                  `json:"is_public" writexs:"system,admin,owner" readxs:"admin,owner"`
+	ModelHostLocation    *HostingLocation       `json:"model_host_location" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
+	MetaData             *map[string]any        `json:"meta_data" writexs:"system,admin,owner" readxs:"system,admin,owner"`
+	FallbackModelIDs     *[]string              `json:"fallback_model_ids" validate:"omitempty,unique,dive,min=1,max=64" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
+	FailoverErrorClasses *[]ExecutionErrorClass `json:"failover_error_classes" validate:"omitempty,unique,dive,oneof=unavailable timeout rate-limited server-error authentication invalid-request content-filter context-length unknown" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
+	CompletionParameters *map[string]any        `json:"completion_parameters" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
 } //@name AgentWriteDto
 
 type Agent struct {
@@ -44,23 +47,29 @@ type Agent struct {
 	AttachedFileIDs     []string        `json:"attached_file_ids" validate:"unique,dive,min=1,max=64" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
 	AssignedTools       []string        `json:"assigned_tools" validate:"unique,dive,min=1,max=64" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
 	ModelHostLocation   HostingLocation `json:"model_host_location" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
-	IsPublic            bool            `json:"is_public" writexs:"system,admin,owner" readxs:"admin,owner"`
-	MetaData            map[string]any  `json:"meta_data" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
-	OwnerId             string          `json:"owner_id" validate:"required,min=1,max=64" writexs:"system,admin" readxs:"system,admin,owner,org"`
-	OwnerOrganizationId string          `json:"owner_organization_id" validate:"required,min=1,max=64" writexs:"system,admin" readxs:"system,admin,owner,org"`
-	CreatedAt           int64           `json:"created_at" writexs:"system,admin" readxs:"system,admin,owner,org"`
-	UpdatedBy           string          `json:"updated_by" validate:"omitempty,min=1,max=64" writexs:"system,admin" readxs:"system,admin,owner,org"`
-	UpdatedAt           int64           `json:"updated_at" writexs:"system,admin" readxs:"system,admin,owner,org"`
+	// tried in order when the model fails with one of the FailoverErrorClasses (DEFAULT_FAILOVER_ERROR_CLASSES if empty), see AIModelFailoverExecutor
+	FallbackModelIDs     []string              `json:"fallback_model_ids" validate:"omitempty,unique,dive,min=1,max=64" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
+	FailoverErrorClasses []ExecutionErrorClass `json:"failover_error_classes" validate:"omitempty,unique,dive,oneof=unavailable timeout rate-limited server-error authentication invalid-request content-filter context-length unknown" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
+	// overrides of the model Parameters, overridden by the message, see ResolveCompletionParameters
+	CompletionParameters map[string]any `json:"completion_parameters" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
+	IsPublic             bool           `json:"is_public" writexs:"system,admin,owner" readxs:"admin,owner"`
+	MetaData             map[string]any `json:"meta_data" writexs:"system,admin,owner" readxs:"system,admin,owner,org"`
+	OwnerId              string         `json:"owner_id" validate:"required,min=1,max=64" writexs:"system,admin" readxs:"system,admin,owner,org"`
+	OwnerOrganizationId  string         `json:"owner_organization_id" validate:"required,min=1,max=64" writexs:"system,admin" readxs:"system,admin,owner,org"`
+	CreatedAt            int64          `json:"created_at" writexs:"system,admin" readxs:"system,admin,owner,org"`
+	UpdatedBy            string         `json:"updated_by" validate:"omitempty,min=1,max=64" writexs:"system,admin" readxs:"system,admin,owner,org"`
+	UpdatedAt            int64          `json:"updated_at" writexs:"system,admin" readxs:"system,admin,owner,org"`
 } //@name Agent
 
 func NewAgent() *Agent {
 	ID := CreateAgentID()
 	now := nuts.TimeToJSTimestamp(time.Now())
 	entity := Agent{
-		ID:        ID,
-		MetaData:  make(map[string]any),
-		CreatedAt: now,
-		UpdatedAt: now,
+		ID:                   ID,
+		MetaData:             make(map[string]any),
+		CompletionParameters: make(map[string]any),
+		CreatedAt:            now,
+		UpdatedAt:            now,
 	}
 	return &entity
 }
@@ -1,15 +1,12 @@
 package models
 
 import (
-	"math"
 	"regexp"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-playground/validator/v10"
-	"github.com/pkoukk/tiktoken-go"
-	"github.com/tiktoken-go/tokenizer"
 	nuts "github.com/vaudience/go-nuts"
 )
 
@@ -18,13 +15,8 @@ const (
 	IDLENGTH_AIGENCYMESSAGE = 16
 )
 
-var (
-	DefaultTextTokenEncoderModel = string(tokenizer.GPT4)
-	DefaultTextTokenEncoder      *tiktoken.Tiktoken
-)
-
 type AIgencyMessageWriteDto struct {
-	Type                   *AIgencyMessageType        `json:"type" validate:"required,oneof=message stateUpdate delta" writexs:"system,admin,owner" readxs:"admin,owner"`
+	Type                   *AIgencyMessageType        `json:"type" validate:"required,oneof=message stateUpdate delta toolResponse" writexs:"system,admin,owner" readxs:"admin,owner"`
 	ReferenceID            *string                    `json:"reference_id" validate:"omitempty,min=1,max=64" writexs:"system,admin,owner" readxs:"admin,owner"`
 	ResponseToID           *string                    `json:"response_to_id" validate:"omitempty,min=1,max=64" writexs:"system,admin,owner" readxs:"admin,owner"`
 	MissionID              *string                    `json:"mission_id" validate:"required,min=1,max=64" writexs:"system,admin,owner" readxs:"admin,owner"`
@@ -44,7 +36,7 @@ type AIgencyMessageWriteDto struct {
 
 type AIgencyMessage struct {
 	ID                     string                     `json:"id" validate:"required,min=1,max=64" writexs:"system" readxs:"admin,owner"`
-	Type                   AIgencyMessageType         `json:"type" validate:"required,oneof=message stateUpdate delta" writexs:"system,admin,owner" readxs:"admin,owner"`
+	Type                   AIgencyMessageType         `json:"type" validate:"required,oneof=message stateUpdate delta toolResponse" writexs:"system,admin,owner" readxs:"admin,owner"`
 	ReferenceID            string                     `json:"reference_id" validate:"omitempty,min=1,max=64" writexs:"system,admin,owner" readxs:"admin,owner"`
 	ResponseToID           string                     `json:"response_to_id" validate:"omitempty,min=1,max=64" writexs:"system,admin,owner" readxs:"admin,owner"`
 	MissionID              string                     `json:"mission_id" validate:"required,min=1,max=64" writexs:"system,admin,owner" readxs:"admin,owner"`
@@ -105,8 +97,9 @@ func ValidateAIgencyMessage(entity *AIgencyMessage) error {
 	return nil
 }
 
-func (msg *AIgencyMessage) CalculateTokenCount() (tokenCount int) {
-	msg.TokenCount = msg.Content.TokenCount()
+// CalculateTokenCount sets the TokenCount of the message as counted by the tokenizer of aiModel, the default tokenizer if nil
+func (msg *AIgencyMessage) CalculateTokenCount(aiModel *AIModel) (tokenCount int) {
+	msg.TokenCount = msg.Content.TokenCount(aiModel)
 	return msg.TokenCount
 }
 
@@ -210,6 +203,19 @@ func (list *AIgencyMessageContentList) GetConcatenatedText(includeAllContentType
 	return text
 }
 
+// GetConcatenatedTextContents joins the non empty text contents, unlike GetConcatenatedText without the names of files
+func (list *AIgencyMessageContentList) GetConcatenatedTextContents() (text string) {
+	texts := make([]string, 0)
+	list.Safety.Lock()
+	for _, content := range list.Data {
+		if content.ContentType == AIgencyMessageContentTypeText && content.Text != "" {
+			texts = append(texts, content.Text)
+		}
+	}
+	list.Safety.Unlock()
+	return strings.Join(texts, list.separatorString)
+}
+
 func (list *AIgencyMessageContentList) GetAIgencyFileList() (files *AIgencyMessageFileList) {
 	files = NewAIgencyMessageFileList()
 	list.Safety.Lock()
@@ -278,35 +284,18 @@ func (list *AIgencyMessageContentList) TextLength() (textLength int) {
 	return textLength
 }
 
-func (list *AIgencyMessageContentList) TokenCount() (tokenCount int) {
+func (list *AIgencyMessageContentList) TokenCount(aiModel *AIModel) (tokenCount int) {
 	allTxt := list.GetConcatenatedText(true, false)
-	tokenCount = CalculateTokenCount(allTxt)
+	tokenCount = CalculateTokenCountForModel(aiModel, allTxt)
 	return tokenCount
 }
 
+// CalculateTokenCount counts the tokens of content with the default tokenizer, with the deprecated DefaultTextTokenEncoder if it is set
 func CalculateTokenCount(content string) (tokenCount int) {
-	var err error
-	if content == "" {
-		return 0
-	}
-	if DefaultTextTokenEncoder == nil {
-		DefaultTextTokenEncoder, err = tiktoken.EncodingForModel(DefaultTextTokenEncoderModel)
-		if err != nil {
-			nuts.L.Debugf("Error getting default tokenizer: (%v)", err)
-		}
+	if encoder := DefaultTextTokenEncoder; encoder != nil && content != "" {
+		return len(encoder.Encode(content, nil, nil))
 	}
-	if DefaultTextTokenEncoder == nil {
-		wordCount, nonWordCharCount, err2 := countWordsAndNonWordChars(content)
-		if err2 != nil {
-			l := math.Round(float64(len(content)) / 4)
-			return int(l)
-		}
-		tokenCount = wordCount + nonWordCharCount
-		return tokenCount
-	}
-	ids := DefaultTextTokenEncoder.Encode(content, nil, nil)
-	tokenCount = len(ids)
-	return tokenCount
+	return CalculateTokenCountForModel(nil, content)
 }
 
 func countWordsAndNonWordChars(text string) (wordCount int, nonWordCharCount int, err error) {
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/base64"
 	"io"
 	"os"
 	"strings"
@@ -69,6 +70,36 @@ func (file *AIgencyMessageFile) ReadFileContentFromLocal() (fileContent []byte,
 	return fileContent, nil
 }
 
+// GetDataURL returns the local file content as a base64 data url, the url of the file if it is one already
+func (file *AIgencyMessageFile) GetDataURL() (dataURL string, err error) {
+	if strings.HasPrefix(file.URL, "data:") {
+		return file.URL, nil
+	}
+	fileContent, err := file.ReadFileContentFromLocal()
+	if err != nil {
+		return "", err
+	}
+	return "data:" + file.MimeType + ";base64," + base64.StdEncoding.EncodeToString(fileContent), nil
+}
+
+// IsRemote tells if the file can be fetched by a provider from its URL
+func (file *AIgencyMessageFile) IsRemote() bool {
+	return strings.HasPrefix(file.URL, "https://") || strings.HasPrefix(file.URL, "http://")
+}
+
+// ParseDataURL splits a base64 data url into its mimetype and content
+func ParseDataURL(dataURL string) (mimeType string, fileContent []byte, isDataURL bool) {
+	header, encoded, hasComma := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ",")
+	if !strings.HasPrefix(dataURL, "data:") || !hasComma || !strings.HasSuffix(header, ";base64") {
+		return "", nil, false
+	}
+	fileContent, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, false
+	}
+	return strings.TrimSuffix(header, ";base64"), fileContent, true
+}
+
 // ---------------------------------------------------------
 
 type AIgencyMessageFileList struct {
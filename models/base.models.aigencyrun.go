@@ -39,3 +39,11 @@ func CreateAIgencyRunID() string {
 func IsAIgencyRunID(id string) bool {
 	return len(id) == IDLENGTH_AGENCYRUN+len(IDPREFIX_AGENCYRUN)+1 && strings.HasPrefix(id, IDPREFIX_AGENCYRUN)
 }
+
+func (run *AIgencyRun) AddToolJobIDs(jobIDs ...string) {
+	for _, jobID := range jobIDs {
+		if !nuts.StringSliceContains(run.ToolJobIDs, jobID) {
+			run.ToolJobIDs = append(run.ToolJobIDs, jobID)
+		}
+	}
+}
@@ -0,0 +1,651 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	nuts "github.com/vaudience/go-nuts"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	MODELCATALOGUE_VERSION = 1
+
+	MODELCATALOGUE_KIND_SERVICE = "service"
+	MODELCATALOGUE_KIND_MODEL   = "model"
+)
+
+var (
+	ErrUnsupportedModelCatalogueFormat  = errors.New("unsupported model catalogue format")
+	ErrUnsupportedModelCatalogueVersion = errors.New("unsupported model catalogue version")
+	ErrInvalidModelCatalogue            = errors.New("invalid model catalogue")
+)
+
+// fields maintained by the system, they are neither exported nor diffed
+var modelCatalogueVolatileFields = []string{"created_at", "updated_at", "updated_by"}
+
+type ModelCatalogueFormat string //@name ModelCatalogueFormat
+
+const (
+	ModelCatalogueFormatYAML ModelCatalogueFormat = "yaml"
+	ModelCatalogueFormatJSON ModelCatalogueFormat = "json"
+)
+
+// ModelCatalogue is the declarative state of services and models (with their features, cost templates, constraints and parameter definitions).
+// it is kept in git and applied to the store, see ApplyModelCatalogue. the yaml format uses the json field names.
+type ModelCatalogue struct {
+	Version  int                    `json:"version"`
+	Services []AIModelServiceObject `json:"services"`
+	Models   []AIModel              `json:"models"`
+} //@name ModelCatalogue
+
+func NewModelCatalogue(services []AIModelServiceObject, models []AIModel) *ModelCatalogue {
+	catalogue := &ModelCatalogue{
+		Version:  MODELCATALOGUE_VERSION,
+		Services: services,
+		Models:   models,
+	}
+	catalogue.Sort()
+	return catalogue
+}
+
+// NewModelCatalogueFromServicesWithModels builds a catalogue from the services with their models as the api lists them
+func NewModelCatalogueFromServicesWithModels(servicesWithModels []AIModelServiceWithModels) *ModelCatalogue {
+	services := make([]AIModelServiceObject, 0, len(servicesWithModels))
+	models := make([]AIModel, 0)
+	for _, serviceWithModels := range servicesWithModels {
+		if serviceWithModels.Service != nil {
+			services = append(services, *serviceWithModels.Service)
+		}
+		for _, aiModel := range serviceWithModels.Models {
+			if aiModel != nil {
+				models = append(models, *aiModel)
+			}
+		}
+	}
+	return NewModelCatalogue(services, models)
+}
+
+// GetModelCatalogueFormat returns the format of a catalogue file by its extension
+func GetModelCatalogueFormat(path string) (format ModelCatalogueFormat, err error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return ModelCatalogueFormatYAML, nil
+	case ".json":
+		return ModelCatalogueFormatJSON, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnsupportedModelCatalogueFormat, path)
+}
+
+func LoadModelCatalogueFile(path string) (catalogue *ModelCatalogue, err error) {
+	format, err := GetModelCatalogueFormat(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseModelCatalogue(data, format)
+}
+
+// ParseModelCatalogue reads a catalogue, unknown fields are rejected so typos do not get lost silently
+func ParseModelCatalogue(data []byte, format ModelCatalogueFormat) (catalogue *ModelCatalogue, err error) {
+	switch format {
+	case ModelCatalogueFormatJSON:
+	case ModelCatalogueFormatYAML:
+		var document any
+		err = yaml.Unmarshal(data, &document)
+		if err != nil {
+			return nil, err
+		}
+		data, err = json.Marshal(document)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedModelCatalogueFormat, format)
+	}
+	catalogue = &ModelCatalogue{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(catalogue)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidModelCatalogue, err)
+	}
+	if catalogue.Version != MODELCATALOGUE_VERSION {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedModelCatalogueVersion, catalogue.Version)
+	}
+	return catalogue, nil
+}
+
+// Sort orders services by id and models by service and id, so exports are stable
+func (catalogue *ModelCatalogue) Sort() {
+	sort.SliceStable(catalogue.Services, func(i, j int) bool {
+		return catalogue.Services[i].ID < catalogue.Services[j].ID
+	})
+	sort.SliceStable(catalogue.Models, func(i, j int) bool {
+		if catalogue.Models[i].ServiceID != catalogue.Models[j].ServiceID {
+			return catalogue.Models[i].ServiceID < catalogue.Models[j].ServiceID
+		}
+		return catalogue.Models[i].ID < catalogue.Models[j].ID
+	})
+}
+
+// Marshal returns the sorted catalogue without the fields maintained by the system and without empty values, meant for review in git
+func (catalogue *ModelCatalogue) Marshal(format ModelCatalogueFormat) (data []byte, err error) {
+	sorted := NewModelCatalogue(append([]AIModelServiceObject{}, catalogue.Services...), append([]AIModel{}, catalogue.Models...))
+	document := map[string]any{"version": sorted.Version}
+	services := make([]any, 0, len(sorted.Services))
+	for _, service := range sorted.Services {
+		entry, err := toModelCatalogueEntry(service)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, pruneEmptyModelCatalogueValues(entry))
+	}
+	models := make([]any, 0, len(sorted.Models))
+	for _, aiModel := range sorted.Models {
+		entry, err := toModelCatalogueEntry(aiModel)
+		if err != nil {
+			return nil, err
+		}
+		models = append(models, pruneEmptyModelCatalogueValues(entry))
+	}
+	document["services"] = services
+	document["models"] = models
+	switch format {
+	case ModelCatalogueFormatJSON:
+		return json.MarshalIndent(document, "", "  ")
+	case ModelCatalogueFormatYAML:
+		buffer := bytes.Buffer{}
+		encoder := yaml.NewEncoder(&buffer)
+		encoder.SetIndent(2)
+		err = encoder.Encode(document)
+		if err != nil {
+			return nil, err
+		}
+		err = encoder.Close()
+		return buffer.Bytes(), err
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedModelCatalogueFormat, format)
+}
+
+// toModelCatalogueEntry converts an entity to its generic json form without the volatile fields
+func toModelCatalogueEntry(entity any) (entry map[string]any, err error) {
+	data, err := json.Marshal(entity)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &entry)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range modelCatalogueVolatileFields {
+		delete(entry, field)
+	}
+	return entry, nil
+}
+
+// pruneEmptyModelCatalogueValues drops null, "", [] and {} from maps, they are read back as the same zero values
+func pruneEmptyModelCatalogueValues(value any) any {
+	switch typed := value.(type) {
+	case map[string]any:
+		for key, element := range typed {
+			element = pruneEmptyModelCatalogueValues(element)
+			if element == "" || isEmptyModelCatalogueValue(element) {
+				delete(typed, key)
+				continue
+			}
+			typed[key] = element
+		}
+	case []any:
+		for n, element := range typed {
+			typed[n] = pruneEmptyModelCatalogueValues(element)
+		}
+	}
+	return value
+}
+
+// ModelCatalogueIssue is one problem found by Validate, Path is the json path below the entity
+type ModelCatalogueIssue struct {
+	Kind    string `json:"kind"` // service or model
+	ID      string `json:"id"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+} //@name ModelCatalogueIssue
+
+type ModelCatalogueIssues []ModelCatalogueIssue //@name ModelCatalogueIssues
+
+func (issues ModelCatalogueIssues) Error() string {
+	messages := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		messages = append(messages, issue.String())
+	}
+	return fmt.Sprintf("(%d) catalogue issues: %s", len(issues), strings.Join(messages, "; "))
+}
+
+func (issue ModelCatalogueIssue) String() string {
+	if issue.Path == "" {
+		return fmt.Sprintf("%s(%s): %s", issue.Kind, issue.ID, issue.Message)
+	}
+	return fmt.Sprintf("%s(%s) %s: %s", issue.Kind, issue.ID, issue.Path, issue.Message)
+}
+
+// Validate checks every entity with its validation rules and the references between them, empty if the catalogue can be applied
+func (catalogue *ModelCatalogue) Validate() (issues ModelCatalogueIssues) {
+	issues = make(ModelCatalogueIssues, 0)
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			return field.Name
+		}
+		return name
+	})
+	add := func(kind string, id string, path string, message string, args ...any) {
+		issues = append(issues, ModelCatalogueIssue{Kind: kind, ID: id, Path: path, Message: fmt.Sprintf(message, args...)})
+	}
+	addValidationErrors := func(kind string, id string, path string, err error) {
+		var validationErrors validator.ValidationErrors
+		if !errors.As(err, &validationErrors) {
+			add(kind, id, path, "%v", err)
+			return
+		}
+		for _, fieldError := range validationErrors {
+			fieldPath := fieldError.Namespace()
+			if _, field, isNested := strings.Cut(fieldPath, "."); isNested {
+				fieldPath = field
+			}
+			if path != "" {
+				fieldPath = path + "." + fieldPath
+			}
+			add(kind, id, fieldPath, "failed on (%s) with value (%v)", fieldError.Tag(), fieldError.Value())
+		}
+	}
+	if catalogue.Version != MODELCATALOGUE_VERSION {
+		add("catalogue", "", "version", "unsupported version (%d), expected (%d)", catalogue.Version, MODELCATALOGUE_VERSION)
+	}
+
+	serviceIDs := make(map[string]bool)
+	for _, service := range catalogue.Services {
+		if serviceIDs[service.ID] {
+			add(MODELCATALOGUE_KIND_SERVICE, service.ID, "id", "duplicate id")
+		}
+		serviceIDs[service.ID] = true
+		if err := validate.Struct(service); err != nil {
+			addValidationErrors(MODELCATALOGUE_KIND_SERVICE, service.ID, "", err)
+		}
+		if service.CostMultiplier < 0 {
+			add(MODELCATALOGUE_KIND_SERVICE, service.ID, "cost_multiplier", "must not be negative")
+		}
+		for name, location := range service.HostingLocations {
+			if !location.IsValid() {
+				add(MODELCATALOGUE_KIND_SERVICE, service.ID, "hosting_locations."+name, "unknown hosting location (%s)", location)
+			}
+			if !IsProviderEndpointURL(name) {
+				add(MODELCATALOGUE_KIND_SERVICE, service.ID, "hosting_locations."+name, "endpoint is not an http(s) base url")
+			}
+		}
+	}
+
+	modelIDs := make(map[string]bool)
+	for _, aiModel := range catalogue.Models {
+		if modelIDs[aiModel.ID] {
+			add(MODELCATALOGUE_KIND_MODEL, aiModel.ID, "id", "duplicate id")
+		}
+		modelIDs[aiModel.ID] = true
+		if err := validate.Struct(aiModel); err != nil {
+			addValidationErrors(MODELCATALOGUE_KIND_MODEL, aiModel.ID, "", err)
+		}
+		if !serviceIDs[aiModel.ServiceID] {
+			add(MODELCATALOGUE_KIND_MODEL, aiModel.ID, "service_id", "service (%s) is not in the catalogue", aiModel.ServiceID)
+		}
+		for n, location := range aiModel.ServiceHostLocations {
+			if !location.IsValid() {
+				add(MODELCATALOGUE_KIND_MODEL, aiModel.ID, fmt.Sprintf("service_host_locations[%d]", n), "unknown hosting location (%s)", location)
+			}
+		}
+		capabilities := make(map[AIModelCapability]bool)
+		for n, feature := range aiModel.Features {
+			path := fmt.Sprintf("features[%d]", n)
+			if !feature.Capability.IsValid() {
+				add(MODELCATALOGUE_KIND_MODEL, aiModel.ID, path+".capability", "unknown capability (%s)", feature.Capability)
+			}
+			if capabilities[feature.Capability] {
+				add(MODELCATALOGUE_KIND_MODEL, aiModel.ID, path+".capability", "duplicate capability (%s)", feature.Capability)
+			}
+			capabilities[feature.Capability] = true
+			for m, constraint := range feature.Constraints {
+				if err := validate.Struct(constraint); err != nil {
+					addValidationErrors(MODELCATALOGUE_KIND_MODEL, aiModel.ID, fmt.Sprintf("%s.constraints[%d]", path, m), err)
+				}
+			}
+			for m, template := range feature.CostItemTemplates {
+				templatePath := fmt.Sprintf("%s.cost_item_templates[%d]", path, m)
+				if !template.CostUnit.IsValid() {
+					add(MODELCATALOGUE_KIND_MODEL, aiModel.ID, templatePath+".cost_unit", "unknown cost unit (%s)", template.CostUnit)
+				}
+				for o := 0; o < m; o++ {
+					if other := feature.CostItemTemplates[o]; other.CostUnit == template.CostUnit && template.overlaps(&other) {
+						add(MODELCATALOGUE_KIND_MODEL, aiModel.ID, templatePath+".valid_from", "overlaps cost_item_templates[%d] of cost unit (%s)", o, template.CostUnit)
+					}
+				}
+				if template.CostPerUnitInEuro < 0 {
+					add(MODELCATALOGUE_KIND_MODEL, aiModel.ID, templatePath+".cost_per_unit_in_euro", "must not be negative")
+				}
+				if err := validate.Struct(template); err != nil {
+					addValidationErrors(MODELCATALOGUE_KIND_MODEL, aiModel.ID, templatePath, err)
+				}
+			}
+		}
+		parameterNames := make(map[string]bool)
+		for n, parameter := range aiModel.ParameterDefinitions {
+			path := fmt.Sprintf("parameter_definitions[%d]", n)
+			if parameter.Name == "" {
+				add(MODELCATALOGUE_KIND_MODEL, aiModel.ID, path+".name", "is required")
+			}
+			if parameterNames[parameter.Name] {
+				add(MODELCATALOGUE_KIND_MODEL, aiModel.ID, path+".name", "duplicate parameter (%s)", parameter.Name)
+			}
+			parameterNames[parameter.Name] = true
+		}
+	}
+	return issues
+}
+
+type ModelCatalogueChangeType string //@name ModelCatalogueChangeType
+
+const (
+	ModelCatalogueChangeCreate ModelCatalogueChangeType = "create"
+	ModelCatalogueChangeUpdate ModelCatalogueChangeType = "update"
+	ModelCatalogueChangeDelete ModelCatalogueChangeType = "delete"
+)
+
+// ModelCatalogueFieldChange is a changed value at a json path like features[0].cost_item_templates[1].cost_per_unit_in_euro
+type ModelCatalogueFieldChange struct {
+	Path     string `json:"path"`
+	OldValue any    `json:"old_value"`
+	NewValue any    `json:"new_value"`
+} //@name ModelCatalogueFieldChange
+
+type ModelCatalogueChange struct {
+	Type   ModelCatalogueChangeType    `json:"type"`
+	Kind   string                      `json:"kind"`
+	ID     string                      `json:"id"`
+	Name   string                      `json:"name"`
+	Fields []ModelCatalogueFieldChange `json:"fields,omitempty"` // updates only
+} //@name ModelCatalogueChange
+
+// ModelCatalogueDiff are the changes needed to get from the current to the desired catalogue, services before models
+type ModelCatalogueDiff struct {
+	Changes []ModelCatalogueChange `json:"changes"`
+} //@name ModelCatalogueDiff
+
+func (diff *ModelCatalogueDiff) IsEmpty() bool {
+	return len(diff.Changes) == 0
+}
+
+// String renders the diff for review, one line per entity and one per changed field
+func (diff *ModelCatalogueDiff) String() string {
+	builder := strings.Builder{}
+	for _, change := range diff.Changes {
+		builder.WriteString(fmt.Sprintf("%s %s(%s) %s\n", change.Type, change.Kind, change.ID, change.Name))
+		for _, field := range change.Fields {
+			builder.WriteString(fmt.Sprintf("  %s: %s -> %s\n", field.Path, formatModelCatalogueValue(field.OldValue), formatModelCatalogueValue(field.NewValue)))
+		}
+	}
+	return builder.String()
+}
+
+func formatModelCatalogueValue(value any) string {
+	if value == nil {
+		return "<none>"
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
+
+// DiffModelCatalogues compares the entities by id, fields maintained by the system are ignored.
+// entities missing in desired are listed as deletes, ApplyModelCatalogue only runs them with Prune.
+func DiffModelCatalogues(current *ModelCatalogue, desired *ModelCatalogue) (diff *ModelCatalogueDiff, err error) {
+	diff = &ModelCatalogueDiff{Changes: make([]ModelCatalogueChange, 0)}
+	currentServices := make(map[string]AIModelServiceObject)
+	for _, service := range current.Services {
+		currentServices[service.ID] = service
+	}
+	desiredServices := make(map[string]bool)
+	for _, service := range desired.Services {
+		desiredServices[service.ID] = true
+		currentService, exists := currentServices[service.ID]
+		change, err := diffModelCatalogueEntity(MODELCATALOGUE_KIND_SERVICE, service.ID, service.Name, currentService, service, exists)
+		if err != nil {
+			return nil, err
+		}
+		if change != nil {
+			diff.Changes = append(diff.Changes, *change)
+		}
+	}
+	currentModels := make(map[string]AIModel)
+	for _, aiModel := range current.Models {
+		currentModels[aiModel.ID] = aiModel
+	}
+	desiredModels := make(map[string]bool)
+	for _, aiModel := range desired.Models {
+		desiredModels[aiModel.ID] = true
+		currentModel, exists := currentModels[aiModel.ID]
+		change, err := diffModelCatalogueEntity(MODELCATALOGUE_KIND_MODEL, aiModel.ID, aiModel.Name, currentModel, aiModel, exists)
+		if err != nil {
+			return nil, err
+		}
+		if change != nil {
+			diff.Changes = append(diff.Changes, *change)
+		}
+	}
+	// models are deleted before the services they belong to
+	for _, aiModel := range current.Models {
+		if !desiredModels[aiModel.ID] {
+			diff.Changes = append(diff.Changes, ModelCatalogueChange{Type: ModelCatalogueChangeDelete, Kind: MODELCATALOGUE_KIND_MODEL, ID: aiModel.ID, Name: aiModel.Name})
+		}
+	}
+	for _, service := range current.Services {
+		if !desiredServices[service.ID] {
+			diff.Changes = append(diff.Changes, ModelCatalogueChange{Type: ModelCatalogueChangeDelete, Kind: MODELCATALOGUE_KIND_SERVICE, ID: service.ID, Name: service.Name})
+		}
+	}
+	return diff, nil
+}
+
+func diffModelCatalogueEntity(kind string, id string, name string, current any, desired any, exists bool) (change *ModelCatalogueChange, err error) {
+	if !exists {
+		return &ModelCatalogueChange{Type: ModelCatalogueChangeCreate, Kind: kind, ID: id, Name: name}, nil
+	}
+	currentEntry, err := toModelCatalogueEntry(current)
+	if err != nil {
+		return nil, err
+	}
+	desiredEntry, err := toModelCatalogueEntry(desired)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]ModelCatalogueFieldChange, 0)
+	diffModelCatalogueValues("", currentEntry, desiredEntry, &fields)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return &ModelCatalogueChange{Type: ModelCatalogueChangeUpdate, Kind: kind, ID: id, Name: name, Fields: fields}, nil
+}
+
+// diffModelCatalogueValues walks the generic json values, maps by sorted key and slices by index
+func diffModelCatalogueValues(path string, oldValue any, newValue any, fields *[]ModelCatalogueFieldChange) {
+	if isEmptyModelCatalogueValue(oldValue) && isEmptyModelCatalogueValue(newValue) {
+		return
+	}
+	oldMap, oldIsMap := oldValue.(map[string]any)
+	newMap, newIsMap := newValue.(map[string]any)
+	if oldIsMap && newIsMap {
+		keys := make([]string, 0, len(oldMap)+len(newMap))
+		for key := range oldMap {
+			keys = append(keys, key)
+		}
+		for key := range newMap {
+			if _, known := oldMap[key]; !known {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+			diffModelCatalogueValues(keyPath, oldMap[key], newMap[key], fields)
+		}
+		return
+	}
+	oldSlice, oldIsSlice := oldValue.([]any)
+	newSlice, newIsSlice := newValue.([]any)
+	if oldIsSlice && newIsSlice {
+		for n := 0; n < len(oldSlice) || n < len(newSlice); n++ {
+			var oldElement, newElement any
+			if n < len(oldSlice) {
+				oldElement = oldSlice[n]
+			}
+			if n < len(newSlice) {
+				newElement = newSlice[n]
+			}
+			diffModelCatalogueValues(fmt.Sprintf("%s[%d]", path, n), oldElement, newElement, fields)
+		}
+		return
+	}
+	if !reflect.DeepEqual(oldValue, newValue) {
+		*fields = append(*fields, ModelCatalogueFieldChange{Path: path, OldValue: oldValue, NewValue: newValue})
+	}
+}
+
+// ModelCatalogueStore is where the catalogue is applied to, e.g. the database behind the model api
+type ModelCatalogueStore interface {
+	ListAIModelServices(ctx context.Context) (services []AIModelServiceObject, err error)
+	ListAIModels(ctx context.Context) (models []AIModel, err error)
+	SaveAIModelService(ctx context.Context, service *AIModelServiceObject) (err error)
+	SaveAIModel(ctx context.Context, aiModel *AIModel) (err error)
+	DeleteAIModelService(ctx context.Context, serviceID string) (err error)
+	DeleteAIModel(ctx context.Context, modelID string) (err error)
+}
+
+type ModelCatalogueApplyOptions struct {
+	Prune     bool   // delete services and models that are not in the catalogue
+	DryRun    bool   // only return the diff
+	AppliedBy string // set as UpdatedBy
+}
+
+// ApplyModelCatalogue validates the catalogue and saves the entities that differ from the store.
+// applying the same catalogue again changes nothing. the returned diff holds the changes made (or planned for DryRun).
+func ApplyModelCatalogue(ctx context.Context, store ModelCatalogueStore, catalogue *ModelCatalogue, options ModelCatalogueApplyOptions) (diff *ModelCatalogueDiff, err error) {
+	var logName string = "[ApplyModelCatalogue] "
+	issues := catalogue.Validate()
+	if len(issues) > 0 {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidModelCatalogue, issues)
+	}
+	currentServices, err := store.ListAIModelServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	currentModels, err := store.ListAIModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	current := &ModelCatalogue{Version: MODELCATALOGUE_VERSION, Services: currentServices, Models: currentModels}
+	diff, err = DiffModelCatalogues(current, catalogue)
+	if err != nil {
+		return nil, err
+	}
+	if !options.Prune {
+		changes := make([]ModelCatalogueChange, 0, len(diff.Changes))
+		for _, change := range diff.Changes {
+			if change.Type != ModelCatalogueChangeDelete {
+				changes = append(changes, change)
+			}
+		}
+		diff.Changes = changes
+	}
+	if options.DryRun || diff.IsEmpty() {
+		return diff, nil
+	}
+
+	now := nuts.TimeToJSTimestamp(time.Now())
+	createdAt := make(map[string]int64)
+	for _, service := range currentServices {
+		createdAt[MODELCATALOGUE_KIND_SERVICE+service.ID] = service.CreatedAt
+	}
+	for _, aiModel := range currentModels {
+		createdAt[MODELCATALOGUE_KIND_MODEL+aiModel.ID] = aiModel.CreatedAt
+	}
+	desiredServices := make(map[string]AIModelServiceObject)
+	for _, service := range catalogue.Services {
+		desiredServices[service.ID] = service
+	}
+	desiredModels := make(map[string]AIModel)
+	for _, aiModel := range catalogue.Models {
+		desiredModels[aiModel.ID] = aiModel
+	}
+	for _, change := range diff.Changes {
+		switch {
+		case change.Type == ModelCatalogueChangeDelete && change.Kind == MODELCATALOGUE_KIND_MODEL:
+			err = store.DeleteAIModel(ctx, change.ID)
+		case change.Type == ModelCatalogueChangeDelete && change.Kind == MODELCATALOGUE_KIND_SERVICE:
+			err = store.DeleteAIModelService(ctx, change.ID)
+		case change.Kind == MODELCATALOGUE_KIND_SERVICE:
+			service := desiredServices[change.ID]
+			service.CreatedAt = getModelCatalogueCreatedAt(createdAt, MODELCATALOGUE_KIND_SERVICE+change.ID, now)
+			service.UpdatedAt = now
+			service.UpdatedBy = options.AppliedBy
+			err = store.SaveAIModelService(ctx, &service)
+		case change.Kind == MODELCATALOGUE_KIND_MODEL:
+			aiModel := desiredModels[change.ID]
+			aiModel.CreatedAt = getModelCatalogueCreatedAt(createdAt, MODELCATALOGUE_KIND_MODEL+change.ID, now)
+			aiModel.UpdatedAt = now
+			aiModel.UpdatedBy = options.AppliedBy
+			err = store.SaveAIModel(ctx, &aiModel)
+		}
+		if err != nil {
+			nuts.L.Errorf("%sfailed to %s %s(%s): %v", logName, change.Type, change.Kind, change.ID, err)
+			return diff, fmt.Errorf("failed to %s %s(%s): %w", change.Type, change.Kind, change.ID, err)
+		}
+		nuts.L.Infof("%s%s %s(%s)", logName, change.Type, change.Kind, change.ID)
+	}
+	return diff, nil
+}
+
+func getModelCatalogueCreatedAt(createdAt map[string]int64, key string, now int64) int64 {
+	if existing, exists := createdAt[key]; exists && existing > 0 {
+		return existing
+	}
+	return now
+}
+
+// null, [] and {} are the same for the catalogue
+func isEmptyModelCatalogueValue(value any) bool {
+	switch typed := value.(type) {
+	case nil:
+		return true
+	case []any:
+		return len(typed) == 0
+	case map[string]any:
+		return len(typed) == 0
+	}
+	return false
+}
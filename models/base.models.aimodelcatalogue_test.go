@@ -0,0 +1,269 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// memoryModelCatalogueStore is a ModelCatalogueStore recording the changing calls
+type memoryModelCatalogueStore struct {
+	services map[string]AIModelServiceObject
+	models   map[string]AIModel
+	calls    []string
+}
+
+func newMemoryModelCatalogueStore(catalogue *ModelCatalogue) *memoryModelCatalogueStore {
+	store := &memoryModelCatalogueStore{services: make(map[string]AIModelServiceObject), models: make(map[string]AIModel)}
+	for _, service := range catalogue.Services {
+		store.services[service.ID] = service
+	}
+	for _, aiModel := range catalogue.Models {
+		store.models[aiModel.ID] = aiModel
+	}
+	return store
+}
+
+func (store *memoryModelCatalogueStore) ListAIModelServices(ctx context.Context) (services []AIModelServiceObject, err error) {
+	for _, service := range store.services {
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].ID < services[j].ID })
+	return services, nil
+}
+
+func (store *memoryModelCatalogueStore) ListAIModels(ctx context.Context) (models []AIModel, err error) {
+	for _, aiModel := range store.models {
+		models = append(models, aiModel)
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].ID < models[j].ID })
+	return models, nil
+}
+
+func (store *memoryModelCatalogueStore) SaveAIModelService(ctx context.Context, service *AIModelServiceObject) (err error) {
+	store.calls = append(store.calls, "save service "+service.ID)
+	store.services[service.ID] = *service
+	return nil
+}
+
+func (store *memoryModelCatalogueStore) SaveAIModel(ctx context.Context, aiModel *AIModel) (err error) {
+	store.calls = append(store.calls, "save model "+aiModel.ID)
+	store.models[aiModel.ID] = *aiModel
+	return nil
+}
+
+func (store *memoryModelCatalogueStore) DeleteAIModelService(ctx context.Context, serviceID string) (err error) {
+	store.calls = append(store.calls, "delete service "+serviceID)
+	delete(store.services, serviceID)
+	return nil
+}
+
+func (store *memoryModelCatalogueStore) DeleteAIModel(ctx context.Context, modelID string) (err error) {
+	store.calls = append(store.calls, "delete model "+modelID)
+	delete(store.models, modelID)
+	return nil
+}
+
+func newCatalogueTestService(id string) AIModelServiceObject {
+	return AIModelServiceObject{
+		ID:                  id,
+		Name:                id,
+		ServiceImpl:         OPENAI_PROVIDER_SERVICE_IMPL,
+		CostMultiplier:      1.5,
+		HostingLocations:    map[string]HostingLocation{"https://" + id + ".example.com/v1": HostingLocationGERMANY},
+		OwnerId:             "owner",
+		OwnerOrganizationId: "org",
+		CreatedAt:           1000,
+		UpdatedAt:           2000,
+	}
+}
+
+func newCatalogueTestModel(t *testing.T, id string, serviceID string, inputPrice string) AIModel {
+	return AIModel{
+		ID:                    id,
+		Name:                  id,
+		ServiceID:             serviceID,
+		ModelID:               id + "-api",
+		MaxInputTokens:        128000,
+		ServiceHostLocations:  []HostingLocation{HostingLocationGERMANY},
+		AcceptedFileMimetypes: []string{"image/*"},
+		Features: []AIModelFeature{{
+			Capability:        AIModelCapabilityTextToText,
+			Constraints:       []AIModelConstraint{{Direction: AIModelConstraintDirectionInput, Unit: AIModelMinMaxUnitFiles, Max: 10}},
+			CostItemTemplates: []ExecutionCostTemplate{{CostUnit: AIModelCostUnitInputPerMillionTokens, CostPerUnitInEuro: mustParseMoney(t, inputPrice)}},
+		}},
+		Parameters:          map[string]any{"temperature": 0.7},
+		OwnerId:             "owner",
+		OwnerOrganizationId: "org",
+		CreatedAt:           1000,
+		UpdatedAt:           2000,
+	}
+}
+
+func newTestModelCatalogue(t *testing.T) *ModelCatalogue {
+	return NewModelCatalogue(
+		[]AIModelServiceObject{newCatalogueTestService("s1")},
+		[]AIModel{newCatalogueTestModel(t, "m2", "s1", "3"), newCatalogueTestModel(t, "m1", "s1", "2")},
+	)
+}
+
+func TestModelCatalogueRoundTrip(t *testing.T) {
+	catalogue := newTestModelCatalogue(t)
+	for _, format := range []ModelCatalogueFormat{ModelCatalogueFormatYAML, ModelCatalogueFormatJSON} {
+		t.Run(string(format), func(t *testing.T) {
+			data, err := catalogue.Marshal(format)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			for _, volatile := range modelCatalogueVolatileFields {
+				if strings.Contains(string(data), volatile) {
+					t.Errorf("Marshal() contains the system field %s:\n%s", volatile, data)
+				}
+			}
+			parsed, err := ParseModelCatalogue(data, format)
+			if err != nil {
+				t.Fatalf("ParseModelCatalogue() error = %v\n%s", err, data)
+			}
+			if issues := parsed.Validate(); len(issues) > 0 {
+				t.Fatalf("Validate() = %v", issues)
+			}
+			diff, err := DiffModelCatalogues(catalogue, parsed)
+			if err != nil {
+				t.Fatalf("DiffModelCatalogues() error = %v", err)
+			}
+			if !diff.IsEmpty() {
+				t.Fatalf("round trip changed the catalogue:\n%s", diff)
+			}
+			again, err := parsed.Marshal(format)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if string(again) != string(data) {
+				t.Fatalf("second Marshal() =\n%s\nwant the stable\n%s", again, data)
+			}
+			if parsed.Models[0].ID != "m1" || parsed.Models[1].ID != "m2" {
+				t.Errorf("models = %s, %s, want them sorted by id", parsed.Models[0].ID, parsed.Models[1].ID)
+			}
+		})
+	}
+}
+
+func TestParseModelCatalogueRejectsInvalidDocuments(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		format  ModelCatalogueFormat
+		wantErr error
+	}{
+		{name: "unknown yaml field", data: "version: 1\nmodels:\n  - id: m1\n    nmae: typo\n", format: ModelCatalogueFormatYAML, wantErr: ErrInvalidModelCatalogue},
+		{name: "unknown nested json field", data: `{"version":1,"models":[{"id":"m1","features":[{"capability":"text-to-text","cost_per_token":1}]}]}`, format: ModelCatalogueFormatJSON, wantErr: ErrInvalidModelCatalogue},
+		{name: "unknown top level field", data: `{"version":1,"providers":[]}`, format: ModelCatalogueFormatJSON, wantErr: ErrInvalidModelCatalogue},
+		{name: "unsupported version", data: "version: 2\n", format: ModelCatalogueFormatYAML, wantErr: ErrUnsupportedModelCatalogueVersion},
+		{name: "unsupported format", data: "version = 1", format: "toml", wantErr: ErrUnsupportedModelCatalogueFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseModelCatalogue([]byte(tt.data), tt.format); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseModelCatalogue() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDiffModelCatalogues(t *testing.T) {
+	current := NewModelCatalogue(
+		[]AIModelServiceObject{newCatalogueTestService("s1"), newCatalogueTestService("s-old")},
+		[]AIModel{newCatalogueTestModel(t, "m1", "s1", "2"), newCatalogueTestModel(t, "m-old", "s-old", "1")},
+	)
+	desired := NewModelCatalogue(
+		[]AIModelServiceObject{newCatalogueTestService("s1")},
+		[]AIModel{newCatalogueTestModel(t, "m1", "s1", "2.5"), newCatalogueTestModel(t, "m2", "s1", "3")},
+	)
+	// fields maintained by the system are no change
+	desired.Services[0].UpdatedAt = 9999
+	desired.Models[0].UpdatedBy = "someone"
+
+	diff, err := DiffModelCatalogues(current, desired)
+	if err != nil {
+		t.Fatalf("DiffModelCatalogues() error = %v", err)
+	}
+	want := []ModelCatalogueChange{
+		{Type: ModelCatalogueChangeUpdate, Kind: MODELCATALOGUE_KIND_MODEL, ID: "m1", Name: "m1", Fields: []ModelCatalogueFieldChange{
+			{Path: "features[0].cost_item_templates[0].cost_per_unit_in_euro", OldValue: float64(2), NewValue: 2.5},
+		}},
+		{Type: ModelCatalogueChangeCreate, Kind: MODELCATALOGUE_KIND_MODEL, ID: "m2", Name: "m2"},
+		{Type: ModelCatalogueChangeDelete, Kind: MODELCATALOGUE_KIND_MODEL, ID: "m-old", Name: "m-old"},
+		{Type: ModelCatalogueChangeDelete, Kind: MODELCATALOGUE_KIND_SERVICE, ID: "s-old", Name: "s-old"},
+	}
+	if !reflect.DeepEqual(diff.Changes, want) {
+		t.Fatalf("diff =\n%s\nwant %+v", diff, want)
+	}
+	if wantText := "update model(m1) m1\n  features[0].cost_item_templates[0].cost_per_unit_in_euro: 2 -> 2.5\n"; !strings.HasPrefix(diff.String(), wantText) {
+		t.Errorf("String() =\n%s\nwant it to start with\n%s", diff, wantText)
+	}
+}
+
+func TestApplyModelCatalogue(t *testing.T) {
+	ctx := context.Background()
+	catalogue := newTestModelCatalogue(t)
+	store := newMemoryModelCatalogueStore(&ModelCatalogue{})
+
+	diff, err := ApplyModelCatalogue(ctx, store, catalogue, ModelCatalogueApplyOptions{AppliedBy: "ci"})
+	if err != nil {
+		t.Fatalf("ApplyModelCatalogue() error = %v", err)
+	}
+	if want := []string{"save service s1", "save model m1", "save model m2"}; !reflect.DeepEqual(store.calls, want) || len(diff.Changes) != 3 {
+		t.Fatalf("first apply called %v with %d changes, want %v", store.calls, len(diff.Changes), want)
+	}
+	if saved := store.models["m1"]; saved.UpdatedBy != "ci" || saved.CreatedAt == 0 || saved.CreatedAt == 1000 {
+		t.Errorf("saved m1 created at %d by %s, want a new creation time and ci", saved.CreatedAt, saved.UpdatedBy)
+	}
+	createdAt := store.models["m1"].CreatedAt
+
+	store.calls = nil
+	diff, err = ApplyModelCatalogue(ctx, store, catalogue, ModelCatalogueApplyOptions{AppliedBy: "ci"})
+	if err != nil {
+		t.Fatalf("second ApplyModelCatalogue() error = %v", err)
+	}
+	if !diff.IsEmpty() || len(store.calls) != 0 {
+		t.Fatalf("second apply changed %v and called %v, want nothing", diff.Changes, store.calls)
+	}
+
+	store.models["m-extra"] = newCatalogueTestModel(t, "m-extra", "s1", "1")
+	catalogue.Models[0].MaxInputTokens = 64000
+	diff, err = ApplyModelCatalogue(ctx, store, catalogue, ModelCatalogueApplyOptions{DryRun: true, Prune: true})
+	if err != nil {
+		t.Fatalf("dry run ApplyModelCatalogue() error = %v", err)
+	}
+	if len(diff.Changes) != 2 || len(store.calls) != 0 || store.models["m1"].MaxInputTokens != 128000 {
+		t.Fatalf("dry run planned %v and called %v, want the update and the delete planned only", diff.Changes, store.calls)
+	}
+
+	diff, err = ApplyModelCatalogue(ctx, store, catalogue, ModelCatalogueApplyOptions{})
+	if err != nil {
+		t.Fatalf("ApplyModelCatalogue() without prune error = %v", err)
+	}
+	if want := []string{"save model m1"}; !reflect.DeepEqual(store.calls, want) || len(diff.Changes) != 1 {
+		t.Fatalf("apply without prune called %v, want %v and m-extra kept", store.calls, want)
+	}
+	if saved := store.models["m1"]; saved.MaxInputTokens != 64000 || saved.CreatedAt != createdAt {
+		t.Errorf("updated m1 has %d max input tokens created at %d, want 64000 and the kept creation time %d", saved.MaxInputTokens, saved.CreatedAt, createdAt)
+	}
+
+	store.calls = nil
+	if _, err = ApplyModelCatalogue(ctx, store, catalogue, ModelCatalogueApplyOptions{Prune: true}); err != nil {
+		t.Fatalf("ApplyModelCatalogue() with prune error = %v", err)
+	}
+	if want := []string{"delete model m-extra"}; !reflect.DeepEqual(store.calls, want) {
+		t.Fatalf("apply with prune called %v, want %v", store.calls, want)
+	}
+
+	store.calls = nil
+	catalogue.Models[0].ServiceID = "missing"
+	if _, err = ApplyModelCatalogue(ctx, store, catalogue, ModelCatalogueApplyOptions{}); !errors.Is(err, ErrInvalidModelCatalogue) || len(store.calls) != 0 {
+		t.Fatalf("invalid catalogue error = %v with calls %v, want %v and no calls", err, store.calls, ErrInvalidModelCatalogue)
+	}
+}
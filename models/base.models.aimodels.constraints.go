@@ -0,0 +1,269 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// metadata of AIgencyMessageFile used to check seconds and pixels constraints, files without them are not checked
+const (
+	AIGENCYMESSAGEFILE_METADATA_DURATION_SECONDS = "duration_seconds"
+	AIGENCYMESSAGEFILE_METADATA_WIDTH            = "width"
+	AIGENCYMESSAGEFILE_METADATA_HEIGHT           = "height"
+
+	AIMODELCONSTRAINT_BYTES_PER_MEGABYTE = 1024 * 1024
+)
+
+var (
+	ErrAIModelConstraintViolated = errors.New("request violates the constraints of the AI model")
+)
+
+type AIModelConstraintViolationCode string //@name AIModelConstraintViolationCode
+
+const (
+	AIModelConstraintViolationBelowMin            AIModelConstraintViolationCode = "below-min"
+	AIModelConstraintViolationAboveMax            AIModelConstraintViolationCode = "above-max"
+	AIModelConstraintViolationMimetypeNotAccepted AIModelConstraintViolationCode = "mimetype-not-accepted"
+)
+
+// where the violated limit is defined on the AIModel
+type AIModelConstraintSource string //@name AIModelConstraintSource
+
+const (
+	AIModelConstraintSourceMaxInputTokens        AIModelConstraintSource = "max_input_tokens"
+	AIModelConstraintSourceMaxOutputTokens       AIModelConstraintSource = "max_output_tokens"
+	AIModelConstraintSourceAcceptedFileMimetypes AIModelConstraintSource = "accepted_file_mimetypes"
+	AIModelConstraintSourceConstraints           AIModelConstraintSource = "constraints"
+	AIModelConstraintSourceFeatureConstraints    AIModelConstraintSource = "feature_constraints"
+)
+
+// AIModelConstraintRequest is an outgoing request as far as the constraints of a model are concerned
+type AIModelConstraintRequest struct {
+	Messages        *AIgencyMessageList   `json:"messages"`
+	Attachments     []*AIgencyMessageFile `json:"attachments"`       // sent in addition to the files of the messages
+	MaxOutputTokens int                   `json:"max_output_tokens"` // the requested output limit, 0 if the model default is used
+	Capability      AIModelCapability     `json:"capability"`        // the constraints of the features with this capability are checked, too
+} //@name AIModelConstraintRequest
+
+// AIModelConstraintViolation is one limit of the model the request does not meet. file violations name the file.
+type AIModelConstraintViolation struct {
+	Code      AIModelConstraintViolationCode `json:"code"`
+	Source    AIModelConstraintSource        `json:"source"`
+	Direction AIModelConstraintDirection     `json:"direction"`
+	Unit      AIModelMinMaxUnit              `json:"unit,omitempty"`
+	Limit     float64                        `json:"limit,omitempty"`
+	Actual    float64                        `json:"actual,omitempty"`
+	FileID    string                         `json:"file_id,omitempty"`
+	FileName  string                         `json:"file_name,omitempty"`
+	Mimetype  string                         `json:"mimetype,omitempty"`
+	Message   string                         `json:"message"`
+} //@name AIModelConstraintViolation
+
+type AIModelConstraintViolations []AIModelConstraintViolation //@name AIModelConstraintViolations
+
+func (violations AIModelConstraintViolations) Error() string {
+	messages := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		messages = append(messages, violation.Message)
+	}
+	return fmt.Sprintf("(%d) constraint violations: %s", len(violations), strings.Join(messages, "; "))
+}
+
+// EnforceConstraints returns ErrAIModelConstraintViolated wrapping the AIModelConstraintViolations if the request does not meet the limits of the model.
+// it is meant to be called before the request is sent to the provider.
+func (aiModel *AIModel) EnforceConstraints(request AIModelConstraintRequest) (err error) {
+	violations := aiModel.CheckConstraints(request)
+	if len(violations) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrAIModelConstraintViolated, violations)
+}
+
+// CheckConstraints checks the request against MaxInputTokens, MaxOutputTokens, AcceptedFileMimetypes and the Constraints of the model
+// (and of its features for request.Capability). tokens, characters, files and images are checked for the whole request,
+// megabytes, seconds and pixels (width x height) for every file. a Max of 0 means unlimited, an empty AcceptedFileMimetypes accepts all files.
+func (aiModel *AIModel) CheckConstraints(request AIModelConstraintRequest) (violations AIModelConstraintViolations) {
+	violations = make(AIModelConstraintViolations, 0)
+	inputTokens := 0
+	if request.Messages != nil {
+		inputTokens, _ = countEstimateInputs(aiModel, request.Messages)
+	}
+	files := collectRequestFiles(request.Messages, request.Attachments)
+
+	if aiModel.MaxInputTokens > 0 && inputTokens > aiModel.MaxInputTokens {
+		violations = append(violations, newAIModelConstraintViolation(AIModelConstraintViolationAboveMax, AIModelConstraintSourceMaxInputTokens, AIModelConstraintDirectionInput, AIModelMinMaxUnitTokens, float64(aiModel.MaxInputTokens), float64(inputTokens), nil))
+	}
+	if aiModel.MaxOutputTokens > 0 && request.MaxOutputTokens > aiModel.MaxOutputTokens {
+		violations = append(violations, newAIModelConstraintViolation(AIModelConstraintViolationAboveMax, AIModelConstraintSourceMaxOutputTokens, AIModelConstraintDirectionOutput, AIModelMinMaxUnitTokens, float64(aiModel.MaxOutputTokens), float64(request.MaxOutputTokens), nil))
+	}
+	for _, file := range files {
+		if !aiModel.AcceptsMimetype(file.MimeType) {
+			violation := newAIModelConstraintViolation(AIModelConstraintViolationMimetypeNotAccepted, AIModelConstraintSourceAcceptedFileMimetypes, AIModelConstraintDirectionInput, AIModelMinMaxUnitFiles, 0, 0, file)
+			violation.Message = fmt.Sprintf("file (%s) of type (%s) is not accepted", file.FileName, file.MimeType)
+			violations = append(violations, violation)
+		}
+	}
+
+	totals := map[AIModelMinMaxUnit]float64{
+		AIModelMinMaxUnitTokens:     float64(inputTokens),
+		AIModelMinMaxUnitCharacters: float64(countRequestCharacters(request.Messages)),
+		AIModelMinMaxUnitFiles:      float64(len(files)),
+		AIModelMinMaxUnitImages:     float64(countImageFiles(files)),
+	}
+	check := func(constraint AIModelConstraint, source AIModelConstraintSource) {
+		if constraint.Direction == AIModelConstraintDirectionOutput {
+			// only the requested output tokens are known before the call
+			if constraint.Unit == AIModelMinMaxUnitTokens && request.MaxOutputTokens > 0 {
+				violations = append(violations, checkAIModelConstraint(constraint, source, float64(request.MaxOutputTokens), nil)...)
+			}
+			return
+		}
+		if total, isTotal := totals[constraint.Unit]; isTotal {
+			violations = append(violations, checkAIModelConstraint(constraint, source, total, nil)...)
+			return
+		}
+		for _, file := range files {
+			if actual, isKnown := measureFile(file, constraint.Unit); isKnown {
+				violations = append(violations, checkAIModelConstraint(constraint, source, actual, file)...)
+			}
+		}
+	}
+	for _, constraint := range aiModel.Constraints {
+		check(constraint, AIModelConstraintSourceConstraints)
+	}
+	if request.Capability != "" {
+		for _, feature := range aiModel.GetFeaturesForCapability(request.Capability) {
+			for _, constraint := range feature.Constraints {
+				check(constraint, AIModelConstraintSourceFeatureConstraints)
+			}
+		}
+	}
+	return violations
+}
+
+func checkAIModelConstraint(constraint AIModelConstraint, source AIModelConstraintSource, actual float64, file *AIgencyMessageFile) (violations []AIModelConstraintViolation) {
+	if constraint.Min > 0 && actual < constraint.Min {
+		violations = append(violations, newAIModelConstraintViolation(AIModelConstraintViolationBelowMin, source, constraint.Direction, constraint.Unit, constraint.Min, actual, file))
+	}
+	if constraint.Max > 0 && actual > constraint.Max {
+		violations = append(violations, newAIModelConstraintViolation(AIModelConstraintViolationAboveMax, source, constraint.Direction, constraint.Unit, constraint.Max, actual, file))
+	}
+	return violations
+}
+
+func newAIModelConstraintViolation(code AIModelConstraintViolationCode, source AIModelConstraintSource, direction AIModelConstraintDirection, unit AIModelMinMaxUnit, limit float64, actual float64, file *AIgencyMessageFile) AIModelConstraintViolation {
+	violation := AIModelConstraintViolation{
+		Code:      code,
+		Source:    source,
+		Direction: direction,
+		Unit:      unit,
+		Limit:     limit,
+		Actual:    actual,
+	}
+	subject := string(direction)
+	if file != nil {
+		violation.FileID = file.ID
+		violation.FileName = file.FileName
+		violation.Mimetype = file.MimeType
+		subject = fmt.Sprintf("file (%s)", file.FileName)
+	}
+	comparison := "exceeds the max"
+	if code == AIModelConstraintViolationBelowMin {
+		comparison = "is below the min"
+	}
+	violation.Message = fmt.Sprintf("%s of (%v) %s %s of (%v) set by %s", subject, actual, unit, comparison, limit, source)
+	return violation
+}
+
+// collectRequestFiles returns the files of the message contents, the message attachments and the extra attachments, each file once
+func collectRequestFiles(messages *AIgencyMessageList, attachments []*AIgencyMessageFile) (files []*AIgencyMessageFile) {
+	files = make([]*AIgencyMessageFile, 0)
+	knownIDs := make(map[string]bool)
+	addFile := func(file *AIgencyMessageFile) {
+		if file == nil {
+			return
+		}
+		if file.ID != "" {
+			if knownIDs[file.ID] {
+				return
+			}
+			knownIDs[file.ID] = true
+		}
+		files = append(files, file)
+	}
+	if messages != nil {
+		for _, msg := range messages.GetMessages() {
+			if msg == nil {
+				continue
+			}
+			if msg.Content != nil {
+				for _, content := range msg.Content.GetContentByType(AIgencyMessageContentTypeFile) {
+					addFile(content.File)
+				}
+			}
+			if msg.Attachments != nil {
+				for _, file := range msg.Attachments.GetFiles() {
+					addFile(file)
+				}
+			}
+		}
+	}
+	for _, file := range attachments {
+		addFile(file)
+	}
+	return files
+}
+
+func countRequestCharacters(messages *AIgencyMessageList) (characters int) {
+	if messages == nil {
+		return 0
+	}
+	for _, msg := range messages.GetMessages() {
+		if msg != nil && msg.Content != nil {
+			characters += utf8.RuneCountInString(msg.Content.GetConcatenatedText(true, false))
+		}
+	}
+	return characters
+}
+
+func countImageFiles(files []*AIgencyMessageFile) (images int) {
+	for _, file := range files {
+		if strings.HasPrefix(strings.ToLower(file.MimeType), "image/") {
+			images++
+		}
+	}
+	return images
+}
+
+// measureFile returns the size of the file in a per file unit, isKnown is false if the file does not carry it
+func measureFile(file *AIgencyMessageFile, unit AIModelMinMaxUnit) (actual float64, isKnown bool) {
+	switch unit {
+	case AIModelMinMaxUnitFilesizeMegabytes:
+		if file.FileSize <= 0 {
+			return 0, false
+		}
+		return float64(file.FileSize) / AIMODELCONSTRAINT_BYTES_PER_MEGABYTE, true
+	case AIModelMinMaxUnitSeconds:
+		return getFileMetaDataNumber(file, AIGENCYMESSAGEFILE_METADATA_DURATION_SECONDS)
+	case AIModelMinMaxUnitPixels:
+		width, hasWidth := getFileMetaDataNumber(file, AIGENCYMESSAGEFILE_METADATA_WIDTH)
+		height, hasHeight := getFileMetaDataNumber(file, AIGENCYMESSAGEFILE_METADATA_HEIGHT)
+		return width * height, hasWidth && hasHeight
+	}
+	return 0, false
+}
+
+func getFileMetaDataNumber(file *AIgencyMessageFile, key string) (number float64, isKnown bool) {
+	if file.MetaData == nil {
+		return 0, false
+	}
+	value := file.MetaData[key]
+	if text, isText := value.(string); isText {
+		parsed, err := strconv.ParseFloat(text, 64)
+		return parsed, err == nil
+	}
+	return toFloat64(value)
+}
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newConstraintTestFile(fileName string, mimeType string, fileSize int64, metaData map[string]any) *AIgencyMessageFile {
+	file := NewAIgencyMessageFile(fileName, "", mimeType, "https://example.com/"+fileName)
+	file.FileSize = fileSize
+	file.MetaData = metaData
+	return file
+}
+
+// newConstraintTestMessages is one user message with 40 characters of text and the files as content
+func newConstraintTestMessages(files ...*AIgencyMessageFile) *AIgencyMessageList {
+	text := strings.Repeat("x", 40)
+	msg := NewAIgencyMessage()
+	msg.Content.AddContent(NewAIgencyMessageContent(AIgencyMessageContentTypeText, &text, nil))
+	for _, file := range files {
+		msg.Content.AddContent(NewAIgencyMessageContent(AIgencyMessageContentTypeFile, nil, file))
+	}
+	messages := NewAIgencyMessageList()
+	messages.AddMessage(msg)
+	return messages
+}
+
+// describeConstraintViolations reduces the violations to code, source, unit and file name
+func describeConstraintViolations(violations AIModelConstraintViolations) (descriptions []string) {
+	descriptions = make([]string, 0)
+	for _, violation := range violations {
+		descriptions = append(descriptions, fmt.Sprintf("%s %s %s %s", violation.Code, violation.Source, violation.Unit, violation.FileName))
+	}
+	return descriptions
+}
+
+func TestAIModelCheckConstraints(t *testing.T) {
+	picture := newConstraintTestFile("picture.png", "image/png", 2*AIMODELCONSTRAINT_BYTES_PER_MEGABYTE, map[string]any{AIGENCYMESSAGEFILE_METADATA_WIDTH: 2000, AIGENCYMESSAGEFILE_METADATA_HEIGHT: "1000"})
+	icon := newConstraintTestFile("icon.png", "image/png", AIMODELCONSTRAINT_BYTES_PER_MEGABYTE/2, nil)
+	long := newConstraintTestFile("long.mp3", "audio/mpeg", 0, map[string]any{AIGENCYMESSAGEFILE_METADATA_DURATION_SECONDS: "90"})
+	short := newConstraintTestFile("short.mp3", "audio/mpeg", 0, map[string]any{AIGENCYMESSAGEFILE_METADATA_DURATION_SECONDS: 30.0})
+	document := newConstraintTestFile("notes.pdf", "application/pdf", 0, nil)
+	aiModel := &AIModel{ID: "constraint-test", ModelID: "gemini-test"}
+	inputTokens, _ := countEstimateInputs(aiModel, newConstraintTestMessages())
+
+	tests := []struct {
+		name    string
+		model   AIModel
+		request AIModelConstraintRequest
+		want    []string
+	}{
+		{name: "no limits and no mimetypes accept everything", request: AIModelConstraintRequest{Messages: newConstraintTestMessages(picture, document, long), MaxOutputTokens: 1_000_000}},
+		{name: "mimetype not accepted", model: AIModel{AcceptedFileMimetypes: []string{"image/*", "audio/mpeg"}}, request: AIModelConstraintRequest{Messages: newConstraintTestMessages(picture, long), Attachments: []*AIgencyMessageFile{document}}, want: []string{
+			"mimetype-not-accepted accepted_file_mimetypes files notes.pdf",
+		}},
+		{name: "max input tokens", model: AIModel{MaxInputTokens: inputTokens - 1}, request: AIModelConstraintRequest{Messages: newConstraintTestMessages()}, want: []string{
+			"above-max max_input_tokens tokens ",
+		}},
+		{name: "max input tokens reached exactly", model: AIModel{MaxInputTokens: inputTokens}, request: AIModelConstraintRequest{Messages: newConstraintTestMessages()}},
+		{name: "max output tokens", model: AIModel{MaxOutputTokens: 100}, request: AIModelConstraintRequest{MaxOutputTokens: 101}, want: []string{
+			"above-max max_output_tokens tokens ",
+		}},
+		{name: "min and max characters", model: AIModel{Constraints: []AIModelConstraint{
+			{Direction: AIModelConstraintDirectionInput, Unit: AIModelMinMaxUnitCharacters, Min: 50},
+			{Direction: AIModelConstraintDirectionInput, Unit: AIModelMinMaxUnitCharacters, Max: 39},
+		}}, request: AIModelConstraintRequest{Messages: newConstraintTestMessages()}, want: []string{
+			"below-min constraints characters ",
+			"above-max constraints characters ",
+		}},
+		{name: "max files and images of the whole request", model: AIModel{Constraints: []AIModelConstraint{
+			{Direction: AIModelConstraintDirectionInput, Unit: AIModelMinMaxUnitFiles, Max: 2},
+			{Direction: AIModelConstraintDirectionInput, Unit: AIModelMinMaxUnitImages, Max: 1},
+		}}, request: AIModelConstraintRequest{Messages: newConstraintTestMessages(picture, icon), Attachments: []*AIgencyMessageFile{picture, document}}, want: []string{
+			"above-max constraints files ",
+			"above-max constraints images ",
+		}},
+		{name: "megabytes per file", model: AIModel{Constraints: []AIModelConstraint{
+			{Direction: AIModelConstraintDirectionInput, Unit: AIModelMinMaxUnitFilesizeMegabytes, Max: 1},
+		}}, request: AIModelConstraintRequest{Messages: newConstraintTestMessages(picture, icon, document)}, want: []string{
+			"above-max constraints megabytes picture.png",
+		}},
+		{name: "seconds per file", model: AIModel{Constraints: []AIModelConstraint{
+			{Direction: AIModelConstraintDirectionInput, Unit: AIModelMinMaxUnitSeconds, Min: 10, Max: 60},
+		}}, request: AIModelConstraintRequest{Messages: newConstraintTestMessages(long, short, document)}, want: []string{
+			"above-max constraints seconds long.mp3",
+		}},
+		{name: "pixels per file", model: AIModel{Constraints: []AIModelConstraint{
+			{Direction: AIModelConstraintDirectionInput, Unit: AIModelMinMaxUnitPixels, Max: 1_000_000},
+		}}, request: AIModelConstraintRequest{Messages: newConstraintTestMessages(picture, icon)}, want: []string{
+			"above-max constraints pixels picture.png",
+		}},
+		{name: "output tokens constraint", model: AIModel{Constraints: []AIModelConstraint{
+			{Direction: AIModelConstraintDirectionOutput, Unit: AIModelMinMaxUnitTokens, Max: 50},
+		}}, request: AIModelConstraintRequest{MaxOutputTokens: 51}, want: []string{
+			"above-max constraints tokens ",
+		}},
+		{name: "output tokens constraint with the model default", model: AIModel{Constraints: []AIModelConstraint{
+			{Direction: AIModelConstraintDirectionOutput, Unit: AIModelMinMaxUnitTokens, Max: 50},
+		}}, request: AIModelConstraintRequest{}},
+		{name: "feature constraints of the capability", model: AIModel{Features: []AIModelFeature{
+			{Capability: AIModelCapabilityImageToText, Constraints: []AIModelConstraint{{Direction: AIModelConstraintDirectionInput, Unit: AIModelMinMaxUnitImages, Max: 1}}},
+		}}, request: AIModelConstraintRequest{Messages: newConstraintTestMessages(picture, icon), Capability: AIModelCapabilityImageToText}, want: []string{
+			"above-max feature_constraints images ",
+		}},
+		{name: "feature constraints of other capabilities", model: AIModel{Features: []AIModelFeature{
+			{Capability: AIModelCapabilityImageToText, Constraints: []AIModelConstraint{{Direction: AIModelConstraintDirectionInput, Unit: AIModelMinMaxUnitImages, Max: 1}}},
+		}}, request: AIModelConstraintRequest{Messages: newConstraintTestMessages(picture, icon), Capability: AIModelCapabilityTextToText}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := tt.model
+			model.ID, model.ModelID = aiModel.ID, aiModel.ModelID
+			violations := model.CheckConstraints(tt.request)
+			if got, want := describeConstraintViolations(violations), append([]string{}, tt.want...); !reflect.DeepEqual(got, want) {
+				t.Fatalf("violations = %q, want %q", got, want)
+			}
+			err := model.EnforceConstraints(tt.request)
+			if (err != nil) != (len(tt.want) > 0) || (err != nil && !errors.Is(err, ErrAIModelConstraintViolated)) {
+				t.Fatalf("EnforceConstraints() error = %v, want %v exactly if there are violations", err, ErrAIModelConstraintViolated)
+			}
+		})
+	}
+}
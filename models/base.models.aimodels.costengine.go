@@ -0,0 +1,186 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	nuts "github.com/vaudience/go-nuts"
+)
+
+var (
+	ErrUnknownCostUnit     = errors.New("unknown cost unit")
+	ErrInvalidUsageAmount  = errors.New("usage amount must not be negative")
+	ErrMissingUsageDetails = errors.New("usage needs a capability and a cost unit")
+)
+
+// ExecutionUsage records how much of one cost unit an execution consumed for one capability.
+// Amount is always in raw units (tokens, characters, files, images, pixels, seconds, calls), the engine normalises per-million units.
+type ExecutionUsage struct {
+	Capability AIModelCapability `json:"capability" readxs:"system:struct,admin:struct"`
+	CostUnit   AIModelCostUnit   `json:"cost_unit" readxs:"system:struct,admin:struct"`
+	Amount     float64           `json:"amount" readxs:"system:struct,admin:struct"`
+} //@name ExecutionUsage
+
+// ExecutionCostTotals sums up the billed costs of the cost items created for a list of usages
+type ExecutionCostTotals struct {
+	Currency         Currency                    `json:"currency" readxs:"system:struct,admin:struct,owner:struct,org-admin:struct"`
+	TotalCost        Money                       `json:"total_cost" readxs:"system:struct,admin:struct,owner:struct,org-admin:struct"`
+	TotalSourceCost  Money                       `json:"-" readxsjson:"total_source_cost" readxs:"system:struct,admin:struct"`
+	TotalMargin      Money                       `json:"-" readxsjson:"total_margin" readxs:"system:struct,admin:struct"`
+	CostByCapability map[AIModelCapability]Money `json:"cost_by_capability" readxs:"system:struct,admin:struct,org-admin:struct"`
+	UnpricedUsages   []ExecutionUsage            `json:"unpriced_usages" readxs:"system:struct,admin:struct"` // usages the model has no cost template for
+} //@name ExecutionCostTotals
+
+func NewExecutionUsage(capability AIModelCapability, costUnit AIModelCostUnit, amount float64) ExecutionUsage {
+	return ExecutionUsage{
+		Capability: capability,
+		CostUnit:   costUnit,
+		Amount:     amount,
+	}
+}
+
+// NewText2TextExecutionUsages creates the input, output and function call usages of a chat completion
+func NewText2TextExecutionUsages(toolCallsUsed int, inputTokenCount int, outputTokenCount int) []ExecutionUsage {
+	return []ExecutionUsage{
+		NewExecutionUsage(AIModelCapabilityTextToText, AIModelCostUnitOutputPerMillionTokens, float64(outputTokenCount)),
+		NewExecutionUsage(AIModelCapabilityTextToText, AIModelCostUnitInputPerMillionTokens, float64(inputTokenCount)),
+		NewExecutionUsage(AIModelCapabilityFunctionCalling, AIModelCostUnitPerFunctionCall, float64(toolCallsUsed)),
+	}
+}
+
+// NewImageGenerationPixelUsage creates a per-pixel usage for numberOfImages generated images of width x height
+func NewImageGenerationPixelUsage(width int, height int, numberOfImages int) ExecutionUsage {
+	return NewExecutionUsage(AIModelCapabilityTextToImage, AIModelCostUnitImageGenerationPerPixel, float64(width)*float64(height)*float64(numberOfImages))
+}
+
+// NewDurationUsage creates a usage for one of the per-second units (audio/video input and generation)
+func NewDurationUsage(capability AIModelCapability, costUnit AIModelCostUnit, duration time.Duration) ExecutionUsage {
+	return NewExecutionUsage(capability, costUnit, duration.Seconds())
+}
+
+func NewExecutionCostTotals(currency Currency) ExecutionCostTotals {
+	return ExecutionCostTotals{
+		Currency:         currency.OrDefault(),
+		CostByCapability: make(map[AIModelCapability]Money),
+		UnpricedUsages:   make([]ExecutionUsage, 0),
+	}
+}
+
+func (usage ExecutionUsage) Validate() error {
+	if usage.Capability == "" || usage.CostUnit == "" {
+		return ErrMissingUsageDetails
+	}
+	if !usage.CostUnit.IsValid() {
+		return fmt.Errorf("%w: %s", ErrUnknownCostUnit, usage.CostUnit)
+	}
+	if usage.Amount < 0 {
+		return fmt.Errorf("%w: %s(%v)", ErrInvalidUsageAmount, usage.CostUnit, usage.Amount)
+	}
+	return nil
+}
+
+// Add sums up the billed costs of the given used features, which must already be converted into the totals currency
+func (totals *ExecutionCostTotals) Add(featuresUsed []AIModelFeature) (err error) {
+	totals.Currency = totals.Currency.OrDefault()
+	if totals.CostByCapability == nil {
+		totals.CostByCapability = make(map[AIModelCapability]Money)
+	}
+	for _, feature := range featuresUsed {
+		for _, costItem := range feature.CostItems {
+			cost, sourceCost, currency := costItem.GetBilledCost()
+			if currency != totals.Currency {
+				return fmt.Errorf("%w: %s->%s", ErrCurrencyMismatch, currency, totals.Currency)
+			}
+			totals.TotalCost += cost
+			totals.TotalSourceCost += sourceCost
+			totals.TotalMargin += cost - sourceCost
+			totals.CostByCapability[feature.Capability] += cost
+		}
+	}
+	return nil
+}
+
+// CalculateCostForUsages is the single entry point for cost calculation: every usage is priced with the
+// matching cost templates of the model's features. usages without a matching template are reported in totals.UnpricedUsages.
+// costs are billed in DEFAULT_CURRENCY, see CalculateCostForUsagesInCurrency.
+func CalculateCostForUsages(aiModel *AIModel, usages []ExecutionUsage, costMultiplier float64) (featuresUsed []AIModelFeature, totals ExecutionCostTotals, err error) {
+	return CalculateCostForUsagesInCurrency(aiModel, usages, costMultiplier, DEFAULT_CURRENCY, GetFXRateProvider(), time.Now())
+}
+
+// ExecutionCostOptions are the billing currency and the pricing circumstances of a cost calculation
+type ExecutionCostOptions struct {
+	Currency       Currency                `json:"currency"`
+	FXRateProvider FXRateProvider          `json:"-"`
+	Pricing        ExecutionPricingContext `json:"pricing"`
+}
+
+func NewExecutionCostOptions() ExecutionCostOptions {
+	return ExecutionCostOptions{
+		Currency:       DEFAULT_CURRENCY,
+		FXRateProvider: GetFXRateProvider(),
+		Pricing:        NewExecutionPricingContext(),
+	}
+}
+
+// CalculateCostForUsagesInCurrency prices the usages like CalculateCostForUsages and converts every cost item into
+// the billing currency (e.g. the currency of the org budget) with the rates of the provider at the given time.
+func CalculateCostForUsagesInCurrency(aiModel *AIModel, usages []ExecutionUsage, costMultiplier float64, currency Currency, provider FXRateProvider, at time.Time) (featuresUsed []AIModelFeature, totals ExecutionCostTotals, err error) {
+	options := NewExecutionCostOptions()
+	options.Currency = currency
+	options.FXRateProvider = provider
+	options.Pricing.At = at
+	return CalculateCostForUsagesWithOptions(aiModel, usages, costMultiplier, options)
+}
+
+// CalculateCostForUsagesWithOptions prices the usages with the pricing rules of the templates (tiers, batch discounts,
+// time of day) and converts the cost items into options.Currency.
+// a usage that cannot be priced does not stop the others: the priced usages are returned along with the joined errors.
+func CalculateCostForUsagesWithOptions(aiModel *AIModel, usages []ExecutionUsage, costMultiplier float64, options ExecutionCostOptions) (featuresUsed []AIModelFeature, totals ExecutionCostTotals, err error) {
+	var logName string = "[CalculateCostForUsagesWithOptions] "
+	featuresUsed = make([]AIModelFeature, 0)
+	totals = NewExecutionCostTotals(options.Currency)
+	usageErrs := make([]error, 0)
+	for _, usage := range usages {
+		usedFeats, usageErr := aiModel.calculateCostForUsage(usage, costMultiplier, options, &totals)
+		if usageErr != nil {
+			nuts.L.Errorf("%sfailed to price usage %s(%v) of model(%s): %v", logName, usage.CostUnit, usage.Amount, aiModel.ID, usageErr)
+			usageErrs = append(usageErrs, fmt.Errorf("%s: %w", usage.CostUnit, usageErr))
+			continue
+		}
+		featuresUsed = append(featuresUsed, usedFeats...)
+	}
+	return featuresUsed, totals, errors.Join(usageErrs...)
+}
+
+// calculateCostForUsage prices one usage and adds it to totals, unpriced usages are recorded in totals
+func (aiModel *AIModel) calculateCostForUsage(usage ExecutionUsage, costMultiplier float64, options ExecutionCostOptions, totals *ExecutionCostTotals) (usedFeats []AIModelFeature, err error) {
+	err = usage.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if usage.Amount == 0 {
+		return nil, nil
+	}
+	usedFeats, err = aiModel.CalculateUsageCostsForFeatureWithPricing(usage.Capability, usage.CostUnit, usage.Amount, costMultiplier, options.Pricing)
+	if err != nil {
+		return nil, err
+	}
+	if len(usedFeats) == 0 {
+		totals.UnpricedUsages = append(totals.UnpricedUsages, usage)
+		return nil, nil
+	}
+	err = ConvertFeatureCostsToCurrency(usedFeats, totals.Currency, options.FXRateProvider, options.Pricing.GetAt())
+	if err != nil {
+		return nil, err
+	}
+	err = totals.Add(usedFeats)
+	if err != nil {
+		return nil, err
+	}
+	return usedFeats, nil
+}
+
+func (aiModel *AIModel) CalculateCostForUsages(usages []ExecutionUsage, costMultiplier float64) (featuresUsed []AIModelFeature, totals ExecutionCostTotals, err error) {
+	return CalculateCostForUsages(aiModel, usages, costMultiplier)
+}
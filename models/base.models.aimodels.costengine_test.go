@@ -0,0 +1,144 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func mustParseMoney(t *testing.T, text string) Money {
+	t.Helper()
+	money, err := ParseMoney(text)
+	if err != nil {
+		t.Fatalf("ParseMoney(%q) error = %v", text, err)
+	}
+	return money
+}
+
+func newCostTestModel(features ...AIModelFeature) *AIModel {
+	aiModel := NewAIModel()
+	aiModel.ID = "cost-test"
+	aiModel.Features = features
+	return aiModel
+}
+
+func newCostTestFeature(capability AIModelCapability, templates ...ExecutionCostTemplate) AIModelFeature {
+	return AIModelFeature{Capability: capability, CostItemTemplates: templates}
+}
+
+func TestCalculateCostForUsagesPerCostUnit(t *testing.T) {
+	tests := []struct {
+		costUnit    AIModelCostUnit
+		capability  AIModelCapability
+		price       string
+		amount      float64
+		wantUnits   float64
+		wantCost    string // with multiplier 1.5
+		wantSrcCost string
+	}{
+		{AIModelCostUnitInputPerMillionTokens, AIModelCapabilityTextToText, "2.50", 400_000, 0.4, "1.5", "1"},
+		{AIModelCostUnitOutputPerMillionTokens, AIModelCapabilityTextToText, "10", 50_000, 0.05, "0.75", "0.5"},
+		{AIModelCostUnitCachedInputPerMillion, AIModelCapabilityTextToText, "1.25", 800_000, 0.8, "1.5", "1"},
+		{AIModelCostUnitCacheWritePerMillion, AIModelCapabilityTextToText, "3.75", 200_000, 0.2, "1.125", "0.75"},
+		{AIModelCostUnitInputPerMillionCharacters, AIModelCapabilityTextToSpeech, "15", 10_000, 0.01, "0.225", "0.15"},
+		{AIModelCostUnitImageInputPerFile, AIModelCapabilityImageToText, "0.002", 3, 3, "0.009", "0.006"},
+		{AIModelCostUnitAudioInputPerSecond, AIModelCapabilitySpeechToText, "0.0001", 90, 90, "0.0135", "0.009"},
+		{AIModelCostUnitVideoInputPerSecond, AIModelCapabilityVideoToText, "0.001", 30, 30, "0.045", "0.03"},
+		{AIModelCostUnitImageGenerationPerImage, AIModelCapabilityTextToImage, "0.04", 2, 2, "0.12", "0.08"},
+		{AIModelCostUnitImageGenerationPerPixel, AIModelCapabilityTextToImage, "0.00000004", 1024 * 1024, 1024 * 1024, "0.06291456", "0.04194304"},
+		{AIModelCostUnitAudioGenerationPerSecond, AIModelCapabilityTextToMusic, "0.002", 60, 60, "0.18", "0.12"},
+		{AIModelCostUnitVideoGenerationPerSecond, AIModelCapabilityTextToVideo, "0.5", 8, 8, "6", "4"},
+		{AIModelCostUnitPerFunctionCall, AIModelCapabilityFunctionCalling, "0.01", 4, 4, "0.06", "0.04"},
+	}
+	covered := make(map[AIModelCostUnit]bool)
+	for _, tt := range tests {
+		covered[tt.costUnit] = true
+		t.Run(string(tt.costUnit), func(t *testing.T) {
+			aiModel := newCostTestModel(newCostTestFeature(tt.capability, ExecutionCostTemplate{CostUnit: tt.costUnit, CostPerUnitInEuro: mustParseMoney(t, tt.price)}))
+			featuresUsed, totals, err := CalculateCostForUsages(aiModel, []ExecutionUsage{NewExecutionUsage(tt.capability, tt.costUnit, tt.amount)}, 1.5)
+			if err != nil {
+				t.Fatalf("CalculateCostForUsages() error = %v", err)
+			}
+			if len(featuresUsed) != 1 || len(featuresUsed[0].CostItems) != 1 {
+				t.Fatalf("featuresUsed = %+v, want one cost item", featuresUsed)
+			}
+			costItem := featuresUsed[0].CostItems[0]
+			if costItem.UsedUnits != tt.wantUnits {
+				t.Errorf("UsedUnits = %v, want %v", costItem.UsedUnits, tt.wantUnits)
+			}
+			if want := mustParseMoney(t, tt.wantCost); costItem.ResultingCostInEuro != want || totals.TotalCost != want {
+				t.Errorf("cost = %v (total %v), want %v", costItem.ResultingCostInEuro, totals.TotalCost, want)
+			}
+			if want := mustParseMoney(t, tt.wantSrcCost); totals.TotalSourceCost != want {
+				t.Errorf("source cost = %v, want %v", totals.TotalSourceCost, want)
+			}
+			if totals.TotalMargin != totals.TotalCost-totals.TotalSourceCost {
+				t.Errorf("margin = %v, want cost - source cost", totals.TotalMargin)
+			}
+		})
+	}
+	for _, costUnit := range AIModelCostUnits {
+		if !covered[costUnit] {
+			t.Errorf("cost unit %s has no test case", costUnit)
+		}
+	}
+}
+
+func TestCalculateCostForUsagesUnpricedAndInvalid(t *testing.T) {
+	aiModel := newCostTestModel(newCostTestFeature(AIModelCapabilityTextToText, ExecutionCostTemplate{CostUnit: AIModelCostUnitInputPerMillionTokens, CostPerUnitInEuro: mustParseMoney(t, "1")}))
+	tests := []struct {
+		name         string
+		usage        ExecutionUsage
+		wantErr      error
+		wantUnpriced int
+	}{
+		{name: "no template", usage: NewExecutionUsage(AIModelCapabilityTextToText, AIModelCostUnitOutputPerMillionTokens, 10), wantUnpriced: 1},
+		{name: "zero amount", usage: NewExecutionUsage(AIModelCapabilityTextToText, AIModelCostUnitOutputPerMillionTokens, 0)},
+		{name: "unknown unit", usage: NewExecutionUsage(AIModelCapabilityTextToText, "per-banana", 1), wantErr: ErrUnknownCostUnit},
+		{name: "negative amount", usage: NewExecutionUsage(AIModelCapabilityTextToText, AIModelCostUnitInputPerMillionTokens, -1), wantErr: ErrInvalidUsageAmount},
+		{name: "missing capability", usage: NewExecutionUsage("", AIModelCostUnitInputPerMillionTokens, 1), wantErr: ErrMissingUsageDetails},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, totals, err := CalculateCostForUsages(aiModel, []ExecutionUsage{tt.usage}, 1)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if len(totals.UnpricedUsages) != tt.wantUnpriced {
+				t.Fatalf("UnpricedUsages = %v, want %d", totals.UnpricedUsages, tt.wantUnpriced)
+			}
+		})
+	}
+}
+
+func TestCalculateCostForUsagesPricesRemainingUsagesOnError(t *testing.T) {
+	aiModel := newCostTestModel(
+		newCostTestFeature(AIModelCapabilityTextToText,
+			// no fx rate for USD, pricing the output tokens fails
+			ExecutionCostTemplate{CostUnit: AIModelCostUnitOutputPerMillionTokens, CostPerUnitInEuro: mustParseMoney(t, "10"), Currency: CurrencyUSD},
+			ExecutionCostTemplate{CostUnit: AIModelCostUnitInputPerMillionTokens, CostPerUnitInEuro: mustParseMoney(t, "2")},
+		),
+		newCostTestFeature(AIModelCapabilityFunctionCalling, ExecutionCostTemplate{CostUnit: AIModelCostUnitPerFunctionCall, CostPerUnitInEuro: mustParseMoney(t, "0.01")}),
+	)
+	options := NewExecutionCostOptions()
+	options.FXRateProvider = NewStaticFXRateProvider(nil)
+	options.Pricing.At = time.Now()
+
+	featuresUsed, totals, err := CalculateCostForUsagesWithOptions(aiModel, NewText2TextExecutionUsages(2, 1_000_000, 1_000_000), 1, options)
+	if !errors.Is(err, ErrFXRateNotFound) {
+		t.Fatalf("error = %v, want %v", err, ErrFXRateNotFound)
+	}
+	if len(featuresUsed) != 2 {
+		t.Fatalf("featuresUsed = %d, want the input and function call features", len(featuresUsed))
+	}
+	if want := mustParseMoney(t, "2.02"); totals.TotalCost != want {
+		t.Fatalf("TotalCost = %v, want %v", totals.TotalCost, want)
+	}
+
+	SetFXRateProvider(options.FXRateProvider)
+	defer SetFXRateProvider(nil)
+	featuresUsed, err = CalculateCostForText2Text(aiModel, 2, 1_000_000, 1_000_000, 1)
+	if !errors.Is(err, ErrFXRateNotFound) || len(featuresUsed) != 2 {
+		t.Fatalf("CalculateCostForText2Text() = %d features, error %v, want 2 features and %v", len(featuresUsed), err, ErrFXRateNotFound)
+	}
+}
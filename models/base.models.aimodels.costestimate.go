@@ -0,0 +1,214 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	nuts "github.com/vaudience/go-nuts"
+)
+
+const (
+	// chat formats wrap every message with role markers, providers bill them as input tokens
+	COSTESTIMATE_TOKENS_PER_MESSAGE = 4
+)
+
+var (
+	ErrNoMessagesToEstimate = errors.New("no messages to estimate the cost for")
+)
+
+// ExecutionCostEstimateParams describe the expected execution besides the input messages
+type ExecutionCostEstimateParams struct {
+	MinOutputTokens      int                  `json:"min_output_tokens"`
+	ExpectedOutputTokens int                  `json:"expected_output_tokens"` // capped at the max output tokens of the model
+	MaxOutputTokens      int                  `json:"max_output_tokens"`      // 0 uses the limit of the model
+	ExpectedToolCalls    int                  `json:"expected_tool_calls"`
+	MaxToolCalls         int                  `json:"max_tool_calls"`
+	CostMultiplier       float64              `json:"cost_multiplier"`
+	Options              ExecutionCostOptions `json:"options"` // same currency and pricing as the later calculation, so estimate and bill match
+} //@name ExecutionCostEstimateParams
+
+// ExecutionCostEstimate is the cost range of an execution before it runs, all costs are in Currency.
+// the min cost assumes MinOutputTokens and no tool calls, the max cost assumes the output limit and MaxToolCalls.
+type ExecutionCostEstimate struct {
+	AIModelID             string           `json:"ai_model_id"`
+	InputTokens           int              `json:"input_tokens"`
+	ImageInputs           int              `json:"image_inputs"`
+	MinOutputTokens       int              `json:"min_output_tokens"`
+	ExpectedOutputTokens  int              `json:"expected_output_tokens"`
+	MaxOutputTokens       int              `json:"max_output_tokens"`
+	ExceedsMaxInputTokens bool             `json:"exceeds_max_input_tokens"` // the model will reject or truncate the input
+	Currency              Currency         `json:"currency"`
+	MinCost               Money            `json:"min_cost"`
+	ExpectedCost          Money            `json:"expected_cost"`
+	MaxCost               Money            `json:"max_cost"`
+	UnpricedUsages        []ExecutionUsage `json:"unpriced_usages"` // the estimate is too low if the model has no template for these
+	CreatedAt             int64            `json:"created_at"`
+} //@name ExecutionCostEstimate
+
+func NewExecutionCostEstimateParams(expectedOutputTokens int) ExecutionCostEstimateParams {
+	return ExecutionCostEstimateParams{
+		ExpectedOutputTokens: expectedOutputTokens,
+		CostMultiplier:       1,
+		Options:              NewExecutionCostOptions(),
+	}
+}
+
+// GetInputTokenLimit returns the max input tokens of the model, taken from MaxInputTokens or an input token constraint, 0 if unlimited
+func (aiModel *AIModel) GetInputTokenLimit() int {
+	return aiModel.getTokenLimit(aiModel.MaxInputTokens, AIModelConstraintDirectionInput)
+}
+
+// GetOutputTokenLimit returns the max output tokens of the model, taken from MaxOutputTokens or an output token constraint, 0 if unlimited
+func (aiModel *AIModel) GetOutputTokenLimit() int {
+	return aiModel.getTokenLimit(aiModel.MaxOutputTokens, AIModelConstraintDirectionOutput)
+}
+
+func (aiModel *AIModel) getTokenLimit(maxTokens int, direction AIModelConstraintDirection) (limit int) {
+	limit = maxTokens
+	for _, constraint := range aiModel.Constraints {
+		if constraint.Direction != direction || constraint.Unit != AIModelMinMaxUnitTokens || constraint.Max <= 0 {
+			continue
+		}
+		if limit == 0 || int(constraint.Max) < limit {
+			limit = int(constraint.Max)
+		}
+	}
+	return limit
+}
+
+// EstimateExecutionCost estimates the cost of sending messages (system prompt, history and attachments) to the model.
+// it counts tokens with the tokenizer of the model and prices the usages with the same templates and options as CalculateCostForUsagesWithOptions.
+func EstimateExecutionCost(aiModel *AIModel, messages *AIgencyMessageList, params ExecutionCostEstimateParams) (estimate *ExecutionCostEstimate, err error) {
+	var logName string = "[EstimateExecutionCost] "
+	if messages == nil || messages.GetMessagesCount() == 0 {
+		return nil, ErrNoMessagesToEstimate
+	}
+	estimate = &ExecutionCostEstimate{
+		AIModelID:      aiModel.ID,
+		Currency:       params.Options.Currency.OrDefault(),
+		UnpricedUsages: make([]ExecutionUsage, 0),
+		CreatedAt:      nuts.TimeToJSTimestamp(time.Now()),
+	}
+	estimate.InputTokens, estimate.ImageInputs = countEstimateInputs(aiModel, messages)
+	if inputLimit := aiModel.GetInputTokenLimit(); inputLimit > 0 && estimate.InputTokens > inputLimit {
+		estimate.ExceedsMaxInputTokens = true
+	}
+	estimate.MaxOutputTokens = params.MaxOutputTokens
+	if outputLimit := aiModel.GetOutputTokenLimit(); outputLimit > 0 && (estimate.MaxOutputTokens == 0 || estimate.MaxOutputTokens > outputLimit) {
+		estimate.MaxOutputTokens = outputLimit
+	}
+	estimate.ExpectedOutputTokens = max(params.ExpectedOutputTokens, 0)
+	if estimate.MaxOutputTokens == 0 {
+		// no limit known, the expected output is the best guess for the worst case
+		estimate.MaxOutputTokens = estimate.ExpectedOutputTokens
+	}
+	estimate.ExpectedOutputTokens = min(estimate.ExpectedOutputTokens, estimate.MaxOutputTokens)
+	estimate.MinOutputTokens = min(max(params.MinOutputTokens, 0), estimate.ExpectedOutputTokens)
+
+	costMultiplier := params.CostMultiplier
+	if costMultiplier == 0 {
+		costMultiplier = 1
+	}
+	params.Options.Currency = estimate.Currency
+	if params.Options.FXRateProvider == nil {
+		params.Options.FXRateProvider = GetFXRateProvider()
+	}
+	cases := []struct {
+		cost         *Money
+		outputTokens int
+		toolCalls    int
+	}{
+		{&estimate.MinCost, estimate.MinOutputTokens, 0},
+		{&estimate.ExpectedCost, estimate.ExpectedOutputTokens, params.ExpectedToolCalls},
+		{&estimate.MaxCost, estimate.MaxOutputTokens, max(params.MaxToolCalls, params.ExpectedToolCalls)},
+	}
+	for _, estimateCase := range cases {
+		usages := estimate.getUsages(estimateCase.outputTokens, estimateCase.toolCalls)
+		_, totals, err := CalculateCostForUsagesWithOptions(aiModel, usages, costMultiplier, params.Options)
+		if err != nil {
+			nuts.L.Errorf("%sfailed to estimate the cost for model(%s): %v", logName, aiModel.ID, err)
+			return nil, err
+		}
+		*estimateCase.cost = totals.TotalCost
+		estimate.addUnpricedUsages(totals.UnpricedUsages)
+	}
+	return estimate, nil
+}
+
+// EstimateExecutionCost estimates the cost of sending messages to this model, see EstimateExecutionCost
+func (aiModel *AIModel) EstimateExecutionCost(messages *AIgencyMessageList, params ExecutionCostEstimateParams) (estimate *ExecutionCostEstimate, err error) {
+	return EstimateExecutionCost(aiModel, messages, params)
+}
+
+// CheckBudget tells if the max cost fits into the budgets, which must be in the currency of the estimate
+func (estimate *ExecutionCostEstimate) CheckBudget(budgets ...*OrgCostBudget) (check OrgCostBudgetCheck, err error) {
+	for _, budget := range budgets {
+		if budget.Currency.OrDefault() != estimate.Currency {
+			return OrgCostBudgetCheck{OrgID: budget.OrgID}, fmt.Errorf("%w: %s->%s", ErrCurrencyMismatch, estimate.Currency, budget.Currency.OrDefault())
+		}
+	}
+	return NewOrgCostBudgetCheck(estimate.MaxCost, budgets...), nil
+}
+
+func (estimate *ExecutionCostEstimate) getUsages(outputTokens int, toolCalls int) []ExecutionUsage {
+	return []ExecutionUsage{
+		NewExecutionUsage(AIModelCapabilityTextToText, AIModelCostUnitInputPerMillionTokens, float64(estimate.InputTokens)),
+		NewExecutionUsage(AIModelCapabilityTextToText, AIModelCostUnitOutputPerMillionTokens, float64(outputTokens)),
+		NewExecutionUsage(AIModelCapabilityImageToText, AIModelCostUnitImageInputPerFile, float64(estimate.ImageInputs)),
+		NewExecutionUsage(AIModelCapabilityFunctionCalling, AIModelCostUnitPerFunctionCall, float64(toolCalls)),
+	}
+}
+
+func (estimate *ExecutionCostEstimate) addUnpricedUsages(usages []ExecutionUsage) {
+	for _, usage := range usages {
+		known := false
+		for n, existing := range estimate.UnpricedUsages {
+			if existing.Capability == usage.Capability && existing.CostUnit == usage.CostUnit {
+				estimate.UnpricedUsages[n].Amount = max(existing.Amount, usage.Amount)
+				known = true
+				break
+			}
+		}
+		if !known {
+			estimate.UnpricedUsages = append(estimate.UnpricedUsages, usage)
+		}
+	}
+}
+
+// countEstimateInputs counts the text tokens of all messages with the tokenizer of the model and the image files, attached or in the content.
+// the messages are not changed, images without id are counted each
+func countEstimateInputs(aiModel *AIModel, messages *AIgencyMessageList) (inputTokens int, imageInputs int) {
+	for _, msg := range messages.GetMessages() {
+		if msg == nil {
+			continue
+		}
+		inputTokens += COSTESTIMATE_TOKENS_PER_MESSAGE
+		imageIDs := make(map[string]bool)
+		countImage := func(file *AIgencyMessageFile) {
+			if file == nil || !strings.HasPrefix(file.MimeType, "image/") {
+				return
+			}
+			if file.ID != "" {
+				if imageIDs[file.ID] {
+					return
+				}
+				imageIDs[file.ID] = true
+			}
+			imageInputs++
+		}
+		if msg.Content != nil {
+			inputTokens += msg.Content.TokenCount(aiModel)
+			for _, content := range msg.Content.GetContentByType(AIgencyMessageContentTypeFile) {
+				countImage(content.File)
+			}
+		}
+		if msg.Attachments != nil {
+			for _, file := range msg.Attachments.GetFiles() {
+				countImage(file)
+			}
+		}
+	}
+	return inputTokens, imageInputs
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestCountEstimateInputs(t *testing.T) {
+	aiModel := &AIModel{ID: "estimate-test", ModelID: "gemini-test"}
+	text := "describe these pictures"
+	sharedImage := NewAIgencyMessageFile("shared.png", "", "image/png", "https://example.com/shared.png")
+
+	msg := NewAIgencyMessage()
+	msg.TokenCount = 999
+	msg.Content.AddContent(NewAIgencyMessageContent(AIgencyMessageContentTypeText, &text, nil))
+	for _, file := range []*AIgencyMessageFile{
+		{FileName: "a.png", MimeType: "image/png"},
+		{FileName: "b.jpg", MimeType: "image/jpeg"},
+		sharedImage,
+		{FileName: "notes.pdf", MimeType: "application/pdf"},
+	} {
+		msg.Content.AddContent(NewAIgencyMessageContent(AIgencyMessageContentTypeFile, nil, file))
+	}
+	msg.Attachments.AddFile(sharedImage)
+	msg.Attachments.AddFile(&AIgencyMessageFile{FileName: "c.png", MimeType: "image/png"})
+	messages := NewAIgencyMessageList()
+	messages.AddMessage(msg)
+
+	inputTokens, imageInputs := countEstimateInputs(aiModel, messages)
+	if imageInputs != 4 {
+		t.Errorf("imageInputs = %d, want 4: three images without id and the shared one once", imageInputs)
+	}
+	if want := COSTESTIMATE_TOKENS_PER_MESSAGE + msg.Content.TokenCount(aiModel); inputTokens != want {
+		t.Errorf("inputTokens = %d, want %d", inputTokens, want)
+	}
+	if msg.TokenCount != 999 {
+		t.Errorf("TokenCount of the message = %d, want it untouched", msg.TokenCount)
+	}
+}
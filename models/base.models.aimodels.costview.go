@@ -0,0 +1,56 @@
+package models
+
+import (
+	"reflect"
+)
+
+// ExecutionCostView is the cost of an execution as one role may see it, built from the readxs tags of the cost types:
+// admins see source costs, multipliers and margins, org admins the billed costs per feature and owners the totals only.
+type ExecutionCostView struct {
+	Role     string `json:"role"`
+	Totals   any    `json:"totals"`
+	Features []any  `json:"features,omitempty"`
+} //@name ExecutionCostView
+
+// NewExecutionCostView sums up the used features in their billed currency and filters features and totals for role
+func NewExecutionCostView(role string, featuresUsed []AIModelFeature) (view *ExecutionCostView, err error) {
+	totals := NewExecutionCostTotals(getBilledCurrency(featuresUsed))
+	err = totals.Add(featuresUsed)
+	if err != nil {
+		return nil, err
+	}
+	return NewExecutionCostViewWithTotals(role, featuresUsed, totals), nil
+}
+
+// NewExecutionCostViewWithTotals filters features and totals for role, features are left out if the role cannot read their cost items
+func NewExecutionCostViewWithTotals(role string, featuresUsed []AIModelFeature, totals ExecutionCostTotals) *ExecutionCostView {
+	view := &ExecutionCostView{
+		Role:   role,
+		Totals: FilterReadableFields(totals, role),
+	}
+	costItemsField, _ := reflect.TypeOf(AIModelFeature{}).FieldByName("CostItems")
+	if !CanRead(costItemsField.Tag.Get(READXS_TAG), role) {
+		return view
+	}
+	view.Features = make([]any, 0, len(featuresUsed))
+	for _, feature := range featuresUsed {
+		view.Features = append(view.Features, FilterReadableFields(feature, role))
+	}
+	return view
+}
+
+// GetCostView returns the costs of the execution as role may see them
+func (result *ExecutionResult) GetCostView(role string) (view *ExecutionCostView, err error) {
+	return NewExecutionCostView(role, result.FeaturesUsed)
+}
+
+// getBilledCurrency returns the billed currency of the first cost item, DEFAULT_CURRENCY without cost items
+func getBilledCurrency(featuresUsed []AIModelFeature) Currency {
+	for _, feature := range featuresUsed {
+		for _, costItem := range feature.CostItems {
+			_, _, currency := costItem.GetBilledCost()
+			return currency
+		}
+	}
+	return DEFAULT_CURRENCY
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestExecutionResultJSONHidesSourceCosts(t *testing.T) {
+	aiModel := newCostTestModel(newCostTestFeature(AIModelCapabilityTextToText, ExecutionCostTemplate{
+		CostUnit:          AIModelCostUnitInputPerMillionTokens,
+		CostPerUnitInEuro: mustParseMoney(t, "2"),
+		TierMode:          ExecutionCostTierModeGraduated,
+		PriceTiers:        []ExecutionCostPriceTier{{FromUnits: 1_000_000, CostPerUnitInEuro: mustParseMoney(t, "1")}},
+	}))
+	featuresUsed, totals, err := CalculateCostForUsages(aiModel, NewText2TextExecutionUsages(0, 2_000_000, 0), 1.5)
+	if err != nil {
+		t.Fatalf("CalculateCostForUsages() error = %v", err)
+	}
+	result := ExecutionResult{ExecutionID: "exec", ModelID: aiModel.ID, FeaturesUsed: featuresUsed}
+
+	resultJson, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	totalsJson, _ := json.Marshal(totals)
+	for _, secret := range []string{"source", "margin", "cost_multiplier"} {
+		if strings.Contains(string(resultJson), secret) || strings.Contains(string(totalsJson), secret) {
+			t.Errorf("%s is marshalled: %s %s", secret, resultJson, totalsJson)
+		}
+	}
+	var decoded ExecutionResult
+	if err := json.Unmarshal(resultJson, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	costItem := decoded.FeaturesUsed[0].CostItems[0]
+	if want := mustParseMoney(t, "3"); costItem.CostPerUnit != want {
+		t.Errorf("marshalled billed price = %v, want %v", costItem.CostPerUnit, want)
+	}
+	if costItem.CostPerUnitInEuro != 0 || len(costItem.PriceTiers) != 0 {
+		t.Errorf("marshalled template prices = %v and %+v, want the source prices left out", costItem.CostPerUnitInEuro, costItem.PriceTiers)
+	}
+	if want := []Money{mustParseMoney(t, "3"), mustParseMoney(t, "1.5")}; len(costItem.PricedBands) != 2 || costItem.PricedBands[0].CostPerUnitInEuro != want[0] || costItem.PricedBands[1].CostPerUnitInEuro != want[1] {
+		t.Errorf("marshalled priced bands = %+v, want the billed prices %v", costItem.PricedBands, want)
+	}
+	sourceTemplate := aiModel.Features[0].CostItemTemplates[0]
+	if usedTemplate := featuresUsed[0].CostItems[0].ExecutionCostTemplate; usedTemplate.CostPerUnitInEuro != sourceTemplate.CostPerUnitInEuro || usedTemplate.PriceTiers[0] != sourceTemplate.PriceTiers[0] {
+		t.Errorf("template copy of the cost item = %+v, want the unchanged source template %+v", usedTemplate, sourceTemplate)
+	}
+
+	tests := []struct {
+		role        string
+		wantSecrets bool
+	}{
+		{role: READXS_ROLE_ADMIN, wantSecrets: true},
+		{role: READXS_ROLE_ORG_ADMIN},
+		{role: READXS_ROLE_OWNER},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			view, err := result.GetCostView(tt.role)
+			if err != nil {
+				t.Fatalf("GetCostView() error = %v", err)
+			}
+			viewJson, _ := json.Marshal(view)
+			var decodedView struct {
+				Features []struct {
+					CostItems []map[string]any `json:"cost_items"`
+				} `json:"features"`
+			}
+			if err := json.Unmarshal(viewJson, &decodedView); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+			if len(decodedView.Features) > 0 && len(decodedView.Features[0].CostItems) > 0 {
+				viewItem := decodedView.Features[0].CostItems[0]
+				sourcePrice, hasSourcePrice := viewItem["cost_per_unit_in_euro"]
+				_, hasSourceTiers := viewItem["price_tiers"]
+				if hasSourcePrice != tt.wantSecrets || hasSourceTiers != tt.wantSecrets {
+					t.Errorf("template prices in the view of %s = %v and %v, want %v", tt.role, hasSourcePrice, hasSourceTiers, tt.wantSecrets)
+				}
+				if hasSourcePrice && sourcePrice != float64(2) {
+					t.Errorf("source price in the view of %s = %v, want 2", tt.role, sourcePrice)
+				}
+			} else if tt.wantSecrets {
+				t.Errorf("view of %s has no cost items: %s", tt.role, viewJson)
+			}
+			for _, secret := range []string{"source_cost_per_unit", "resulting_source_cost_in_euro", "resulting_margin_in_euro", "cost_multiplier", "total_source_cost", "total_margin"} {
+				if strings.Contains(string(viewJson), `"`+secret+`"`) != tt.wantSecrets {
+					t.Errorf("%s in the view of %s = %v, want %v: %s", secret, tt.role, !tt.wantSecrets, tt.wantSecrets, viewJson)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	nuts "github.com/vaudience/go-nuts"
+)
+
+var (
+	ErrAllModelsFailed       = errors.New("all models of the fallback chain failed")
+	ErrNoModelInChain        = errors.New("no usable model in the fallback chain")
+	ErrNoExecutionResult     = errors.New("execution returned no result")
+	ErrNoModelLookupForChain = errors.New("no model lookup configured for the failover executor")
+)
+
+const (
+	AIModelRejectionModelNotFound AIModelRejectionCode = "model-not-found"
+)
+
+// ExecutionErrorClass groups provider errors by how they should be handled
+type ExecutionErrorClass string //@name ExecutionErrorClass
+
+const (
+	ExecutionErrorClassUnavailable    ExecutionErrorClass = "unavailable"
+	ExecutionErrorClassTimeout        ExecutionErrorClass = "timeout"
+	ExecutionErrorClassRateLimited    ExecutionErrorClass = "rate-limited"
+	ExecutionErrorClassServerError    ExecutionErrorClass = "server-error"
+	ExecutionErrorClassAuthentication ExecutionErrorClass = "authentication"
+	ExecutionErrorClassInvalidRequest ExecutionErrorClass = "invalid-request"
+	ExecutionErrorClassContentFilter  ExecutionErrorClass = "content-filter"
+	ExecutionErrorClassContextLength  ExecutionErrorClass = "context-length"
+	ExecutionErrorClassUnknown        ExecutionErrorClass = "unknown"
+)
+
+// DEFAULT_FAILOVER_ERROR_CLASSES are the errors another model can be expected to not have
+var DEFAULT_FAILOVER_ERROR_CLASSES = []ExecutionErrorClass{
+	ExecutionErrorClassUnavailable,
+	ExecutionErrorClassTimeout,
+	ExecutionErrorClassRateLimited,
+	ExecutionErrorClassServerError,
+}
+
+// ExecutionError is returned by model services that know the class of their error, e.g. from the http status of the provider
+type ExecutionError struct {
+	Class      ExecutionErrorClass
+	StatusCode int
+	Err        error
+}
+
+// ExecutionAttempt records a model of a fallback chain that failed
+type ExecutionAttempt struct {
+	ModelID      string              `json:"model_id"`
+	ServiceID    string              `json:"service_id"`
+	ErrorClass   ExecutionErrorClass `json:"error_class"`
+	ErrorMessage string              `json:"error_message"`
+	Timestamp    int64               `json:"timestamp"`   // in Milliseconds unix epoch
+	TimeNeeded   int64               `json:"time_needed"` // in Milliseconds
+} //@name ExecutionAttempt
+
+func NewExecutionError(class ExecutionErrorClass, statusCode int, err error) *ExecutionError {
+	return &ExecutionError{
+		Class:      class,
+		StatusCode: statusCode,
+		Err:        err,
+	}
+}
+
+func (executionError *ExecutionError) Error() string {
+	if executionError.Err == nil {
+		return string(executionError.Class)
+	}
+	return fmt.Sprintf("%s: %v", executionError.Class, executionError.Err)
+}
+
+func (executionError *ExecutionError) Unwrap() error {
+	return executionError.Err
+}
+
+// ClassifyExecutionError returns the class of an ExecutionError, otherwise it guesses it from well known errors and messages
+func ClassifyExecutionError(err error) ExecutionErrorClass {
+	if err == nil {
+		return ""
+	}
+	var executionError *ExecutionError
+	if errors.As(err, &executionError) {
+		return executionError.Class
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ExecutionErrorClassTimeout
+	}
+	var netError net.Error
+	if errors.As(err, &netError) && netError.Timeout() {
+		return ExecutionErrorClassTimeout
+	}
+	message := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(message, "429") || strings.Contains(message, "rate limit") || strings.Contains(message, "too many requests"):
+		return ExecutionErrorClassRateLimited
+	case strings.Contains(message, "timeout") || strings.Contains(message, "timed out"):
+		return ExecutionErrorClassTimeout
+	case strings.Contains(message, "context length") || strings.Contains(message, "context_length") || strings.Contains(message, "maximum context"):
+		return ExecutionErrorClassContextLength
+	case strings.Contains(message, "401") || strings.Contains(message, "403") || strings.Contains(message, "unauthorized") || strings.Contains(message, "api key"):
+		return ExecutionErrorClassAuthentication
+	case strings.Contains(message, "content filter") || strings.Contains(message, "content_filter") || strings.Contains(message, "content policy"):
+		return ExecutionErrorClassContentFilter
+	case strings.Contains(message, "503") || strings.Contains(message, "unavailable") || strings.Contains(message, "overloaded") || strings.Contains(message, "connection refused") || strings.Contains(message, "no such host"):
+		return ExecutionErrorClassUnavailable
+	case strings.Contains(message, "500") || strings.Contains(message, "502") || strings.Contains(message, "504") || strings.Contains(message, "internal server error"):
+		return ExecutionErrorClassServerError
+	case strings.Contains(message, "400") || strings.Contains(message, "invalid request") || strings.Contains(message, "bad request"):
+		return ExecutionErrorClassInvalidRequest
+	}
+	return ExecutionErrorClassUnknown
+}
+
+// GetModelChain returns ModelID followed by the fallback models, without duplicates
+func (agent *Agent) GetModelChain() (modelIDs []string) {
+	known := make(map[string]bool)
+	for _, modelID := range append([]string{agent.ModelID}, agent.FallbackModelIDs...) {
+		if modelID == "" || known[modelID] {
+			continue
+		}
+		known[modelID] = true
+		modelIDs = append(modelIDs, modelID)
+	}
+	return modelIDs
+}
+
+// ShouldFailover tells if an error of this class moves on to the next model of the chain
+func (agent *Agent) ShouldFailover(class ExecutionErrorClass) bool {
+	classes := agent.FailoverErrorClasses
+	if len(classes) == 0 {
+		classes = DEFAULT_FAILOVER_ERROR_CLASSES
+	}
+	for _, failoverClass := range classes {
+		if failoverClass == class {
+			return true
+		}
+	}
+	return false
+}
+
+// AIModelExecuteFunc runs the request on one model of the chain
+type AIModelExecuteFunc func(ctx context.Context, aiModel *AIModel) (result *ExecutionResult, err error)
+
+// AIModelFailoverExecutor runs requests on the model chain of an agent, moving on to the next model on failover errors
+type AIModelFailoverExecutor struct {
+	GetModel          func(modelID string) (aiModel *AIModel, err error)
+	GetCostMultiplier func(aiModel *AIModel) float64 // for pricing ExecutionResult.Usages, 1 if nil
+}
+
+func NewAIModelFailoverExecutor(getModel func(modelID string) (aiModel *AIModel, err error)) *AIModelFailoverExecutor {
+	return &AIModelFailoverExecutor{
+		GetModel: getModel,
+	}
+}
+
+// ResolveModelChain looks up the models of the chain. fallback models outside the ModelHostLocation of the agent
+// are left out like unknown ones, so a failover never moves data to another location. the pinned model is always kept.
+func (executor *AIModelFailoverExecutor) ResolveModelChain(agent *Agent) (chain []*AIModel, rejections []AIModelRejection, err error) {
+	if executor.GetModel == nil {
+		return nil, nil, ErrNoModelLookupForChain
+	}
+	chain = make([]*AIModel, 0)
+	rejections = make([]AIModelRejection, 0)
+	var allowedLocations []HostingLocation
+	if agent.ModelHostLocation != "" {
+		allowedLocations = []HostingLocation{agent.ModelHostLocation}
+	}
+	for n, modelID := range agent.GetModelChain() {
+		aiModel, lookupErr := executor.GetModel(modelID)
+		if lookupErr != nil || aiModel == nil {
+			rejections = append(rejections, AIModelRejection{ModelID: modelID, Reasons: []AIModelRejectionReason{{Code: AIModelRejectionModelNotFound, Message: fmt.Sprintf("model lookup failed: %v", lookupErr)}}})
+			continue
+		}
+		if n > 0 && !aiModel.IsHostedIn(allowedLocations) {
+			rejections = append(rejections, AIModelRejection{ModelID: modelID, ModelName: aiModel.Name, Reasons: []AIModelRejectionReason{{Code: AIModelRejectionLocationNotAllowed, Message: fmt.Sprintf("hosted in (%v), the agent requires (%s)", aiModel.ServiceHostLocations, agent.ModelHostLocation)}}})
+			continue
+		}
+		chain = append(chain, aiModel)
+	}
+	if len(chain) == 0 {
+		return chain, rejections, ErrNoModelInChain
+	}
+	return chain, rejections, nil
+}
+
+// Execute runs execute on the model chain of the agent until a model serves the request.
+// the result names the serving model, the requested one and the failed attempts; its costs are those of the serving model.
+// if every model fails, the result of the last attempt (or an empty one) is returned with ErrAllModelsFailed.
+// an error that does not fail over stops the chain and is returned as ExecutionError with its class.
+func (executor *AIModelFailoverExecutor) Execute(ctx context.Context, agent *Agent, execute AIModelExecuteFunc) (result *ExecutionResult, err error) {
+	var logName string = "[AIModelFailoverExecutor.Execute] "
+	chain, _, err := executor.ResolveModelChain(agent)
+	if err != nil {
+		return nil, err
+	}
+	attempts := make([]ExecutionAttempt, 0)
+	for n, aiModel := range chain {
+		startedAt := time.Now()
+		result, err = execute(ctx, aiModel)
+		if err == nil && result == nil {
+			err = ErrNoExecutionResult
+		}
+		if err == nil {
+			err = executor.completeResult(result, aiModel, agent, attempts)
+			return result, err
+		}
+		class := ClassifyExecutionError(err)
+		attempts = append(attempts, ExecutionAttempt{
+			ModelID:      aiModel.ID,
+			ServiceID:    aiModel.ServiceID,
+			ErrorClass:   class,
+			ErrorMessage: err.Error(),
+			Timestamp:    nuts.TimeToJSTimestamp(startedAt),
+			TimeNeeded:   time.Since(startedAt).Milliseconds(),
+		})
+		if ctx.Err() != nil || !agent.ShouldFailover(class) {
+			break
+		}
+		if n < len(chain)-1 {
+			nuts.L.Infof("%smodel(%s) of agent(%s) failed with (%s), failing over to model(%s): %v", logName, aiModel.ID, agent.ID, class, chain[n+1].ID, err)
+		}
+	}
+	if result == nil {
+		result = &ExecutionResult{Timestamp: nuts.TimeToJSTimestamp(time.Now())}
+	}
+	result.RequestedModelID = agent.ModelID
+	result.FailedAttempts = attempts
+	result.ErrorMessage = err.Error()
+	if len(attempts) < len(chain) {
+		var executionError *ExecutionError
+		if !errors.As(err, &executionError) {
+			err = NewExecutionError(ClassifyExecutionError(err), 0, err)
+		}
+		return result, err
+	}
+	return result, fmt.Errorf("%w: %w", ErrAllModelsFailed, err)
+}
+
+// completeResult records the failover on the result and prices its usages against the serving model at the execution timestamp
+func (executor *AIModelFailoverExecutor) completeResult(result *ExecutionResult, servingModel *AIModel, agent *Agent, attempts []ExecutionAttempt) (err error) {
+	if result.ModelID != "" && result.ModelID != servingModel.ID {
+		nuts.L.Errorf("[AIModelFailoverExecutor.completeResult] result names model(%s) but was served by model(%s)", result.ModelID, servingModel.ID)
+	}
+	result.ModelID = servingModel.ID
+	result.ServiceID = servingModel.ServiceID
+	result.RequestedModelID = agent.ModelID
+	result.FailedAttempts = attempts
+	if len(result.FeaturesUsed) > 0 || len(result.Usages) == 0 {
+		return nil
+	}
+	costMultiplier := 1.0
+	if executor.GetCostMultiplier != nil {
+		costMultiplier = executor.GetCostMultiplier(servingModel)
+	}
+	_, err = result.CalculateCost(servingModel, costMultiplier)
+	return err
+}
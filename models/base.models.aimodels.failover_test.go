@@ -0,0 +1,72 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestAIModelFailoverExecutorExecute(t *testing.T) {
+	models := map[string]*AIModel{}
+	for _, modelID := range []string{"primary", "fallback-1", "fallback-2"} {
+		models[modelID] = &AIModel{ID: modelID, ServiceID: modelID + "-service"}
+	}
+	executor := NewAIModelFailoverExecutor(func(modelID string) (*AIModel, error) {
+		if aiModel, found := models[modelID]; found {
+			return aiModel, nil
+		}
+		return nil, fmt.Errorf("unknown model %s", modelID)
+	})
+	unavailable := NewExecutionError(ExecutionErrorClassUnavailable, 503, errors.New("down"))
+
+	tests := []struct {
+		name         string
+		errs         map[string]error // by model id, models without error serve the request
+		wantServedBy string
+		wantAttempts []string
+		wantErr      error
+		wantClass    ExecutionErrorClass
+	}{
+		{name: "primary serves", wantServedBy: "primary", wantAttempts: []string{}},
+		{name: "fails over to the first fallback", errs: map[string]error{"primary": unavailable}, wantServedBy: "fallback-1", wantAttempts: []string{"primary"}},
+		{name: "every model failed", errs: map[string]error{"primary": unavailable, "fallback-1": unavailable, "fallback-2": unavailable}, wantAttempts: []string{"primary", "fallback-1", "fallback-2"}, wantErr: ErrAllModelsFailed, wantClass: ExecutionErrorClassUnavailable},
+		{name: "invalid request stops the chain", errs: map[string]error{"primary": errors.New("400 bad request")}, wantAttempts: []string{"primary"}, wantClass: ExecutionErrorClassInvalidRequest},
+		{name: "stop after a fallback failed", errs: map[string]error{"primary": unavailable, "fallback-1": NewExecutionError(ExecutionErrorClassContentFilter, 400, errors.New("flagged"))}, wantAttempts: []string{"primary", "fallback-1"}, wantClass: ExecutionErrorClassContentFilter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := &Agent{ID: "agent", ModelID: "primary", FallbackModelIDs: []string{"fallback-1", "fallback-2"}}
+			result, err := executor.Execute(context.Background(), agent, func(ctx context.Context, aiModel *AIModel) (*ExecutionResult, error) {
+				if err := tt.errs[aiModel.ID]; err != nil {
+					return nil, err
+				}
+				return &ExecutionResult{ExecutionID: "exec"}, nil
+			})
+			if tt.wantClass == "" {
+				if err != nil {
+					t.Fatalf("Execute() error = %v", err)
+				}
+				if result.ModelID != tt.wantServedBy || result.RequestedModelID != "primary" {
+					t.Errorf("served by %s requested %s, want %s requested primary", result.ModelID, result.RequestedModelID, tt.wantServedBy)
+				}
+			} else {
+				if errors.Is(err, ErrAllModelsFailed) != (tt.wantErr == ErrAllModelsFailed) {
+					t.Fatalf("Execute() error = %v, want ErrAllModelsFailed %v", err, tt.wantErr == ErrAllModelsFailed)
+				}
+				var executionError *ExecutionError
+				if !errors.As(err, &executionError) || executionError.Class != tt.wantClass {
+					t.Fatalf("Execute() error = %v, want an ExecutionError of class %s", err, tt.wantClass)
+				}
+			}
+			attempts := make([]string, 0)
+			for _, attempt := range result.FailedAttempts {
+				attempts = append(attempts, attempt.ModelID)
+			}
+			if !reflect.DeepEqual(attempts, tt.wantAttempts) {
+				t.Errorf("failed attempts = %v, want %v", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
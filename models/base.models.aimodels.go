@@ -2,7 +2,9 @@
 package models
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"strings"
 	"time"
 
@@ -42,14 +44,47 @@ const (
 	AIModelCapabilityImageToText              AIModelCapability = "image-to-text"
 	AIModelCapabilityVideoToText              AIModelCapability = "video-to-text"
 	AIModelCapabilityVideoToTextStreaming     AIModelCapability = "video-to-text_streaming"
+	AIModelCapabilityEmbeddings               AIModelCapability = "embeddings"
 )
 
+var AIModelCapabilities = []AIModelCapability{
+	AIModelCapabilityFunctionCalling,
+	AIModelCapabilityFunctionCallingStreaming,
+	AIModelCapabilityAcceptsDocumentFiles,
+	AIModelCapabilityTextToText,
+	AIModelCapabilityTextToTextStreaming,
+	AIModelCapabilityTextToImage,
+	AIModelCapabilityTextToSpeech,
+	AIModelCapabilityTextToSpeechStreaming,
+	AIModelCapabilityTextToMusic,
+	AIModelCapabilityTextToMusicStreaming,
+	AIModelCapabilityTextToVideo,
+	AIModelCapabilityTextToVideoStreaming,
+	AIModelCapabilitySpeechToText,
+	AIModelCapabilitySpeechToTextStreaming,
+	AIModelCapabilityImageToText,
+	AIModelCapabilityVideoToText,
+	AIModelCapabilityVideoToTextStreaming,
+	AIModelCapabilityEmbeddings,
+}
+
+func (capability AIModelCapability) IsValid() bool {
+	for _, known := range AIModelCapabilities {
+		if capability == known {
+			return true
+		}
+	}
+	return false
+}
+
 // cost units for model Features
 type AIModelCostUnit string //@name AIModelCostUnit
 
 const (
 	AIModelCostUnitInputPerMillionTokens     AIModelCostUnit = "input-tokens-per-million"
 	AIModelCostUnitOutputPerMillionTokens    AIModelCostUnit = "output-tokens-per-million"
+	AIModelCostUnitCachedInputPerMillion     AIModelCostUnit = "cached-input-tokens-per-million"
+	AIModelCostUnitCacheWritePerMillion      AIModelCostUnit = "cache-write-tokens-per-million"
 	AIModelCostUnitInputPerMillionCharacters AIModelCostUnit = "input-characters-per-million"
 	AIModelCostUnitImageInputPerFile         AIModelCostUnit = "image-input-file"
 	AIModelCostUnitAudioInputPerSecond       AIModelCostUnit = "audio-input-per-second"
@@ -61,13 +96,70 @@ const (
 	AIModelCostUnitPerFunctionCall           AIModelCostUnit = "per-function-call"
 )
 
+var AIModelCostUnits = []AIModelCostUnit{
+	AIModelCostUnitInputPerMillionTokens,
+	AIModelCostUnitOutputPerMillionTokens,
+	AIModelCostUnitCachedInputPerMillion,
+	AIModelCostUnitCacheWritePerMillion,
+	AIModelCostUnitInputPerMillionCharacters,
+	AIModelCostUnitImageInputPerFile,
+	AIModelCostUnitAudioInputPerSecond,
+	AIModelCostUnitVideoInputPerSecond,
+	AIModelCostUnitImageGenerationPerImage,
+	AIModelCostUnitImageGenerationPerPixel,
+	AIModelCostUnitAudioGenerationPerSecond,
+	AIModelCostUnitVideoGenerationPerSecond,
+	AIModelCostUnitPerFunctionCall,
+}
+
+func (unit AIModelCostUnit) String() string {
+	return string(unit)
+}
+
+func (unit AIModelCostUnit) IsValid() bool {
+	for _, knownUnit := range AIModelCostUnits {
+		if unit == knownUnit {
+			return true
+		}
+	}
+	return false
+}
+
+// UnitDivisor is the number of raw units (tokens, characters, pixels, seconds, ...) one priced unit consists of
+func (unit AIModelCostUnit) UnitDivisor() float64 {
+	switch unit {
+	case AIModelCostUnitInputPerMillionTokens, AIModelCostUnitOutputPerMillionTokens, AIModelCostUnitCachedInputPerMillion, AIModelCostUnitCacheWritePerMillion, AIModelCostUnitInputPerMillionCharacters:
+		return 1_000_000
+	default:
+		return 1
+	}
+}
+
+// AIModelConstraintDirection tells if a constraint applies to the request sent to the model or to what it returns.
+// the bounds themselves are Min and Max of the AIModelConstraint.
 type AIModelConstraintDirection string //@name AIModelConstraintDirection
 
 const (
-	AIModelConstraintDirectionMin AIModelConstraintDirection = "input"
-	AIModelConstraintDirectionMax AIModelConstraintDirection = "output"
+	AIModelConstraintDirectionInput  AIModelConstraintDirection = "input"
+	AIModelConstraintDirectionOutput AIModelConstraintDirection = "output"
+
+	// Deprecated: the value is "input", use AIModelConstraintDirectionInput
+	AIModelConstraintDirectionMin = AIModelConstraintDirectionInput
+	// Deprecated: the value is "output", use AIModelConstraintDirectionOutput
+	AIModelConstraintDirectionMax = AIModelConstraintDirectionOutput
 )
 
+var AIModelConstraintDirections = []AIModelConstraintDirection{AIModelConstraintDirectionInput, AIModelConstraintDirectionOutput}
+
+func (direction AIModelConstraintDirection) IsValid() bool {
+	for _, known := range AIModelConstraintDirections {
+		if direction == known {
+			return true
+		}
+	}
+	return false
+}
+
 type AIModelMinMaxUnit string //@name AIModelMinMaxUnit
 
 const (
@@ -80,11 +172,22 @@ const (
 	AIModelMinMaxUnitFilesizeMegabytes AIModelMinMaxUnit = "megabytes"
 )
 
+var AIModelMinMaxUnits = []AIModelMinMaxUnit{AIModelMinMaxUnitTokens, AIModelMinMaxUnitCharacters, AIModelMinMaxUnitFiles, AIModelMinMaxUnitSeconds, AIModelMinMaxUnitImages, AIModelMinMaxUnitPixels, AIModelMinMaxUnitFilesizeMegabytes}
+
+func (unit AIModelMinMaxUnit) IsValid() bool {
+	for _, known := range AIModelMinMaxUnits {
+		if unit == known {
+			return true
+		}
+	}
+	return false
+}
+
 type AIModelConstraint struct {
-	Direction AIModelConstraintDirection `json:"direction" writexs:"system:struct,admin:struct" readxs:"*"`
-	Min       float64                    `json:"min" writexs:"system:struct,admin:struct" readxs:"*"`
-	Max       float64                    `json:"max" writexs:"system:struct,admin:struct" readxs:"*"`
-	Unit      AIModelMinMaxUnit          `json:"unit" writexs:"system:struct,admin:struct" readxs:"*"`
+	Direction AIModelConstraintDirection `json:"direction" validate:"oneof=input output" writexs:"system:struct,admin:struct" readxs:"*"`
+	Min       float64                    `json:"min" validate:"gte=0" writexs:"system:struct,admin:struct" readxs:"*"`
+	Max       float64                    `json:"max" validate:"gte=0" writexs:"system:struct,admin:struct" readxs:"*"`
+	Unit      AIModelMinMaxUnit          `json:"unit" validate:"oneof=tokens characters files seconds images pixels megabytes" writexs:"system:struct,admin:struct" readxs:"*"`
 } //@name AIModelConstraint
 
 /*  COST CALCULATION SYSTEM
@@ -97,17 +200,23 @@ type AIModelConstraint struct {
 
 // a AIModelFeature describes ONE capability of a AIModel and the associated cost caluclations templates, since there might be multiple parameters like functioncalling, etc.
 type AIModelFeature struct {
-	Capability        AIModelCapability       `json:"capability"`
-	Constraints       []AIModelConstraint     `json:"constraints"`
-	CostItemTemplates []ExecutionCostTemplate `json:"cost_item_templates"`
-	CostItems         []ExecutionUsageCost    `json:"cost_items"`
+	Capability        AIModelCapability       `json:"capability" readxs:"system:struct,admin:struct,owner:struct,org-admin:struct"`
+	Constraints       []AIModelConstraint     `json:"constraints" readxs:"system:struct,admin:struct,owner:struct"`
+	CostItemTemplates []ExecutionCostTemplate `json:"cost_item_templates" readxs:"system:struct,admin:struct"`
+	CostItems         []ExecutionUsageCost    `json:"cost_items" readxs:"system:struct,admin:struct,org-admin:struct"`
 } //@name AIModelFeature
 
+// GetCostItemByCostUnit returns the template for costUnit that is valid now
 func (feat *AIModelFeature) GetCostItemByCostUnit(costUnit AIModelCostUnit) *ExecutionCostTemplate {
+	return feat.GetCostItemByCostUnitAt(costUnit, time.Now())
+}
+
+// GetCostItemByCostUnitAt returns the template for costUnit that is valid at the given time, see ExecutionCostTemplate.ValidFrom
+func (feat *AIModelFeature) GetCostItemByCostUnitAt(costUnit AIModelCostUnit, at time.Time) *ExecutionCostTemplate {
 	// nuts.L.Debugf("[GetCostItemByCostUnit] Checking cost items for costUnit(%v) via feat\n%s", costUnit, nuts.GetPrettyJson(feat))
 	for _, costItem := range feat.CostItemTemplates {
 		// nuts.L.Debugf("[GetCostItemByCostUnit] Checking cost item for costUnit(%v): %v", costUnit, costItem.CostUnit)
-		if costItem.CostUnit == costUnit {
+		if costItem.CostUnit == costUnit && costItem.IsValidAt(at) {
 			// nuts.L.Debugf("[GetCostItemByCostUnit] Found cost item for costUnit(%v): %v", costUnit, costItem)
 			return &costItem
 		}
@@ -117,15 +226,22 @@ func (feat *AIModelFeature) GetCostItemByCostUnit(costUnit AIModelCostUnit) *Exe
 }
 
 func (feat *AIModelFeature) CreateUsedFeatures(capability AIModelCapability, costUnit AIModelCostUnit, usedUnits float64, multiplier float64) (usedFeatures []AIModelFeature, err error) {
+	return feat.CreateUsedFeaturesWithPricing(capability, costUnit, usedUnits, multiplier, NewExecutionPricingContext())
+}
+
+func (feat *AIModelFeature) CreateUsedFeaturesWithPricing(capability AIModelCapability, costUnit AIModelCostUnit, usedUnits float64, multiplier float64, pricing ExecutionPricingContext) (usedFeatures []AIModelFeature, err error) {
 	usedFeatures = make([]AIModelFeature, 0)
 	if feat.Capability != capability {
 		return usedFeatures, ErrFeatureHasDifferentCapability
 	}
 	for _, costTemplate := range feat.CostItemTemplates {
-		if costTemplate.CostUnit != costUnit {
+		if costTemplate.CostUnit != costUnit || !costTemplate.IsValidAt(pricing.GetAt()) {
 			continue
 		}
-		usageCost := NewExecutionUsageCost(&costTemplate, usedUnits, multiplier)
+		usageCost, err := CalculateExecutionUsageCost(&costTemplate, usedUnits, multiplier, pricing)
+		if err != nil {
+			return usedFeatures, err
+		}
 		usedFeatures = append(usedFeatures, AIModelFeature{
 			Capability: feat.Capability,
 			CostItems:  []ExecutionUsageCost{*usageCost},
@@ -141,35 +257,145 @@ func (feat *AIModelFeature) CreateUsedFeatures(capability AIModelCapability, cos
 type ExecutionCostTemplate struct {
 	Description       string          `json:"description" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,org-admin:struct"`
 	CostUnit          AIModelCostUnit `json:"cost_unit" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,org-admin:struct"`
-	CostPerUnitInEuro float64         `json:"cost_per_unit_in_euro" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,org-admin:struct"`
+	CostPerUnitInEuro Money           `json:"cost_per_unit_in_euro" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct"`                                                          // source price in Currency, the name is kept for compatibility
+	Currency          Currency        `json:"currency,omitempty" validate:"omitempty,oneof=EUR USD GBP CHF" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,org-admin:struct"` // DEFAULT_CURRENCY if empty
+	// optional pricing rules on top of the flat CostPerUnitInEuro, see base.models.aimodels.pricing.go
+	TierMode             ExecutionCostTierMode        `json:"tier_mode,omitempty" validate:"omitempty,oneof=threshold graduated volume" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct"`
+	PriceTiers           []ExecutionCostPriceTier     `json:"price_tiers,omitempty" validate:"omitempty,dive" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct"`
+	BatchDiscountPercent float64                      `json:"batch_discount_percent,omitempty" validate:"min=0,max=100" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct"`
+	TimeOfDayTiers       []ExecutionCostTimeOfDayTier `json:"time_of_day_tiers,omitempty" validate:"omitempty,dive" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct"`
+	// the period the price applies to, executions are priced with the template valid at their timestamp, see base.models.aimodels.pricehistory.go
+	ValidFrom int64 `json:"valid_from,omitempty" validate:"gte=0" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,org-admin:struct"`                     // in Milliseconds unix epoch, 0 is open
+	ValidTo   int64 `json:"valid_to,omitempty" validate:"omitempty,gtfield=ValidFrom" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,org-admin:struct"` // in Milliseconds unix epoch, exclusive, 0 is open
 } //@name ExecutionCostTemplate
 
-// for each aimodel capability, there is a specific ExecutionCostTemplate and we use this and add usedUnits along with the resulting cost in euro
+// for each aimodel capability, there is a specific ExecutionCostTemplate and we use this and add usedUnits along with the resulting cost in euro.
+// the embedded template is an unchanged copy of the source template. billed prices are CostPerUnit and the PricedBands,
+// source prices, multiplier and margins are never marshalled, only admins see them in NewExecutionCostView.
 type ExecutionUsageCost struct {
 	ExecutionCostTemplate
-	UsedUnits                 float64 `json:"used_units" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,org-admin:struct"`
-	ResultingCostInEuro       float64 `json:"resulting_cost_in_euro" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,org-admin:struct"`
-	ResultingSourceCostInEuro float64 `json:"-" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct"`
+	// always empty, they shadow the source prices of the embedded template in json. views read the template fields by their readxs tags.
+	ShadowedCostPerUnitInEuro json.RawMessage `json:"cost_per_unit_in_euro,omitempty"`
+	ShadowedPriceTiers        json.RawMessage `json:"price_tiers,omitempty"`
+	UsedUnits                 float64         `json:"used_units" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,org-admin:struct"`
+	CostMultiplier            float64         `json:"-" readxsjson:"cost_multiplier" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct"`
+	SourceCostPerUnit         Money           `json:"-" readxsjson:"source_cost_per_unit" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct"` // CostPerUnitInEuro of the source template
+	CostPerUnit               Money           `json:"cost_per_unit" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,org-admin:struct"`      // billed price in Currency, SourceCostPerUnit * CostMultiplier
+	ResultingCostInEuro       Money           `json:"resulting_cost_in_euro" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,org-admin:struct"`
+	ResultingSourceCostInEuro Money           `json:"-" readxsjson:"resulting_source_cost_in_euro" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct"`
+	ResultingMarginInEuro     Money           `json:"-" readxsjson:"resulting_margin_in_euro" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct"`
+	// the resulting costs above stay in the currency of the template for audit, the billed costs are converted into the budget currency
+	BilledCurrency   Currency `json:"billed_currency,omitempty" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,org-admin:struct"`
+	BilledCost       Money    `json:"billed_cost" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,org-admin:struct"`
+	BilledSourceCost Money    `json:"-" readxsjson:"billed_source_cost" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct"`
+	BilledMargin     Money    `json:"-" readxsjson:"billed_margin" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct"`
+	FXRate           float64  `json:"fx_rate,omitempty" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,org-admin:struct"`
+	// the applied pricing rules, prices include the multiplier
+	PricedBands          []ExecutionCostPricedBand `json:"priced_bands,omitempty" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,org-admin:struct"`
+	BatchDiscountApplied float64                   `json:"batch_discount_applied,omitempty" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,org-admin:struct"`
+	TimeOfDayPriceFactor float64                   `json:"time_of_day_price_factor,omitempty" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,org-admin:struct"`
 } //@name ExecutionUsageCost
 
 func NewExecutionUsageCost(template *ExecutionCostTemplate, usedUnits float64, multiplier float64) *ExecutionUsageCost {
-	unitRelativeUsedUnits := usedUnits
-	if template.CostUnit == AIModelCostUnitOutputPerMillionTokens {
-		unitRelativeUsedUnits = usedUnits / 1_000_000
-	} else if template.CostUnit == AIModelCostUnitInputPerMillionCharacters {
-		unitRelativeUsedUnits = usedUnits / 1_000_000
-	} else if template.CostUnit == AIModelCostUnitInputPerMillionTokens {
-		unitRelativeUsedUnits = usedUnits / 1_000_000
+	return NewExecutionUsageCostWithPricing(template, usedUnits, multiplier, NewExecutionPricingContext())
+}
+
+// NewExecutionUsageCostWithPricing applies the tiers, batch discount and time of day tiers of the template to usedUnits (raw units).
+// amounts that cannot be calculated are logged and saturated, see CalculateExecutionUsageCost to get the error instead.
+func NewExecutionUsageCostWithPricing(template *ExecutionCostTemplate, usedUnits float64, multiplier float64, pricing ExecutionPricingContext) *ExecutionUsageCost {
+	usageCost, err := CalculateExecutionUsageCost(template, usedUnits, multiplier, pricing)
+	if err != nil {
+		nuts.L.Errorf("[NewExecutionUsageCostWithPricing] %v", err)
 	}
-	templateCopy := *template
-	// TODO: this needs to be filtered later - for alpha use, this is removed for now to keep multiplier in the cost calculation secret
-	templateCopy.CostPerUnitInEuro = template.CostPerUnitInEuro * multiplier
-	return &ExecutionUsageCost{
-		ExecutionCostTemplate:     templateCopy,
-		UsedUnits:                 unitRelativeUsedUnits,
-		ResultingCostInEuro:       template.CostPerUnitInEuro * unitRelativeUsedUnits * multiplier,
-		ResultingSourceCostInEuro: template.CostPerUnitInEuro * unitRelativeUsedUnits,
+	return usageCost
+}
+
+// CalculateExecutionUsageCost is NewExecutionUsageCostWithPricing returning an error for a NaN or infinite multiplier,
+// units or price factor and for amounts out of range. the returned cost then holds saturated amounts.
+func CalculateExecutionUsageCost(template *ExecutionCostTemplate, usedUnits float64, multiplier float64, pricing ExecutionPricingContext) (usageCost *ExecutionUsageCost, err error) {
+	divisor := template.CostUnit.UnitDivisor()
+	priceFactor := 1.0
+	errs := make([]error, 0)
+	mul := func(amount Money, factors ...float64) Money {
+		product, mulErr := amount.MulFloatChecked(factors...)
+		if mulErr != nil {
+			errs = append(errs, mulErr)
+			return amount.saturate(factors)
+		}
+		return product
+	}
+	usageCost = &ExecutionUsageCost{
+		ExecutionCostTemplate: *template,
+		UsedUnits:             usedUnits / divisor,
+		CostMultiplier:        multiplier,
+		SourceCostPerUnit:     template.CostPerUnitInEuro,
+		CostPerUnit:           mul(template.CostPerUnitInEuro, multiplier),
+		PricedBands:           make([]ExecutionCostPricedBand, 0),
+	}
+	if pricing.Batch && template.BatchDiscountPercent > 0 {
+		usageCost.BatchDiscountApplied = template.BatchDiscountPercent
+		priceFactor *= 1 - template.BatchDiscountPercent/100
+	}
+	if timeOfDayTier := template.GetTimeOfDayTier(pricing.GetAt()); timeOfDayTier != nil {
+		usageCost.TimeOfDayPriceFactor = timeOfDayTier.PriceFactor
+		priceFactor *= timeOfDayTier.PriceFactor
+	}
+	for _, band := range template.GetPriceBands(usedUnits, pricing.VolumeUnits[template.CostUnit]) {
+		bandUnits := band.Units / divisor
+		bandCost := mul(band.CostPerUnitInEuro, bandUnits, priceFactor, multiplier)
+		usageCost.ResultingCostInEuro = usageCost.ResultingCostInEuro.addSaturating(bandCost)
+		usageCost.ResultingSourceCostInEuro = usageCost.ResultingSourceCostInEuro.addSaturating(mul(band.CostPerUnitInEuro, bandUnits, priceFactor))
+		usageCost.PricedBands = append(usageCost.PricedBands, ExecutionCostPricedBand{
+			FromUnits:           band.FromUnits / divisor,
+			Units:               bandUnits,
+			CostPerUnitInEuro:   mul(band.CostPerUnitInEuro, multiplier),
+			ResultingCostInEuro: bandCost,
+		})
+	}
+	usageCost.ResultingMarginInEuro = usageCost.ResultingCostInEuro - usageCost.ResultingSourceCostInEuro
+	if len(errs) > 0 {
+		return usageCost, fmt.Errorf("cost of %s(%v) with multiplier(%v): %w", template.CostUnit, usedUnits, multiplier, errors.Join(errs...))
+	}
+	return usageCost, nil
+}
+
+// ConvertTo sets the billed costs by converting the resulting costs from the template currency into currency
+func (usageCost *ExecutionUsageCost) ConvertTo(currency Currency, provider FXRateProvider, at time.Time) (err error) {
+	currency = currency.OrDefault()
+	billedCost, rate, err := ConvertMoney(usageCost.ResultingCostInEuro, usageCost.Currency, currency, provider, at)
+	if err != nil {
+		return err
+	}
+	usageCost.BilledCurrency = currency
+	usageCost.BilledCost = billedCost
+	usageCost.BilledSourceCost, err = usageCost.ResultingSourceCostInEuro.MulFloatChecked(rate)
+	if err != nil {
+		return err
+	}
+	usageCost.BilledMargin = usageCost.BilledCost - usageCost.BilledSourceCost
+	usageCost.FXRate = rate
+	return nil
+}
+
+// GetBilledCost returns the billed cost, or the resulting cost in the template currency if it was not converted yet
+func (usageCost *ExecutionUsageCost) GetBilledCost() (cost Money, sourceCost Money, currency Currency) {
+	if usageCost.BilledCurrency == "" {
+		return usageCost.ResultingCostInEuro, usageCost.ResultingSourceCostInEuro, usageCost.Currency.OrDefault()
+	}
+	return usageCost.BilledCost, usageCost.BilledSourceCost, usageCost.BilledCurrency
+}
+
+// ConvertFeatureCostsToCurrency converts all cost items of the used features into currency
+func ConvertFeatureCostsToCurrency(featuresUsed []AIModelFeature, currency Currency, provider FXRateProvider, at time.Time) (err error) {
+	for n := range featuresUsed {
+		for i := range featuresUsed[n].CostItems {
+			err = featuresUsed[n].CostItems[i].ConvertTo(currency, provider, at)
+			if err != nil {
+				return err
+			}
+		}
 	}
+	return nil
 }
 
 // -- DTOs and DB Models --
@@ -201,10 +427,10 @@ type AIModel struct {
 	ModelID               string              `json:"model_id" validate:"required,min=1,max=64" writexs:"system:struct,admin:struct,owner:struct" readxs:"*"`
 	MaxInputTokens        int                 `json:"max_input_tokens" validate:"gte=0" writexs:"system:struct,admin:struct,owner:struct" readxs:"system:struct,admin:struct,owner:struct"`
 	MaxOutputTokens       int                 `json:"max_output_tokens" validate:"gte=0" writexs:"system:struct,admin:struct,owner:struct" readxs:"system:struct,admin:struct,owner:struct"`
-	Constraints           []AIModelConstraint `json:"constraints" writexs:"system:struct,admin:struct,owner:struct" readxs:"system:struct,admin:struct,owner:struct"`
+	Constraints           []AIModelConstraint `json:"constraints" validate:"omitempty,dive" writexs:"system:struct,admin:struct,owner:struct" readxs:"system:struct,admin:struct,owner:struct"`
 	Features              []AIModelFeature    `json:"features" writexs:"system:struct,admin:struct,owner:struct" readxs:"system:struct,admin:struct,owner:struct"`
 	ServiceHostLocations  []HostingLocation   `json:"service_host_locations" writexs:"system:struct,admin:struct,owner:struct" readxs:"*"`
-	AcceptedFileMimetypes []string            `json:"accepted_file_mimetypes" writexs:"system:struct,admin:struct,owner:struct" readxs:"*"`
+	AcceptedFileMimetypes []string            `json:"accepted_file_mimetypes" writexs:"system:struct,admin:struct,owner:struct" readxs:"*"` // empty accepts all, see AcceptsMimetype
 	Parameters            map[string]any      `json:"parameters" writexs:"system:struct,admin:struct,owner:struct" readxs:"system:struct,admin:struct,owner:struct"`
 	ParameterDefinitions  []NatsToolParameter `json:"parameter_definitions" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,owner:struct"`
 	IsPublic              bool                `json:"is_public" writexs:"system:struct,admin:struct,owner:struct" readxs:"system:struct,admin:struct,owner:struct"`
@@ -245,7 +471,7 @@ func ValidateAIModel(aiModel *AIModel) error {
 		return ErrInvalidAIModel
 	}
 
-	return nil
+	return aiModel.ValidateCostTemplatePeriods()
 }
 
 func (aiModel *AIModel) Validate() error {
@@ -263,6 +489,10 @@ func (aiModel *AIModel) GetFeaturesForCapability(capability AIModelCapability) [
 }
 
 func (aiModel *AIModel) CalculateUsageCostsForFeature(capability AIModelCapability, costUnit AIModelCostUnit, usedUnits float64, multiplier float64) (totalUsedFeatures []AIModelFeature, err error) {
+	return aiModel.CalculateUsageCostsForFeatureWithPricing(capability, costUnit, usedUnits, multiplier, NewExecutionPricingContext())
+}
+
+func (aiModel *AIModel) CalculateUsageCostsForFeatureWithPricing(capability AIModelCapability, costUnit AIModelCostUnit, usedUnits float64, multiplier float64, pricing ExecutionPricingContext) (totalUsedFeatures []AIModelFeature, err error) {
 	totalUsedFeatures = make([]AIModelFeature, 0)
 	for _, feature := range aiModel.Features {
 		// nuts.L.Debugf("[CalculateUsageCostsForFeature] Checking Model(%s) feature: (%v) and costUnit(%v)", aiModel.Name, feature.Capability, costUnit)
@@ -270,11 +500,11 @@ func (aiModel *AIModel) CalculateUsageCostsForFeature(capability AIModelCapabili
 			// nuts.L.Debugf("Feature not found or has different capability feat(%v) capa(%v)", feature.Capability, capability)
 			continue
 		}
-		if feature.GetCostItemByCostUnit(costUnit) == nil {
+		if feature.GetCostItemByCostUnitAt(costUnit, pricing.GetAt()) == nil {
 			// nuts.L.Debugf("Cost item not found for costUnit(%v)", costUnit)
 			continue
 		}
-		usedFeatures, err := feature.CreateUsedFeatures(capability, costUnit, usedUnits, multiplier)
+		usedFeatures, err := feature.CreateUsedFeaturesWithPricing(capability, costUnit, usedUnits, multiplier, pricing)
 		if err != nil {
 			return totalUsedFeatures, err
 		}
@@ -283,44 +513,26 @@ func (aiModel *AIModel) CalculateUsageCostsForFeature(capability AIModelCapabili
 	return totalUsedFeatures, nil
 }
 
+// CalculateCostForText2Text prices the tokens and tool calls of a chat completion. on error the features that could be priced are returned as well.
 func CalculateCostForText2Text(aiModel *AIModel, toolCallsUsed int, inputTokenCount int, outputTokenCount int, costMultiplier float64) (featuresUsed []AIModelFeature, err error) {
 	nuts.L.Debugf("Calculating costs for AIModel(%s) with toolCallsUsed(%d), inputTokenCount(%d), outputTokenCount(%d)", aiModel.ID, toolCallsUsed, inputTokenCount, outputTokenCount)
-	if outputTokenCount > 0 {
-		usedFeats, err := aiModel.CalculateUsageCostsForFeature(AIModelCapabilityTextToText, AIModelCostUnitOutputPerMillionTokens, float64(outputTokenCount), costMultiplier)
-		if err != nil {
-			nuts.L.Errorf("failed to calculate feature costs: %w", err)
-		}
-		// nuts.L.Debugf("Used features for outputTokenCount: %v", usedFeats)
-		featuresUsed = append(featuresUsed, usedFeats...)
-	}
-	if inputTokenCount > 0 {
-		usedFeats, err := aiModel.CalculateUsageCostsForFeature(AIModelCapabilityTextToText, AIModelCostUnitInputPerMillionTokens, float64(inputTokenCount), costMultiplier)
-		if err != nil {
-			nuts.L.Errorf("failed to calculate feature costs: %w", err)
-		}
-		// nuts.L.Debugf("Used features for inputTokenCount: %v", usedFeats)
-		featuresUsed = append(featuresUsed, usedFeats...)
-	}
-	if toolCallsUsed > 0 {
-		usedFeats, err := aiModel.CalculateUsageCostsForFeature(AIModelCapabilityFunctionCalling, AIModelCostUnitPerFunctionCall, float64(toolCallsUsed), costMultiplier)
-		if err != nil {
-			nuts.L.Errorf("failed to calculate feature costs: %w", err)
-		}
-		// nuts.L.Debugf("Used features for toolCallsUsed: %v", usedFeats)
-		featuresUsed = append(featuresUsed, usedFeats...)
+	usages := NewText2TextExecutionUsages(toolCallsUsed, inputTokenCount, outputTokenCount)
+	featuresUsed, _, err = CalculateCostForUsages(aiModel, usages, costMultiplier)
+	if err != nil {
+		nuts.L.Errorf("failed to calculate feature costs: %v", err)
 	}
-	return featuresUsed, nil
+	return featuresUsed, err
 }
 
+// CalculateCostForText2Image prices the generated images. on error the features that could be priced are returned as well.
 func CalculateCostForText2Image(aiModel *AIModel, numberOfImages int, costMultiplier float64) (featuresUsed []AIModelFeature, err error) {
 	nuts.L.Debugf("Calculating costs for AIModel(%s) with numberOfImages(%d)", aiModel.ID, numberOfImages)
-	if numberOfImages > 0 {
-		usedFeats, err := aiModel.CalculateUsageCostsForFeature(AIModelCapabilityTextToImage, AIModelCostUnitImageGenerationPerImage, float64(numberOfImages), costMultiplier)
-		if err != nil {
-			nuts.L.Errorf("failed to calculate feature costs: %w", err)
-		}
-		// nuts.L.Debugf("Used features for numberOfImages: %v", usedFeats)
-		featuresUsed = append(featuresUsed, usedFeats...)
+	usages := []ExecutionUsage{
+		NewExecutionUsage(AIModelCapabilityTextToImage, AIModelCostUnitImageGenerationPerImage, float64(numberOfImages)),
+	}
+	featuresUsed, _, err = CalculateCostForUsages(aiModel, usages, costMultiplier)
+	if err != nil {
+		nuts.L.Errorf("failed to calculate feature costs: %v", err)
 	}
-	return featuresUsed, nil
+	return featuresUsed, err
 }
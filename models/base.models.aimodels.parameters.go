@@ -0,0 +1,233 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	COMPLETION_PARAMETER_TEMPERATURE = "temperature"
+	COMPLETION_PARAMETER_TOP_P       = "top_p"
+	COMPLETION_PARAMETER_MAX_TOKENS  = "max_tokens"
+)
+
+var (
+	ErrInvalidCompletionParameters = errors.New("invalid completion parameters")
+)
+
+// DEFAULT_COMPLETION_PARAMETER_DEFINITIONS are used for models without ParameterDefinitions
+var DEFAULT_COMPLETION_PARAMETER_DEFINITIONS = []NatsToolParameter{
+	{Name: COMPLETION_PARAMETER_TEMPERATURE, Description: "sampling temperature", VarType: NatsToolParameterTypeNumber, Validations: "min=0,max=2"},
+	{Name: COMPLETION_PARAMETER_TOP_P, Aliases: []string{"topP"}, Description: "nucleus sampling probability mass", VarType: NatsToolParameterTypeNumber, Validations: "min=0,max=1"},
+	{Name: COMPLETION_PARAMETER_MAX_TOKENS, Aliases: []string{"maxTokens", "max_output_tokens"}, Description: "maximum number of output tokens", VarType: NatsToolParameterTypeNumber, Validations: "min=1"},
+}
+
+// UnknownParameterPolicy decides what happens to parameters without a definition
+type UnknownParameterPolicy string //@name UnknownParameterPolicy
+
+const (
+	UnknownParameterPolicyStrip  UnknownParameterPolicy = "strip"
+	UnknownParameterPolicyReject UnknownParameterPolicy = "reject"
+)
+
+// CompletionParameterSource is the layer an effective parameter value comes from
+type CompletionParameterSource string //@name CompletionParameterSource
+
+const (
+	CompletionParameterSourceDefinition CompletionParameterSource = "definition" // DefaultValue of the definition
+	CompletionParameterSourceModel      CompletionParameterSource = "model"
+	CompletionParameterSourceAgent      CompletionParameterSource = "agent"
+	CompletionParameterSourceMessage    CompletionParameterSource = "message"
+	CompletionParameterSourceFixed      CompletionParameterSource = "fixed" // FixedValue of the definition, overrides every layer
+)
+
+type CompletionParameterIssue struct {
+	Name    string                    `json:"name"`
+	Source  CompletionParameterSource `json:"source"`
+	Value   any                       `json:"value"`
+	Message string                    `json:"message"`
+} //@name CompletionParameterIssue
+
+type CompletionParameterIssues []CompletionParameterIssue //@name CompletionParameterIssues
+
+func (issues CompletionParameterIssues) Error() string {
+	messages := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		messages = append(messages, fmt.Sprintf("%s(%s): %s", issue.Name, issue.Source, issue.Message))
+	}
+	return fmt.Sprintf("(%d) parameter issues: %s", len(issues), strings.Join(messages, "; "))
+}
+
+// EffectiveCompletionParameters are the parameters sent to the provider and where each of them comes from
+type EffectiveCompletionParameters struct {
+	Values     map[string]any                       `json:"values"`
+	Sources    map[string]CompletionParameterSource `json:"sources"`
+	Stripped   CompletionParameterIssues            `json:"stripped"`   // unknown parameters removed by UnknownParameterPolicyStrip
+	Overridden CompletionParameterIssues            `json:"overridden"` // values replaced by the FixedValue of their definition
+} //@name EffectiveCompletionParameters
+
+type completionParameterLayer struct {
+	source CompletionParameterSource
+	values map[string]any
+}
+
+// ResolveCompletionParameters merges the model Parameters, the agent CompletionParameters and the message
+// ChatCompletionConfig and CompletionParameters (later layers win) and validates the result against the ParameterDefinitions
+// of the model (DEFAULT_COMPLETION_PARAMETER_DEFINITIONS if it has none): aliases are mapped to the parameter name,
+// defaults filled in, fixed values enforced, types and validations checked and max_tokens kept within the output limit of the model.
+// unknown parameters are stripped or rejected by policy. agent and msg may be nil.
+func ResolveCompletionParameters(aiModel *AIModel, agent *Agent, msg *AIgencyMessage, policy UnknownParameterPolicy) (effective *EffectiveCompletionParameters, err error) {
+	layers := []completionParameterLayer{{source: CompletionParameterSourceModel, values: aiModel.Parameters}}
+	if agent != nil {
+		layers = append(layers, completionParameterLayer{source: CompletionParameterSourceAgent, values: agent.CompletionParameters})
+	}
+	if msg != nil {
+		layers = append(layers, completionParameterLayer{source: CompletionParameterSourceMessage, values: msg.ChatCompletionConfig})
+		layers = append(layers, completionParameterLayer{source: CompletionParameterSourceMessage, values: msg.CompletionParameters})
+	}
+	return aiModel.resolveCompletionParameterLayers(layers, policy)
+}
+
+// GetCompletionParameterDefinitions returns the ParameterDefinitions, DEFAULT_COMPLETION_PARAMETER_DEFINITIONS if there are none
+func (aiModel *AIModel) GetCompletionParameterDefinitions() []NatsToolParameter {
+	if len(aiModel.ParameterDefinitions) == 0 {
+		return DEFAULT_COMPLETION_PARAMETER_DEFINITIONS
+	}
+	return aiModel.ParameterDefinitions
+}
+
+func (aiModel *AIModel) resolveCompletionParameterLayers(layers []completionParameterLayer, policy UnknownParameterPolicy) (effective *EffectiveCompletionParameters, err error) {
+	definitions := aiModel.GetCompletionParameterDefinitions()
+	names := make(map[string]string) // lowercase name or alias -> name
+	for _, definition := range definitions {
+		names[strings.ToLower(definition.Name)] = definition.Name
+		for _, alias := range definition.Aliases {
+			names[strings.ToLower(alias)] = definition.Name
+		}
+	}
+	effective = &EffectiveCompletionParameters{
+		Values:     make(map[string]any),
+		Sources:    make(map[string]CompletionParameterSource),
+		Stripped:   make(CompletionParameterIssues, 0),
+		Overridden: make(CompletionParameterIssues, 0),
+	}
+	issues := make(CompletionParameterIssues, 0)
+	for _, layer := range layers {
+		for _, key := range getSortedParameterKeys(layer.values) {
+			value := layer.values[key]
+			name, isKnown := names[strings.ToLower(key)]
+			if !isKnown {
+				issue := CompletionParameterIssue{Name: key, Source: layer.source, Value: value, Message: "parameter is not defined for the model"}
+				if policy == UnknownParameterPolicyReject {
+					issues = append(issues, issue)
+				} else {
+					effective.Stripped = append(effective.Stripped, issue)
+				}
+				continue
+			}
+			effective.Values[name] = value
+			effective.Sources[name] = layer.source
+		}
+	}
+
+	outputTokenLimit := aiModel.GetOutputTokenLimit()
+	for _, definition := range definitions {
+		value, isSet := effective.Values[definition.Name]
+		if definition.FixedValue != nil {
+			if isSet && fmt.Sprint(value) != fmt.Sprint(definition.FixedValue) {
+				effective.Overridden = append(effective.Overridden, CompletionParameterIssue{Name: definition.Name, Source: effective.Sources[definition.Name], Value: value, Message: fmt.Sprintf("replaced by the fixed value (%v)", definition.FixedValue)})
+			}
+			effective.Values[definition.Name] = definition.FixedValue
+			effective.Sources[definition.Name] = CompletionParameterSourceFixed
+			continue
+		}
+		if !isSet || value == nil {
+			if definition.DefaultValue != nil {
+				effective.Values[definition.Name] = definition.DefaultValue
+				effective.Sources[definition.Name] = CompletionParameterSourceDefinition
+				continue
+			}
+			if definition.Required {
+				issues = append(issues, CompletionParameterIssue{Name: definition.Name, Message: "required parameter is missing"})
+			}
+			delete(effective.Values, definition.Name)
+			delete(effective.Sources, definition.Name)
+			continue
+		}
+		if definition.VarType == NatsToolParameterTypeNumber {
+			value = normalizeParameterNumber(value)
+			effective.Values[definition.Name] = value
+		}
+		if len(definition.Enum) > 0 && !isParameterEnumValue(definition.Enum, value) {
+			issues = append(issues, CompletionParameterIssue{Name: definition.Name, Source: effective.Sources[definition.Name], Value: value, Message: fmt.Sprintf("must be one of (%s)", strings.Join(definition.Enum, ", "))})
+			continue
+		}
+		if validationErr := definition.ValidateValue(value); validationErr != nil {
+			issues = append(issues, CompletionParameterIssue{Name: definition.Name, Source: effective.Sources[definition.Name], Value: value, Message: validationErr.Error()})
+			continue
+		}
+		if definition.Name == COMPLETION_PARAMETER_MAX_TOKENS && outputTokenLimit > 0 {
+			if maxTokens, isNumber := toFloat64(value); isNumber && maxTokens > float64(outputTokenLimit) {
+				issues = append(issues, CompletionParameterIssue{Name: definition.Name, Source: effective.Sources[definition.Name], Value: value, Message: fmt.Sprintf("exceeds the output limit of the model (%d)", outputTokenLimit)})
+			}
+		}
+	}
+	if len(issues) > 0 {
+		return effective, fmt.Errorf("%w: %w", ErrInvalidCompletionParameters, issues)
+	}
+	return effective, nil
+}
+
+func getSortedParameterKeys(values map[string]any) (keys []string) {
+	keys = make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func isParameterEnumValue(enum []string, value any) bool {
+	text := fmt.Sprint(value)
+	for _, allowed := range enum {
+		if allowed == text {
+			return true
+		}
+	}
+	return false
+}
+
+// toFloat64 converts the numbers of json (including json.Number), yaml and go code of any int, uint or float kind
+func toFloat64(value any) (number float64, isNumber bool) {
+	if jsonNumber, isJSONNumber := value.(json.Number); isJSONNumber {
+		number, err := jsonNumber.Float64()
+		return number, err == nil
+	}
+	reflected := reflect.ValueOf(value)
+	switch reflected.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return reflected.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(reflected.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(reflected.Uint()), true
+	}
+	return 0, false
+}
+
+// normalizeParameterNumber returns numbers that are neither int nor float64 (json.Number, int32, uint, ...) as float64,
+// the types the number validation of NatsToolParameter accepts. other values are returned unchanged.
+func normalizeParameterNumber(value any) any {
+	switch value.(type) {
+	case int, float64:
+		return value
+	}
+	if number, isNumber := toFloat64(value); isNumber {
+		return number
+	}
+	return value
+}
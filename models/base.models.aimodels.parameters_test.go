@@ -0,0 +1,147 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestResolveCompletionParametersLayers(t *testing.T) {
+	aiModel := &AIModel{ID: "parameter-test", Parameters: map[string]any{COMPLETION_PARAMETER_TEMPERATURE: 0.2, COMPLETION_PARAMETER_TOP_P: 0.5, COMPLETION_PARAMETER_MAX_TOKENS: 100}}
+	agent := NewAgent()
+	agent.CompletionParameters = map[string]any{COMPLETION_PARAMETER_TEMPERATURE: 0.4, "TopP": 0.6}
+	msg := NewAIgencyMessage()
+	msg.ChatCompletionConfig = map[string]any{"max_output_tokens": 50}
+	msg.CompletionParameters = map[string]any{COMPLETION_PARAMETER_TEMPERATURE: 0.9}
+
+	tests := []struct {
+		name        string
+		agent       *Agent
+		msg         *AIgencyMessage
+		wantValues  map[string]any
+		wantSources map[string]CompletionParameterSource
+	}{
+		{name: "model only", wantValues: map[string]any{COMPLETION_PARAMETER_TEMPERATURE: 0.2, COMPLETION_PARAMETER_TOP_P: 0.5, COMPLETION_PARAMETER_MAX_TOKENS: 100}, wantSources: map[string]CompletionParameterSource{
+			COMPLETION_PARAMETER_TEMPERATURE: CompletionParameterSourceModel, COMPLETION_PARAMETER_TOP_P: CompletionParameterSourceModel, COMPLETION_PARAMETER_MAX_TOKENS: CompletionParameterSourceModel,
+		}},
+		{name: "agent overrides the model", agent: agent, wantValues: map[string]any{COMPLETION_PARAMETER_TEMPERATURE: 0.4, COMPLETION_PARAMETER_TOP_P: 0.6, COMPLETION_PARAMETER_MAX_TOKENS: 100}, wantSources: map[string]CompletionParameterSource{
+			COMPLETION_PARAMETER_TEMPERATURE: CompletionParameterSourceAgent, COMPLETION_PARAMETER_TOP_P: CompletionParameterSourceAgent, COMPLETION_PARAMETER_MAX_TOKENS: CompletionParameterSourceModel,
+		}},
+		{name: "message overrides the agent", agent: agent, msg: msg, wantValues: map[string]any{COMPLETION_PARAMETER_TEMPERATURE: 0.9, COMPLETION_PARAMETER_TOP_P: 0.6, COMPLETION_PARAMETER_MAX_TOKENS: 50}, wantSources: map[string]CompletionParameterSource{
+			COMPLETION_PARAMETER_TEMPERATURE: CompletionParameterSourceMessage, COMPLETION_PARAMETER_TOP_P: CompletionParameterSourceAgent, COMPLETION_PARAMETER_MAX_TOKENS: CompletionParameterSourceMessage,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			effective, err := ResolveCompletionParameters(aiModel, tt.agent, tt.msg, UnknownParameterPolicyReject)
+			if err != nil {
+				t.Fatalf("ResolveCompletionParameters() error = %v", err)
+			}
+			if !reflect.DeepEqual(effective.Values, tt.wantValues) {
+				t.Errorf("Values = %v, want %v", effective.Values, tt.wantValues)
+			}
+			if !reflect.DeepEqual(effective.Sources, tt.wantSources) {
+				t.Errorf("Sources = %v, want %v", effective.Sources, tt.wantSources)
+			}
+		})
+	}
+}
+
+func TestResolveCompletionParametersDefinitions(t *testing.T) {
+	definitions := []NatsToolParameter{
+		{Name: COMPLETION_PARAMETER_TEMPERATURE, VarType: NatsToolParameterTypeNumber, FixedValue: 1.0},
+		{Name: COMPLETION_PARAMETER_MAX_TOKENS, Aliases: []string{"maxTokens"}, VarType: NatsToolParameterTypeNumber, Validations: "min=1", DefaultValue: 10},
+		{Name: "reasoning_effort", VarType: NatsToolParameterTypeString, Enum: []string{"low", "high"}},
+	}
+	tests := []struct {
+		name           string
+		parameters     map[string]any
+		policy         UnknownParameterPolicy
+		wantValues     map[string]any
+		wantStripped   []string
+		wantOverridden []string
+		wantErr        bool
+	}{
+		{name: "defaults and fixed values", parameters: map[string]any{}, wantValues: map[string]any{COMPLETION_PARAMETER_TEMPERATURE: 1.0, COMPLETION_PARAMETER_MAX_TOKENS: 10}},
+		{name: "fixed value overrides the message", parameters: map[string]any{COMPLETION_PARAMETER_TEMPERATURE: 0.3, "MAXTOKENS": 20}, wantValues: map[string]any{COMPLETION_PARAMETER_TEMPERATURE: 1.0, COMPLETION_PARAMETER_MAX_TOKENS: 20}, wantOverridden: []string{COMPLETION_PARAMETER_TEMPERATURE}},
+		{name: "fixed value set to itself", parameters: map[string]any{COMPLETION_PARAMETER_TEMPERATURE: 1}, wantValues: map[string]any{COMPLETION_PARAMETER_TEMPERATURE: 1.0, COMPLETION_PARAMETER_MAX_TOKENS: 10}},
+		{name: "unknown parameters stripped", parameters: map[string]any{"seed": 7, "top_k": 3}, policy: UnknownParameterPolicyStrip, wantValues: map[string]any{COMPLETION_PARAMETER_TEMPERATURE: 1.0, COMPLETION_PARAMETER_MAX_TOKENS: 10}, wantStripped: []string{"seed", "top_k"}},
+		{name: "unknown parameters rejected", parameters: map[string]any{"seed": 7}, policy: UnknownParameterPolicyReject, wantErr: true},
+		{name: "enum value", parameters: map[string]any{"reasoning_effort": "high"}, wantValues: map[string]any{COMPLETION_PARAMETER_TEMPERATURE: 1.0, COMPLETION_PARAMETER_MAX_TOKENS: 10, "reasoning_effort": "high"}},
+		{name: "not an enum value", parameters: map[string]any{"reasoning_effort": "medium"}, wantErr: true},
+		{name: "failed validation", parameters: map[string]any{COMPLETION_PARAMETER_MAX_TOKENS: 0}, wantErr: true},
+		{name: "wrong type", parameters: map[string]any{COMPLETION_PARAMETER_MAX_TOKENS: "20"}, wantErr: true},
+		{name: "json number", parameters: map[string]any{COMPLETION_PARAMETER_MAX_TOKENS: json.Number("20")}, wantValues: map[string]any{COMPLETION_PARAMETER_TEMPERATURE: 1.0, COMPLETION_PARAMETER_MAX_TOKENS: 20.0}},
+		{name: "uint", parameters: map[string]any{COMPLETION_PARAMETER_MAX_TOKENS: uint(20)}, wantValues: map[string]any{COMPLETION_PARAMETER_TEMPERATURE: 1.0, COMPLETION_PARAMETER_MAX_TOKENS: 20.0}},
+		{name: "invalid json number", parameters: map[string]any{COMPLETION_PARAMETER_MAX_TOKENS: json.Number("many")}, wantErr: true},
+		{name: "max tokens at the output limit", parameters: map[string]any{COMPLETION_PARAMETER_MAX_TOKENS: int32(100)}, wantValues: map[string]any{COMPLETION_PARAMETER_TEMPERATURE: 1.0, COMPLETION_PARAMETER_MAX_TOKENS: 100.0}},
+		{name: "max tokens above the output limit", parameters: map[string]any{COMPLETION_PARAMETER_MAX_TOKENS: 101}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aiModel := &AIModel{ID: "parameter-test", MaxOutputTokens: 100, ParameterDefinitions: definitions}
+			msg := NewAIgencyMessage()
+			msg.CompletionParameters = tt.parameters
+			effective, err := ResolveCompletionParameters(aiModel, nil, msg, tt.policy)
+			if tt.wantErr {
+				var issues CompletionParameterIssues
+				if !errors.Is(err, ErrInvalidCompletionParameters) || !errors.As(err, &issues) || len(issues) != 1 {
+					t.Fatalf("ResolveCompletionParameters() error = %v, want %v with one issue", err, ErrInvalidCompletionParameters)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveCompletionParameters() error = %v", err)
+			}
+			if !reflect.DeepEqual(effective.Values, tt.wantValues) {
+				t.Errorf("Values = %#v, want %#v", effective.Values, tt.wantValues)
+			}
+			if got := getCompletionParameterIssueNames(effective.Stripped); !reflect.DeepEqual(got, append([]string{}, tt.wantStripped...)) {
+				t.Errorf("Stripped = %v, want %v", got, tt.wantStripped)
+			}
+			if got := getCompletionParameterIssueNames(effective.Overridden); !reflect.DeepEqual(got, append([]string{}, tt.wantOverridden...)) {
+				t.Errorf("Overridden = %v, want %v", got, tt.wantOverridden)
+			}
+			if len(tt.wantOverridden) > 0 && effective.Sources[COMPLETION_PARAMETER_TEMPERATURE] != CompletionParameterSourceFixed {
+				t.Errorf("source of the fixed value = %s, want %s", effective.Sources[COMPLETION_PARAMETER_TEMPERATURE], CompletionParameterSourceFixed)
+			}
+		})
+	}
+}
+
+func getCompletionParameterIssueNames(issues CompletionParameterIssues) (names []string) {
+	names = make([]string, 0)
+	for _, issue := range issues {
+		names = append(names, issue.Name)
+	}
+	return names
+}
+
+func TestToFloat64(t *testing.T) {
+	type temperature float32
+	tests := []struct {
+		value        any
+		want         float64
+		wantIsNumber bool
+	}{
+		{value: 0.5, want: 0.5, wantIsNumber: true},
+		{value: float32(0.5), want: 0.5, wantIsNumber: true},
+		{value: temperature(0.25), want: 0.25, wantIsNumber: true},
+		{value: 3, want: 3, wantIsNumber: true},
+		{value: int32(-3), want: -3, wantIsNumber: true},
+		{value: int64(3), want: 3, wantIsNumber: true},
+		{value: uint(3), want: 3, wantIsNumber: true},
+		{value: uint8(3), want: 3, wantIsNumber: true},
+		{value: json.Number("0.75"), want: 0.75, wantIsNumber: true},
+		{value: json.Number("x")},
+		{value: "3"},
+		{value: true},
+		{value: nil},
+	}
+	for _, tt := range tests {
+		if got, isNumber := toFloat64(tt.value); got != tt.want || isNumber != tt.wantIsNumber {
+			t.Errorf("toFloat64(%#v) = %v, %v, want %v, %v", tt.value, got, isNumber, tt.want, tt.wantIsNumber)
+		}
+	}
+}
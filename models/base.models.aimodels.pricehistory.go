@@ -0,0 +1,140 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	nuts "github.com/vaudience/go-nuts"
+)
+
+var (
+	ErrOverlappingCostTemplates = errors.New("cost templates of the same cost unit overlap")
+	ErrPriceChangeBeforeCurrent = errors.New("price change must not start before the current price")
+	ErrFeatureNotFound          = errors.New("feature not found")
+)
+
+// IsValidAt tells if the template applies to an execution at the given time, ValidTo is exclusive
+func (template *ExecutionCostTemplate) IsValidAt(at time.Time) bool {
+	timestamp := nuts.TimeToJSTimestamp(at)
+	if template.ValidFrom > 0 && timestamp < template.ValidFrom {
+		return false
+	}
+	if template.ValidTo > 0 && timestamp >= template.ValidTo {
+		return false
+	}
+	return true
+}
+
+// overlaps tells if both templates are valid at some point in time, open bounds reach to the beginning or end of time
+func (template *ExecutionCostTemplate) overlaps(other *ExecutionCostTemplate) bool {
+	startsBeforeOtherEnds := other.ValidTo == 0 || template.ValidFrom < other.ValidTo
+	endsAfterOtherStarts := template.ValidTo == 0 || template.ValidTo > other.ValidFrom
+	return startsBeforeOtherEnds && endsAfterOtherStarts
+}
+
+// AIModelPriceHistoryEntry is one price of a model feature and the period it was valid
+type AIModelPriceHistoryEntry struct {
+	Capability AIModelCapability     `json:"capability"`
+	Template   ExecutionCostTemplate `json:"template"`
+} //@name AIModelPriceHistoryEntry
+
+// GetPriceHistory returns all prices of the model for costUnit (all cost units if empty), ordered by capability, cost unit and ValidFrom
+func (aiModel *AIModel) GetPriceHistory(costUnit AIModelCostUnit) (history []AIModelPriceHistoryEntry) {
+	history = make([]AIModelPriceHistoryEntry, 0)
+	for _, feature := range aiModel.Features {
+		for _, template := range feature.CostItemTemplates {
+			if costUnit != "" && template.CostUnit != costUnit {
+				continue
+			}
+			history = append(history, AIModelPriceHistoryEntry{Capability: feature.Capability, Template: template})
+		}
+	}
+	sort.SliceStable(history, func(i, j int) bool {
+		if history[i].Capability != history[j].Capability {
+			return history[i].Capability < history[j].Capability
+		}
+		if history[i].Template.CostUnit != history[j].Template.CostUnit {
+			return history[i].Template.CostUnit < history[j].Template.CostUnit
+		}
+		return history[i].Template.ValidFrom < history[j].Template.ValidFrom
+	})
+	return history
+}
+
+// ChangePrice keeps the price history: the templates of the same cost unit that are still valid at effectiveFrom end there
+// and the new template starts. a change must not start before the latest price, prices of the past stay as they were.
+func (aiModel *AIModel) ChangePrice(capability AIModelCapability, template ExecutionCostTemplate, effectiveFrom time.Time) (err error) {
+	var logName string = "[AIModel.ChangePrice] "
+	featureIndex := -1
+	for n, feature := range aiModel.Features {
+		if feature.Capability == capability {
+			featureIndex = n
+			break
+		}
+	}
+	if featureIndex < 0 {
+		return fmt.Errorf("%w: model(%s) has no feature for capability(%s)", ErrFeatureNotFound, aiModel.ID, capability)
+	}
+	feature := &aiModel.Features[featureIndex]
+	effectiveFromTimestamp := nuts.TimeToJSTimestamp(effectiveFrom)
+	for _, existing := range feature.CostItemTemplates {
+		if existing.CostUnit == template.CostUnit && existing.ValidFrom >= effectiveFromTimestamp {
+			return fmt.Errorf("%w: %s of model(%s) has a price from (%d)", ErrPriceChangeBeforeCurrent, template.CostUnit, aiModel.ID, existing.ValidFrom)
+		}
+	}
+	templates := make([]ExecutionCostTemplate, 0, len(feature.CostItemTemplates)+1)
+	for _, existing := range feature.CostItemTemplates {
+		if existing.CostUnit == template.CostUnit && (existing.ValidTo == 0 || existing.ValidTo > effectiveFromTimestamp) {
+			existing.ValidTo = effectiveFromTimestamp
+		}
+		templates = append(templates, existing)
+	}
+	template.ValidFrom = effectiveFromTimestamp
+	template.ValidTo = 0
+	feature.CostItemTemplates = append(templates, template)
+	aiModel.UpdatedAt = nuts.TimeToJSTimestamp(time.Now())
+	nuts.L.Infof("%smodel(%s) %s %s is (%s %s) from (%s)", logName, aiModel.ID, capability, template.CostUnit, template.CostPerUnitInEuro, template.Currency.OrDefault(), effectiveFrom.UTC().Format(time.RFC3339))
+	return nil
+}
+
+// ValidateCostTemplatePeriods returns ErrOverlappingCostTemplates if two templates of the same feature and cost unit are valid at the same time
+func (aiModel *AIModel) ValidateCostTemplatePeriods() (err error) {
+	for _, feature := range aiModel.Features {
+		for n := range feature.CostItemTemplates {
+			for m := n + 1; m < len(feature.CostItemTemplates); m++ {
+				template, other := &feature.CostItemTemplates[n], &feature.CostItemTemplates[m]
+				if template.CostUnit == other.CostUnit && template.overlaps(other) {
+					return fmt.Errorf("%w: %s %s of model(%s) at (%d-%d) and (%d-%d)", ErrOverlappingCostTemplates, feature.Capability, template.CostUnit, aiModel.ID, template.ValidFrom, template.ValidTo, other.ValidFrom, other.ValidTo)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// GetExecutedAt returns the time of the execution from Timestamp, now if it is not set
+func (result *ExecutionResult) GetExecutedAt() time.Time {
+	if result.Timestamp <= 0 {
+		return time.Now()
+	}
+	return time.UnixMilli(result.Timestamp)
+}
+
+// CalculateCost prices the Usages with the templates valid at the execution timestamp and sets FeaturesUsed,
+// so results can be recomputed and audited with the prices of their time after a price change.
+func (result *ExecutionResult) CalculateCost(aiModel *AIModel, costMultiplier float64) (totals ExecutionCostTotals, err error) {
+	return result.CalculateCostWithOptions(aiModel, costMultiplier, NewExecutionCostOptions())
+}
+
+// CalculateCostWithOptions is CalculateCost with a billing currency and pricing circumstances, options.Pricing.At is set to the execution timestamp
+func (result *ExecutionResult) CalculateCostWithOptions(aiModel *AIModel, costMultiplier float64, options ExecutionCostOptions) (totals ExecutionCostTotals, err error) {
+	options.Pricing.At = result.GetExecutedAt()
+	featuresUsed, totals, err := CalculateCostForUsagesWithOptions(aiModel, result.Usages, costMultiplier, options)
+	if err != nil {
+		return totals, err
+	}
+	result.FeaturesUsed = featuresUsed
+	return totals, nil
+}
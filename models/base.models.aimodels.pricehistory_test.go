@@ -0,0 +1,116 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	nuts "github.com/vaudience/go-nuts"
+)
+
+func TestAIModelChangePriceKeepsTheHistory(t *testing.T) {
+	changedAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	aiModel := newCostTestModel(newCostTestFeature(AIModelCapabilityTextToText,
+		ExecutionCostTemplate{CostUnit: AIModelCostUnitInputPerMillionTokens, CostPerUnitInEuro: mustParseMoney(t, "2")},
+		ExecutionCostTemplate{CostUnit: AIModelCostUnitOutputPerMillionTokens, CostPerUnitInEuro: mustParseMoney(t, "4")},
+	))
+	err := aiModel.ChangePrice(AIModelCapabilityTextToText, ExecutionCostTemplate{CostUnit: AIModelCostUnitInputPerMillionTokens, CostPerUnitInEuro: mustParseMoney(t, "3")}, changedAt)
+	if err != nil {
+		t.Fatalf("ChangePrice() error = %v", err)
+	}
+	if err = aiModel.ValidateCostTemplatePeriods(); err != nil {
+		t.Fatalf("ValidateCostTemplatePeriods() after ChangePrice() error = %v", err)
+	}
+	history := aiModel.GetPriceHistory(AIModelCostUnitInputPerMillionTokens)
+	changedAtTimestamp := nuts.TimeToJSTimestamp(changedAt)
+	if len(history) != 2 || history[0].Template.ValidTo != changedAtTimestamp || history[1].Template.ValidFrom != changedAtTimestamp || history[1].Template.ValidTo != 0 {
+		t.Fatalf("price history = %+v, want the old price ending and the new price starting at %d", history, changedAtTimestamp)
+	}
+	if output := aiModel.GetPriceHistory(AIModelCostUnitOutputPerMillionTokens); len(output) != 1 || output[0].Template.ValidTo != 0 {
+		t.Errorf("output price history = %+v, want the other cost unit unchanged", output)
+	}
+
+	tests := []struct {
+		name       string
+		executedAt time.Time
+		wantCost   string
+	}{
+		{name: "old result with the old price", executedAt: changedAt.Add(-time.Millisecond), wantCost: "2"},
+		{name: "result at the change with the new price", executedAt: changedAt, wantCost: "3"},
+		{name: "later result with the new price", executedAt: changedAt.Add(24 * time.Hour), wantCost: "3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := ExecutionResult{Timestamp: tt.executedAt.UnixMilli(), Usages: []ExecutionUsage{NewExecutionUsage(AIModelCapabilityTextToText, AIModelCostUnitInputPerMillionTokens, 1_000_000)}}
+			totals, err := result.CalculateCost(aiModel, 1)
+			if err != nil {
+				t.Fatalf("CalculateCost() error = %v", err)
+			}
+			if want := mustParseMoney(t, tt.wantCost); totals.TotalCost != want {
+				t.Errorf("TotalCost = %v, want %v", totals.TotalCost, want)
+			}
+			if len(result.FeaturesUsed) != 1 || len(result.FeaturesUsed[0].CostItems) != 1 {
+				t.Fatalf("FeaturesUsed = %+v, want the one priced feature", result.FeaturesUsed)
+			}
+			if price := result.FeaturesUsed[0].CostItems[0].CostPerUnitInEuro; price != mustParseMoney(t, tt.wantCost) {
+				t.Errorf("priced with the template of %v, want %v", price, tt.wantCost)
+			}
+		})
+	}
+}
+
+func TestAIModelChangePriceRejectsInvalidChanges(t *testing.T) {
+	changedAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	aiModel := newCostTestModel(newCostTestFeature(AIModelCapabilityTextToText,
+		ExecutionCostTemplate{CostUnit: AIModelCostUnitInputPerMillionTokens, CostPerUnitInEuro: mustParseMoney(t, "2"), ValidFrom: nuts.TimeToJSTimestamp(changedAt)},
+	))
+	newPrice := ExecutionCostTemplate{CostUnit: AIModelCostUnitInputPerMillionTokens, CostPerUnitInEuro: mustParseMoney(t, "3")}
+
+	tests := []struct {
+		name          string
+		capability    AIModelCapability
+		effectiveFrom time.Time
+		wantErr       error
+	}{
+		{name: "back-dated before the current price", capability: AIModelCapabilityTextToText, effectiveFrom: changedAt.Add(-time.Hour), wantErr: ErrPriceChangeBeforeCurrent},
+		{name: "at the start of the current price", capability: AIModelCapabilityTextToText, effectiveFrom: changedAt, wantErr: ErrPriceChangeBeforeCurrent},
+		{name: "unknown capability", capability: AIModelCapabilityTextToImage, effectiveFrom: changedAt.Add(time.Hour), wantErr: ErrFeatureNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := aiModel.ChangePrice(tt.capability, newPrice, tt.effectiveFrom); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ChangePrice() error = %v, want %v", err, tt.wantErr)
+			}
+			if history := aiModel.GetPriceHistory(""); len(history) != 1 || history[0].Template.ValidTo != 0 {
+				t.Fatalf("price history = %+v, want it unchanged", history)
+			}
+		})
+	}
+}
+
+func TestAIModelValidateCostTemplatePeriods(t *testing.T) {
+	input := func(validFrom int64, validTo int64) ExecutionCostTemplate {
+		return ExecutionCostTemplate{CostUnit: AIModelCostUnitInputPerMillionTokens, ValidFrom: validFrom, ValidTo: validTo}
+	}
+	output := ExecutionCostTemplate{CostUnit: AIModelCostUnitOutputPerMillionTokens}
+
+	tests := []struct {
+		name     string
+		features []AIModelFeature
+		wantErr  error
+	}{
+		{name: "adjacent periods", features: []AIModelFeature{newCostTestFeature(AIModelCapabilityTextToText, input(0, 100), input(100, 200), input(200, 0))}},
+		{name: "different cost units", features: []AIModelFeature{newCostTestFeature(AIModelCapabilityTextToText, input(0, 0), output)}},
+		{name: "different features", features: []AIModelFeature{newCostTestFeature(AIModelCapabilityTextToText, input(0, 0)), newCostTestFeature(AIModelCapabilityImageToText, input(0, 0))}},
+		{name: "overlapping periods", features: []AIModelFeature{newCostTestFeature(AIModelCapabilityTextToText, input(0, 150), input(100, 200))}, wantErr: ErrOverlappingCostTemplates},
+		{name: "two open ended prices", features: []AIModelFeature{newCostTestFeature(AIModelCapabilityTextToText, input(0, 0), input(100, 0))}, wantErr: ErrOverlappingCostTemplates},
+		{name: "period inside another", features: []AIModelFeature{newCostTestFeature(AIModelCapabilityTextToText, input(200, 300), input(0, 0))}, wantErr: ErrOverlappingCostTemplates},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := newCostTestModel(tt.features...).ValidateCostTemplatePeriods(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ValidateCostTemplatePeriods() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// ExecutionCostTierMode defines how the PriceTiers of an ExecutionCostTemplate are applied
+type ExecutionCostTierMode string //@name ExecutionCostTierMode
+
+const (
+	// the whole usage is priced with the highest tier it reached, e.g. all input tokens cost more above a 200k context
+	ExecutionCostTierModeThreshold ExecutionCostTierMode = "threshold"
+	// every tier prices only the units within its band
+	ExecutionCostTierModeGraduated ExecutionCostTierMode = "graduated"
+	// like graduated, but the bands are based on the volume accumulated before this usage (e.g. in the current month)
+	ExecutionCostTierModeVolume ExecutionCostTierMode = "volume"
+)
+
+// ExecutionCostPriceTier replaces the flat price of a template for the units above FromUnits (raw units, e.g. tokens).
+// a threshold tier applies once the usage exceeds FromUnits, a usage of exactly FromUnits keeps the lower price.
+type ExecutionCostPriceTier struct {
+	FromUnits         float64 `json:"from_units" validate:"min=0"`
+	CostPerUnitInEuro Money   `json:"cost_per_unit_in_euro" validate:"min=0"`
+} //@name ExecutionCostPriceTier
+
+// ExecutionCostTimeOfDayTier scales the price for executions between FromHour (inclusive) and ToHour (exclusive) in UTC.
+// ranges may wrap around midnight, e.g. 22 -> 6
+type ExecutionCostTimeOfDayTier struct {
+	FromHour    int     `json:"from_hour" validate:"min=0,max=23"`
+	ToHour      int     `json:"to_hour" validate:"min=0,max=24"`
+	PriceFactor float64 `json:"price_factor" validate:"min=0"`
+} //@name ExecutionCostTimeOfDayTier
+
+// ExecutionCostPricedBand is the part of a usage that was priced with one price, in priced units (e.g. million tokens)
+type ExecutionCostPricedBand struct {
+	FromUnits           float64 `json:"from_units" readxs:"system:struct,admin:struct,org-admin:struct"`
+	Units               float64 `json:"units" readxs:"system:struct,admin:struct,org-admin:struct"`
+	CostPerUnitInEuro   Money   `json:"cost_per_unit_in_euro" readxs:"system:struct,admin:struct,org-admin:struct"`
+	ResultingCostInEuro Money   `json:"resulting_cost_in_euro" readxs:"system:struct,admin:struct,org-admin:struct"`
+} //@name ExecutionCostPricedBand
+
+// ExecutionPricingContext holds the circumstances of an execution that the pricing rules depend on
+type ExecutionPricingContext struct {
+	Batch       bool                        `json:"batch"`        // executed via a provider batch api
+	At          time.Time                   `json:"at"`           // for time of day tiers, now if zero
+	VolumeUnits map[AIModelCostUnit]float64 `json:"volume_units"` // raw units used before, for volume tiers, see GetUsageLedgerVolumeUnits
+} //@name ExecutionPricingContext
+
+func NewExecutionPricingContext() ExecutionPricingContext {
+	return ExecutionPricingContext{
+		At:          time.Now(),
+		VolumeUnits: make(map[AIModelCostUnit]float64),
+	}
+}
+
+// GetUsageLedgerVolumeUnits sums up the raw units an org used of a model since from (e.g. the start of the billing month)
+// from the usage ledger. the result is meant for ExecutionPricingContext.VolumeUnits, without it volume tiers start at 0.
+func GetUsageLedgerVolumeUnits(ctx context.Context, ledger UsageLedger, orgID string, modelID string, from time.Time) (volumeUnits map[AIModelCostUnit]float64, err error) {
+	aggregates, err := ledger.Aggregate(ctx, UsageLedgerFilter{OrgID: orgID, ModelID: modelID, From: from.UnixMilli()}, UsageLedgerGroupByModel)
+	if err != nil {
+		return nil, err
+	}
+	volumeUnits = make(map[AIModelCostUnit]float64)
+	for _, aggregate := range aggregates {
+		// the ledger stores priced units (e.g. million tokens), the tiers are in raw units
+		for costUnit, usedUnits := range aggregate.UsedUnits {
+			volumeUnits[costUnit] += usedUnits * costUnit.UnitDivisor()
+		}
+	}
+	return volumeUnits, nil
+}
+
+func (pricing ExecutionPricingContext) GetAt() time.Time {
+	if pricing.At.IsZero() {
+		return time.Now()
+	}
+	return pricing.At
+}
+
+func (tier *ExecutionCostTimeOfDayTier) Contains(at time.Time) bool {
+	hour := at.UTC().Hour()
+	if tier.FromHour <= tier.ToHour {
+		return hour >= tier.FromHour && hour < tier.ToHour
+	}
+	return hour >= tier.FromHour || hour < tier.ToHour
+}
+
+// GetTimeOfDayTier returns the first time of day tier containing at, or nil
+func (template *ExecutionCostTemplate) GetTimeOfDayTier(at time.Time) *ExecutionCostTimeOfDayTier {
+	for n := range template.TimeOfDayTiers {
+		if template.TimeOfDayTiers[n].Contains(at) {
+			return &template.TimeOfDayTiers[n]
+		}
+	}
+	return nil
+}
+
+// GetPriceBands splits usedUnits (raw units) into bands with one price each.
+// without tiers, the whole usage is one band priced with CostPerUnitInEuro.
+func (template *ExecutionCostTemplate) GetPriceBands(usedUnits float64, volumeUnits float64) (bands []ExecutionCostPricedBand) {
+	tiers := template.getSortedPriceTiers()
+	if len(tiers) == 0 {
+		return []ExecutionCostPricedBand{{Units: usedUnits, CostPerUnitInEuro: template.CostPerUnitInEuro}}
+	}
+	switch template.TierMode {
+	case ExecutionCostTierModeGraduated:
+		return template.getGraduatedBands(tiers, 0, usedUnits)
+	case ExecutionCostTierModeVolume:
+		return template.getGraduatedBands(tiers, volumeUnits, usedUnits)
+	default:
+		price := template.CostPerUnitInEuro
+		for _, tier := range tiers {
+			if usedUnits > tier.FromUnits {
+				price = tier.CostPerUnitInEuro
+			}
+		}
+		return []ExecutionCostPricedBand{{Units: usedUnits, CostPerUnitInEuro: price}}
+	}
+}
+
+func (template *ExecutionCostTemplate) getSortedPriceTiers() []ExecutionCostPriceTier {
+	tiers := make([]ExecutionCostPriceTier, len(template.PriceTiers))
+	copy(tiers, template.PriceTiers)
+	sort.SliceStable(tiers, func(i, j int) bool {
+		return tiers[i].FromUnits < tiers[j].FromUnits
+	})
+	return tiers
+}
+
+// getGraduatedBands prices the range [offset, offset+usedUnits); below the first tier the flat price applies
+func (template *ExecutionCostTemplate) getGraduatedBands(tiers []ExecutionCostPriceTier, offset float64, usedUnits float64) (bands []ExecutionCostPricedBand) {
+	bands = make([]ExecutionCostPricedBand, 0)
+	start, end := offset, offset+usedUnits
+	bandFrom, bandPrice := 0.0, template.CostPerUnitInEuro
+	for n := 0; n <= len(tiers); n++ {
+		bandTo := end
+		if n < len(tiers) {
+			bandTo = tiers[n].FromUnits
+		}
+		from, to := max(bandFrom, start), min(bandTo, end)
+		if to > from {
+			bands = append(bands, ExecutionCostPricedBand{FromUnits: from, Units: to - from, CostPerUnitInEuro: bandPrice})
+		}
+		if n < len(tiers) {
+			bandFrom, bandPrice = tiers[n].FromUnits, tiers[n].CostPerUnitInEuro
+		}
+	}
+	return bands
+}
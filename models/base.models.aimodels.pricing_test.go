@@ -0,0 +1,189 @@
+package models
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestTieredCostTemplate(t *testing.T, mode ExecutionCostTierMode) ExecutionCostTemplate {
+	return ExecutionCostTemplate{
+		CostUnit:          AIModelCostUnitPerFunctionCall,
+		CostPerUnitInEuro: mustParseMoney(t, "1"),
+		TierMode:          mode,
+		// unsorted on purpose, the tiers apply by FromUnits
+		PriceTiers: []ExecutionCostPriceTier{
+			{FromUnits: 100, CostPerUnitInEuro: mustParseMoney(t, "2")},
+			{FromUnits: 50, CostPerUnitInEuro: mustParseMoney(t, "1.5")},
+		},
+	}
+}
+
+func TestExecutionCostTemplateGetPriceBands(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        ExecutionCostTierMode
+		usedUnits   float64
+		volumeUnits float64
+		want        []ExecutionCostPricedBand
+	}{
+		{name: "threshold below the first tier", mode: ExecutionCostTierModeThreshold, usedUnits: 50, want: []ExecutionCostPricedBand{
+			{Units: 50, CostPerUnitInEuro: mustParseMoney(t, "1")},
+		}},
+		{name: "threshold exactly at FromUnits keeps the lower tier", mode: ExecutionCostTierModeThreshold, usedUnits: 100, want: []ExecutionCostPricedBand{
+			{Units: 100, CostPerUnitInEuro: mustParseMoney(t, "1.5")},
+		}},
+		{name: "threshold above FromUnits prices everything", mode: ExecutionCostTierModeThreshold, usedUnits: 101, want: []ExecutionCostPricedBand{
+			{Units: 101, CostPerUnitInEuro: mustParseMoney(t, "2")},
+		}},
+		{name: "empty mode is threshold", usedUnits: 101, want: []ExecutionCostPricedBand{
+			{Units: 101, CostPerUnitInEuro: mustParseMoney(t, "2")},
+		}},
+		{name: "graduated split across bands", mode: ExecutionCostTierModeGraduated, usedUnits: 120, want: []ExecutionCostPricedBand{
+			{FromUnits: 0, Units: 50, CostPerUnitInEuro: mustParseMoney(t, "1")},
+			{FromUnits: 50, Units: 50, CostPerUnitInEuro: mustParseMoney(t, "1.5")},
+			{FromUnits: 100, Units: 20, CostPerUnitInEuro: mustParseMoney(t, "2")},
+		}},
+		{name: "graduated ignores the volume", mode: ExecutionCostTierModeGraduated, usedUnits: 30, volumeUnits: 500, want: []ExecutionCostPricedBand{
+			{FromUnits: 0, Units: 30, CostPerUnitInEuro: mustParseMoney(t, "1")},
+		}},
+		{name: "volume starts at the offset", mode: ExecutionCostTierModeVolume, usedUnits: 40, volumeUnits: 80, want: []ExecutionCostPricedBand{
+			{FromUnits: 80, Units: 20, CostPerUnitInEuro: mustParseMoney(t, "1.5")},
+			{FromUnits: 100, Units: 20, CostPerUnitInEuro: mustParseMoney(t, "2")},
+		}},
+		{name: "volume beyond the last tier", mode: ExecutionCostTierModeVolume, usedUnits: 10, volumeUnits: 1000, want: []ExecutionCostPricedBand{
+			{FromUnits: 1000, Units: 10, CostPerUnitInEuro: mustParseMoney(t, "2")},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template := newTestTieredCostTemplate(t, tt.mode)
+			if got := template.GetPriceBands(tt.usedUnits, tt.volumeUnits); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("GetPriceBands(%v, %v) = %+v, want %+v", tt.usedUnits, tt.volumeUnits, got, tt.want)
+			}
+		})
+	}
+
+	flat := ExecutionCostTemplate{CostUnit: AIModelCostUnitPerFunctionCall, CostPerUnitInEuro: mustParseMoney(t, "3")}
+	if got, want := flat.GetPriceBands(7, 100), []ExecutionCostPricedBand{{Units: 7, CostPerUnitInEuro: mustParseMoney(t, "3")}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetPriceBands() without tiers = %+v, want %+v", got, want)
+	}
+}
+
+func TestExecutionCostTemplateGetTimeOfDayTier(t *testing.T) {
+	template := ExecutionCostTemplate{TimeOfDayTiers: []ExecutionCostTimeOfDayTier{
+		{FromHour: 22, ToHour: 6, PriceFactor: 0.5},
+		{FromHour: 8, ToHour: 18, PriceFactor: 1.2},
+		{FromHour: 0, ToHour: 24, PriceFactor: 1},
+	}}
+	tests := []struct {
+		hour   int
+		minute int
+		want   float64
+	}{
+		{hour: 22, want: 0.5},
+		{hour: 23, minute: 59, want: 0.5},
+		{hour: 0, want: 0.5},
+		{hour: 5, minute: 59, want: 0.5},
+		{hour: 6, want: 1},
+		{hour: 8, want: 1.2},
+		{hour: 18, want: 1},
+		{hour: 21, minute: 59, want: 1},
+	}
+	for _, tt := range tests {
+		at := time.Date(2026, 3, 1, tt.hour, tt.minute, 0, 0, time.UTC)
+		tier := template.GetTimeOfDayTier(at)
+		if tier == nil || tier.PriceFactor != tt.want {
+			t.Errorf("GetTimeOfDayTier(%s) = %+v, want the tier with factor %v", at.Format("15:04"), tier, tt.want)
+		}
+	}
+
+	// the hours are UTC, 23:30 in berlin is 22:30 UTC in winter
+	berlin := time.FixedZone("CET", 60*60)
+	if tier := template.GetTimeOfDayTier(time.Date(2026, 3, 1, 23, 30, 0, 0, berlin)); tier == nil || tier.PriceFactor != 0.5 {
+		t.Errorf("GetTimeOfDayTier(23:30 CET) = %+v, want the night tier", tier)
+	}
+	if tier := (&ExecutionCostTemplate{}).GetTimeOfDayTier(time.Now()); tier != nil {
+		t.Errorf("GetTimeOfDayTier() without tiers = %+v, want nil", tier)
+	}
+}
+
+func TestCalculateExecutionUsageCostAppliesThePricingRules(t *testing.T) {
+	night := time.Date(2026, 3, 1, 23, 0, 0, 0, time.UTC)
+	day := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		pricing       ExecutionPricingContext
+		usedUnits     float64
+		wantCost      string
+		wantDiscount  float64
+		wantDayFactor float64
+	}{
+		{name: "graduated", pricing: ExecutionPricingContext{At: day}, usedUnits: 120, wantCost: "165"},
+		{name: "batch discount", pricing: ExecutionPricingContext{At: day, Batch: true}, usedUnits: 120, wantCost: "123.75", wantDiscount: 25},
+		{name: "night tier", pricing: ExecutionPricingContext{At: night}, usedUnits: 120, wantCost: "82.5", wantDayFactor: 0.5},
+		{name: "batch at night", pricing: ExecutionPricingContext{At: night, Batch: true}, usedUnits: 120, wantCost: "61.875", wantDiscount: 25, wantDayFactor: 0.5},
+		{name: "volume is only used by volume tiers", pricing: ExecutionPricingContext{At: day, VolumeUnits: map[AIModelCostUnit]float64{AIModelCostUnitPerFunctionCall: 100}}, usedUnits: 10, wantCost: "10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template := newTestTieredCostTemplate(t, ExecutionCostTierModeGraduated)
+			template.BatchDiscountPercent = 25
+			template.TimeOfDayTiers = []ExecutionCostTimeOfDayTier{{FromHour: 22, ToHour: 6, PriceFactor: 0.5}}
+			usageCost, err := CalculateExecutionUsageCost(&template, tt.usedUnits, 1, tt.pricing)
+			if err != nil {
+				t.Fatalf("CalculateExecutionUsageCost() error = %v", err)
+			}
+			if want := mustParseMoney(t, tt.wantCost); usageCost.ResultingCostInEuro != want {
+				t.Errorf("ResultingCostInEuro = %v, want %v", usageCost.ResultingCostInEuro, want)
+			}
+			if usageCost.BatchDiscountApplied != tt.wantDiscount || usageCost.TimeOfDayPriceFactor != tt.wantDayFactor {
+				t.Errorf("applied batch discount %v and time of day factor %v, want %v and %v", usageCost.BatchDiscountApplied, usageCost.TimeOfDayPriceFactor, tt.wantDiscount, tt.wantDayFactor)
+			}
+		})
+	}
+
+	template := newTestTieredCostTemplate(t, ExecutionCostTierModeVolume)
+	usageCost, err := CalculateExecutionUsageCost(&template, 10, 1, ExecutionPricingContext{At: day, VolumeUnits: map[AIModelCostUnit]float64{AIModelCostUnitPerFunctionCall: 100}})
+	if err != nil {
+		t.Fatalf("CalculateExecutionUsageCost() error = %v", err)
+	}
+	if want := mustParseMoney(t, "20"); usageCost.ResultingCostInEuro != want {
+		t.Errorf("volume priced ResultingCostInEuro = %v, want %v from the tier the volume reached", usageCost.ResultingCostInEuro, want)
+	}
+}
+
+func TestGetUsageLedgerVolumeUnits(t *testing.T) {
+	ctx := context.Background()
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	newEntry := func(id string, modelID string, costUnit AIModelCostUnit, usedUnits float64, currency Currency, at time.Time) UsageLedgerEntry {
+		entry := newTestUsageLedgerEntry(id)
+		entry.ModelID = modelID
+		entry.CostUnit = costUnit
+		entry.UsedUnits = usedUnits
+		entry.Currency = currency
+		entry.Timestamp = at.UnixMilli()
+		return entry
+	}
+	ledger := NewMemoryUsageLedger()
+	err := ledger.Append(ctx,
+		newEntry("e1", "model", AIModelCostUnitInputPerMillionTokens, 0.5, CurrencyEUR, from),
+		newEntry("e2", "model", AIModelCostUnitInputPerMillionTokens, 0.25, CurrencyUSD, from.Add(time.Hour)),
+		newEntry("e3", "model", AIModelCostUnitPerFunctionCall, 3, CurrencyEUR, from.Add(time.Hour)),
+		newEntry("e4", "model", AIModelCostUnitInputPerMillionTokens, 2, CurrencyEUR, from.Add(-time.Hour)),
+		newEntry("e5", "other", AIModelCostUnitInputPerMillionTokens, 2, CurrencyEUR, from.Add(time.Hour)),
+	)
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	volumeUnits, err := GetUsageLedgerVolumeUnits(ctx, ledger, "org1", "model", from)
+	if err != nil {
+		t.Fatalf("GetUsageLedgerVolumeUnits() error = %v", err)
+	}
+	want := map[AIModelCostUnit]float64{AIModelCostUnitInputPerMillionTokens: 750_000, AIModelCostUnitPerFunctionCall: 3}
+	if !reflect.DeepEqual(volumeUnits, want) {
+		t.Fatalf("GetUsageLedgerVolumeUnits() = %v, want the raw units of the model since from across currencies %v", volumeUnits, want)
+	}
+}
@@ -0,0 +1,309 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	nuts "github.com/vaudience/go-nuts"
+)
+
+const (
+	// weight of a new latency sample in the moving average of a model
+	MODELROUTER_LATENCY_SMOOTHING = 0.2
+)
+
+var (
+	ErrNoEligibleAIModel = errors.New("no eligible AI model for the request")
+)
+
+type AIModelRoutingPreference string //@name AIModelRoutingPreference
+
+const (
+	AIModelRoutingPreferenceCost     AIModelRoutingPreference = "cost"
+	AIModelRoutingPreferenceLatency  AIModelRoutingPreference = "latency"
+	AIModelRoutingPreferenceBalanced AIModelRoutingPreference = "balanced"
+)
+
+type AIModelRejectionCode string //@name AIModelRejectionCode
+
+const (
+	AIModelRejectionMissingCapability   AIModelRejectionCode = "missing-capability"
+	AIModelRejectionInputTooLarge       AIModelRejectionCode = "input-too-large"
+	AIModelRejectionOutputTooLarge      AIModelRejectionCode = "output-too-large"
+	AIModelRejectionMimetypeNotAccepted AIModelRejectionCode = "mimetype-not-accepted"
+	AIModelRejectionLocationNotAllowed  AIModelRejectionCode = "hosting-location-not-allowed"
+	AIModelRejectionCostNotCalculable   AIModelRejectionCode = "cost-not-calculable"
+)
+
+// AIModelRoutingRequest describes what a request needs from a model
+type AIModelRoutingRequest struct {
+	RequiredCapabilities []AIModelCapability      `json:"required_capabilities"`
+	InputTokens          int                      `json:"input_tokens"`
+	ExpectedOutputTokens int                      `json:"expected_output_tokens"`
+	AttachmentMimetypes  []string                 `json:"attachment_mimetypes"`
+	AllowedLocations     []HostingLocation        `json:"allowed_locations"` // empty or HostingLocationANY allows all
+	Preference           AIModelRoutingPreference `json:"preference"`        // balanced if empty
+} //@name AIModelRoutingRequest
+
+type AIModelRejectionReason struct {
+	Code    AIModelRejectionCode `json:"code"`
+	Message string               `json:"message"`
+} //@name AIModelRejectionReason
+
+// AIModelRejection explains why a model was not eligible, with every failed requirement
+type AIModelRejection struct {
+	ModelID   string                   `json:"model_id"`
+	ModelName string                   `json:"model_name"`
+	Reasons   []AIModelRejectionReason `json:"reasons"`
+} //@name AIModelRejection
+
+// AIModelRoutingCandidate is an eligible model with the values it was ranked by
+type AIModelRoutingCandidate struct {
+	Model         *AIModel `json:"model"`
+	EstimatedCost Money    `json:"estimated_cost"` // source cost in DEFAULT_CURRENCY, without multiplier
+	Unpriced      bool     `json:"unpriced"`       // the model lacks templates for some usages, so the cost is too low
+	LatencyMs     int64    `json:"latency_ms"`     // moving average, 0 if unknown
+	Score         float64  `json:"score"`          // lower is better
+} //@name AIModelRoutingCandidate
+
+// AIModelRoutingDecision holds the selected model, all eligible candidates in ranking order and the rejected models
+type AIModelRoutingDecision struct {
+	Selected   *AIModel                  `json:"selected"`
+	Candidates []AIModelRoutingCandidate `json:"candidates"`
+	Rejections []AIModelRejection        `json:"rejections"`
+	Preference AIModelRoutingPreference  `json:"preference"`
+	CreatedAt  int64                     `json:"created_at"`
+} //@name AIModelRoutingDecision
+
+// AIModelRouter selects the best model for a request from a set of models by their features, constraints, locations and mimetypes.
+// latencies are learned from the execution results it is fed with.
+type AIModelRouter struct {
+	models    []*AIModel
+	latencies map[string]float64 // model id -> moving average in ms
+	safety    sync.RWMutex
+}
+
+func NewAIModelRouter(models []*AIModel) *AIModelRouter {
+	return &AIModelRouter{
+		models:    models,
+		latencies: make(map[string]float64),
+	}
+}
+
+func (router *AIModelRouter) SetModels(models []*AIModel) {
+	router.safety.Lock()
+	defer router.safety.Unlock()
+	router.models = models
+}
+
+// RecordLatency adds a latency sample of a model to its moving average
+func (router *AIModelRouter) RecordLatency(modelID string, latency time.Duration) {
+	if latency <= 0 {
+		return
+	}
+	router.safety.Lock()
+	defer router.safety.Unlock()
+	sample := float64(latency.Milliseconds())
+	average, known := router.latencies[modelID]
+	if !known {
+		router.latencies[modelID] = sample
+		return
+	}
+	router.latencies[modelID] = average + MODELROUTER_LATENCY_SMOOTHING*(sample-average)
+}
+
+// RecordExecutionResult learns the latency of the model from a finished execution
+func (router *AIModelRouter) RecordExecutionResult(result *ExecutionResult) {
+	if result == nil || result.ErrorMessage != "" {
+		return
+	}
+	router.RecordLatency(result.ModelID, time.Duration(result.TimeNeeded)*time.Millisecond)
+}
+
+// Route checks every model against the request and ranks the eligible ones by the preference.
+// the decision is returned with ErrNoEligibleAIModel as well, so the rejections can be shown.
+func (router *AIModelRouter) Route(request AIModelRoutingRequest) (decision *AIModelRoutingDecision, err error) {
+	var logName string = "[AIModelRouter.Route] "
+	if request.Preference == "" {
+		request.Preference = AIModelRoutingPreferenceBalanced
+	}
+	decision = &AIModelRoutingDecision{
+		Candidates: make([]AIModelRoutingCandidate, 0),
+		Rejections: make([]AIModelRejection, 0),
+		Preference: request.Preference,
+		CreatedAt:  nuts.TimeToJSTimestamp(time.Now()),
+	}
+	router.safety.RLock()
+	models := router.models
+	latencies := make(map[string]float64, len(router.latencies))
+	for modelID, latency := range router.latencies {
+		latencies[modelID] = latency
+	}
+	router.safety.RUnlock()
+
+	for _, aiModel := range models {
+		if aiModel == nil {
+			continue
+		}
+		reasons := aiModel.CheckRoutingRequest(request)
+		candidate := AIModelRoutingCandidate{
+			Model:     aiModel,
+			LatencyMs: int64(latencies[aiModel.ID]),
+		}
+		if len(reasons) == 0 {
+			_, totals, costErr := CalculateCostForUsages(aiModel, request.getUsages(), 1)
+			if costErr != nil {
+				reasons = append(reasons, AIModelRejectionReason{Code: AIModelRejectionCostNotCalculable, Message: costErr.Error()})
+			}
+			candidate.EstimatedCost = totals.TotalSourceCost
+			candidate.Unpriced = len(totals.UnpricedUsages) > 0
+		}
+		if len(reasons) > 0 {
+			decision.Rejections = append(decision.Rejections, AIModelRejection{ModelID: aiModel.ID, ModelName: aiModel.Name, Reasons: reasons})
+			continue
+		}
+		decision.Candidates = append(decision.Candidates, candidate)
+	}
+	if len(decision.Candidates) == 0 {
+		nuts.L.Debugf("%sno eligible model among (%d) models", logName, len(models))
+		return decision, ErrNoEligibleAIModel
+	}
+	rankRoutingCandidates(decision.Candidates, request.Preference)
+	decision.Selected = decision.Candidates[0].Model
+	nuts.L.Debugf("%sselected model(%s) out of (%d) candidates by (%s)", logName, decision.Selected.ID, len(decision.Candidates), request.Preference)
+	return decision, nil
+}
+
+// CheckRoutingRequest returns every requirement of the request the model does not fulfil, empty if it is eligible
+func (aiModel *AIModel) CheckRoutingRequest(request AIModelRoutingRequest) (reasons []AIModelRejectionReason) {
+	reasons = make([]AIModelRejectionReason, 0)
+	for _, capability := range request.RequiredCapabilities {
+		if !aiModel.HasCapability(capability) {
+			reasons = append(reasons, AIModelRejectionReason{Code: AIModelRejectionMissingCapability, Message: fmt.Sprintf("capability (%s) is not supported", capability)})
+		}
+	}
+	if limit := aiModel.GetInputTokenLimit(); limit > 0 && request.InputTokens > limit {
+		reasons = append(reasons, AIModelRejectionReason{Code: AIModelRejectionInputTooLarge, Message: fmt.Sprintf("input of (%d) tokens exceeds the limit of (%d)", request.InputTokens, limit)})
+	}
+	if limit := aiModel.GetOutputTokenLimit(); limit > 0 && request.ExpectedOutputTokens > limit {
+		reasons = append(reasons, AIModelRejectionReason{Code: AIModelRejectionOutputTooLarge, Message: fmt.Sprintf("output of (%d) tokens exceeds the limit of (%d)", request.ExpectedOutputTokens, limit)})
+	}
+	for _, mimetype := range request.AttachmentMimetypes {
+		if !aiModel.AcceptsMimetype(mimetype) {
+			reasons = append(reasons, AIModelRejectionReason{Code: AIModelRejectionMimetypeNotAccepted, Message: fmt.Sprintf("attachments of type (%s) are not accepted", mimetype)})
+		}
+	}
+	if !aiModel.IsHostedIn(request.AllowedLocations) {
+		reasons = append(reasons, AIModelRejectionReason{Code: AIModelRejectionLocationNotAllowed, Message: fmt.Sprintf("hosted in (%v), allowed are (%v)", aiModel.ServiceHostLocations, request.AllowedLocations)})
+	}
+	return reasons
+}
+
+func (aiModel *AIModel) HasCapability(capability AIModelCapability) bool {
+	for _, feature := range aiModel.Features {
+		if feature.Capability == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// AcceptsMimetype matches the mimetype against AcceptedFileMimetypes, which may contain wildcards like "image/*".
+// an empty AcceptedFileMimetypes declares no restriction, so every mimetype is accepted.
+func (aiModel *AIModel) AcceptsMimetype(mimetype string) bool {
+	if len(aiModel.AcceptedFileMimetypes) == 0 {
+		return true
+	}
+	mimetype = strings.ToLower(strings.TrimSpace(mimetype))
+	for _, accepted := range aiModel.AcceptedFileMimetypes {
+		accepted = strings.ToLower(strings.TrimSpace(accepted))
+		if accepted == "*/*" || accepted == mimetype {
+			return true
+		}
+		if prefix, isWildcard := strings.CutSuffix(accepted, "*"); isWildcard && strings.HasPrefix(mimetype, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsHostedIn tells if one of the ServiceHostLocations is within the allowed locations
+func (aiModel *AIModel) IsHostedIn(allowedLocations []HostingLocation) bool {
+	if len(allowedLocations) == 0 {
+		return true
+	}
+	for _, allowed := range allowedLocations {
+		if allowed == HostingLocationANY {
+			return true
+		}
+		for _, location := range aiModel.ServiceHostLocations {
+			if location.IsWithin(allowed) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsWithin tells if the location is covered by other, e.g. germany is within europe
+func (location HostingLocation) IsWithin(other HostingLocation) bool {
+	if location == other || other == HostingLocationANY {
+		return true
+	}
+	return location == HostingLocationGERMANY && other == HostingLocationEU
+}
+
+func (request AIModelRoutingRequest) getUsages() []ExecutionUsage {
+	imageInputs := 0
+	for _, mimetype := range request.AttachmentMimetypes {
+		if strings.HasPrefix(strings.ToLower(mimetype), "image/") {
+			imageInputs++
+		}
+	}
+	return []ExecutionUsage{
+		NewExecutionUsage(AIModelCapabilityTextToText, AIModelCostUnitInputPerMillionTokens, float64(request.InputTokens)),
+		NewExecutionUsage(AIModelCapabilityTextToText, AIModelCostUnitOutputPerMillionTokens, float64(request.ExpectedOutputTokens)),
+		NewExecutionUsage(AIModelCapabilityImageToText, AIModelCostUnitImageInputPerFile, float64(imageInputs)),
+	}
+}
+
+// rankRoutingCandidates scores the candidates relative to the cheapest and fastest one and sorts them, best first.
+// unknown latencies count as the slowest known one, unpriced models rank behind priced ones.
+func rankRoutingCandidates(candidates []AIModelRoutingCandidate, preference AIModelRoutingPreference) {
+	var maxCost Money
+	var maxLatency int64
+	for _, candidate := range candidates {
+		maxCost = max(maxCost, candidate.EstimatedCost)
+		maxLatency = max(maxLatency, candidate.LatencyMs)
+	}
+	costWeight, latencyWeight := 0.5, 0.5
+	switch preference {
+	case AIModelRoutingPreferenceCost:
+		costWeight, latencyWeight = 1, 0.01
+	case AIModelRoutingPreferenceLatency:
+		costWeight, latencyWeight = 0.01, 1
+	}
+	for n := range candidates {
+		costScore, latencyScore := 0.0, 1.0
+		if maxCost > 0 {
+			costScore = float64(candidates[n].EstimatedCost) / float64(maxCost)
+		}
+		if candidates[n].Unpriced {
+			costScore = 1
+		}
+		if maxLatency > 0 && candidates[n].LatencyMs > 0 {
+			latencyScore = float64(candidates[n].LatencyMs) / float64(maxLatency)
+		}
+		candidates[n].Score = costWeight*costScore + latencyWeight*latencyScore
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Unpriced != candidates[j].Unpriced {
+			return !candidates[i].Unpriced
+		}
+		return candidates[i].Score < candidates[j].Score
+	})
+}
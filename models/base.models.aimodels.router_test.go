@@ -0,0 +1,131 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newRouterTestModel is a text model priced per million input and output tokens, an empty output price leaves the output unpriced
+func newRouterTestModel(t *testing.T, id string, inputPrice string, outputPrice string) *AIModel {
+	templates := []ExecutionCostTemplate{{CostUnit: AIModelCostUnitInputPerMillionTokens, CostPerUnitInEuro: mustParseMoney(t, inputPrice)}}
+	if outputPrice != "" {
+		templates = append(templates, ExecutionCostTemplate{CostUnit: AIModelCostUnitOutputPerMillionTokens, CostPerUnitInEuro: mustParseMoney(t, outputPrice)})
+	}
+	aiModel := newCostTestModel(newCostTestFeature(AIModelCapabilityTextToText, templates...))
+	aiModel.ID = id
+	aiModel.Name = id
+	return aiModel
+}
+
+func getRoutingCandidateIDs(candidates []AIModelRoutingCandidate) (ids []string) {
+	for _, candidate := range candidates {
+		ids = append(ids, candidate.Model.ID)
+	}
+	return ids
+}
+
+func TestAIModelRouterRejectsIneligibleModels(t *testing.T) {
+	limited := newRouterTestModel(t, "limited", "1", "2")
+	limited.MaxInputTokens = 1000
+	limited.MaxOutputTokens = 100
+	limited.AcceptedFileMimetypes = []string{"image/*"}
+	limited.ServiceHostLocations = []HostingLocation{HostingLocationGERMANY}
+	broken := newRouterTestModel(t, "broken", "1", "2")
+	broken.Features[0].CostItemTemplates[0].CostPerUnitInEuro = Money(math.MaxInt64)
+
+	tests := []struct {
+		name      string
+		model     *AIModel
+		request   AIModelRoutingRequest
+		wantCodes []AIModelRejectionCode
+	}{
+		{name: "eligible", model: limited, request: AIModelRoutingRequest{RequiredCapabilities: []AIModelCapability{AIModelCapabilityTextToText}, InputTokens: 1000, ExpectedOutputTokens: 100, AttachmentMimetypes: []string{"image/png"}, AllowedLocations: []HostingLocation{HostingLocationEU}}},
+		{name: "missing capability", model: limited, request: AIModelRoutingRequest{RequiredCapabilities: []AIModelCapability{AIModelCapabilityTextToImage}}, wantCodes: []AIModelRejectionCode{AIModelRejectionMissingCapability}},
+		{name: "input too large", model: limited, request: AIModelRoutingRequest{InputTokens: 1001}, wantCodes: []AIModelRejectionCode{AIModelRejectionInputTooLarge}},
+		{name: "output too large", model: limited, request: AIModelRoutingRequest{ExpectedOutputTokens: 101}, wantCodes: []AIModelRejectionCode{AIModelRejectionOutputTooLarge}},
+		{name: "no declared mimetypes accept all", model: broken, request: AIModelRoutingRequest{AttachmentMimetypes: []string{"application/pdf", "image/png"}}},
+		{name: "mimetype not accepted", model: limited, request: AIModelRoutingRequest{AttachmentMimetypes: []string{"application/pdf"}}, wantCodes: []AIModelRejectionCode{AIModelRejectionMimetypeNotAccepted}},
+		{name: "location not allowed", model: limited, request: AIModelRoutingRequest{AllowedLocations: []HostingLocation{HostingLocationUSA}}, wantCodes: []AIModelRejectionCode{AIModelRejectionLocationNotAllowed}},
+		{name: "every failed requirement", model: limited, request: AIModelRoutingRequest{RequiredCapabilities: []AIModelCapability{AIModelCapabilityEmbeddings}, InputTokens: 5000, AllowedLocations: []HostingLocation{HostingLocationUK}}, wantCodes: []AIModelRejectionCode{AIModelRejectionMissingCapability, AIModelRejectionInputTooLarge, AIModelRejectionLocationNotAllowed}},
+		{name: "cost not calculable", model: broken, request: AIModelRoutingRequest{InputTokens: 2_000_000}, wantCodes: []AIModelRejectionCode{AIModelRejectionCostNotCalculable}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, err := NewAIModelRouter([]*AIModel{tt.model}).Route(tt.request)
+			if len(tt.wantCodes) == 0 {
+				if err != nil || decision.Selected != tt.model || len(decision.Rejections) != 0 {
+					t.Fatalf("Route() = %+v, %v, want %s selected", decision, err, tt.model.ID)
+				}
+				return
+			}
+			if !errors.Is(err, ErrNoEligibleAIModel) {
+				t.Fatalf("Route() error = %v, want %v", err, ErrNoEligibleAIModel)
+			}
+			if decision.Selected != nil || len(decision.Rejections) != 1 || decision.Rejections[0].ModelID != tt.model.ID {
+				t.Fatalf("decision = %+v, want only a rejection of %s", decision, tt.model.ID)
+			}
+			codes := make([]AIModelRejectionCode, 0)
+			for _, reason := range decision.Rejections[0].Reasons {
+				codes = append(codes, reason.Code)
+			}
+			if !reflect.DeepEqual(codes, tt.wantCodes) {
+				t.Fatalf("rejected for %v, want %v", codes, tt.wantCodes)
+			}
+		})
+	}
+}
+
+func TestAIModelRouterRanksByPreference(t *testing.T) {
+	cheap := newRouterTestModel(t, "cheap", "1", "2")
+	fast := newRouterTestModel(t, "fast", "10", "20")
+	middle := newRouterTestModel(t, "middle", "3", "6")
+	unpriced := newRouterTestModel(t, "unpriced", "0.1", "")
+	router := NewAIModelRouter([]*AIModel{cheap, fast, middle, unpriced})
+	router.RecordLatency(cheap.ID, 900*time.Millisecond)
+	router.RecordLatency(fast.ID, 100*time.Millisecond)
+	router.RecordLatency(middle.ID, 300*time.Millisecond)
+
+	tests := []struct {
+		preference AIModelRoutingPreference
+		want       []string
+	}{
+		{preference: AIModelRoutingPreferenceCost, want: []string{"cheap", "middle", "fast", "unpriced"}},
+		{preference: AIModelRoutingPreferenceLatency, want: []string{"fast", "middle", "cheap", "unpriced"}},
+		{preference: AIModelRoutingPreferenceBalanced, want: []string{"middle", "cheap", "fast", "unpriced"}},
+		{preference: "", want: []string{"middle", "cheap", "fast", "unpriced"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.preference), func(t *testing.T) {
+			decision, err := router.Route(AIModelRoutingRequest{InputTokens: 1000, ExpectedOutputTokens: 100, Preference: tt.preference})
+			if err != nil {
+				t.Fatalf("Route() error = %v", err)
+			}
+			if got := getRoutingCandidateIDs(decision.Candidates); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ranked %v, want %v", got, tt.want)
+			}
+			if decision.Selected.ID != tt.want[0] {
+				t.Errorf("selected %s, want %s", decision.Selected.ID, tt.want[0])
+			}
+		})
+	}
+
+	decision, _ := router.Route(AIModelRoutingRequest{InputTokens: 1000, ExpectedOutputTokens: 100, Preference: AIModelRoutingPreferenceCost})
+	wantCosts := map[string]string{"cheap": "0.0012", "middle": "0.0036", "fast": "0.012", "unpriced": "0.0001"}
+	for _, candidate := range decision.Candidates {
+		if want := mustParseMoney(t, wantCosts[candidate.Model.ID]); candidate.EstimatedCost != want {
+			t.Errorf("estimated cost of %s = %v, want the source cost %v", candidate.Model.ID, candidate.EstimatedCost, want)
+		}
+		if candidate.Unpriced != (candidate.Model.ID == "unpriced") {
+			t.Errorf("%s unpriced = %v", candidate.Model.ID, candidate.Unpriced)
+		}
+	}
+
+	router.RecordLatency(cheap.ID, 100*time.Millisecond)
+	decision, _ = router.Route(AIModelRoutingRequest{InputTokens: 1000, ExpectedOutputTokens: 100, Preference: AIModelRoutingPreferenceLatency})
+	if got, want := getRoutingCandidateIDs(decision.Candidates), []string{"fast", "middle", "cheap", "unpriced"}; !reflect.DeepEqual(got, want) || decision.Candidates[2].LatencyMs != 740 {
+		t.Errorf("ranked %v with a latency of %d for cheap, want %v and the moving average 740", got, decision.Candidates[2].LatencyMs, want)
+	}
+}
@@ -29,6 +29,17 @@ const (
 	HostingLocationANY     HostingLocation = "any"
 )
 
+var HostingLocations = []HostingLocation{HostingLocationUSA, HostingLocationEU, HostingLocationGERMANY, HostingLocationUK, HostingLocationSWISS, HostingLocationANY}
+
+func (location HostingLocation) IsValid() bool {
+	for _, known := range HostingLocations {
+		if location == known {
+			return true
+		}
+	}
+	return false
+}
+
 type AIModelServiceWithModels struct {
 	Service *AIModelServiceObject `json:"service"`
 	Models  []*AIModel            `json:"models"`
@@ -52,6 +63,14 @@ type ExecutionResult struct {
 	ErrorMessage string           `json:"error_message"`
 	FinishReason string           `json:"finish_reason"`
 	FeaturesUsed []AIModelFeature `json:"features_used"`
+	Usages       []ExecutionUsage `json:"usages,omitempty"` // raw usages, priced against the serving model if FeaturesUsed is empty
+	// failover: the model the agent asked for and the models that failed before ModelID served the request
+	RequestedModelID string             `json:"requested_model_id,omitempty"`
+	FailedAttempts   []ExecutionAttempt `json:"failed_attempts,omitempty"`
+	// residency: where the request was processed, see ResolveCompliantEndpoint
+	HostingEndpoint    string          `json:"hosting_endpoint,omitempty"`
+	HostingLocation    HostingLocation `json:"hosting_location,omitempty"`
+	ResidencyCompliant *bool           `json:"residency_compliant,omitempty"` // nil if no residency was resolved
 } //@name ExecutionResult
 
 type ExecutionResultText2Text struct {
@@ -60,6 +79,8 @@ type ExecutionResultText2Text struct {
 	FinishReason string `json:"finish_reason"`
 	InputTokens  int    `json:"input_tokens"`
 	OutputTokens int    `json:"output_tokens"`
+	// the tools the model wants to call, answered with AIgencyMessageTypeToolResponse messages
+	ToolCalls []ExecutionToolCall `json:"tool_calls,omitempty"`
 }
 
 type AIModelServiceObject struct {
@@ -68,7 +89,7 @@ type AIModelServiceObject struct {
 	Description         string                     `json:"description" validate:"omitempty,max=1024" writexs:"system,admin,owner" readxs:"*"`
 	CostMultiplier      float64                    `json:"cost_multiplier" validate:"omitempty" writexs:"system,admin,owner" readxs:"system,admin,owner"`   // 1.0 is default, we use this to adjust our margin
 	ServiceImpl         string                     `json:"service_impl" validate:"required,min=1,max=64" writexs:"system,admin,owner" readxs:"admin,owner"` // this is used for internal identification!
-	HostingLocations    map[string]HostingLocation `json:"hosting_locations" validate:"omitempty" writexs:"system,admin,owner" readxs:"admin,owner"`
+	HostingLocations    map[string]HostingLocation `json:"hosting_locations" validate:"omitempty" writexs:"system,admin,owner" readxs:"admin,owner"`        // by the base url of the endpoint, see ResolveCompliantEndpoint
 	IsPublic            bool                       `json:"is_public" writexs:"system,admin,owner" readxs:"admin,owner"`
 	OwnerId             string                     `json:"owner_id" validate:"required,min=1,max=64" writexs:"system" readxs:"admin,owner"`
 	OwnerOrganizationId string                     `json:"owner_organization_id" validate:"required,min=1,max=64" writexs:"system" readxs:"admin,owner"`
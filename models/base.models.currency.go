@@ -0,0 +1,193 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	nuts "github.com/vaudience/go-nuts"
+)
+
+const (
+	// FX_RATES holds the static fx table as "USD:1.08,GBP:0.85" - units of each currency per 1 DEFAULT_CURRENCY
+	FX_RATES_CONFIG_KEY = "FX_RATES"
+	DEFAULT_CURRENCY    = CurrencyEUR
+)
+
+var (
+	ErrUnknownCurrency   = errors.New("unknown currency")
+	ErrFXRateNotFound    = errors.New("no fx rate available")
+	ErrInvalidFXRate     = errors.New("invalid fx rate")
+	ErrNoFXRateProvider  = errors.New("no fx rate provider configured")
+	ErrInvalidFXRateList = errors.New("invalid fx rate configuration")
+	ErrCurrencyMismatch  = errors.New("amounts are in different currencies")
+)
+
+type Currency string //@name Currency
+
+const (
+	CurrencyEUR Currency = "EUR"
+	CurrencyUSD Currency = "USD"
+	CurrencyGBP Currency = "GBP"
+	CurrencyCHF Currency = "CHF"
+)
+
+var Currencies = []Currency{CurrencyEUR, CurrencyUSD, CurrencyGBP, CurrencyCHF}
+
+func (currency Currency) String() string {
+	return string(currency)
+}
+
+func (currency Currency) IsValid() bool {
+	for _, known := range Currencies {
+		if currency == known {
+			return true
+		}
+	}
+	return false
+}
+
+// OrDefault returns the currency or DEFAULT_CURRENCY if it is not set, since all prices used to be in euro
+func (currency Currency) OrDefault() Currency {
+	if currency == "" {
+		return DEFAULT_CURRENCY
+	}
+	return currency
+}
+
+// FXRateProvider delivers the rate to convert an amount in currency `from` into currency `to` at a given time
+type FXRateProvider interface {
+	GetRate(from Currency, to Currency, at time.Time) (rate float64, err error)
+}
+
+// StaticFXRateProvider uses a fixed table of rates relative to DEFAULT_CURRENCY, e.g. for offline use
+type StaticFXRateProvider struct {
+	rates  map[Currency]float64
+	safety sync.RWMutex
+}
+
+func NewStaticFXRateProvider(ratesPerDefaultCurrency map[Currency]float64) *StaticFXRateProvider {
+	provider := &StaticFXRateProvider{
+		rates: map[Currency]float64{DEFAULT_CURRENCY: 1},
+	}
+	for currency, rate := range ratesPerDefaultCurrency {
+		_ = provider.SetRate(currency, rate)
+	}
+	return provider
+}
+
+// NewStaticFXRateProviderFromConfig loads the rate table from the FX_RATES config value
+func NewStaticFXRateProviderFromConfig() (provider *StaticFXRateProvider, err error) {
+	rates, err := ParseFXRates(viper.GetString(FX_RATES_CONFIG_KEY))
+	if err != nil {
+		return nil, err
+	}
+	return NewStaticFXRateProvider(rates), nil
+}
+
+// ParseFXRates parses a rate table like "USD:1.08,GBP:0.85"
+func ParseFXRates(text string) (rates map[Currency]float64, err error) {
+	rates = make(map[Currency]float64)
+	for _, entry := range strings.Split(text, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidFXRateList, entry)
+		}
+		currency := Currency(strings.ToUpper(strings.TrimSpace(parts[0])))
+		if !currency.IsValid() {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownCurrency, currency)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidFXRate, entry)
+		}
+		rates[currency] = rate
+	}
+	return rates, nil
+}
+
+func (provider *StaticFXRateProvider) SetRate(currency Currency, ratePerDefaultCurrency float64) error {
+	if !currency.IsValid() {
+		return fmt.Errorf("%w: %s", ErrUnknownCurrency, currency)
+	}
+	if ratePerDefaultCurrency <= 0 {
+		return fmt.Errorf("%w: %s(%v)", ErrInvalidFXRate, currency, ratePerDefaultCurrency)
+	}
+	provider.safety.Lock()
+	defer provider.safety.Unlock()
+	provider.rates[currency] = ratePerDefaultCurrency
+	return nil
+}
+
+func (provider *StaticFXRateProvider) GetRate(from Currency, to Currency, at time.Time) (rate float64, err error) {
+	from, to = from.OrDefault(), to.OrDefault()
+	if from == to {
+		return 1, nil
+	}
+	provider.safety.RLock()
+	defer provider.safety.RUnlock()
+	fromRate, fromFound := provider.rates[from]
+	toRate, toFound := provider.rates[to]
+	if !fromFound || !toFound {
+		return 0, fmt.Errorf("%w: %s->%s", ErrFXRateNotFound, from, to)
+	}
+	return toRate / fromRate, nil
+}
+
+var (
+	fxRateProvider       FXRateProvider
+	fxRateProviderSafety sync.RWMutex
+)
+
+// SetFXRateProvider replaces the provider used for cost conversion
+func SetFXRateProvider(provider FXRateProvider) {
+	fxRateProviderSafety.Lock()
+	defer fxRateProviderSafety.Unlock()
+	fxRateProvider = provider
+}
+
+// GetFXRateProvider returns the configured provider, lazily falling back to the static table from the config
+func GetFXRateProvider() FXRateProvider {
+	var logName string = "[GetFXRateProvider] "
+	fxRateProviderSafety.Lock()
+	defer fxRateProviderSafety.Unlock()
+	if fxRateProvider == nil {
+		provider, err := NewStaticFXRateProviderFromConfig()
+		if err != nil {
+			nuts.L.Errorf("%sfailed to load fx rates from config, only %s is available: %v", logName, DEFAULT_CURRENCY, err)
+			provider = NewStaticFXRateProvider(nil)
+		}
+		fxRateProvider = provider
+	}
+	return fxRateProvider
+}
+
+// ConvertMoney converts an amount between currencies and returns the used rate
+func ConvertMoney(amount Money, from Currency, to Currency, provider FXRateProvider, at time.Time) (converted Money, rate float64, err error) {
+	if from.OrDefault() == to.OrDefault() {
+		return amount, 1, nil
+	}
+	if provider == nil {
+		return 0, 0, ErrNoFXRateProvider
+	}
+	rate, err = provider.GetRate(from, to, at)
+	if err != nil {
+		return 0, 0, err
+	}
+	if rate <= 0 {
+		return 0, 0, fmt.Errorf("%w: %s->%s(%v)", ErrInvalidFXRate, from, to, rate)
+	}
+	converted, err = amount.MulFloatChecked(rate)
+	if err != nil {
+		return 0, 0, err
+	}
+	return converted, rate, nil
+}
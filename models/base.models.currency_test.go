@@ -0,0 +1,121 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseFXRates(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    map[Currency]float64
+		wantErr error
+	}{
+		{name: "rate table", text: "USD:1.08,GBP:0.85", want: map[Currency]float64{CurrencyUSD: 1.08, CurrencyGBP: 0.85}},
+		{name: "spaces, lower case and empty entries", text: " usd : 1.08 ,, chf:0.95,", want: map[Currency]float64{CurrencyUSD: 1.08, CurrencyCHF: 0.95}},
+		{name: "empty", text: "", want: map[Currency]float64{}},
+		{name: "missing rate", text: "USD", wantErr: ErrInvalidFXRateList},
+		{name: "unknown currency", text: "JPY:160", wantErr: ErrUnknownCurrency},
+		{name: "not a number", text: "USD:abc", wantErr: ErrInvalidFXRate},
+		{name: "zero rate", text: "USD:0", wantErr: ErrInvalidFXRate},
+		{name: "negative rate", text: "USD:-1.08", wantErr: ErrInvalidFXRate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rates, err := ParseFXRates(tt.text)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseFXRates(%q) error = %v, want %v", tt.text, err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(rates, tt.want) {
+				t.Fatalf("ParseFXRates(%q) = %v, want %v", tt.text, rates, tt.want)
+			}
+		})
+	}
+}
+
+func TestStaticFXRateProviderGetRate(t *testing.T) {
+	provider := NewStaticFXRateProvider(map[Currency]float64{CurrencyUSD: 1.25, CurrencyGBP: 0.8})
+	tests := []struct {
+		name    string
+		from    Currency
+		to      Currency
+		want    float64
+		wantErr error
+	}{
+		{name: "direct", from: CurrencyEUR, to: CurrencyUSD, want: 1.25},
+		{name: "inverse", from: CurrencyUSD, to: CurrencyEUR, want: 0.8},
+		{name: "cross", from: CurrencyGBP, to: CurrencyUSD, want: 1.5625},
+		{name: "same currency", from: CurrencyCHF, to: CurrencyCHF, want: 1},
+		{name: "unset currency is the default", from: "", to: CurrencyEUR, want: 1},
+		{name: "missing rate", from: CurrencyEUR, to: CurrencyCHF, wantErr: ErrFXRateNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate, err := provider.GetRate(tt.from, tt.to, time.Now())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetRate(%s, %s) error = %v, want %v", tt.from, tt.to, err, tt.wantErr)
+			}
+			if math.Abs(rate-tt.want) > 1e-12 {
+				t.Fatalf("GetRate(%s, %s) = %v, want %v", tt.from, tt.to, rate, tt.want)
+			}
+		})
+	}
+
+	if err := provider.SetRate("JPY", 160); !errors.Is(err, ErrUnknownCurrency) {
+		t.Errorf("SetRate(JPY) error = %v, want %v", err, ErrUnknownCurrency)
+	}
+	if err := provider.SetRate(CurrencyCHF, 0); !errors.Is(err, ErrInvalidFXRate) {
+		t.Errorf("SetRate(CHF, 0) error = %v, want %v", err, ErrInvalidFXRate)
+	}
+}
+
+// fixedFXRateProvider returns the same rate for every pair
+type fixedFXRateProvider float64
+
+func (provider fixedFXRateProvider) GetRate(from Currency, to Currency, at time.Time) (float64, error) {
+	return float64(provider), nil
+}
+
+func TestConvertMoney(t *testing.T) {
+	static := NewStaticFXRateProvider(map[Currency]float64{CurrencyUSD: 1.08, CurrencyGBP: 0.85})
+	tests := []struct {
+		name     string
+		amount   string
+		from     Currency
+		to       Currency
+		provider FXRateProvider
+		want     string
+		wantRate float64
+		wantErr  error
+	}{
+		{name: "direct", amount: "10", from: CurrencyEUR, to: CurrencyUSD, provider: static, want: "10.8", wantRate: 1.08},
+		{name: "inverse", amount: "10.8", from: CurrencyUSD, to: CurrencyEUR, provider: static, want: "10", wantRate: 1 / 1.08},
+		{name: "rounds half even to nano euros", amount: "0.000000005", from: CurrencyEUR, to: CurrencyUSD, provider: fixedFXRateProvider(0.5), want: "0.000000002", wantRate: 0.5},
+		{name: "same currency needs no provider", amount: "3.5", from: CurrencyUSD, to: CurrencyUSD, want: "3.5", wantRate: 1},
+		{name: "no provider", amount: "1", from: CurrencyEUR, to: CurrencyUSD, wantErr: ErrNoFXRateProvider},
+		{name: "missing rate", amount: "1", from: CurrencyEUR, to: CurrencyCHF, provider: static, wantErr: ErrFXRateNotFound},
+		{name: "invalid rate", amount: "1", from: CurrencyEUR, to: CurrencyUSD, provider: fixedFXRateProvider(0), wantErr: ErrInvalidFXRate},
+		{name: "overflow", amount: "1000000000", from: CurrencyEUR, to: CurrencyUSD, provider: fixedFXRateProvider(1e12), wantErr: ErrMoneyOverflow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			converted, rate, err := ConvertMoney(mustParseMoney(t, tt.amount), tt.from, tt.to, tt.provider, time.Now())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ConvertMoney() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if converted != mustParseMoney(t, tt.want) {
+				t.Errorf("ConvertMoney() = %v, want %s", converted, tt.want)
+			}
+			if math.Abs(rate-tt.wantRate) > 1e-12 {
+				t.Errorf("ConvertMoney() rate = %v, want %v", rate, tt.wantRate)
+			}
+		})
+	}
+}
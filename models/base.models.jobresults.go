@@ -1,14 +1,19 @@
 package models
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
 
 type JobResults struct {
-	JobId       string                    `json:"jobId"`
-	AdapterName string                    `json:"adapterName"`
-	ResultTexts []string                  `json:"resultTexts"`
-	ResultFiles []AdapterFileInfo         `json:"resultFiles"`
-	FinalState  AdapterToolExecutionState `json:"finalState"`
-	Err         error                     `json:"err"`
+	JobId            string                    `json:"jobId"`
+	AdapterName      string                    `json:"adapterName"`
+	ResultTexts      []string                  `json:"resultTexts"`
+	ResultFiles      []AdapterFileInfo         `json:"resultFiles"`
+	StructuredResult map[string]any            `json:"structuredResult"` // set when the tool declares a ResponseFormat and the result matched it
+	FinalState       AdapterToolExecutionState `json:"finalState"`
+	Err              error                     `json:"err"`
 }
 
 func NewJobResults(jobId string, adapterName string) *JobResults {
@@ -22,11 +27,7 @@ func NewJobResults(jobId string, adapterName string) *JobResults {
 }
 
 func (jr *JobResults) GetResultText(joinBy string) string {
-	joined := ""
-	for _, result := range jr.ResultTexts {
-		joined += result + joinBy
-	}
-	return joined
+	return strings.Join(jr.ResultTexts, joinBy)
 }
 
 func (jr *JobResults) GetResultFiles() []AdapterFileInfo {
@@ -46,8 +47,28 @@ func (jr *JobResults) GetResultFilesText() string {
 	for n, result := range jr.ResultFiles {
 		joined += fmt.Sprintf("%d. File Name: '%s'\n", n+1, result.FileName)
 		joined += fmt.Sprintf("  - Mime Type: '%s'\n", result.MimeType)
-		joined += fmt.Sprintf("  - Local File Path: '%s'\n", result.LocalPath)
 		joined += fmt.Sprintf("  - Public Url: '%s'\n", result.PublicUrl)
 	}
 	return joined
 }
+
+func (jr *JobResults) HasStructuredResult() bool {
+	return jr.StructuredResult != nil
+}
+
+func (jr *JobResults) GetStructuredResultValue(key string) (val any, ok bool) {
+	val, ok = jr.StructuredResult[key]
+	return val, ok
+}
+
+// DecodeStructuredResult decodes the validated structured result into a typed struct
+func (jr *JobResults) DecodeStructuredResult(target any) error {
+	if jr.StructuredResult == nil {
+		return ErrToolResponseNotStructured
+	}
+	structuredJsonBytes, err := json.Marshal(jr.StructuredResult)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(structuredJsonBytes, target)
+}
@@ -0,0 +1,155 @@
+package models
+
+import "fmt"
+
+const (
+	TOOL_RESPONSE_DEFAULT_MAX_TOKENS   = 8000
+	TOOL_RESPONSE_DEFAULT_SEPARATOR    = "\n"
+	TOOL_RESPONSE_TRUNCATION_NOTICE    = "\n[... tool output truncated, %d of %d tokens shown]"
+	TOOL_RESPONSE_METADATA_TOOL_NAME   = "tool_name"
+	TOOL_RESPONSE_METADATA_JOB_ID      = "tool_job_id"
+	TOOL_RESPONSE_METADATA_FINAL_STATE = "tool_final_state"
+	TOOL_RESPONSE_METADATA_TRUNCATED   = "truncated"
+	TOOL_RESPONSE_METADATA_SUMMARIZED  = "summarized"
+	TOOL_RESPONSE_METADATA_FULL_TOKENS = "original_token_count"
+)
+
+type ToolResponseTruncationMode string //@name ToolResponseTruncationMode
+
+const (
+	ToolResponseTruncationModeTruncate ToolResponseTruncationMode = "truncate"
+	ToolResponseTruncationModeSummary  ToolResponseTruncationMode = "summary"
+)
+
+// ToolResponseSummarizer condenses oversized tool output to at most maxTokens, e.g. by asking a cheap llm
+type ToolResponseSummarizer func(text string, maxTokens int) (summary string, err error)
+
+type ToolResponseRenderOptions struct {
+	TextSeparator  string                     `json:"text_separator"`
+	MaxTokens      int                        `json:"max_tokens"` // 0 disables truncation
+	TruncationMode ToolResponseTruncationMode `json:"truncation_mode"`
+	Summarizer     ToolResponseSummarizer     `json:"-"` // used by ToolResponseTruncationModeSummary, falls back to truncation
+	AIModel        *AIModel                   `json:"-"` // counts the tokens with its tokenizer, the default tokenizer if nil
+}
+
+func NewToolResponseRenderOptions() ToolResponseRenderOptions {
+	return ToolResponseRenderOptions{
+		TextSeparator:  TOOL_RESPONSE_DEFAULT_SEPARATOR,
+		MaxTokens:      TOOL_RESPONSE_DEFAULT_MAX_TOKENS,
+		TruncationMode: ToolResponseTruncationModeTruncate,
+	}
+}
+
+// RenderToolResponseMessage converts the JobResults of a tool call into a toolResponse AIgencyMessage.
+// callingMessage is the message that contained the tool call; its routing fields are copied.
+// result files are added as file contents without exposing their local paths.
+func RenderToolResponseMessage(jobResults *JobResults, callingMessage *AIgencyMessage, options ToolResponseRenderOptions) (msg *AIgencyMessage) {
+	if options.TextSeparator == "" {
+		options.TextSeparator = TOOL_RESPONSE_DEFAULT_SEPARATOR
+	}
+	msg = NewAIgencyMessage()
+	msg.Type = AIgencyMessageTypeToolResponse
+	msg.ResponseToID = jobResults.JobId
+	msg.SenderID = jobResults.AdapterName
+	msg.SenderName = jobResults.AdapterName
+	// tool results are input for the model, providers map them to their tool-result format by message Type
+	msg.SenderConversationRole = ConversationRoleUser
+	msg.TokenDirection = InputToken
+	if callingMessage != nil {
+		msg.ReferenceID = callingMessage.ID
+		msg.MissionID = callingMessage.MissionID
+		msg.ChannelID = callingMessage.ChannelID
+		msg.ChannelName = callingMessage.ChannelName
+		msg.AIgentThreadID = callingMessage.AIgentThreadID
+		msg.OwnerOrganizationId = callingMessage.OwnerOrganizationId
+		msg.AIServiceID = callingMessage.AIServiceID
+		msg.AIModelID = callingMessage.AIModelID
+	}
+	msg.MetaData[TOOL_RESPONSE_METADATA_TOOL_NAME] = jobResults.AdapterName
+	msg.MetaData[TOOL_RESPONSE_METADATA_JOB_ID] = jobResults.JobId
+	msg.MetaData[TOOL_RESPONSE_METADATA_FINAL_STATE] = jobResults.FinalState
+
+	// only the result text is shortened, the file references always stay in the message
+	text, filesText := renderJobResultsText(jobResults, options.TextSeparator)
+	if options.MaxTokens > 0 {
+		maxTokens := options.MaxTokens - CalculateTokenCountForModel(options.AIModel, filesText)
+		if maxTokens < 0 {
+			maxTokens = 0
+		}
+		fullTokenCount := CalculateTokenCountForModel(options.AIModel, text)
+		if fullTokenCount > maxTokens {
+			msg.MetaData[TOOL_RESPONSE_METADATA_FULL_TOKENS] = fullTokenCount
+			text = shortenToolResponseText(text, fullTokenCount, maxTokens, options, msg.MetaData)
+		}
+	}
+	text += filesText
+	msg.Content.AddContent(NewAIgencyMessageContent(AIgencyMessageContentTypeText, &text, nil))
+	for _, resultFile := range jobResults.GetResultFiles() {
+		file := NewAIgencyMessageFile(resultFile.FileName, "", resultFile.MimeType, resultFile.PublicUrl)
+		msg.Content.AddContent(NewAIgencyMessageContent(AIgencyMessageContentTypeFile, nil, file))
+	}
+	if jobResults.Err != nil {
+		msg.ErrorMessage = jobResults.Err.Error()
+	}
+	msg.CalculateTokenCount(options.AIModel)
+	return msg
+}
+
+// renderJobResultsText returns the status and result text and, separately, the list of result files
+func renderJobResultsText(jobResults *JobResults, separator string) (text string, filesText string) {
+	text = jobResults.GetResultText(separator)
+	if jobResults.FinalState != AdapterToolExecutionState_Completed {
+		status := fmt.Sprintf("Tool '%s' ended with status '%s'", jobResults.AdapterName, jobResults.FinalState)
+		if jobResults.Err != nil {
+			status += ": " + jobResults.Err.Error()
+		}
+		if text != "" {
+			text = status + separator + text
+		} else {
+			text = status
+		}
+	}
+	if len(jobResults.ResultFiles) > 0 {
+		filesText = separator + "Files:" + separator + jobResults.GetResultFilesText()
+	}
+	return text, filesText
+}
+
+// shortenToolResponseText fits text into maxTokens, the truncation notice is left out if it does not fit itself
+func shortenToolResponseText(text string, fullTokenCount int, maxTokens int, options ToolResponseRenderOptions, metaData map[string]any) string {
+	if options.TruncationMode == ToolResponseTruncationModeSummary && options.Summarizer != nil && maxTokens > 0 {
+		summary, err := options.Summarizer(text, maxTokens)
+		if err == nil && summary != "" && CalculateTokenCountForModel(options.AIModel, summary) <= maxTokens {
+			metaData[TOOL_RESPONSE_METADATA_SUMMARIZED] = true
+			return summary
+		}
+	}
+	metaData[TOOL_RESPONSE_METADATA_TRUNCATED] = true
+	notice := fmt.Sprintf(TOOL_RESPONSE_TRUNCATION_NOTICE, maxTokens, fullTokenCount)
+	textTokens := maxTokens - CalculateTokenCountForModel(options.AIModel, notice)
+	if textTokens <= 0 {
+		return TruncateTextToTokenCount(options.AIModel, text, maxTokens)
+	}
+	return TruncateTextToTokenCount(options.AIModel, text, textTokens) + notice
+}
+
+// TruncateTextToTokenCount returns the longest prefix of text (cut at rune boundaries) that fits into maxTokens of aiModel
+func TruncateTextToTokenCount(aiModel *AIModel, text string, maxTokens int) string {
+	if maxTokens <= 0 {
+		return ""
+	}
+	if CalculateTokenCountForModel(aiModel, text) <= maxTokens {
+		return text
+	}
+	runes := []rune(text)
+	low, high := 0, len(runes)
+	for low < high {
+		mid := (low + high + 1) / 2
+		if CalculateTokenCountForModel(aiModel, string(runes[:mid])) <= maxTokens {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	return string(runes[:low])
+}
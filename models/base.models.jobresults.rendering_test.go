@@ -0,0 +1,62 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderToolResponseMessageTruncation(t *testing.T) {
+	// chars/4 counting keeps the expectations independent of a bpe vocabulary
+	aiModel := &AIModel{ID: "render-test", ModelID: "gemini-test"}
+	longText := strings.Repeat("lorem ipsum dolor sit amet ", 200)
+
+	tests := []struct {
+		name          string
+		text          string
+		withFile      bool
+		maxTokens     int
+		wantTruncated bool
+		wantNotice    bool
+	}{
+		{name: "fits", text: "short result", maxTokens: 100},
+		{name: "truncated with notice", text: longText, maxTokens: 100, wantTruncated: true, wantNotice: true},
+		{name: "file list survives truncation", text: longText, withFile: true, maxTokens: 100, wantTruncated: true, wantNotice: true},
+		{name: "budget smaller than the notice", text: longText, maxTokens: 5, wantTruncated: true},
+		{name: "file list larger than the budget", text: longText, withFile: true, maxTokens: 2, wantTruncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobResults := NewJobResults("job", "tool")
+			jobResults.FinalState = AdapterToolExecutionState_Completed
+			jobResults.AddResultText(tt.text)
+			if tt.withFile {
+				jobResults.AddResultFile(NewAdapterFileInfo("chart", "chart.png", "image/png", "/tmp/chart.png", "https://example.com/chart.png"))
+			}
+			options := NewToolResponseRenderOptions()
+			options.MaxTokens = tt.maxTokens
+			options.AIModel = aiModel
+
+			msg := RenderToolResponseMessage(jobResults, nil, options)
+			textContents := msg.Content.GetContentByType(AIgencyMessageContentTypeText)
+			if len(textContents) != 1 {
+				t.Fatalf("text contents = %+v, want one", textContents)
+			}
+			text := textContents[0].Text
+			if truncated := msg.MetaData[TOOL_RESPONSE_METADATA_TRUNCATED] == true; truncated != tt.wantTruncated {
+				t.Fatalf("truncated = %v, want %v", truncated, tt.wantTruncated)
+			}
+			if hasNotice := strings.Contains(text, "tool output truncated"); hasNotice != tt.wantNotice {
+				t.Errorf("notice in text = %v, want %v", hasNotice, tt.wantNotice)
+			}
+			if tt.withFile {
+				if !strings.HasSuffix(text, "\nFiles:\n"+jobResults.GetResultFilesText()) {
+					t.Errorf("file list missing at the end of %q", text)
+				}
+				return
+			}
+			if tokens := CalculateTokenCountForModel(aiModel, text); tokens > tt.maxTokens {
+				t.Errorf("rendered text has %d tokens, more than MaxTokens %d", tokens, tt.maxTokens)
+			}
+		})
+	}
+}
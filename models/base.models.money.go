@@ -0,0 +1,275 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	nuts "github.com/vaudience/go-nuts"
+)
+
+const (
+	// money is stored as an integer amount of nano euros (1e-9), which is precise enough for per-token and per-pixel prices
+	MONEY_DECIMALS             = 9
+	MONEY_UNITS_PER_EURO Money = 1_000_000_000
+
+	MONEY_DEFAULT_ROUNDING_DECIMALS = MONEY_DECIMALS
+	MONEY_DEFAULT_ROUNDING_MODE     = MoneyRoundingModeHalfEven
+)
+
+var (
+	ErrInvalidMoneyAmount = errors.New("invalid money amount")
+	ErrMoneyOverflow      = errors.New("money amount out of range")
+)
+
+// Money is a fixed-point amount in nano euros. it (un)marshals as a plain json decimal number,
+// so it is compatible with the float fields it replaced; quoted decimal strings are accepted as well.
+type Money int64 //@name Money
+
+type MoneyRoundingMode string //@name MoneyRoundingMode
+
+const (
+	MoneyRoundingModeHalfEven MoneyRoundingMode = "half_even" // bankers rounding
+	MoneyRoundingModeHalfUp   MoneyRoundingMode = "half_up"
+	MoneyRoundingModeUp       MoneyRoundingMode = "up"   // away from zero
+	MoneyRoundingModeDown     MoneyRoundingMode = "down" // towards zero
+)
+
+func (mode MoneyRoundingMode) IsValid() bool {
+	switch mode {
+	case MoneyRoundingModeHalfEven, MoneyRoundingModeHalfUp, MoneyRoundingModeUp, MoneyRoundingModeDown:
+		return true
+	}
+	return false
+}
+
+// MoneyFromFloat converts a euro float into Money, rounding half even to nano euros.
+// it returns ErrInvalidMoneyAmount for NaN or infinity and ErrMoneyOverflow if the amount is out of range.
+func MoneyFromFloat(euro float64) (money Money, err error) {
+	if math.IsNaN(euro) || math.IsInf(euro, 0) {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidMoneyAmount, euro)
+	}
+	return moneyFromRat(new(big.Rat).SetFloat64(euro), MoneyRoundingModeHalfEven)
+}
+
+// ParseMoney parses a decimal euro amount like "12.50" or "1.5e-7" without going through float64
+func ParseMoney(text string) (money Money, err error) {
+	rat, ok := new(big.Rat).SetString(strings.TrimSpace(text))
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMoneyAmount, text)
+	}
+	return moneyFromRat(rat, MoneyRoundingModeHalfEven)
+}
+
+func MustParseMoney(text string) Money {
+	money, err := ParseMoney(text)
+	if err != nil {
+		panic(err)
+	}
+	return money
+}
+
+func (m Money) Float64() float64 {
+	return float64(m) / float64(MONEY_UNITS_PER_EURO)
+}
+
+func (m Money) Rat() *big.Rat {
+	return new(big.Rat).SetFrac64(int64(m), int64(MONEY_UNITS_PER_EURO))
+}
+
+func (m Money) IsZero() bool {
+	return m == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m < 0
+}
+
+func (m Money) Add(other Money) Money {
+	return m + other
+}
+
+func (m Money) Sub(other Money) Money {
+	return m - other
+}
+
+// MulFloat multiplies the amount with all factors using exact rational arithmetic and rounds once (half even) at the end.
+// a NaN or infinite factor or an overflow is logged and saturates at the largest amount, so a broken price never becomes free.
+// cost calculations use MulFloatChecked to surface the error.
+func (m Money) MulFloat(factors ...float64) Money {
+	product, err := m.MulFloatChecked(factors...)
+	if err != nil {
+		nuts.L.Errorf("[Money.MulFloat] saturating (%s) x %v: %v", m, factors, err)
+		return m.saturate(factors)
+	}
+	return product
+}
+
+// MulFloatChecked is MulFloat returning ErrInvalidMoneyAmount for a NaN or infinite factor and ErrMoneyOverflow if the product is out of range
+func (m Money) MulFloatChecked(factors ...float64) (product Money, err error) {
+	rat := m.Rat()
+	for _, factor := range factors {
+		if math.IsNaN(factor) || math.IsInf(factor, 0) {
+			return 0, fmt.Errorf("%w: factor %v", ErrInvalidMoneyAmount, factor)
+		}
+		rat.Mul(rat, new(big.Rat).SetFloat64(factor))
+	}
+	return moneyFromRat(rat, MoneyRoundingModeHalfEven)
+}
+
+// addSaturating adds without wrapping around, sums of saturated amounts stay saturated
+func (m Money) addSaturating(other Money) Money {
+	sum := m + other
+	if other > 0 && sum < m {
+		return Money(math.MaxInt64)
+	}
+	if other < 0 && sum > m {
+		return Money(math.MinInt64)
+	}
+	return sum
+}
+
+// saturate returns the largest amount with the sign of the product of m and the factors
+func (m Money) saturate(factors []float64) Money {
+	negative := m < 0
+	for _, factor := range factors {
+		if factor < 0 || math.IsInf(factor, -1) {
+			negative = !negative
+		}
+	}
+	if negative {
+		return Money(math.MinInt64)
+	}
+	return Money(math.MaxInt64)
+}
+
+// Round rounds the amount to the given number of euro decimals (2 = cents) with the given mode
+func (m Money) Round(decimals int, mode MoneyRoundingMode) Money {
+	if decimals >= MONEY_DECIMALS || decimals < 0 {
+		return m
+	}
+	step := int64(math.Pow10(MONEY_DECIMALS - decimals))
+	quotient, remainder := int64(m)/step, int64(m)%step
+	if remainder == 0 {
+		return m
+	}
+	sign := int64(1)
+	if m < 0 {
+		sign = -1
+		remainder = -remainder
+	}
+	switch mode {
+	case MoneyRoundingModeDown:
+	case MoneyRoundingModeUp:
+		quotient += sign
+	case MoneyRoundingModeHalfUp:
+		if remainder*2 >= step {
+			quotient += sign
+		}
+	default:
+		if remainder*2 > step || (remainder*2 == step && quotient%2 != 0) {
+			quotient += sign
+		}
+	}
+	return Money(quotient * step)
+}
+
+// String renders the amount as a plain decimal euro number without trailing zeros, e.g. "0.00015"
+func (m Money) String() string {
+	sign := ""
+	value := uint64(m)
+	if m < 0 {
+		sign = "-"
+		value = uint64(-int64(m))
+	}
+	whole := value / uint64(MONEY_UNITS_PER_EURO)
+	fraction := value % uint64(MONEY_UNITS_PER_EURO)
+	if fraction == 0 {
+		return fmt.Sprintf("%s%d", sign, whole)
+	}
+	fractionText := strings.TrimRight(fmt.Sprintf("%09d", fraction), "0")
+	return fmt.Sprintf("%s%d.%s", sign, whole, fractionText)
+}
+
+// StringFixed renders the amount with exactly the given number of decimals, e.g. for invoices
+func (m Money) StringFixed(decimals int) string {
+	return m.Rat().FloatString(decimals)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) (err error) {
+	text := string(bytes.Trim(bytes.TrimSpace(data), `"`))
+	if text == "null" || text == "" {
+		*m = 0
+		return nil
+	}
+	*m, err = ParseMoney(text)
+	return err
+}
+
+func moneyFromRat(rat *big.Rat, mode MoneyRoundingMode) (Money, error) {
+	scaled := new(big.Rat).Mul(rat, new(big.Rat).SetInt64(int64(MONEY_UNITS_PER_EURO)))
+	quotient, remainder := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	if remainder.Sign() != 0 {
+		doubled := new(big.Int).Abs(remainder)
+		doubled.Lsh(doubled, 1)
+		halfComparison := doubled.Cmp(scaled.Denom())
+		roundAway := false
+		switch mode {
+		case MoneyRoundingModeDown:
+		case MoneyRoundingModeUp:
+			roundAway = true
+		case MoneyRoundingModeHalfUp:
+			roundAway = halfComparison >= 0
+		default:
+			roundAway = halfComparison > 0 || (halfComparison == 0 && quotient.Bit(0) == 1)
+		}
+		if roundAway {
+			quotient.Add(quotient, big.NewInt(int64(scaled.Num().Sign())))
+		}
+	}
+	if !quotient.IsInt64() {
+		return 0, fmt.Errorf("%w: %s", ErrMoneyOverflow, rat.FloatString(MONEY_DECIMALS))
+	}
+	return Money(quotient.Int64()), nil
+}
+
+// MoneyRounding are the per org rules to round billed amounts
+type MoneyRounding struct {
+	Decimals int               `json:"decimals" validate:"min=0,max=9"`
+	Mode     MoneyRoundingMode `json:"mode" validate:"omitempty,oneof=half_even half_up up down"`
+} //@name MoneyRounding
+
+func NewMoneyRounding() MoneyRounding {
+	return MoneyRounding{
+		Decimals: MONEY_DEFAULT_ROUNDING_DECIMALS,
+		Mode:     MONEY_DEFAULT_ROUNDING_MODE,
+	}
+}
+
+// UnmarshalJSON starts from NewMoneyRounding, so a config that only sets the mode keeps full precision
+// instead of rounding to whole euros. an explicit "decimals": 0 still rounds to whole euros.
+func (rounding *MoneyRounding) UnmarshalJSON(data []byte) error {
+	type plainMoneyRounding MoneyRounding
+	decoded := plainMoneyRounding(NewMoneyRounding())
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*rounding = MoneyRounding(decoded)
+	return nil
+}
+
+func (rounding MoneyRounding) Apply(m Money) Money {
+	mode := rounding.Mode
+	if mode == "" {
+		mode = MONEY_DEFAULT_ROUNDING_MODE
+	}
+	return m.Round(rounding.Decimals, mode)
+}
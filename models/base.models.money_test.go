@@ -0,0 +1,123 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestMoneyMulFloat(t *testing.T) {
+	tests := []struct {
+		name      string
+		money     Money
+		factors   []float64
+		want      Money
+		wantErr   error
+		saturated Money
+	}{
+		{name: "exact product", money: MONEY_UNITS_PER_EURO, factors: []float64{0.5, 3}, want: 1_500_000_000},
+		{name: "rounds half even once", money: 5, factors: []float64{0.5}, want: 2},
+		{name: "no factors", money: 42, want: 42},
+		{name: "nan factor", money: MONEY_UNITS_PER_EURO, factors: []float64{math.NaN()}, wantErr: ErrInvalidMoneyAmount, saturated: math.MaxInt64},
+		{name: "infinite factor", money: MONEY_UNITS_PER_EURO, factors: []float64{math.Inf(1)}, wantErr: ErrInvalidMoneyAmount, saturated: math.MaxInt64},
+		{name: "negative infinite factor", money: MONEY_UNITS_PER_EURO, factors: []float64{math.Inf(-1)}, wantErr: ErrInvalidMoneyAmount, saturated: math.MinInt64},
+		{name: "overflow", money: MONEY_UNITS_PER_EURO, factors: []float64{1e30}, wantErr: ErrMoneyOverflow, saturated: math.MaxInt64},
+		{name: "negative overflow", money: -MONEY_UNITS_PER_EURO, factors: []float64{1e30}, wantErr: ErrMoneyOverflow, saturated: math.MinInt64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.money.MulFloatChecked(tt.factors...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("MulFloatChecked() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil {
+				if got != tt.want {
+					t.Fatalf("MulFloatChecked() = %d, want %d", got, tt.want)
+				}
+				if unchecked := tt.money.MulFloat(tt.factors...); unchecked != tt.want {
+					t.Fatalf("MulFloat() = %d, want %d", unchecked, tt.want)
+				}
+				return
+			}
+			if unchecked := tt.money.MulFloat(tt.factors...); unchecked != tt.saturated {
+				t.Fatalf("MulFloat() = %d, want saturated %d", unchecked, tt.saturated)
+			}
+		})
+	}
+}
+
+func TestMoneyFromFloat(t *testing.T) {
+	tests := []struct {
+		name    string
+		euro    float64
+		want    Money
+		wantErr error
+	}{
+		{name: "cents", euro: 12.5, want: 12_500_000_000},
+		{name: "rounds to nano euros", euro: 1.5e-10, want: 0},
+		{name: "negative", euro: -0.25, want: -250_000_000},
+		{name: "nan", euro: math.NaN(), wantErr: ErrInvalidMoneyAmount},
+		{name: "infinite", euro: math.Inf(1), wantErr: ErrInvalidMoneyAmount},
+		{name: "negative infinite", euro: math.Inf(-1), wantErr: ErrInvalidMoneyAmount},
+		{name: "out of range", euro: 1e12, wantErr: ErrMoneyOverflow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MoneyFromFloat(tt.euro)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("MoneyFromFloat(%v) error = %v, want %v", tt.euro, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("MoneyFromFloat(%v) = %d, want %d", tt.euro, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyRoundingUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want MoneyRounding
+	}{
+		{name: "mode only keeps full precision", data: `{"mode":"half_up"}`, want: MoneyRounding{Decimals: MONEY_DEFAULT_ROUNDING_DECIMALS, Mode: MoneyRoundingModeHalfUp}},
+		{name: "explicit whole euros", data: `{"decimals":0,"mode":"up"}`, want: MoneyRounding{Decimals: 0, Mode: MoneyRoundingModeUp}},
+		{name: "decimals only", data: `{"decimals":2}`, want: MoneyRounding{Decimals: 2, Mode: MONEY_DEFAULT_ROUNDING_MODE}},
+		{name: "empty", data: `{}`, want: NewMoneyRounding()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rounding MoneyRounding
+			if err := json.Unmarshal([]byte(tt.data), &rounding); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+			if rounding != tt.want {
+				t.Fatalf("decoded %+v, want %+v", rounding, tt.want)
+			}
+		})
+	}
+
+	var budget struct {
+		Rounding *MoneyRounding `json:"rounding"`
+	}
+	if err := json.Unmarshal([]byte(`{"rounding":{"mode":"half_up"}}`), &budget); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if got := budget.Rounding.Apply(mustParseMoney(t, "0.123456789")); got != mustParseMoney(t, "0.123456789") {
+		t.Fatalf("Apply() = %v, want the amount kept at full precision", got)
+	}
+}
+
+func TestCalculateCostForUsagesRejectsBrokenMultiplier(t *testing.T) {
+	aiModel := newCostTestModel(newCostTestFeature(AIModelCapabilityTextToText, ExecutionCostTemplate{CostUnit: AIModelCostUnitInputPerMillionTokens, CostPerUnitInEuro: mustParseMoney(t, "2")}))
+	for _, multiplier := range []float64{math.NaN(), math.Inf(1)} {
+		_, totals, err := CalculateCostForUsages(aiModel, []ExecutionUsage{NewExecutionUsage(AIModelCapabilityTextToText, AIModelCostUnitInputPerMillionTokens, 1000)}, multiplier)
+		if !errors.Is(err, ErrInvalidMoneyAmount) {
+			t.Fatalf("multiplier %v: error = %v, want %v", multiplier, err, ErrInvalidMoneyAmount)
+		}
+		if totals.TotalCost != 0 {
+			t.Fatalf("multiplier %v: TotalCost = %v, want nothing billed next to the error", multiplier, totals.TotalCost)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	nuts "github.com/vaudience/go-nuts"
+)
+
+const (
+	NATSTOOL_BATCH_DEFAULT_TIMEOUT = 300 * time.Second
+)
+
+var (
+	ErrToolBatchEmpty         = errors.New("tool batch is empty")
+	ErrToolBatchRunIdMismatch = errors.New("tool batch contains a job for a different run")
+	ErrToolBatchDuplicateJob  = errors.New("tool batch contains a duplicate job id")
+	ErrToolBatchTimeout       = errors.New("tool batch timed out")
+	ErrToolBatchAborted       = errors.New("tool batch aborted after a failed job")
+)
+
+// NatsToolBatchOptions controls how a batch of tool calls from a single llm turn is awaited
+type NatsToolBatchOptions struct {
+	Timeout  time.Duration `json:"timeout"`   // for the whole batch, NATSTOOL_BATCH_DEFAULT_TIMEOUT if 0
+	FailFast bool          `json:"fail_fast"` // stop all outstanding jobs as soon as one job failed
+}
+
+// ExecuteJobBatch submits all tool calls of one llm turn concurrently and waits for them.
+// results are returned in the order of the batch. jobs that did not end in time (or were stopped by FailFast)
+// are returned as Cancelled and no longer tracked by the manager. all job ids are recorded in run.ToolJobIDs.
+// batch is not modified, generated job ids are found in the JobId of the results.
+func (tm *NatsToolManager) ExecuteJobBatch(run *AIgencyRun, batch []AdapterExecutionData, options NatsToolBatchOptions) (results []JobResults, err error) {
+	var logName string = "[NatsToolManager.ExecuteJobBatch] "
+	if len(batch) == 0 {
+		return []JobResults{}, ErrToolBatchEmpty
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = NATSTOOL_BATCH_DEFAULT_TIMEOUT
+	}
+	batch = append([]AdapterExecutionData{}, batch...)
+	seenJobIDs := make(map[string]bool)
+	for n := range batch {
+		if batch[n].RunId == "" {
+			batch[n].RunId = run.ID
+		}
+		if batch[n].RunId != run.ID {
+			return []JobResults{}, fmt.Errorf("%w: job(%s) has runId(%s) instead of (%s)", ErrToolBatchRunIdMismatch, batch[n].JobId, batch[n].RunId, run.ID)
+		}
+		if batch[n].JobId == "" {
+			batch[n].JobId = CreateToolJobID()
+		}
+		if seenJobIDs[batch[n].JobId] {
+			return []JobResults{}, fmt.Errorf("%w: %s", ErrToolBatchDuplicateJob, batch[n].JobId)
+		}
+		seenJobIDs[batch[n].JobId] = true
+	}
+
+	results = make([]JobResults, len(batch))
+	ended := make([]bool, len(batch))
+	type indexedResults struct {
+		index   int
+		results JobResults
+	}
+	finished := make(chan indexedResults, len(batch))
+	// closing stop releases the waiters of jobs that did not end
+	stop := make(chan struct{})
+	defer close(stop)
+	outstanding := 0
+	failed := false
+	for n, executionData := range batch {
+		if failed && options.FailFast {
+			break
+		}
+		run.AddToolJobIDs(executionData.JobId)
+		job, submitErr := tm.ExecuteJob(executionData)
+		if submitErr != nil {
+			results[n] = *NewJobResults(executionData.JobId, executionData.AdapterName)
+			results[n].FinalState = AdapterToolExecutionState_Failed
+			results[n].Err = submitErr
+			ended[n] = true
+			failed = true
+			continue
+		}
+		outstanding++
+		go func(index int, done <-chan JobResults) {
+			select {
+			case jobResults := <-done:
+				finished <- indexedResults{index: index, results: jobResults}
+			case <-stop:
+			}
+		}(n, tm.awaitToolJob(job, stop))
+	}
+
+	timeout := time.NewTimer(options.Timeout)
+	defer timeout.Stop()
+	for outstanding > 0 && !(failed && options.FailFast) {
+		select {
+		case jobResults := <-finished:
+			outstanding--
+			results[jobResults.index] = jobResults.results
+			ended[jobResults.index] = true
+			if jobResults.results.FinalState == AdapterToolExecutionState_Failed {
+				failed = true
+			}
+		case <-timeout.C:
+			nuts.L.Infof("%sbatch of run(%s) timed out after (%v) with (%d) outstanding jobs", logName, run.ID, options.Timeout, outstanding)
+			tm.cancelOutstandingBatchJobs(batch, results, ended, ErrToolBatchTimeout)
+			return results, ErrToolBatchTimeout
+		}
+	}
+	for _, isEnded := range ended {
+		if !isEnded {
+			nuts.L.Infof("%sbatch of run(%s) aborted with (%d) outstanding jobs after a failed job", logName, run.ID, outstanding)
+			tm.cancelOutstandingBatchJobs(batch, results, ended, ErrToolBatchAborted)
+			return results, ErrToolBatchAborted
+		}
+	}
+	return results, nil
+}
+
+func (tm *NatsToolManager) cancelOutstandingBatchJobs(batch []AdapterExecutionData, results []JobResults, ended []bool, reason error) {
+	for n, executionData := range batch {
+		if ended[n] {
+			continue
+		}
+		err := tm.StopToolJob(executionData.JobId)
+		if err != nil {
+			nuts.L.Debugf("[NatsToolManager.cancelOutstandingBatchJobs] failed to stop job(%s): %v", executionData.JobId, err)
+		}
+		results[n] = *NewJobResults(executionData.JobId, executionData.AdapterName)
+		if job := tm.GetToolJob(executionData.JobId); job != nil {
+			results[n] = job.GetResults()
+		}
+		results[n].FinalState = AdapterToolExecutionState_Cancelled
+		results[n].Err = reason
+	}
+}
+
+// awaitToolJob drains the updates of a job until it ended and then delivers its results.
+// closing stop ends the waiting for a job that did not end, the job is then abandoned.
+func (tm *NatsToolManager) awaitToolJob(job *NatsToolJob, stop <-chan struct{}) <-chan JobResults {
+	done := make(chan JobResults, 1)
+	go func() {
+		for {
+			select {
+			case update := <-job.UpdatesChannel:
+				if IsEndedToolExecutionState(update.Status) {
+					done <- job.GetResults()
+					return
+				}
+			case <-stop:
+				tm.abandonToolJob(job)
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// abandonToolJob stops tracking a job nobody waits for anymore. job updates block until they are consumed,
+// so updates are drained until the job is removed and no further update can reach it.
+func (tm *NatsToolManager) abandonToolJob(job *NatsToolJob) {
+	removed := make(chan struct{})
+	go func() {
+		tm.safety.Lock()
+		if tm.toolJobs[job.JobID] == job {
+			delete(tm.toolJobs, job.JobID)
+		}
+		tm.safety.Unlock()
+		close(removed)
+	}()
+	for {
+		select {
+		case <-job.UpdatesChannel:
+		case <-removed:
+			return
+		}
+	}
+}
+
+func IsEndedToolExecutionState(state AdapterToolExecutionState) bool {
+	return state == AdapterToolExecutionState_Completed || state == AdapterToolExecutionState_Cancelled || state == AdapterToolExecutionState_Failed
+}
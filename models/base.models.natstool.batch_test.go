@@ -0,0 +1,311 @@
+package models
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+type testNATSMessage struct {
+	Subject string
+	Data    string
+}
+
+// startTestNATSServer speaks just enough of the nats protocol for a client to connect and publish,
+// the published messages are passed on in order
+func startTestNATSServer(t *testing.T) (url string, published <-chan testNATSMessage) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no local listener for the nats test server: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	messages := make(chan testNATSMessage, 100)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestNATSConn(conn, messages)
+		}
+	}()
+	return "nats://" + listener.Addr().String(), messages
+}
+
+func serveTestNATSConn(conn net.Conn, messages chan<- testNATSMessage) {
+	defer conn.Close()
+	fmt.Fprint(conn, "INFO {\"server_id\":\"test\",\"version\":\"2.10.0\",\"proto\":1,\"max_payload\":1048576}\r\n")
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			messages <- testNATSMessage{Subject: fields[1], Data: string(payload[:size])}
+		}
+	}
+}
+
+// newTestToolManager returns a manager publishing to a test nats server
+func newTestToolManager(t *testing.T) (*NatsToolManager, <-chan testNATSMessage) {
+	t.Helper()
+	url, published := startTestNATSServer(t)
+	natsClient, err := nats.Connect(url)
+	if err != nil {
+		t.Fatalf("connecting to the nats test server failed: %v", err)
+	}
+	t.Cleanup(natsClient.Close)
+	tm := &NatsToolManager{
+		tools:      make(map[string]*NatsTool),
+		toolJobs:   make(map[string]*NatsToolJob),
+		natsClient: natsClient,
+	}
+	return tm, published
+}
+
+// waitForPublished returns the next message published to subject, other messages are skipped
+func waitForPublished(t *testing.T, published <-chan testNATSMessage, subject string) testNATSMessage {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case message := <-published:
+			if message.Subject == subject {
+				return message
+			}
+		case <-timeout:
+			t.Fatalf("nothing published to %s", subject)
+		}
+	}
+}
+
+// waitForSubmittedJobs returns the next count jobs submitted for the tool
+func waitForSubmittedJobs(t *testing.T, published <-chan testNATSMessage, tool string, count int) (jobs []*NatsToolJob) {
+	t.Helper()
+	for len(jobs) < count {
+		job := &NatsToolJob{}
+		if err := json.Unmarshal([]byte(waitForPublished(t, published, "aigency.tools.jobs.new."+tool).Data), job); err != nil {
+			t.Fatalf("submitted job is no json: %v", err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// waitForSubmittedJobIDs returns the ids of the next count jobs submitted for the tool
+func waitForSubmittedJobIDs(t *testing.T, published <-chan testNATSMessage, tool string, count int) (jobIDs []string) {
+	t.Helper()
+	for _, job := range waitForSubmittedJobs(t, published, tool, count) {
+		jobIDs = append(jobIDs, job.JobID)
+	}
+	return jobIDs
+}
+
+type testBatchReturn struct {
+	results []JobResults
+	err     error
+}
+
+func executeTestJobBatch(tm *NatsToolManager, run *AIgencyRun, batch []AdapterExecutionData, options NatsToolBatchOptions) <-chan testBatchReturn {
+	returned := make(chan testBatchReturn, 1)
+	go func() {
+		results, err := tm.ExecuteJobBatch(run, batch, options)
+		returned <- testBatchReturn{results: results, err: err}
+	}()
+	return returned
+}
+
+func waitForBatch(t *testing.T, returned <-chan testBatchReturn) testBatchReturn {
+	t.Helper()
+	select {
+	case batchReturn := <-returned:
+		return batchReturn
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch did not return")
+	}
+	return testBatchReturn{}
+}
+
+func newTestToolManagerWithJob(jobID string) (*NatsToolManager, *NatsToolJob) {
+	tm := &NatsToolManager{
+		tools:    make(map[string]*NatsTool),
+		toolJobs: make(map[string]*NatsToolJob),
+	}
+	job := CreateToolJobFromExecutionData(AdapterExecutionData{JobId: jobID, AdapterName: "tool"})
+	tm.toolJobs[job.JobID] = job
+	return tm, job
+}
+
+// deliverJobUpdate sends an update like ListenForToolJobUpdates does, holding the manager lock
+func deliverJobUpdate(tm *NatsToolManager, jobID string, status AdapterToolExecutionState) <-chan struct{} {
+	delivered := make(chan struct{})
+	go func() {
+		defer close(delivered)
+		tm.safety.Lock()
+		defer tm.safety.Unlock()
+		if job, ok := tm.toolJobs[jobID]; ok {
+			job.UpdateStatus(status, "", []string{"data"}, nil)
+		}
+	}()
+	return delivered
+}
+
+func waitFor(t *testing.T, what string, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func TestAwaitToolJobDeliversResultsOnEndState(t *testing.T) {
+	tm, job := newTestToolManagerWithJob("job-1")
+	stop := make(chan struct{})
+	defer close(stop)
+	done := tm.awaitToolJob(job, stop)
+
+	waitFor(t, "running update", deliverJobUpdate(tm, job.JobID, AdapterToolExecutionState_Running))
+	waitFor(t, "completed update", deliverJobUpdate(tm, job.JobID, AdapterToolExecutionState_Completed))
+	select {
+	case results := <-done:
+		if results.FinalState != AdapterToolExecutionState_Completed || len(results.ResultTexts) != 2 {
+			t.Fatalf("results = %+v", results)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no results after the end state")
+	}
+	if tm.GetToolJob(job.JobID) == nil {
+		t.Fatal("ended job was removed from the manager")
+	}
+}
+
+func TestAwaitToolJobStopAbandonsJob(t *testing.T) {
+	tm, job := newTestToolManagerWithJob("job-2")
+	stop := make(chan struct{})
+	done := tm.awaitToolJob(job, stop)
+	waitFor(t, "running update", deliverJobUpdate(tm, job.JobID, AdapterToolExecutionState_Running))
+
+	// an update in flight while the waiter stops must not block the manager
+	countUpdates := func() int {
+		job.Safety.Lock()
+		defer job.Safety.Unlock()
+		return len(job.Updates)
+	}
+	sent := countUpdates()
+	// the update is appended before it is sent, the sender holds the manager lock until the send is done
+	inFlight := deliverJobUpdate(tm, job.JobID, AdapterToolExecutionState_Running)
+	deadline := time.Now().Add(2 * time.Second)
+	for countUpdates() == sent {
+		if time.Now().After(deadline) {
+			t.Fatal("update was not sent")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	waitFor(t, "in flight update", inFlight)
+
+	deadline = time.Now().Add(2 * time.Second)
+	for tm.GetToolJob(job.JobID) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("abandoned job is still tracked by the manager")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// later updates of the adapter are ignored instead of blocking forever
+	waitFor(t, "update after abandoning", deliverJobUpdate(tm, job.JobID, AdapterToolExecutionState_Cancelled))
+	select {
+	case results := <-done:
+		t.Fatalf("abandoned job delivered results %+v", results)
+	default:
+	}
+}
+
+func TestExecuteJobBatchReturnsResultsInBatchOrder(t *testing.T) {
+	tm, published := newTestToolManager(t)
+	run := NewAIgencyRun()
+	batch := []AdapterExecutionData{{JobId: "job-a", AdapterName: "tool"}, {JobId: "job-b", AdapterName: "tool"}, {AdapterName: "tool"}}
+	returned := executeTestJobBatch(tm, run, batch, NatsToolBatchOptions{Timeout: 5 * time.Second})
+
+	jobIDs := waitForSubmittedJobIDs(t, published, "tool", len(batch))
+	for n := len(jobIDs) - 1; n >= 0; n-- {
+		waitFor(t, "completed update", deliverJobUpdate(tm, jobIDs[n], AdapterToolExecutionState_Completed))
+	}
+	batchReturn := waitForBatch(t, returned)
+	if batchReturn.err != nil {
+		t.Fatalf("ExecuteJobBatch() error = %v", batchReturn.err)
+	}
+	for n, results := range batchReturn.results {
+		if results.JobId != jobIDs[n] || results.FinalState != AdapterToolExecutionState_Completed {
+			t.Errorf("results[%d] = job %s in %s, want job %s completed", n, results.JobId, results.FinalState, jobIDs[n])
+		}
+	}
+	if !reflect.DeepEqual(run.ToolJobIDs, jobIDs) {
+		t.Errorf("run.ToolJobIDs = %v, want %v", run.ToolJobIDs, jobIDs)
+	}
+	if batch[2].JobId != "" {
+		t.Errorf("the batch of the caller got job id %s", batch[2].JobId)
+	}
+}
+
+func TestExecuteJobBatchCancelsJobsOnTimeout(t *testing.T) {
+	tm, published := newTestToolManager(t)
+	run := NewAIgencyRun()
+	returned := executeTestJobBatch(tm, run, []AdapterExecutionData{{JobId: "job-slow", AdapterName: "tool"}}, NatsToolBatchOptions{Timeout: 50 * time.Millisecond})
+
+	waitForSubmittedJobIDs(t, published, "tool", 1)
+	batchReturn := waitForBatch(t, returned)
+	if !errors.Is(batchReturn.err, ErrToolBatchTimeout) {
+		t.Fatalf("ExecuteJobBatch() error = %v, want %v", batchReturn.err, ErrToolBatchTimeout)
+	}
+	if results := batchReturn.results[0]; results.FinalState != AdapterToolExecutionState_Cancelled || !errors.Is(results.Err, ErrToolBatchTimeout) {
+		t.Errorf("results = %+v, want cancelled by the timeout", results)
+	}
+	if stop := waitForPublished(t, published, "aigency.tools.jobs.stop.tool"); stop.Data != "job-slow" {
+		t.Errorf("stopped job %s, want job-slow", stop.Data)
+	}
+}
+
+func TestExecuteJobBatchFailFastStopsTheOtherJobs(t *testing.T) {
+	tm, published := newTestToolManager(t)
+	run := NewAIgencyRun()
+	batch := []AdapterExecutionData{{JobId: "job-fails", AdapterName: "tool"}, {JobId: "job-runs", AdapterName: "tool"}}
+	returned := executeTestJobBatch(tm, run, batch, NatsToolBatchOptions{Timeout: 5 * time.Second, FailFast: true})
+
+	waitForSubmittedJobIDs(t, published, "tool", len(batch))
+	waitFor(t, "failed update", deliverJobUpdate(tm, "job-fails", AdapterToolExecutionState_Failed))
+	batchReturn := waitForBatch(t, returned)
+	if !errors.Is(batchReturn.err, ErrToolBatchAborted) {
+		t.Fatalf("ExecuteJobBatch() error = %v, want %v", batchReturn.err, ErrToolBatchAborted)
+	}
+	if states := []AdapterToolExecutionState{batchReturn.results[0].FinalState, batchReturn.results[1].FinalState}; !reflect.DeepEqual(states, []AdapterToolExecutionState{AdapterToolExecutionState_Failed, AdapterToolExecutionState_Cancelled}) {
+		t.Errorf("final states = %v, want the failed job and the cancelled sibling", states)
+	}
+	if stop := waitForPublished(t, published, "aigency.tools.jobs.stop.tool"); stop.Data != "job-runs" {
+		t.Errorf("stopped job %s, want job-runs", stop.Data)
+	}
+}
@@ -27,6 +27,11 @@ type ToolExecutor func(tool *NatsTool, jobData AdapterExecutionData) (jobResults
 var ErrJobNotFound = errors.New("job not found")
 var ErrNoExecutorForTool = errors.New("no executor set for tool")
 
+const (
+	IDPREFIX_TOOLJOB = "tjob"
+	IDLENGTH_TOOLJOB = 16
+)
+
 var NATS_MANAGER_SERVER_URL string = "nats://localhost:4222"
 var NATS_MANAGER_USERNAME string = "nats"
 var NATS_MANAGER_PASSWORD string = "pw"
@@ -54,6 +59,10 @@ func NewNatsToolJob() (emptyJob *NatsToolJob) {
 	return emptyJob
 }
 
+func CreateToolJobID() string {
+	return nuts.NID(IDPREFIX_TOOLJOB, IDLENGTH_TOOLJOB)
+}
+
 func CreateToolJobFromExecutionData(executionData AdapterExecutionData) (job *NatsToolJob) {
 	job = NewNatsToolJob()
 	job.JobID = executionData.JobId
@@ -155,6 +164,10 @@ func (tool *NatsTool) Execute(data AdapterExecutionData) (jobResults JobResults)
 		return jobResults
 	}
 	jobResults = tool.executor(tool, data)
+	err := tool.ValidateJobResults(&jobResults)
+	if err != nil {
+		nuts.L.Infof("%sjob(%s) of tool(%s) returned an invalid response: %v", logName, data.JobId, tool.Name, err)
+	}
 	// publish the results as a JobUpdate
 	msg := fmt.Sprintf("Job(%s) for tool(%s) ended with status(%s)", data.JobId, tool.Name, jobResults.FinalState)
 	if jobResults.Err != nil {
@@ -171,7 +184,7 @@ func (tool *NatsTool) Execute(data AdapterExecutionData) (jobResults JobResults)
 		NewResultData:  jobResults.ResultTexts,
 		NewResultFiles: jobResults.ResultFiles,
 	}
-	err := tool.natsClient.Publish(NATS_TOPIC_TOOLS_JOBS_UPDATES, tool.MarshalJobUpdate(jobUpdate))
+	err = tool.natsClient.Publish(NATS_TOPIC_TOOLS_JOBS_UPDATES, tool.MarshalJobUpdate(jobUpdate))
 	if err != nil {
 		nuts.L.Errorf("%sfailed to publish job update: (%v)", logName, err)
 	}
@@ -495,24 +508,26 @@ func (param *NatsToolParameter) applyValidations(value any) error {
 
 // NatsToolJob represents a job for a tool
 type NatsToolJob struct {
-	JobID          string                    `json:"job_id"` // for openai this is the CallId
-	Status         AdapterToolExecutionState `json:"status"`
-	StatusMessage  string                    `json:"status_message"`
-	Updates        []NatsToolJobUpdates      `json:"updates"`
-	ToolName       string                    `json:"tool_name"`
-	ToolVersion    string                    `json:"tool_version"`
-	Safety         sync.Mutex                `json:"-"`
-	Parameters     map[string]any            `json:"parameters"`
-	MissionId      string                    `json:"mission_id"`
-	MissionBaseUrl string                    `json:"mission_base_url"`
-	ThreadId       string                    `json:"thread_id"`
-	RunId          string                    `json:"run_id"`
-	SubmittedAt    time.Time                 `json:"submitted_at"`
-	LatestUpdateAt time.Time                 `json:"latest_update_at"`
-	ResultFiles    []AdapterFileInfo         `json:"created_files"`
-	ResultData     []string                  `json:"result_data"`
-	EndedAt        time.Time                 `json:"ended_at"`
-	UpdatesChannel chan *NatsToolJobUpdates  `json:"-"`
+	JobID            string                    `json:"job_id"` // for openai this is the CallId
+	Status           AdapterToolExecutionState `json:"status"`
+	StatusMessage    string                    `json:"status_message"`
+	Updates          []NatsToolJobUpdates      `json:"updates"`
+	ToolName         string                    `json:"tool_name"`
+	ToolVersion      string                    `json:"tool_version"`
+	Safety           sync.Mutex                `json:"-"`
+	Parameters       map[string]any            `json:"parameters"`
+	MissionId        string                    `json:"mission_id"`
+	MissionBaseUrl   string                    `json:"mission_base_url"`
+	ThreadId         string                    `json:"thread_id"`
+	RunId            string                    `json:"run_id"`
+	SubmittedAt      time.Time                 `json:"submitted_at"`
+	LatestUpdateAt   time.Time                 `json:"latest_update_at"`
+	ResultFiles      []AdapterFileInfo         `json:"created_files"`
+	ResultData       []string                  `json:"result_data"`
+	StructuredResult map[string]any            `json:"structured_result"`
+	Err              error                     `json:"-"`
+	EndedAt          time.Time                 `json:"ended_at"`
+	UpdatesChannel   chan *NatsToolJobUpdates  `json:"-"`
 }
 
 func (job *NatsToolJob) AddResultFile(file AdapterFileInfo) {
@@ -530,7 +545,7 @@ func (job *NatsToolJob) AddResultData(data string) {
 func (job *NatsToolJob) IsEnded() bool {
 	job.Safety.Lock()
 	defer job.Safety.Unlock()
-	return job.Status == AdapterToolExecutionState_Completed || job.Status == AdapterToolExecutionState_Cancelled || job.Status == AdapterToolExecutionState_Failed
+	return IsEndedToolExecutionState(job.Status)
 }
 
 func (job *NatsToolJob) UpdateStatus(status AdapterToolExecutionState, msg string, newResultData []string, newResultFiles []AdapterFileInfo) {
@@ -564,11 +579,15 @@ func (job *NatsToolJob) UpdateStatus(status AdapterToolExecutionState, msg strin
 }
 
 func (job *NatsToolJob) GetResults() (results JobResults) {
+	job.Safety.Lock()
+	defer job.Safety.Unlock()
 	results.JobId = job.JobID
 	results.AdapterName = job.ToolName
 	results.ResultTexts = job.ResultData
 	results.ResultFiles = job.ResultFiles
+	results.StructuredResult = job.StructuredResult
 	results.FinalState = job.Status
+	results.Err = job.Err
 	return results
 }
 
@@ -789,11 +808,37 @@ func (tm *NatsToolManager) ListenForToolJobUpdates() {
 			nuts.L.Debugf("%s!?!?!??!?!?!? Job not found(%s) in update:\n%s", logName, jobUpdate.JobID, nuts.GetPrettyJson(jobUpdate))
 			return
 		}
+		if jobUpdate.Status == AdapterToolExecutionState_Completed {
+			tm.validateCompletedJobUpdate(job, &jobUpdate)
+		}
 		nuts.L.Debugf("%sJob(%s) updated with status(%s) and msg(%s)", logName, jobUpdate.JobID, jobUpdate.Status, jobUpdate.UpdateMsg)
 		job.UpdateStatus(jobUpdate.Status, jobUpdate.UpdateMsg, jobUpdate.NewResultData, jobUpdate.NewResultFiles)
 	})
 }
 
+// validateCompletedJobUpdate enforces the ResponseFormat of the tool on the final results of a job.
+// tools can be implemented anywhere, so the manager does not trust them to validate their own responses.
+// expects tm.safety to be locked
+func (tm *NatsToolManager) validateCompletedJobUpdate(job *NatsToolJob, jobUpdate *NatsToolJobUpdates) {
+	tool, ok := tm.tools[job.ToolName]
+	if !ok || len(tool.ResponseFormat) == 0 {
+		return
+	}
+	finalResults := job.GetResults()
+	finalResults.ResultTexts = append(append([]string{}, finalResults.ResultTexts...), jobUpdate.NewResultData...)
+	finalResults.FinalState = jobUpdate.Status
+	err := tool.ValidateJobResults(&finalResults)
+	job.Safety.Lock()
+	defer job.Safety.Unlock()
+	if err != nil {
+		jobUpdate.Status = AdapterToolExecutionState_Failed
+		jobUpdate.UpdateMsg += " | " + err.Error()
+		job.Err = err
+		return
+	}
+	job.StructuredResult = finalResults.StructuredResult
+}
+
 // AddToolCall adds a new NatsToolJob to the manager
 // Implement the logic to add NatsToolJob based on incoming requests
 func (tm *NatsToolManager) AddToolJob(job *NatsToolJob) (err error) {
@@ -817,8 +862,12 @@ func (tm *NatsToolManager) AddToolJob(job *NatsToolJob) (err error) {
 // CancelToolJob stops an ongoing NatsToolJob
 // Implement logic to stop a NatsToolJob, likely by sending a message to the appropriate tool
 func (tm *NatsToolManager) StopToolJob(jobID string) (err error) {
+	job := tm.GetToolJob(jobID)
+	if job == nil {
+		return ErrJobNotFound
+	}
 	// publish via nats
-	topic := strings.ReplaceAll(NATS_TOPIC_TOOLS_JOBS_STOP, "{{tool.name}}", tm.toolJobs[jobID].ToolName)
+	topic := strings.ReplaceAll(NATS_TOPIC_TOOLS_JOBS_STOP, "{{tool.name}}", job.ToolName)
 	err = tm.natsClient.Publish(topic, []byte(jobID))
 	if err != nil {
 		nuts.L.Errorf("failed to publish job stop: %v", err)
@@ -870,11 +919,12 @@ func ValidateAndFilterParameters(parameterDefinitions []NatsToolParameter, incom
 		for _, alias := range param.Aliases {
 			allowedLowercaseAliases = append(allowedLowercaseAliases, strings.ToLower(alias))
 		}
-		// copy aliases to the "real" key
+		// the value of the "real" key or the first alias, incomingParameters is not changed
+		var value any
 		foundParam := false
 		for _, alias := range allowedLowercaseAliases {
-			if _, ok := incomingParameters[alias]; ok {
-				incomingParameters[param.Name] = incomingParameters[alias]
+			if aliasValue, ok := incomingParameters[alias]; ok {
+				value = aliasValue
 				foundParam = true
 				break
 			}
@@ -882,7 +932,7 @@ func ValidateAndFilterParameters(parameterDefinitions []NatsToolParameter, incom
 		if param.Required && !foundParam {
 			return filteredValidParameters, fmt.Errorf("required argument(%s) is missing", param.Name)
 		}
-		validVal, err := param.SetValue(incomingParameters[param.Name])
+		validVal, err := param.SetValue(value)
 		if err != nil || validVal == nil {
 			if param.Required {
 				return filteredValidParameters, fmt.Errorf("required argument(%s) is invalid", param.Name)
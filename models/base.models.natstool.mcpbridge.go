@@ -0,0 +1,299 @@
+package models
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	nuts "github.com/vaudience/go-nuts"
+)
+
+const (
+	NATSTOOL_TYPE_MCP               = "mcp"
+	MCP_BRIDGE_DEFAULT_CALL_TIMEOUT = 120 * time.Second
+)
+
+var (
+	ErrMCPBridgeNotStarted = errors.New("mcp bridge not started")
+	ErrMCPToolNotBridged   = errors.New("tool is not bridged by this mcp bridge")
+)
+
+type MCPBridgeConfig struct {
+	ServerName          string        `json:"server_name"`
+	Command             string        `json:"command"`
+	Args                []string      `json:"args"`
+	Env                 []string      `json:"env"`
+	ToolNamePrefix      string        `json:"tool_name_prefix"` // prepended to the mcp tool names to keep them unique in the fleet
+	Version             string        `json:"version"`
+	IsPublic            bool          `json:"is_public"`
+	OwnerOrganizationID string        `json:"owner_organization_id"`
+	CallTimeout         time.Duration `json:"call_timeout"`
+}
+
+// MCPBridge connects to an MCP server via stdio and announces each of its tools as a NatsTool
+type MCPBridge struct {
+	Config    MCPBridgeConfig
+	client    *MCPStdioClient
+	safety    sync.Mutex
+	tools     map[string]*NatsTool // map of bridged tools by nats tool name
+	mcpNames  map[string]string    // map of nats tool name to mcp tool name
+	startedAt time.Time
+}
+
+func NewMCPBridge(config MCPBridgeConfig) *MCPBridge {
+	if config.CallTimeout <= 0 {
+		config.CallTimeout = MCP_BRIDGE_DEFAULT_CALL_TIMEOUT
+	}
+	return &MCPBridge{
+		Config:   config,
+		client:   NewMCPStdioClient(config.Command, config.Args, config.Env),
+		tools:    make(map[string]*NatsTool),
+		mcpNames: make(map[string]string),
+	}
+}
+
+// Start launches the MCP server, lists its tools and converts them into NatsTools
+func (bridge *MCPBridge) Start(ctx context.Context) (err error) {
+	var logName string = "[MCPBridge.Start] "
+	err = bridge.client.Start(ctx)
+	if err != nil {
+		return err
+	}
+	mcpTools, err := bridge.client.ListTools(ctx)
+	if err != nil {
+		bridge.client.Close()
+		return fmt.Errorf("failed to list tools of mcp server(%s): %w", bridge.Config.ServerName, err)
+	}
+	version := bridge.Config.Version
+	if version == "" {
+		version = bridge.client.ServerInfo.Version
+	}
+	bridge.safety.Lock()
+	defer bridge.safety.Unlock()
+	for _, mcpTool := range mcpTools {
+		tool := &NatsTool{
+			Name:                bridge.Config.ToolNamePrefix + mcpTool.Name,
+			Description:         mcpTool.Description,
+			Type:                NATSTOOL_TYPE_MCP,
+			IsPublic:            bridge.Config.IsPublic,
+			OwnerOrganizationID: bridge.Config.OwnerOrganizationID,
+			Parameters:          ConvertJSONSchemaToNatsToolParameters(mcpTool.InputSchema),
+			ResponseFormat:      []NatsToolParameter{},
+			Version:             version,
+		}
+		tool.SetExecutor(bridge.executeTool)
+		bridge.tools[tool.Name] = tool
+		bridge.mcpNames[tool.Name] = mcpTool.Name
+		nuts.L.Debugf("%sbridging mcp tool(%s) of server(%s) as nats tool(%s) with (%d) parameters", logName, mcpTool.Name, bridge.Config.ServerName, tool.Name, len(tool.Parameters))
+	}
+	bridge.startedAt = time.Now()
+	return nil
+}
+
+// ConnectToNATS connects every bridged tool to NATS so it announces itself and listens for jobs
+func (bridge *MCPBridge) ConnectToNATS(serverAddress string, username string, password string) (err error) {
+	bridge.safety.Lock()
+	started := !bridge.startedAt.IsZero()
+	bridge.safety.Unlock()
+	if !started {
+		return ErrMCPBridgeNotStarted
+	}
+	for _, tool := range bridge.GetTools() {
+		err = tool.ConnectToNATS(serverAddress, username, password)
+		if err != nil {
+			return fmt.Errorf("failed to connect bridged tool(%s) to nats: %w", tool.Name, err)
+		}
+	}
+	return nil
+}
+
+func (bridge *MCPBridge) GetTools() []*NatsTool {
+	bridge.safety.Lock()
+	defer bridge.safety.Unlock()
+	tools := make([]*NatsTool, 0, len(bridge.tools))
+	for _, tool := range bridge.tools {
+		tools = append(tools, tool)
+	}
+	sort.Slice(tools, func(i, j int) bool {
+		return tools[i].Name < tools[j].Name
+	})
+	return tools
+}
+
+func (bridge *MCPBridge) Close() {
+	for _, tool := range bridge.GetTools() {
+		tool.CloseNATS()
+	}
+	bridge.client.Close()
+}
+
+func (bridge *MCPBridge) executeTool(tool *NatsTool, jobData AdapterExecutionData) (jobResults JobResults) {
+	var logName string = "[MCPBridge.executeTool] "
+	jobResults = *NewJobResults(jobData.JobId, tool.Name)
+	bridge.safety.Lock()
+	mcpName, ok := bridge.mcpNames[tool.Name]
+	bridge.safety.Unlock()
+	if !ok {
+		jobResults.FinalState = AdapterToolExecutionState_Failed
+		jobResults.Err = ErrMCPToolNotBridged
+		return jobResults
+	}
+	validJobData, valid, err := ValidateToolArguments(tool, jobData)
+	if !valid {
+		jobResults.FinalState = AdapterToolExecutionState_Failed
+		jobResults.Err = err
+		return jobResults
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), bridge.Config.CallTimeout)
+	defer cancel()
+	callResult, err := bridge.client.CallTool(ctx, mcpName, validJobData.Arguments)
+	if err != nil {
+		nuts.L.Errorf("%smcp call of tool(%s) for job(%s) failed: %v", logName, mcpName, jobData.JobId, err)
+		jobResults.FinalState = AdapterToolExecutionState_Failed
+		jobResults.Err = err
+		return jobResults
+	}
+	for n, content := range callResult.Content {
+		bridge.addContentToJobResults(&jobResults, jobData, n, content)
+	}
+	if callResult.IsError {
+		jobResults.FinalState = AdapterToolExecutionState_Failed
+		jobResults.Err = fmt.Errorf("mcp tool(%s) reported an error: %s", mcpName, jobResults.GetResultText("\n"))
+		return jobResults
+	}
+	jobResults.FinalState = AdapterToolExecutionState_Completed
+	return jobResults
+}
+
+func (bridge *MCPBridge) addContentToJobResults(jobResults *JobResults, jobData AdapterExecutionData, index int, content MCPContent) {
+	var logName string = "[MCPBridge.addContentToJobResults] "
+	switch content.Type {
+	case "text":
+		jobResults.AddResultText(content.Text)
+	case "image", "audio":
+		fileInfo, err := bridge.writeContentFile(jobData, index, content.MimeType, content.Data, content.Type)
+		if err != nil {
+			nuts.L.Errorf("%sfailed to store %s content of job(%s): %v", logName, content.Type, jobData.JobId, err)
+			return
+		}
+		jobResults.AddResultFile(fileInfo)
+	case "resource":
+		if content.Resource == nil {
+			return
+		}
+		if content.Resource.Text != "" {
+			jobResults.AddResultText(content.Resource.Text)
+			return
+		}
+		if content.Resource.Blob != "" {
+			fileInfo, err := bridge.writeContentFile(jobData, index, content.Resource.MimeType, content.Resource.Blob, content.Resource.URI)
+			if err != nil {
+				nuts.L.Errorf("%sfailed to store resource(%s) of job(%s): %v", logName, content.Resource.URI, jobData.JobId, err)
+				return
+			}
+			jobResults.AddResultFile(fileInfo)
+			return
+		}
+		jobResults.AddResultFile(NewAdapterFileInfo(content.Resource.URI, path.Base(content.Resource.URI), content.Resource.MimeType, "", content.Resource.URI))
+	default:
+		nuts.L.Debugf("%signoring unsupported mcp content type(%s) of job(%s)", logName, content.Type, jobData.JobId)
+	}
+}
+
+// writeContentFile stores base64 content returned by the mcp server in the mission workdir
+func (bridge *MCPBridge) writeContentFile(jobData AdapterExecutionData, index int, mimeType string, base64Data string, description string) (fileInfo AdapterFileInfo, err error) {
+	data, err := base64.StdEncoding.DecodeString(base64Data)
+	if err != nil {
+		return fileInfo, err
+	}
+	extension := ""
+	extensions, _ := mime.ExtensionsByType(mimeType)
+	if len(extensions) > 0 {
+		extension = extensions[0]
+	}
+	fileName := fmt.Sprintf("%s_%d%s", jobData.JobId, index, extension)
+	localDir := path.Join(AdapterBaseWorkdir, jobData.MissionId)
+	err = os.MkdirAll(localDir, 0755)
+	if err != nil {
+		return fileInfo, err
+	}
+	localPath := path.Join(localDir, fileName)
+	err = os.WriteFile(localPath, data, 0644)
+	if err != nil {
+		return fileInfo, err
+	}
+	publicUrl := strings.TrimSuffix(AdapterBaseWebUrl, "/") + "/" + path.Join(jobData.MissionId, fileName)
+	return NewAdapterFileInfo(description, fileName, mimeType, localPath, publicUrl), nil
+}
+
+// ConvertJSONSchemaToNatsToolParameters converts the (object) input schema of an mcp tool into NatsToolParameter definitions
+func ConvertJSONSchemaToNatsToolParameters(schema map[string]any) (parameters []NatsToolParameter) {
+	parameters = make([]NatsToolParameter, 0)
+	properties, _ := schema["properties"].(map[string]any)
+	requireds := make([]string, 0)
+	if requiredList, ok := schema["required"].([]any); ok {
+		for _, required := range requiredList {
+			if name, ok := required.(string); ok {
+				requireds = append(requireds, name)
+			}
+		}
+	}
+	// keep a stable order since maps are unordered
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		property, _ := properties[name].(map[string]any)
+		param := NatsToolParameter{
+			Name:         name,
+			Aliases:      []string{},
+			Description:  nuts.AssignToCastStringOr(property["description"], ""),
+			VarType:      jsonSchemaTypeToNatsToolParameterType(property["type"]),
+			Required:     nuts.StringSliceContains(requireds, name),
+			Enum:         []string{},
+			DefaultValue: property["default"],
+		}
+		if enumList, ok := property["enum"].([]any); ok {
+			for _, enumValue := range enumList {
+				param.Enum = append(param.Enum, fmt.Sprint(enumValue))
+			}
+		}
+		parameters = append(parameters, param)
+	}
+	return parameters
+}
+
+func jsonSchemaTypeToNatsToolParameterType(schemaType any) NatsToolParameterType {
+	typeName, _ := schemaType.(string)
+	// json schema allows a list of types like ["string", "null"]
+	if typeList, ok := schemaType.([]any); ok {
+		for _, entry := range typeList {
+			if entryName, ok := entry.(string); ok && entryName != "null" {
+				typeName = entryName
+				break
+			}
+		}
+	}
+	switch typeName {
+	case "number", "integer":
+		return NatsToolParameterTypeNumber
+	case "boolean":
+		return NatsToolParameterTypeBoolean
+	case "array":
+		return NatsToolParameterTypeArray
+	case "object":
+		return NatsToolParameterTypeObject
+	default:
+		return NatsToolParameterTypeString
+	}
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func startMCPStubBridge(t *testing.T) *MCPBridge {
+	t.Helper()
+	bridge := NewMCPBridge(MCPBridgeConfig{
+		ServerName:     "stub",
+		Command:        buildMCPStub(t),
+		ToolNamePrefix: "stub_",
+		CallTimeout:    5 * time.Second,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := bridge.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(bridge.Close)
+	return bridge
+}
+
+func TestMCPBridgeStartConvertsTools(t *testing.T) {
+	bridge := startMCPStubBridge(t)
+	tools := bridge.GetTools()
+	if len(tools) != 3 {
+		t.Fatalf("GetTools() returned %d tools, want 3", len(tools))
+	}
+	echo := tools[0]
+	if echo.Name != "stub_echo" || echo.Type != NATSTOOL_TYPE_MCP || echo.Version != "1.2.3" {
+		t.Fatalf("echo tool = name(%s) type(%s) version(%s)", echo.Name, echo.Type, echo.Version)
+	}
+	if len(echo.Parameters) != 3 || echo.Parameters[1].Name != "text" || !echo.Parameters[1].Required {
+		t.Fatalf("echo parameters = %+v", echo.Parameters)
+	}
+}
+
+func TestMCPBridgeExecuteTool(t *testing.T) {
+	baseWorkdir := AdapterBaseWorkdir
+	AdapterBaseWorkdir = t.TempDir()
+	t.Cleanup(func() { AdapterBaseWorkdir = baseWorkdir })
+	bridge := startMCPStubBridge(t)
+	tools := make(map[string]*NatsTool)
+	for _, tool := range bridge.GetTools() {
+		tools[tool.Name] = tool
+	}
+
+	tests := []struct {
+		name      string
+		tool      string
+		arguments map[string]any
+		wantState AdapterToolExecutionState
+		wantText  string
+		wantFiles int
+	}{
+		{name: "text result", tool: "stub_echo", arguments: map[string]any{"text": "hi", "mode": "plain"}, wantState: AdapterToolExecutionState_Completed, wantText: "hi"},
+		{name: "missing required argument", tool: "stub_echo", arguments: map[string]any{}, wantState: AdapterToolExecutionState_Failed},
+		{name: "mcp tool error", tool: "stub_fail", arguments: map[string]any{"reason": "nope"}, wantState: AdapterToolExecutionState_Failed, wantText: "nope"},
+		{name: "image stored as file", tool: "stub_image", arguments: map[string]any{}, wantState: AdapterToolExecutionState_Completed, wantFiles: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobData := AdapterExecutionData{AdapterName: tt.tool, JobId: "job-" + tt.tool, MissionId: "mission", Arguments: tt.arguments}
+			jobResults := bridge.executeTool(tools[tt.tool], jobData)
+			if jobResults.FinalState != tt.wantState {
+				t.Fatalf("FinalState = %s, want %s (err %v)", jobResults.FinalState, tt.wantState, jobResults.Err)
+			}
+			if got := jobResults.GetResultText(""); got != tt.wantText {
+				t.Errorf("result text = %q, want %q", got, tt.wantText)
+			}
+			files := jobResults.GetResultFiles()
+			if len(files) != tt.wantFiles {
+				t.Fatalf("result files = %d, want %d", len(files), tt.wantFiles)
+			}
+			for _, file := range files {
+				if _, err := os.Stat(file.LocalPath); err != nil {
+					t.Errorf("result file not written: %v", err)
+				}
+			}
+		})
+	}
+
+	unbridged := &NatsTool{Name: "other"}
+	if jobResults := bridge.executeTool(unbridged, AdapterExecutionData{JobId: "job"}); !errors.Is(jobResults.Err, ErrMCPToolNotBridged) {
+		t.Fatalf("executeTool() of an unbridged tool error = %v, want %v", jobResults.Err, ErrMCPToolNotBridged)
+	}
+}
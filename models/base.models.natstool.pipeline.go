@@ -0,0 +1,463 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	nuts "github.com/vaudience/go-nuts"
+)
+
+const (
+	IDPREFIX_TOOLPIPELINE = "tpipe"
+	IDLENGTH_TOOLPIPELINE = 16
+)
+
+var (
+	ErrToolPipelineEmpty             = errors.New("tool pipeline has no steps")
+	ErrToolPipelineInvalidStep       = errors.New("tool pipeline step is invalid")
+	ErrToolPipelineDuplicateStep     = errors.New("tool pipeline contains a duplicate step id")
+	ErrToolPipelineUnknownDependency = errors.New("tool pipeline step depends on an unknown step")
+	ErrToolPipelineCycle             = errors.New("tool pipeline contains a dependency cycle")
+	ErrToolPipelineInvalidReference  = errors.New("tool pipeline argument reference is invalid")
+	ErrToolPipelineDependencyFailed  = errors.New("tool pipeline step skipped since a dependency did not complete")
+	ErrToolPipelineCancelled         = errors.New("tool pipeline cancelled")
+	ErrToolPipelineFailed            = errors.New("tool pipeline failed")
+)
+
+// ${stepId.result_data}, ${stepId.result_data[0]}, ${stepId.structured_result.key.subkey}, ${stepId.result_files[0].public_url}
+var toolPipelineReferenceRegex = regexp.MustCompile(`\$\{([A-Za-z0-9_-]+)\.(result_data|structured_result|result_files)(?:\[(\d+)\])?((?:\.[A-Za-z0-9_-]+)*)\}`)
+
+// ToolPipelineStep is one tool job within a pipeline. string arguments may reference results of the steps it depends on
+type ToolPipelineStep struct {
+	ID        string         `json:"id"`
+	ToolName  string         `json:"tool_name"`
+	Arguments map[string]any `json:"arguments"`
+	DependsOn []string       `json:"depends_on"`
+}
+
+type ToolPipeline struct {
+	ID    string             `json:"id"`
+	Name  string             `json:"name"`
+	Steps []ToolPipelineStep `json:"steps"`
+}
+
+type ToolPipelineOptions struct {
+	ThreadId string `json:"thread_id"`
+	FailFast bool   `json:"fail_fast"` // stop all branches as soon as one step failed, otherwise only dependents are skipped
+}
+
+type ToolPipelineResult struct {
+	PipelineID  string                               `json:"pipeline_id"`
+	RunId       string                               `json:"run_id"`
+	FinalState  AdapterToolExecutionState            `json:"final_state"`
+	StepOrder   []string                             `json:"step_order"`
+	StepStates  map[string]AdapterToolExecutionState `json:"step_states"`
+	StepResults map[string]JobResults                `json:"step_results"`
+	StartedAt   time.Time                            `json:"started_at"`
+	EndedAt     time.Time                            `json:"ended_at"`
+	Err         error                                `json:"-"`
+}
+
+func NewToolPipeline(name string) *ToolPipeline {
+	return &ToolPipeline{
+		ID:    CreateToolPipelineID(),
+		Name:  name,
+		Steps: make([]ToolPipelineStep, 0),
+	}
+}
+
+func CreateToolPipelineID() string {
+	return nuts.NID(IDPREFIX_TOOLPIPELINE, IDLENGTH_TOOLPIPELINE)
+}
+
+func IsToolPipelineID(id string) bool {
+	return len(id) == IDLENGTH_TOOLPIPELINE+len(IDPREFIX_TOOLPIPELINE)+1 && strings.HasPrefix(id, IDPREFIX_TOOLPIPELINE)
+}
+
+func (pipeline *ToolPipeline) AddStep(id string, toolName string, arguments map[string]any, dependsOn ...string) *ToolPipeline {
+	pipeline.Steps = append(pipeline.Steps, ToolPipelineStep{
+		ID:        id,
+		ToolName:  toolName,
+		Arguments: arguments,
+		DependsOn: dependsOn,
+	})
+	return pipeline
+}
+
+func (pipeline *ToolPipeline) GetStep(id string) *ToolPipelineStep {
+	for n := range pipeline.Steps {
+		if pipeline.Steps[n].ID == id {
+			return &pipeline.Steps[n]
+		}
+	}
+	return nil
+}
+
+// Validate checks the pipeline and returns the step ids in a topological order
+func (pipeline *ToolPipeline) Validate() (order []string, err error) {
+	if len(pipeline.Steps) == 0 {
+		return nil, ErrToolPipelineEmpty
+	}
+	dependents := make(map[string][]string)
+	openDependencies := make(map[string]int)
+	for _, step := range pipeline.Steps {
+		if step.ID == "" || step.ToolName == "" {
+			return nil, fmt.Errorf("%w: step(%s) needs an id and a tool name", ErrToolPipelineInvalidStep, step.ID)
+		}
+		if _, ok := openDependencies[step.ID]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrToolPipelineDuplicateStep, step.ID)
+		}
+		openDependencies[step.ID] = len(step.DependsOn)
+	}
+	for _, step := range pipeline.Steps {
+		for _, dependency := range step.DependsOn {
+			if dependency == step.ID {
+				return nil, fmt.Errorf("%w: step(%s) depends on itself", ErrToolPipelineCycle, step.ID)
+			}
+			if _, ok := openDependencies[dependency]; !ok {
+				return nil, fmt.Errorf("%w: step(%s) depends on (%s)", ErrToolPipelineUnknownDependency, step.ID, dependency)
+			}
+			dependents[dependency] = append(dependents[dependency], step.ID)
+		}
+		for _, referencedStepID := range collectToolPipelineReferences(step.Arguments) {
+			if !nuts.StringSliceContains(step.DependsOn, referencedStepID) {
+				return nil, fmt.Errorf("%w: step(%s) references step(%s) without depending on it", ErrToolPipelineInvalidReference, step.ID, referencedStepID)
+			}
+		}
+	}
+	// kahn's algorithm, keeping the declaration order for independent steps
+	order = make([]string, 0, len(pipeline.Steps))
+	ready := make([]string, 0)
+	for _, step := range pipeline.Steps {
+		if openDependencies[step.ID] == 0 {
+			ready = append(ready, step.ID)
+		}
+	}
+	for len(ready) > 0 {
+		stepID := ready[0]
+		ready = ready[1:]
+		order = append(order, stepID)
+		for _, dependent := range dependents[stepID] {
+			openDependencies[dependent]--
+			if openDependencies[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	if len(order) != len(pipeline.Steps) {
+		return nil, ErrToolPipelineCycle
+	}
+	return order, nil
+}
+
+// ExecutePipeline runs all steps of the pipeline as a DAG under one run. independent branches run in parallel.
+// cancelling ctx stops all running jobs and skips everything that did not start yet.
+func (tm *NatsToolManager) ExecutePipeline(ctx context.Context, run *AIgencyRun, pipeline *ToolPipeline, options ToolPipelineOptions) (result *ToolPipelineResult, err error) {
+	var logName string = "[NatsToolManager.ExecutePipeline] "
+	result = &ToolPipelineResult{
+		PipelineID:  pipeline.ID,
+		RunId:       run.ID,
+		FinalState:  AdapterToolExecutionState_Queued,
+		StepStates:  make(map[string]AdapterToolExecutionState),
+		StepResults: make(map[string]JobResults),
+		StartedAt:   time.Now(),
+	}
+	result.StepOrder, err = pipeline.Validate()
+	if err != nil {
+		result.FinalState = AdapterToolExecutionState_Failed
+		result.Err = err
+		result.EndedAt = time.Now()
+		return result, err
+	}
+	for _, stepID := range result.StepOrder {
+		result.StepStates[stepID] = AdapterToolExecutionState_Queued
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// closing stop releases the waiters of running steps. it must not be ctx: the jobs have to be tracked
+	// by the manager until stopRunningSteps published their stop.
+	stop := make(chan struct{})
+	defer close(stop)
+	type stepFinish struct {
+		stepID  string
+		results JobResults
+	}
+	finished := make(chan stepFinish, len(pipeline.Steps))
+	jobIDs := make(map[string]string)
+	running := 0
+	failed := false
+
+	skipStep := func(stepID string, reason error) {
+		skipped := *NewJobResults(jobIDs[stepID], pipeline.GetStep(stepID).ToolName)
+		skipped.FinalState = AdapterToolExecutionState_Cancelled
+		skipped.Err = reason
+		result.StepStates[stepID] = AdapterToolExecutionState_Cancelled
+		result.StepResults[stepID] = skipped
+	}
+	stopRunningSteps := func(reason error) {
+		for _, stepID := range result.StepOrder {
+			switch result.StepStates[stepID] {
+			case AdapterToolExecutionState_Running:
+				stopErr := tm.StopToolJob(jobIDs[stepID])
+				if stopErr != nil {
+					nuts.L.Debugf("%sfailed to stop job(%s) of step(%s): %v", logName, jobIDs[stepID], stepID, stopErr)
+				}
+				skipStep(stepID, reason)
+			case AdapterToolExecutionState_Queued:
+				skipStep(stepID, reason)
+			}
+		}
+		running = 0
+	}
+	startReadySteps := func() {
+		// the topological order guarantees that dependencies were handled before their dependents
+		for _, stepID := range result.StepOrder {
+			if result.StepStates[stepID] != AdapterToolExecutionState_Queued {
+				continue
+			}
+			step := pipeline.GetStep(stepID)
+			ready := true
+			for _, dependency := range step.DependsOn {
+				dependencyState := result.StepStates[dependency]
+				if dependencyState == AdapterToolExecutionState_Completed {
+					continue
+				}
+				ready = false
+				if IsEndedToolExecutionState(dependencyState) {
+					skipStep(stepID, fmt.Errorf("%w: %s", ErrToolPipelineDependencyFailed, dependency))
+					break
+				}
+			}
+			if !ready {
+				continue
+			}
+			arguments, resolveErr := ResolveToolPipelineArguments(step.Arguments, result.StepResults)
+			jobIDs[stepID] = CreateToolJobID()
+			run.AddToolJobIDs(jobIDs[stepID])
+			var job *NatsToolJob
+			if resolveErr == nil {
+				job, resolveErr = tm.ExecuteJob(AdapterExecutionData{
+					AdapterName: step.ToolName,
+					JobId:       jobIDs[stepID],
+					MissionId:   run.MissionID,
+					ThreadId:    options.ThreadId,
+					RunId:       run.ID,
+					Arguments:   arguments,
+				})
+			}
+			if resolveErr != nil {
+				failedResults := *NewJobResults(jobIDs[stepID], step.ToolName)
+				failedResults.FinalState = AdapterToolExecutionState_Failed
+				failedResults.Err = resolveErr
+				result.StepStates[stepID] = AdapterToolExecutionState_Failed
+				result.StepResults[stepID] = failedResults
+				failed = true
+				continue
+			}
+			result.StepStates[stepID] = AdapterToolExecutionState_Running
+			running++
+			go func(stepID string, done <-chan JobResults) {
+				select {
+				case jobResults := <-done:
+					finished <- stepFinish{stepID: stepID, results: jobResults}
+				case <-stop:
+				}
+			}(stepID, tm.awaitToolJob(job, stop))
+		}
+	}
+
+	result.FinalState = AdapterToolExecutionState_Running
+	startReadySteps()
+	for running > 0 && !(failed && options.FailFast) {
+		select {
+		case stepFinished := <-finished:
+			running--
+			result.StepStates[stepFinished.stepID] = stepFinished.results.FinalState
+			result.StepResults[stepFinished.stepID] = stepFinished.results
+			if stepFinished.results.FinalState != AdapterToolExecutionState_Completed {
+				failed = true
+				if options.FailFast {
+					continue
+				}
+			}
+			startReadySteps()
+		case <-ctx.Done():
+			nuts.L.Infof("%spipeline(%s) of run(%s) cancelled: %v", logName, pipeline.ID, run.ID, ctx.Err())
+			stopRunningSteps(fmt.Errorf("%w: %v", ErrToolPipelineCancelled, ctx.Err()))
+			result.FinalState = AdapterToolExecutionState_Cancelled
+			result.Err = ErrToolPipelineCancelled
+			result.EndedAt = time.Now()
+			return result, result.Err
+		}
+	}
+	if failed {
+		stopRunningSteps(ErrToolPipelineFailed)
+		result.FinalState = AdapterToolExecutionState_Failed
+		result.Err = ErrToolPipelineFailed
+	} else {
+		result.FinalState = AdapterToolExecutionState_Completed
+	}
+	result.EndedAt = time.Now()
+	return result, result.Err
+}
+
+// GetResultText joins the result texts of all steps in execution order
+func (result *ToolPipelineResult) GetResultText(joinBy string) string {
+	texts := make([]string, 0, len(result.StepOrder))
+	for _, stepID := range result.StepOrder {
+		stepResults, ok := result.StepResults[stepID]
+		if !ok || len(stepResults.ResultTexts) == 0 {
+			continue
+		}
+		texts = append(texts, stepResults.GetResultText(joinBy))
+	}
+	return strings.Join(texts, joinBy)
+}
+
+// GetResultFiles collects the result files of all steps in execution order
+func (result *ToolPipelineResult) GetResultFiles() []AdapterFileInfo {
+	files := make([]AdapterFileInfo, 0)
+	for _, stepID := range result.StepOrder {
+		files = append(files, result.StepResults[stepID].ResultFiles...)
+	}
+	return files
+}
+
+// ResolveToolPipelineArguments replaces references in (nested) string arguments with values from the results of previous steps.
+// an argument that consists of a single reference keeps the type of the referenced value.
+func ResolveToolPipelineArguments(arguments map[string]any, stepResults map[string]JobResults) (resolved map[string]any, err error) {
+	resolved = make(map[string]any, len(arguments))
+	for key, value := range arguments {
+		resolved[key], err = resolveToolPipelineValue(value, stepResults)
+		if err != nil {
+			return resolved, fmt.Errorf("argument(%s): %w", key, err)
+		}
+	}
+	return resolved, nil
+}
+
+func resolveToolPipelineValue(value any, stepResults map[string]JobResults) (resolved any, err error) {
+	switch typedValue := value.(type) {
+	case string:
+		matches := toolPipelineReferenceRegex.FindAllStringSubmatchIndex(typedValue, -1)
+		if len(matches) == 0 {
+			return typedValue, nil
+		}
+		if len(matches) == 1 && matches[0][0] == 0 && matches[0][1] == len(typedValue) {
+			return lookupToolPipelineReference(toolPipelineReferenceRegex.FindStringSubmatch(typedValue), stepResults)
+		}
+		var lookupErr error
+		replaced := toolPipelineReferenceRegex.ReplaceAllStringFunc(typedValue, func(reference string) string {
+			referencedValue, err := lookupToolPipelineReference(toolPipelineReferenceRegex.FindStringSubmatch(reference), stepResults)
+			if err != nil {
+				lookupErr = err
+				return reference
+			}
+			return fmt.Sprint(referencedValue)
+		})
+		return replaced, lookupErr
+	case map[string]any:
+		return ResolveToolPipelineArguments(typedValue, stepResults)
+	case []any:
+		resolvedList := make([]any, len(typedValue))
+		for n, entry := range typedValue {
+			resolvedList[n], err = resolveToolPipelineValue(entry, stepResults)
+			if err != nil {
+				return resolvedList, err
+			}
+		}
+		return resolvedList, nil
+	default:
+		return value, nil
+	}
+}
+
+// lookupToolPipelineReference resolves a reference match of the form [full, stepId, field, index, .path]
+func lookupToolPipelineReference(match []string, stepResults map[string]JobResults) (value any, err error) {
+	reference, stepID, field, indexString, subPath := match[0], match[1], match[2], match[3], strings.TrimPrefix(match[4], ".")
+	stepResult, ok := stepResults[stepID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s has no results", ErrToolPipelineInvalidReference, reference)
+	}
+	index := -1
+	if indexString != "" {
+		index, _ = strconv.Atoi(indexString)
+	}
+	switch field {
+	case "result_data":
+		if index < 0 {
+			return stepResult.GetResultText("\n"), nil
+		}
+		if index >= len(stepResult.ResultTexts) {
+			return nil, fmt.Errorf("%w: %s is out of range", ErrToolPipelineInvalidReference, reference)
+		}
+		return stepResult.ResultTexts[index], nil
+	case "structured_result":
+		var current any = stepResult.StructuredResult
+		if index >= 0 || stepResult.StructuredResult == nil {
+			return nil, fmt.Errorf("%w: %s", ErrToolPipelineInvalidReference, reference)
+		}
+		for _, key := range strings.Split(subPath, ".") {
+			if key == "" {
+				continue
+			}
+			currentMap, ok := current.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrToolPipelineInvalidReference, reference)
+			}
+			current, ok = currentMap[key]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s not found", ErrToolPipelineInvalidReference, reference)
+			}
+		}
+		return current, nil
+	case "result_files":
+		if index < 0 {
+			urls := make([]any, 0, len(stepResult.ResultFiles))
+			for _, file := range stepResult.ResultFiles {
+				urls = append(urls, file.PublicUrl)
+			}
+			return urls, nil
+		}
+		if index >= len(stepResult.ResultFiles) {
+			return nil, fmt.Errorf("%w: %s is out of range", ErrToolPipelineInvalidReference, reference)
+		}
+		file := stepResult.ResultFiles[index]
+		switch subPath {
+		case "", "public_url":
+			return file.PublicUrl, nil
+		case "file_name":
+			return file.FileName, nil
+		case "mime_type":
+			return file.MimeType, nil
+		case "description":
+			return file.Description, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrToolPipelineInvalidReference, reference)
+}
+
+func collectToolPipelineReferences(value any) (stepIDs []string) {
+	stepIDs = make([]string, 0)
+	switch typedValue := value.(type) {
+	case string:
+		for _, match := range toolPipelineReferenceRegex.FindAllStringSubmatch(typedValue, -1) {
+			stepIDs = append(stepIDs, match[1])
+		}
+	case map[string]any:
+		for _, entry := range typedValue {
+			stepIDs = append(stepIDs, collectToolPipelineReferences(entry)...)
+		}
+	case []any:
+		for _, entry := range typedValue {
+			stepIDs = append(stepIDs, collectToolPipelineReferences(entry)...)
+		}
+	}
+	return stepIDs
+}
@@ -0,0 +1,222 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestToolPipelineValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		pipeline  *ToolPipeline
+		wantOrder []string
+		wantErr   error
+	}{
+		{
+			name:     "empty",
+			pipeline: NewToolPipeline("empty"),
+			wantErr:  ErrToolPipelineEmpty,
+		},
+		{
+			name: "diamond in declaration order",
+			pipeline: NewToolPipeline("diamond").
+				AddStep("fetch", "web", nil).
+				AddStep("summarize", "llm", map[string]any{"text": "${fetch.result_data}"}, "fetch").
+				AddStep("translate", "llm", nil, "fetch").
+				AddStep("report", "doc", nil, "summarize", "translate"),
+			wantOrder: []string{"fetch", "summarize", "translate", "report"},
+		},
+		{
+			name:     "duplicate step",
+			pipeline: NewToolPipeline("dup").AddStep("a", "tool", nil).AddStep("a", "tool", nil),
+			wantErr:  ErrToolPipelineDuplicateStep,
+		},
+		{
+			name:     "unknown dependency",
+			pipeline: NewToolPipeline("unknown").AddStep("a", "tool", nil, "missing"),
+			wantErr:  ErrToolPipelineUnknownDependency,
+		},
+		{
+			name:     "self dependency is a cycle",
+			pipeline: NewToolPipeline("self").AddStep("a", "tool", nil, "a"),
+			wantErr:  ErrToolPipelineCycle,
+		},
+		{
+			name:     "cycle",
+			pipeline: NewToolPipeline("cycle").AddStep("a", "tool", nil, "b").AddStep("b", "tool", nil, "a"),
+			wantErr:  ErrToolPipelineCycle,
+		},
+		{
+			name:     "reference without dependency",
+			pipeline: NewToolPipeline("ref").AddStep("a", "tool", nil).AddStep("b", "tool", map[string]any{"x": "${a.result_data[0]}"}),
+			wantErr:  ErrToolPipelineInvalidReference,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := tt.pipeline.Validate()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(order, tt.wantOrder) {
+				t.Fatalf("Validate() order = %v, want %v", order, tt.wantOrder)
+			}
+		})
+	}
+}
+
+type testPipelineReturn struct {
+	result *ToolPipelineResult
+	err    error
+}
+
+func executeTestPipeline(ctx context.Context, tm *NatsToolManager, run *AIgencyRun, pipeline *ToolPipeline, options ToolPipelineOptions) <-chan testPipelineReturn {
+	returned := make(chan testPipelineReturn, 1)
+	go func() {
+		result, err := tm.ExecutePipeline(ctx, run, pipeline, options)
+		returned <- testPipelineReturn{result: result, err: err}
+	}()
+	return returned
+}
+
+func waitForPipeline(t *testing.T, returned <-chan testPipelineReturn) testPipelineReturn {
+	t.Helper()
+	select {
+	case pipelineReturn := <-returned:
+		return pipelineReturn
+	case <-time.After(5 * time.Second):
+		t.Fatal("pipeline did not return")
+	}
+	return testPipelineReturn{}
+}
+
+func TestExecutePipelineRunsTheDAG(t *testing.T) {
+	tm, published := newTestToolManager(t)
+	run := NewAIgencyRun()
+	pipeline := NewToolPipeline("diamond").
+		AddStep("fetch", "web", nil).
+		AddStep("summarize", "llm", map[string]any{"text": "summarize ${fetch.result_data[0]}"}, "fetch").
+		AddStep("translate", "llm", nil, "fetch").
+		AddStep("report", "doc", nil, "summarize", "translate")
+	returned := executeTestPipeline(context.Background(), tm, run, pipeline, ToolPipelineOptions{})
+
+	fetch := waitForSubmittedJobs(t, published, "web", 1)[0]
+	waitFor(t, "fetch completed", deliverJobUpdate(tm, fetch.JobID, AdapterToolExecutionState_Completed))
+	// both branches run at the same time
+	branches := waitForSubmittedJobs(t, published, "llm", 2)
+	if branches[0].Parameters["text"] != "summarize data" {
+		t.Errorf("summarize got arguments %v, want the fetched data resolved", branches[0].Parameters)
+	}
+	for _, branch := range branches {
+		waitFor(t, "branch completed", deliverJobUpdate(tm, branch.JobID, AdapterToolExecutionState_Completed))
+	}
+	report := waitForSubmittedJobs(t, published, "doc", 1)[0]
+	waitFor(t, "report completed", deliverJobUpdate(tm, report.JobID, AdapterToolExecutionState_Completed))
+
+	pipelineReturn := waitForPipeline(t, returned)
+	if pipelineReturn.err != nil || pipelineReturn.result.FinalState != AdapterToolExecutionState_Completed {
+		t.Fatalf("ExecutePipeline() = %s, %v, want completed", pipelineReturn.result.FinalState, pipelineReturn.err)
+	}
+	if want := []string{fetch.JobID, branches[0].JobID, branches[1].JobID, report.JobID}; !reflect.DeepEqual(run.ToolJobIDs, want) {
+		t.Errorf("run.ToolJobIDs = %v, want %v", run.ToolJobIDs, want)
+	}
+}
+
+func TestExecutePipelineSkipsTheDependentsOfAFailedStep(t *testing.T) {
+	tm, published := newTestToolManager(t)
+	pipeline := NewToolPipeline("branches").
+		AddStep("broken", "fails", nil).
+		AddStep("after_broken", "tool", nil, "broken").
+		AddStep("independent", "other", nil)
+	returned := executeTestPipeline(context.Background(), tm, NewAIgencyRun(), pipeline, ToolPipelineOptions{})
+
+	broken := waitForSubmittedJobs(t, published, "fails", 1)[0]
+	waitFor(t, "failed update", deliverJobUpdate(tm, broken.JobID, AdapterToolExecutionState_Failed))
+	independent := waitForSubmittedJobs(t, published, "other", 1)[0]
+	waitFor(t, "completed update", deliverJobUpdate(tm, independent.JobID, AdapterToolExecutionState_Completed))
+
+	pipelineReturn := waitForPipeline(t, returned)
+	if !errors.Is(pipelineReturn.err, ErrToolPipelineFailed) {
+		t.Fatalf("ExecutePipeline() error = %v, want %v", pipelineReturn.err, ErrToolPipelineFailed)
+	}
+	want := map[string]AdapterToolExecutionState{"broken": AdapterToolExecutionState_Failed, "after_broken": AdapterToolExecutionState_Cancelled, "independent": AdapterToolExecutionState_Completed}
+	if !reflect.DeepEqual(pipelineReturn.result.StepStates, want) {
+		t.Errorf("step states = %v, want %v", pipelineReturn.result.StepStates, want)
+	}
+	if err := pipelineReturn.result.StepResults["after_broken"].Err; !errors.Is(err, ErrToolPipelineDependencyFailed) {
+		t.Errorf("skipped step error = %v, want %v", err, ErrToolPipelineDependencyFailed)
+	}
+}
+
+func TestExecutePipelineCancelStopsTheRunningJobs(t *testing.T) {
+	tm, published := newTestToolManager(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pipeline := NewToolPipeline("cancel")
+	for n := 0; n < 20; n++ {
+		pipeline.AddStep(fmt.Sprintf("slow%d", n), "slow", nil)
+	}
+	pipeline.AddStep("next", "tool", nil, "slow0")
+	returned := executeTestPipeline(ctx, tm, NewAIgencyRun(), pipeline, ToolPipelineOptions{})
+
+	jobIDs := waitForSubmittedJobIDs(t, published, "slow", 20)
+	// everyone reacting to the cancellation queues up for the manager, waiters must not remove jobs before they were stopped
+	tm.safety.Lock()
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	tm.safety.Unlock()
+	pipelineReturn := waitForPipeline(t, returned)
+	if !errors.Is(pipelineReturn.err, ErrToolPipelineCancelled) || pipelineReturn.result.FinalState != AdapterToolExecutionState_Cancelled {
+		t.Fatalf("ExecutePipeline() = %s, %v, want cancelled", pipelineReturn.result.FinalState, pipelineReturn.err)
+	}
+	for stepID, state := range pipelineReturn.result.StepStates {
+		if state != AdapterToolExecutionState_Cancelled {
+			t.Errorf("step %s is %s, want cancelled", stepID, state)
+		}
+	}
+	stopped := make([]string, 0)
+	for range jobIDs {
+		stopped = append(stopped, waitForPublished(t, published, "aigency.tools.jobs.stop.slow").Data)
+	}
+	if !reflect.DeepEqual(stopped, jobIDs) {
+		t.Errorf("stopped jobs %v, want every running job %v", stopped, jobIDs)
+	}
+}
+
+func TestResolveToolPipelineArguments(t *testing.T) {
+	stepResults := map[string]JobResults{
+		"fetch": {ResultTexts: []string{"first", "second"}, ResultFiles: []AdapterFileInfo{{FileName: "a.png", PublicUrl: "https://example.com/a.png"}, {FileName: "b.png", PublicUrl: "https://example.com/b.png"}}},
+		"parse": {StructuredResult: map[string]any{"count": 3.0, "author": map[string]any{"name": "ada"}}},
+	}
+	tests := []struct {
+		name      string
+		arguments map[string]any
+		want      map[string]any
+		wantErr   error
+	}{
+		{name: "plain values", arguments: map[string]any{"text": "no reference", "limit": 5}, want: map[string]any{"text": "no reference", "limit": 5}},
+		{name: "single reference keeps the type", arguments: map[string]any{"count": "${parse.structured_result.count}"}, want: map[string]any{"count": 3.0}},
+		{name: "references within text", arguments: map[string]any{"text": "${parse.structured_result.author.name} read ${fetch.result_data[1]}"}, want: map[string]any{"text": "ada read second"}},
+		{name: "all result data", arguments: map[string]any{"text": "${fetch.result_data}"}, want: map[string]any{"text": "first\nsecond"}},
+		{name: "files", arguments: map[string]any{"urls": "${fetch.result_files}", "name": "${fetch.result_files[1].file_name}"}, want: map[string]any{"urls": []any{"https://example.com/a.png", "https://example.com/b.png"}, "name": "b.png"}},
+		{name: "nested", arguments: map[string]any{"options": map[string]any{"list": []any{"${fetch.result_data[0]}", 1}}}, want: map[string]any{"options": map[string]any{"list": []any{"first", 1}}}},
+		{name: "index out of range", arguments: map[string]any{"text": "${fetch.result_data[2]}"}, wantErr: ErrToolPipelineInvalidReference},
+		{name: "missing structured key", arguments: map[string]any{"text": "${parse.structured_result.missing}"}, wantErr: ErrToolPipelineInvalidReference},
+		{name: "step without results", arguments: map[string]any{"text": "${other.result_data}"}, wantErr: ErrToolPipelineInvalidReference},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, err := ResolveToolPipelineArguments(tt.arguments, stepResults)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ResolveToolPipelineArguments() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(resolved, tt.want) {
+				t.Fatalf("ResolveToolPipelineArguments() = %v, want %v", resolved, tt.want)
+			}
+		})
+	}
+}
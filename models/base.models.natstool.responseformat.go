@@ -0,0 +1,49 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrToolResponseSchemaViolation = errors.New("tool response violates the response format of the tool")
+	ErrToolResponseNotStructured   = errors.New("tool response contains no structured json result")
+)
+
+// ParseStructuredToolResult finds the structured result of a tool within its result texts.
+// the last result text that holds a json object is used, so tools can send progress texts before the result.
+func ParseStructuredToolResult(resultTexts []string) (structured map[string]any, err error) {
+	for n := len(resultTexts) - 1; n >= 0; n-- {
+		text := strings.TrimSpace(resultTexts[n])
+		if !strings.HasPrefix(text, "{") {
+			continue
+		}
+		structured = make(map[string]any)
+		if json.Unmarshal([]byte(text), &structured) == nil {
+			return structured, nil
+		}
+	}
+	return nil, ErrToolResponseNotStructured
+}
+
+// ValidateJobResults checks completed JobResults against the tool's ResponseFormat.
+// valid results get their StructuredResult set, invalid results are marked as Failed with a schema violation error.
+func (tool *NatsTool) ValidateJobResults(jobResults *JobResults) (err error) {
+	if len(tool.ResponseFormat) == 0 || jobResults.FinalState != AdapterToolExecutionState_Completed {
+		return nil
+	}
+	structured, err := ParseStructuredToolResult(jobResults.ResultTexts)
+	if err == nil {
+		structured, err = ValidateAndFilterParameters(tool.ResponseFormat, structured)
+	}
+	if err != nil {
+		jobResults.StructuredResult = nil
+		jobResults.FinalState = AdapterToolExecutionState_Failed
+		jobResults.Err = fmt.Errorf("%w(%s): %v", ErrToolResponseSchemaViolation, tool.Name, err)
+		return jobResults.Err
+	}
+	jobResults.StructuredResult = structured
+	return nil
+}
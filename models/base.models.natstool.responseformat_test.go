@@ -0,0 +1,88 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func newTestResponseFormatTool() *NatsTool {
+	return &NatsTool{Name: "tool", ResponseFormat: []NatsToolParameter{
+		{Name: "answer", VarType: NatsToolParameterTypeString, Required: true},
+		{Name: "score", VarType: NatsToolParameterTypeNumber, Aliases: []string{"points"}},
+	}}
+}
+
+func TestNatsToolValidateJobResults(t *testing.T) {
+	tests := []struct {
+		name           string
+		resultTexts    []string
+		wantStructured map[string]any
+		wantErr        error
+	}{
+		{name: "valid result after progress texts", resultTexts: []string{"working", `{"answer":"42","points":3,"extra":true}`}, wantStructured: map[string]any{"answer": "42", "score": 3.0}},
+		{name: "schema violation", resultTexts: []string{`{"points":3}`}, wantErr: ErrToolResponseSchemaViolation},
+		{name: "no json", resultTexts: []string{"the answer is 42"}, wantErr: ErrToolResponseSchemaViolation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobResults := &JobResults{JobId: "job", ResultTexts: tt.resultTexts, FinalState: AdapterToolExecutionState_Completed}
+			err := newTestResponseFormatTool().ValidateJobResults(jobResults)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ValidateJobResults() error = %v, want %v", err, tt.wantErr)
+			}
+			wantState := AdapterToolExecutionState_Completed
+			if tt.wantErr != nil {
+				wantState = AdapterToolExecutionState_Failed
+			}
+			if jobResults.FinalState != wantState || !reflect.DeepEqual(jobResults.StructuredResult, tt.wantStructured) {
+				t.Errorf("results %s with %v, want %s with %v", jobResults.FinalState, jobResults.StructuredResult, wantState, tt.wantStructured)
+			}
+		})
+	}
+}
+
+func TestValidateAndFilterParametersKeepsTheIncomingParameters(t *testing.T) {
+	incoming := map[string]any{"answer": "42", "points": 3.0}
+	filtered, err := ValidateAndFilterParameters(newTestResponseFormatTool().ResponseFormat, incoming)
+	if err != nil {
+		t.Fatalf("ValidateAndFilterParameters() error = %v", err)
+	}
+	if want := map[string]any{"answer": "42", "score": 3.0}; !reflect.DeepEqual(filtered, want) {
+		t.Errorf("filtered = %v, want %v", filtered, want)
+	}
+	if want := map[string]any{"answer": "42", "points": 3.0}; !reflect.DeepEqual(incoming, want) {
+		t.Errorf("incoming parameters changed to %v, want %v", incoming, want)
+	}
+}
+
+func TestValidateCompletedJobUpdateFailsSchemaViolations(t *testing.T) {
+	tm, job := newTestToolManagerWithJob("job-1")
+	tm.tools["tool"] = newTestResponseFormatTool()
+	job.ToolName = "tool"
+
+	tests := []struct {
+		name       string
+		data       []string
+		wantStatus AdapterToolExecutionState
+		wantErr    error
+	}{
+		{name: "violation", data: []string{`{"score":1}`}, wantStatus: AdapterToolExecutionState_Failed, wantErr: ErrToolResponseSchemaViolation},
+		{name: "valid", data: []string{`{"answer":"yes"}`}, wantStatus: AdapterToolExecutionState_Completed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job.Err = nil
+			jobUpdate := &NatsToolJobUpdates{JobID: job.JobID, Status: AdapterToolExecutionState_Completed, NewResultData: tt.data}
+			tm.safety.Lock()
+			tm.validateCompletedJobUpdate(job, jobUpdate)
+			tm.safety.Unlock()
+			if jobUpdate.Status != tt.wantStatus || !errors.Is(job.Err, tt.wantErr) {
+				t.Fatalf("update status %s, job error %v, want %s and %v", jobUpdate.Status, job.Err, tt.wantStatus, tt.wantErr)
+			}
+			if tt.wantErr == nil && job.StructuredResult["answer"] != "yes" {
+				t.Errorf("StructuredResult = %v, want the validated result", job.StructuredResult)
+			}
+		})
+	}
+}
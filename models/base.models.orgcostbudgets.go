@@ -13,19 +13,46 @@ var (
 )
 
 type OrgCostBudgetWriteDto struct {
-	TotalBudget *float64 `json:"total_budget" validate:"omitempty,min=0" writexs:"system:struct,admin:struct,owner:struct" readxs:"system:struct,admin:struct,owner:struct"`
-	UsedBudget  *float64 `json:"used_budget" validate:"omitempty,min=0" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,owner:struct"`
+	TotalBudget     *Money                  `json:"total_budget" validate:"omitempty,min=0" writexs:"system:struct,admin:struct,owner:struct" readxs:"system:struct,admin:struct,owner:struct"`
+	UsedBudget      *Money                  `json:"used_budget" validate:"omitempty,min=0" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,owner:struct"`
+	Rounding        *MoneyRounding          `json:"rounding" validate:"omitempty" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,owner:struct"`
+	Currency        *Currency               `json:"currency" validate:"omitempty,oneof=EUR USD GBP CHF" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,owner:struct"`
+	LimitMode       *OrgCostBudgetLimitMode `json:"limit_mode" validate:"omitempty,oneof=hard soft" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,owner:struct"`
+	Period          *OrgCostBudgetPeriod    `json:"period" validate:"omitempty,oneof=none daily weekly monthly yearly" writexs:"system:struct,admin:struct,owner:struct" readxs:"system:struct,admin:struct,owner:struct"`
+	AlertThresholds *[]int                  `json:"alert_thresholds" validate:"omitempty,dive,min=1,max=1000" writexs:"system:struct,admin:struct,owner:struct" readxs:"system:struct,admin:struct,owner:struct"`
+	ParentScope     *OrgCostBudgetScope     `json:"parent_scope" validate:"omitempty" writexs:"system:struct,admin:struct,owner:struct" readxs:"system:struct,admin:struct,owner:struct"`
 } //@name OrgCostBudgetWriteDto
 
 type OrgCostBudget struct {
-	OrgID           string  `json:"org_id" validate:"required,min=1,max=64" writexs:"system:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`
-	TotalBudget     float64 `json:"total_budget" validate:"min=0" writexs:"system:struct,admin:struct,owner:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`
-	UsedBudget      float64 `json:"used_budget" validate:"min=0" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`
-	RemainingBudget float64 `json:"remaining_budget" validate:"min=0" writexs:"system:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`
-	UpdatedAt       int64   `json:"updated_at" writexs:"system:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`
-	UpdatedBy       string  `json:"updated_by" writexs:"system:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`
+	OrgID            string                 `json:"org_id" validate:"required,min=1,max=64" writexs:"system:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`
+	ScopeType        OrgCostBudgetScopeType `json:"scope_type,omitempty" validate:"omitempty,oneof=org team agent user apikey" writexs:"system:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"` // org if empty, all other scopes are sub-budgets of the org budget
+	ScopeID          string                 `json:"scope_id,omitempty" validate:"max=64" writexs:"system:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`
+	ParentScope      *OrgCostBudgetScope    `json:"parent_scope,omitempty" validate:"omitempty" writexs:"system:struct,admin:struct,owner:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"` // team budget an agent, user or api key budget rolls up into, nil books on the org budget only
+	TotalBudget      Money                  `json:"total_budget" validate:"min=0" writexs:"system:struct,admin:struct,owner:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`
+	UsedBudget       Money                  `json:"used_budget" validate:"min=0" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`
+	ReservedBudget   Money                  `json:"reserved_budget" validate:"min=0" writexs:"system:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"` // held by open reservations of running executions
+	RemainingBudget  Money                  `json:"remaining_budget" validate:"min=0" writexs:"system:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`
+	LimitMode        OrgCostBudgetLimitMode `json:"limit_mode,omitempty" validate:"omitempty,oneof=hard soft" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`                                 // hard if empty
+	Currency         Currency               `json:"currency,omitempty" validate:"omitempty,oneof=EUR USD GBP CHF" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`                             // DEFAULT_CURRENCY if empty
+	Rounding         *MoneyRounding         `json:"rounding,omitempty" validate:"omitempty" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`                                                   // nil keeps full nano euro precision
+	Period           OrgCostBudgetPeriod    `json:"period,omitempty" validate:"omitempty,oneof=none daily weekly monthly yearly" writexs:"system:struct,admin:struct,owner:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"` // UsedBudget is reset at the end of every period
+	PeriodStart      int64                  `json:"period_start,omitempty" writexs:"system:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`
+	PeriodEnd        int64                  `json:"period_end,omitempty" writexs:"system:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`
+	AlertThresholds  []int                  `json:"alert_thresholds,omitempty" validate:"omitempty,dive,min=1,max=1000" writexs:"system:struct,admin:struct,owner:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"` // percent of TotalBudget, ORGCOSTBUDGET_DEFAULT_ALERT_THRESHOLDS if empty
+	AlertedThreshold int                    `json:"alerted_threshold" writexs:"system:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`                                                                             // highest threshold alerted in the current period
+	UpdatedAt        int64                  `json:"updated_at" writexs:"system:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`
+	UpdatedBy        string                 `json:"updated_by" writexs:"system:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`
 } //@name OrgCostBudget
 
+type OrgCostBudgetLimitMode string //@name OrgCostBudgetLimitMode
+
+const (
+	// reservations beyond the remaining budget are rejected
+	OrgCostBudgetLimitModeHard OrgCostBudgetLimitMode = "hard"
+	// reservations beyond the remaining budget are granted but flagged as exceeding the budget
+	OrgCostBudgetLimitModeSoft OrgCostBudgetLimitMode = "soft"
+)
+
 type OrgCostBudgetCheck struct {
 	OrgID            string `json:"org_id" validate:"required"`
 	SufficientBudget bool   `json:"sufficient_budget" validate:"required"`
@@ -46,5 +73,81 @@ func ValidateOrgCostBudget(budget *OrgCostBudget) error {
 	if err != nil {
 		return ErrInvalidOrgCostBudget
 	}
+	if !budget.IsOrgScope() && budget.ScopeID == "" {
+		return ErrInvalidOrgCostBudget
+	}
+	// only agent, user and api key budgets roll up into a team budget, so the hierarchy can not loop
+	if budget.ParentScope != nil && (budget.ParentScope.Type != OrgCostBudgetScopeTypeTeam || budget.IsOrgScope() || budget.ScopeType == OrgCostBudgetScopeTypeTeam) {
+		return ErrInvalidOrgCostBudget
+	}
+	return nil
+}
+
+func (budget *OrgCostBudget) IsHardLimit() bool {
+	return budget.LimitMode != OrgCostBudgetLimitModeSoft
+}
+
+// RoundAmount applies the rounding rules of the org to a billed amount
+func (budget *OrgCostBudget) RoundAmount(amount Money) Money {
+	if budget.Rounding == nil {
+		return amount
+	}
+	return budget.Rounding.Apply(amount)
+}
+
+// AddUsedCost books the (rounded) cost of an execution and updates the remaining budget
+func (budget *OrgCostBudget) AddUsedCost(cost Money) {
+	budget.UsedBudget += budget.RoundAmount(cost)
+	budget.UpdateRemainingBudget()
+	budget.UpdatedAt = nuts.TimeToJSTimestamp(time.Now())
+}
+
+func (budget *OrgCostBudget) UpdateRemainingBudget() {
+	budget.RemainingBudget = budget.TotalBudget - budget.UsedBudget - budget.ReservedBudget
+	if budget.RemainingBudget < 0 {
+		budget.RemainingBudget = 0
+	}
+}
+
+// AddUsedCostInCurrency converts a cost into the budget currency and books it
+func (budget *OrgCostBudget) AddUsedCostInCurrency(cost Money, currency Currency, provider FXRateProvider) (err error) {
+	converted, _, err := ConvertMoney(cost, currency, budget.Currency, provider, time.Now())
+	if err != nil {
+		return err
+	}
+	budget.AddUsedCost(converted)
 	return nil
 }
+
+// ApplyWriteDto copies the set fields of the dto onto the budget
+func (budget *OrgCostBudget) ApplyWriteDto(dto *OrgCostBudgetWriteDto) {
+	if dto.TotalBudget != nil {
+		budget.TotalBudget = *dto.TotalBudget
+	}
+	if dto.UsedBudget != nil {
+		budget.UsedBudget = *dto.UsedBudget
+	}
+	if dto.Currency != nil {
+		budget.Currency = *dto.Currency
+	}
+	if dto.Period != nil && *dto.Period != budget.Period {
+		budget.Period = *dto.Period
+		budget.StartPeriod(time.Now())
+	}
+	if dto.AlertThresholds != nil {
+		budget.AlertThresholds = *dto.AlertThresholds
+	}
+	if dto.LimitMode != nil {
+		budget.LimitMode = *dto.LimitMode
+	}
+	if dto.Rounding != nil {
+		rounding := *dto.Rounding
+		budget.Rounding = &rounding
+	}
+	if dto.ParentScope != nil {
+		parentScope := *dto.ParentScope
+		budget.ParentScope = &parentScope
+	}
+	budget.UpdateRemainingBudget()
+	budget.UpdatedAt = nuts.TimeToJSTimestamp(time.Now())
+}
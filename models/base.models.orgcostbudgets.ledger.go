@@ -0,0 +1,373 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	nuts "github.com/vaudience/go-nuts"
+)
+
+const (
+	IDPREFIX_BUDGETRESERVATION = "bres"
+	IDLENGTH_BUDGETRESERVATION = 16
+
+	ORGCOSTBUDGET_RESERVATION_DEFAULT_TTL = 30 * time.Minute
+)
+
+var (
+	ErrOrgCostBudgetNotFound      = errors.New("organization cost budget not found")
+	ErrInsufficientBudget         = errors.New("insufficient budget")
+	ErrBudgetReservationNotFound  = errors.New("budget reservation not found, it was settled, released or expired")
+	ErrInvalidBudgetAmount        = errors.New("budget amount must not be negative")
+	ErrInvalidBudgetReservationID = errors.New("invalid budget reservation id")
+)
+
+// OrgCostBudgetReservation holds an estimated cost against a budget while an execution is running.
+// it has to be settled with the actual cost or released; expired reservations are released automatically.
+type OrgCostBudgetReservation struct {
+	ID            string               `json:"id"`
+	OrgID         string               `json:"org_id"`
+	Scopes        []OrgCostBudgetScope `json:"scopes"` // sub-budgets the amount is reserved on as well
+	Amount        Money                `json:"amount"`
+	Reference     string               `json:"reference"`      // e.g. the mission or run id
+	ExceedsBudget bool                 `json:"exceeds_budget"` // granted by a soft limit beyond the remaining budget
+	CreatedAt     int64                `json:"created_at"`
+	ExpiresAt     int64                `json:"expires_at"`
+} //@name OrgCostBudgetReservation
+
+// OrgCostBudgetLedger books costs against org budgets atomically, also across replicas.
+// all amounts are in the currency of the budget, see ConvertMoney.
+// scopes name sub-budgets (team, agent, user, api key) that are booked together with the org budget, scopes without a sub-budget are skipped.
+// sub-budgets with a ParentScope are booked on their team budget as well.
+type OrgCostBudgetLedger interface {
+	GetBudget(ctx context.Context, orgID string) (budget *OrgCostBudget, err error)
+	GetScopedBudget(ctx context.Context, orgID string, scope OrgCostBudgetScope) (budget *OrgCostBudget, err error)
+	// ListBudgets returns the org budget and all its sub-budgets
+	ListBudgets(ctx context.Context, orgID string) (budgets []*OrgCostBudget, err error)
+	// SetBudget stores the settings and used budget of an org or sub-budget, open reservations are kept
+	SetBudget(ctx context.Context, budget *OrgCostBudget) (err error)
+	// Check tells if amount could be reserved right now
+	Check(ctx context.Context, orgID string, amount Money, scopes ...OrgCostBudgetScope) (check OrgCostBudgetCheck, err error)
+	// Reserve holds amount until it is settled or released or ttl passed. hard limits return ErrInsufficientBudget.
+	Reserve(ctx context.Context, orgID string, amount Money, reference string, ttl time.Duration, scopes ...OrgCostBudgetScope) (reservation *OrgCostBudgetReservation, err error)
+	// Settle replaces the reservation with the actual (rounded) cost
+	Settle(ctx context.Context, reservation *OrgCostBudgetReservation, actualCost Money) (budget *OrgCostBudget, err error)
+	// Release drops the reservation without booking any cost, e.g. when the execution failed before calling a model
+	Release(ctx context.Context, reservation *OrgCostBudgetReservation) (budget *OrgCostBudget, err error)
+	// Charge books a cost without reservation
+	Charge(ctx context.Context, orgID string, cost Money, scopes ...OrgCostBudgetScope) (budget *OrgCostBudget, err error)
+	GetStatusReport(ctx context.Context, orgID string) (report *OrgCostBudgetStatusReport, err error)
+}
+
+func CreateBudgetReservationID() string {
+	return nuts.NID(IDPREFIX_BUDGETRESERVATION, IDLENGTH_BUDGETRESERVATION)
+}
+
+func IsBudgetReservationID(id string) (isReservationID bool) {
+	isReservationID = (len(id) == IDLENGTH_BUDGETRESERVATION+len(IDPREFIX_BUDGETRESERVATION)+1) && strings.HasPrefix(id, IDPREFIX_BUDGETRESERVATION)
+	return isReservationID
+}
+
+func NewOrgCostBudgetReservation(orgID string, amount Money, reference string, ttl time.Duration) *OrgCostBudgetReservation {
+	if ttl <= 0 {
+		ttl = ORGCOSTBUDGET_RESERVATION_DEFAULT_TTL
+	}
+	now := time.Now()
+	return &OrgCostBudgetReservation{
+		ID:        CreateBudgetReservationID(),
+		OrgID:     orgID,
+		Scopes:    make([]OrgCostBudgetScope, 0),
+		Amount:    amount,
+		Reference: reference,
+		CreatedAt: nuts.TimeToJSTimestamp(now),
+		ExpiresAt: nuts.TimeToJSTimestamp(now.Add(ttl)),
+	}
+}
+
+// NewOrgCostBudgetCheck tells if amount fits into all budgets, budgets with soft limits always pass
+func NewOrgCostBudgetCheck(amount Money, budgets ...*OrgCostBudget) (check OrgCostBudgetCheck) {
+	check.SufficientBudget = true
+	for _, budget := range budgets {
+		if budget.IsOrgScope() {
+			check.OrgID = budget.OrgID
+		}
+		if budget.IsHardLimit() && amount > budget.RemainingBudget {
+			check.SufficientBudget = false
+		}
+	}
+	return check
+}
+
+// checkReachedThresholds returns an alert for every threshold the budget reached above the alerted one.
+// markAlerted has to atomically store the threshold and return the threshold alerted before if this call did it,
+// so alerts are sent once across replicas.
+func checkReachedThresholds(budget *OrgCostBudget, markAlerted func(threshold int) (alerted int, marked bool)) (alerts []OrgCostBudgetAlert) {
+	threshold := budget.GetReachedThreshold()
+	if threshold <= budget.AlertedThreshold {
+		return nil
+	}
+	alerted, marked := markAlerted(threshold)
+	if !marked {
+		return nil
+	}
+	budget.AlertedThreshold = threshold
+	for _, reached := range budget.GetReachedThresholdsAbove(alerted) {
+		alerts = append(alerts, budget.NewAlert(reached))
+	}
+	return alerts
+}
+
+// emitOrgCostBudgetAlerts is deferred by the ledgers, so listeners run after the budgets were unlocked
+func emitOrgCostBudgetAlerts(alerts *[]OrgCostBudgetAlert) {
+	for _, alert := range *alerts {
+		EmitOrgCostBudgetAlert(alert)
+	}
+}
+
+// -- in-memory ledger, for tests and single instance setups --
+
+type MemoryOrgCostBudgetLedger struct {
+	budgets      map[string]map[string]*OrgCostBudget // org id -> scope key -> budget
+	reservations map[string]*OrgCostBudgetReservation
+	safety       sync.Mutex
+}
+
+func NewMemoryOrgCostBudgetLedger() *MemoryOrgCostBudgetLedger {
+	return &MemoryOrgCostBudgetLedger{
+		budgets:      make(map[string]map[string]*OrgCostBudget),
+		reservations: make(map[string]*OrgCostBudgetReservation),
+	}
+}
+
+func (ledger *MemoryOrgCostBudgetLedger) GetBudget(ctx context.Context, orgID string) (budget *OrgCostBudget, err error) {
+	return ledger.GetScopedBudget(ctx, orgID, OrgCostBudgetScope{Type: OrgCostBudgetScopeTypeOrg})
+}
+
+func (ledger *MemoryOrgCostBudgetLedger) GetScopedBudget(ctx context.Context, orgID string, scope OrgCostBudgetScope) (budget *OrgCostBudget, err error) {
+	ledger.safety.Lock()
+	defer ledger.safety.Unlock()
+	ledger.releaseExpiredReservations()
+	stored := ledger.getStoredBudget(orgID, scope)
+	if stored == nil {
+		return nil, fmt.Errorf("%w: %s(%s)", ErrOrgCostBudgetNotFound, orgID, scope.Key())
+	}
+	budgetCopy := *stored
+	return &budgetCopy, nil
+}
+
+func (ledger *MemoryOrgCostBudgetLedger) ListBudgets(ctx context.Context, orgID string) (budgets []*OrgCostBudget, err error) {
+	ledger.safety.Lock()
+	defer ledger.safety.Unlock()
+	ledger.releaseExpiredReservations()
+	budgets = make([]*OrgCostBudget, 0)
+	for _, stored := range ledger.budgets[orgID] {
+		stored.ResetPeriodIfDue(time.Now())
+		budgetCopy := *stored
+		budgets = append(budgets, &budgetCopy)
+	}
+	return budgets, nil
+}
+
+func (ledger *MemoryOrgCostBudgetLedger) SetBudget(ctx context.Context, budget *OrgCostBudget) (err error) {
+	err = ValidateOrgCostBudget(budget)
+	if err != nil {
+		return err
+	}
+	ledger.safety.Lock()
+	defer ledger.safety.Unlock()
+	stored := *budget
+	stored.ReservedBudget = 0
+	if existing := ledger.getStoredBudget(budget.OrgID, budget.GetScope()); existing != nil {
+		stored.ReservedBudget = existing.ReservedBudget
+	}
+	if stored.HasPeriod() && stored.PeriodEnd == 0 {
+		stored.StartPeriod(time.Now())
+	}
+	stored.UpdateRemainingBudget()
+	if ledger.budgets[budget.OrgID] == nil {
+		ledger.budgets[budget.OrgID] = make(map[string]*OrgCostBudget)
+	}
+	ledger.budgets[budget.OrgID][budget.GetScope().Key()] = &stored
+	return nil
+}
+
+func (ledger *MemoryOrgCostBudgetLedger) Check(ctx context.Context, orgID string, amount Money, scopes ...OrgCostBudgetScope) (check OrgCostBudgetCheck, err error) {
+	ledger.safety.Lock()
+	defer ledger.safety.Unlock()
+	ledger.releaseExpiredReservations()
+	budgets, err := ledger.getScopedBudgets(orgID, ledger.withParentScopes(orgID, scopes))
+	if err != nil {
+		return OrgCostBudgetCheck{OrgID: orgID}, err
+	}
+	return NewOrgCostBudgetCheck(amount, budgets...), nil
+}
+
+func (ledger *MemoryOrgCostBudgetLedger) Reserve(ctx context.Context, orgID string, amount Money, reference string, ttl time.Duration, scopes ...OrgCostBudgetScope) (reservation *OrgCostBudgetReservation, err error) {
+	if amount < 0 {
+		return nil, ErrInvalidBudgetAmount
+	}
+	ledger.safety.Lock()
+	defer ledger.safety.Unlock()
+	ledger.releaseExpiredReservations()
+	budgets, err := ledger.getScopedBudgets(orgID, ledger.withParentScopes(orgID, scopes))
+	if err != nil {
+		return nil, err
+	}
+	reservation = NewOrgCostBudgetReservation(orgID, amount, reference, ttl)
+	for _, budget := range budgets {
+		if amount <= budget.RemainingBudget {
+			continue
+		}
+		if budget.IsHardLimit() {
+			return nil, fmt.Errorf("%w: budget(%s) of org(%s) needs (%s) but has (%s) left", ErrInsufficientBudget, budget.GetScope().Key(), orgID, amount, budget.RemainingBudget)
+		}
+		reservation.ExceedsBudget = true
+	}
+	for _, budget := range budgets {
+		budget.ReservedBudget += amount
+		budget.UpdateRemainingBudget()
+		if !budget.IsOrgScope() {
+			reservation.Scopes = append(reservation.Scopes, budget.GetScope())
+		}
+	}
+	ledger.reservations[reservation.ID] = reservation
+	reservationCopy := *reservation
+	return &reservationCopy, nil
+}
+
+func (ledger *MemoryOrgCostBudgetLedger) Settle(ctx context.Context, reservation *OrgCostBudgetReservation, actualCost Money) (budget *OrgCostBudget, err error) {
+	if actualCost < 0 {
+		return nil, ErrInvalidBudgetAmount
+	}
+	return ledger.closeReservation(reservation, &actualCost)
+}
+
+func (ledger *MemoryOrgCostBudgetLedger) Release(ctx context.Context, reservation *OrgCostBudgetReservation) (budget *OrgCostBudget, err error) {
+	return ledger.closeReservation(reservation, nil)
+}
+
+func (ledger *MemoryOrgCostBudgetLedger) Charge(ctx context.Context, orgID string, cost Money, scopes ...OrgCostBudgetScope) (budget *OrgCostBudget, err error) {
+	if cost < 0 {
+		return nil, ErrInvalidBudgetAmount
+	}
+	alerts := make([]OrgCostBudgetAlert, 0)
+	defer emitOrgCostBudgetAlerts(&alerts)
+	ledger.safety.Lock()
+	defer ledger.safety.Unlock()
+	ledger.releaseExpiredReservations()
+	budgets, err := ledger.getScopedBudgets(orgID, ledger.withParentScopes(orgID, scopes))
+	if err != nil {
+		return nil, err
+	}
+	for _, stored := range budgets {
+		alerts = ledger.addUsedCost(stored, cost, alerts)
+	}
+	budgetCopy := *budgets[0]
+	return &budgetCopy, nil
+}
+
+func (ledger *MemoryOrgCostBudgetLedger) GetStatusReport(ctx context.Context, orgID string) (report *OrgCostBudgetStatusReport, err error) {
+	budgets, err := ledger.ListBudgets(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if len(budgets) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrOrgCostBudgetNotFound, orgID)
+	}
+	return NewOrgCostBudgetStatusReport(orgID, budgets), nil
+}
+
+func (ledger *MemoryOrgCostBudgetLedger) closeReservation(reservation *OrgCostBudgetReservation, actualCost *Money) (budget *OrgCostBudget, err error) {
+	if reservation == nil || !IsBudgetReservationID(reservation.ID) {
+		return nil, ErrInvalidBudgetReservationID
+	}
+	alerts := make([]OrgCostBudgetAlert, 0)
+	defer emitOrgCostBudgetAlerts(&alerts)
+	ledger.safety.Lock()
+	defer ledger.safety.Unlock()
+	ledger.releaseExpiredReservations()
+	stored, found := ledger.reservations[reservation.ID]
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrBudgetReservationNotFound, reservation.ID)
+	}
+	delete(ledger.reservations, reservation.ID)
+	budgets, err := ledger.getScopedBudgets(stored.OrgID, stored.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	for _, storedBudget := range budgets {
+		storedBudget.ReservedBudget -= stored.Amount
+		storedBudget.UpdateRemainingBudget()
+		if actualCost != nil {
+			alerts = ledger.addUsedCost(storedBudget, *actualCost, alerts)
+		}
+	}
+	budgetCopy := *budgets[0]
+	return &budgetCopy, nil
+}
+
+// addUsedCost must be called with the lock held, which also makes marking alerts atomic
+func (ledger *MemoryOrgCostBudgetLedger) addUsedCost(budget *OrgCostBudget, cost Money, alerts []OrgCostBudgetAlert) []OrgCostBudgetAlert {
+	budget.AddUsedCost(cost)
+	return append(alerts, checkReachedThresholds(budget, func(threshold int) (alerted int, marked bool) {
+		return budget.AlertedThreshold, true
+	})...)
+}
+
+// withParentScopes adds the team budgets the sub-budgets of scopes roll up into, must be called with the lock held
+func (ledger *MemoryOrgCostBudgetLedger) withParentScopes(orgID string, scopes []OrgCostBudgetScope) []OrgCostBudgetScope {
+	return withParentScopes(scopes, func(scope OrgCostBudgetScope) *OrgCostBudgetScope {
+		if budget := ledger.getStoredBudget(orgID, scope); budget != nil {
+			return budget.ParentScope
+		}
+		return nil
+	})
+}
+
+// getScopedBudgets returns the org budget first, followed by the existing sub-budgets of scopes, without their parents.
+// must be called with the lock held
+func (ledger *MemoryOrgCostBudgetLedger) getScopedBudgets(orgID string, scopes []OrgCostBudgetScope) (budgets []*OrgCostBudget, err error) {
+	orgBudget := ledger.getStoredBudget(orgID, OrgCostBudgetScope{Type: OrgCostBudgetScopeTypeOrg})
+	if orgBudget == nil {
+		return nil, fmt.Errorf("%w: %s", ErrOrgCostBudgetNotFound, orgID)
+	}
+	budgets = []*OrgCostBudget{orgBudget}
+	for _, scope := range uniqueSubBudgetScopes(scopes) {
+		if scoped := ledger.getStoredBudget(orgID, scope); scoped != nil {
+			budgets = append(budgets, scoped)
+		}
+	}
+	return budgets, nil
+}
+
+// getStoredBudget resets the period of the budget if due, must be called with the lock held
+func (ledger *MemoryOrgCostBudgetLedger) getStoredBudget(orgID string, scope OrgCostBudgetScope) *OrgCostBudget {
+	budget := ledger.budgets[orgID][scope.Key()]
+	if budget != nil {
+		budget.ResetPeriodIfDue(time.Now())
+	}
+	return budget
+}
+
+// releaseExpiredReservations must be called with the lock held
+func (ledger *MemoryOrgCostBudgetLedger) releaseExpiredReservations() {
+	now := nuts.TimeToJSTimestamp(time.Now())
+	for id, reservation := range ledger.reservations {
+		if reservation.ExpiresAt > now {
+			continue
+		}
+		nuts.L.Infof("[MemoryOrgCostBudgetLedger.releaseExpiredReservations] reservation(%s) of org(%s) for (%s) expired", id, reservation.OrgID, reservation.Reference)
+		delete(ledger.reservations, id)
+		scopes := append([]OrgCostBudgetScope{{Type: OrgCostBudgetScopeTypeOrg}}, reservation.Scopes...)
+		for _, scope := range scopes {
+			if budget := ledger.budgets[reservation.OrgID][scope.Key()]; budget != nil {
+				budget.ReservedBudget -= reservation.Amount
+				budget.UpdateRemainingBudget()
+			}
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	REDIS_ORGCOSTBUDGET_KEY_PREFIX = "orgcostbudget:"
+)
+
+// all scripts get KEYS = reservations hash (id -> "amount budgetkey..."), expiries zset (id -> expires at in ms), budget hashes...
+// and ARGV = now in ms, start/end of the current daily, weekly, monthly and yearly period, script arguments...
+// they release expired reservations first and reset due periods. amounts are nano euro integers, so HINCRBY keeps them exact.
+const redisBudgetLuaCommon = `
+local now = tonumber(ARGV[1])
+local bounds = {daily = {ARGV[2], ARGV[3]}, weekly = {ARGV[4], ARGV[5]}, monthly = {ARGV[6], ARGV[7]}, yearly = {ARGV[8], ARGV[9]}}
+local function resetPeriod(key)
+	local values = redis.call('HMGET', key, 'period', 'period_end')
+	local periodBounds = bounds[values[1] or '']
+	if not periodBounds then return end
+	local periodEnd = tonumber(values[2]) or 0
+	if periodEnd > now then return end
+	if periodEnd > 0 then redis.call('HSET', key, 'used', 0, 'alerted_threshold', 0) end
+	redis.call('HSET', key, 'period_start', periodBounds[1], 'period_end', periodBounds[2])
+end
+local function release(entry)
+	local amount = nil
+	for word in string.gmatch(entry, '%S+') do
+		if amount == nil then amount = tonumber(word) else redis.call('HINCRBY', word, 'reserved', -amount) end
+	end
+end
+local function sweep()
+	local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', now)
+	for _, id in ipairs(expired) do
+		local entry = redis.call('HGET', KEYS[1], id)
+		if entry then
+			release(entry)
+			redis.call('HDEL', KEYS[1], id)
+		end
+		redis.call('ZREM', KEYS[2], id)
+	end
+end
+local function remaining(key)
+	local values = redis.call('HMGET', key, 'total', 'used', 'reserved')
+	return (tonumber(values[1]) or 0) - (tonumber(values[2]) or 0) - (tonumber(values[3]) or 0)
+end
+sweep()
+`
+
+// KEYS[3] = budget
+var redisBudgetGetScript = redis.NewScript(redisBudgetLuaCommon + `
+if redis.call('EXISTS', KEYS[3]) == 0 then return {} end
+resetPeriod(KEYS[3])
+return redis.call('HGETALL', KEYS[3])
+`)
+
+// KEYS[3] = org budget, KEYS[4...] = sub-budgets. ARGV[10] = reservation id, ARGV[11] = amount, ARGV[12] = expires at.
+// returns {status, exceeds budget or failing budget index, indexes of the reserved budgets...}, status -1 = no org budget, 0 = insufficient
+var redisBudgetReserveScript = redis.NewScript(redisBudgetLuaCommon + `
+if redis.call('EXISTS', KEYS[3]) == 0 then return {-1, 0} end
+local amount = tonumber(ARGV[11])
+local result = {1, 0}
+local entry = ARGV[11]
+for n = 3, #KEYS do
+	if redis.call('EXISTS', KEYS[n]) == 1 then
+		resetPeriod(KEYS[n])
+		if amount > remaining(KEYS[n]) then
+			if redis.call('HGET', KEYS[n], 'limit_mode') ~= 'soft' then return {0, n - 3} end
+			result[2] = 1
+		end
+		table.insert(result, n - 3)
+		entry = entry .. ' ' .. KEYS[n]
+	end
+end
+for n = 3, #result do redis.call('HINCRBY', KEYS[result[n] + 3], 'reserved', amount) end
+redis.call('HSET', KEYS[1], ARGV[10], entry)
+redis.call('ZADD', KEYS[2], ARGV[12], ARGV[10])
+return result
+`)
+
+// ARGV[10] = reservation id, ARGV[11] = actual cost or -1 to release, ARGV[12...] = pairs of budget key and its rounded actual cost.
+// returns the reserved budget keys, empty if the reservation is gone
+var redisBudgetCloseScript = redis.NewScript(redisBudgetLuaCommon + `
+local entry = redis.call('HGET', KEYS[1], ARGV[10])
+if not entry then return {} end
+local actuals = {}
+for n = 12, #ARGV - 1, 2 do actuals[ARGV[n]] = ARGV[n + 1] end
+release(entry)
+redis.call('HDEL', KEYS[1], ARGV[10])
+redis.call('ZREM', KEYS[2], ARGV[10])
+local actual = tonumber(ARGV[11])
+local budgetKeys = {}
+local first = true
+for word in string.gmatch(entry, '%S+') do
+	if not first then
+		resetPeriod(word)
+		if actual >= 0 then
+			redis.call('HINCRBY', word, 'used', actuals[word] or actual)
+			redis.call('HSET', word, 'updated_at', ARGV[1])
+		end
+		table.insert(budgetKeys, word)
+	end
+	first = false
+end
+return budgetKeys
+`)
+
+// KEYS[3] = org budget, KEYS[4...] = sub-budgets. ARGV[10...] = cost of each budget key, rounded by the budget. returns the charged budget keys, empty if there is no org budget
+var redisBudgetChargeScript = redis.NewScript(redisBudgetLuaCommon + `
+if redis.call('EXISTS', KEYS[3]) == 0 then return {} end
+local budgetKeys = {}
+for n = 3, #KEYS do
+	if redis.call('EXISTS', KEYS[n]) == 1 then
+		resetPeriod(KEYS[n])
+		redis.call('HINCRBY', KEYS[n], 'used', ARGV[n + 7])
+		redis.call('HSET', KEYS[n], 'updated_at', ARGV[1])
+		table.insert(budgetKeys, KEYS[n])
+	end
+end
+return budgetKeys
+`)
+
+// KEYS[1] = budget, ARGV[1] = threshold. returns the threshold alerted before, -1 if the threshold was already alerted
+var redisBudgetMarkAlertedScript = redis.NewScript(`
+local alerted = tonumber(redis.call('HGET', KEYS[1], 'alerted_threshold')) or 0
+if alerted >= tonumber(ARGV[1]) then return -1 end
+redis.call('HSET', KEYS[1], 'alerted_threshold', ARGV[1])
+return alerted
+`)
+
+// RedisOrgCostBudgetLedger keeps budgets in redis and changes them with lua scripts, so reservations are atomic across replicas.
+// all keys of an org share a hash tag, so they are in the same cluster slot.
+type RedisOrgCostBudgetLedger struct {
+	client redis.UniversalClient
+}
+
+func NewRedisOrgCostBudgetLedger(client redis.UniversalClient) *RedisOrgCostBudgetLedger {
+	return &RedisOrgCostBudgetLedger{
+		client: client,
+	}
+}
+
+func (ledger *RedisOrgCostBudgetLedger) orgKey(orgID string) string {
+	return REDIS_ORGCOSTBUDGET_KEY_PREFIX + "{" + orgID + "}"
+}
+
+func (ledger *RedisOrgCostBudgetLedger) budgetKey(orgID string, scope OrgCostBudgetScope) string {
+	if scope.Key() == string(OrgCostBudgetScopeTypeOrg) {
+		return ledger.orgKey(orgID)
+	}
+	return ledger.orgKey(orgID) + ":scope:" + scope.Key()
+}
+
+// keys returns the reservation keys followed by the budget keys of the org and the scopes, org and repeated scopes are skipped
+func (ledger *RedisOrgCostBudgetLedger) keys(orgID string, scopes ...OrgCostBudgetScope) []string {
+	keys := []string{ledger.orgKey(orgID) + ":reservations", ledger.orgKey(orgID) + ":expiries", ledger.orgKey(orgID)}
+	for _, scope := range uniqueSubBudgetScopes(scopes) {
+		keys = append(keys, ledger.budgetKey(orgID, scope))
+	}
+	return keys
+}
+
+// args returns the common script arguments followed by the script specific ones
+func (ledger *RedisOrgCostBudgetLedger) args(scriptArgs ...any) []any {
+	now := time.Now()
+	args := []any{now.UnixMilli()}
+	for _, period := range OrgCostBudgetPeriods {
+		start, end := GetBudgetPeriodBounds(period, now)
+		args = append(args, start.UnixMilli(), end.UnixMilli())
+	}
+	return append(args, scriptArgs...)
+}
+
+func (ledger *RedisOrgCostBudgetLedger) GetBudget(ctx context.Context, orgID string) (budget *OrgCostBudget, err error) {
+	return ledger.GetScopedBudget(ctx, orgID, OrgCostBudgetScope{Type: OrgCostBudgetScopeTypeOrg})
+}
+
+func (ledger *RedisOrgCostBudgetLedger) GetScopedBudget(ctx context.Context, orgID string, scope OrgCostBudgetScope) (budget *OrgCostBudget, err error) {
+	keys := ledger.keys(orgID)
+	keys[2] = ledger.budgetKey(orgID, scope)
+	values, err := redisBudgetGetScript.Run(ctx, ledger.client, keys, ledger.args()...).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%w: %s(%s)", ErrOrgCostBudgetNotFound, orgID, scope.Key())
+	}
+	fields := make(map[string]string)
+	for n := 0; n+1 < len(values); n += 2 {
+		fields[values[n]] = values[n+1]
+	}
+	budget = NewOrgCostBudget(orgID)
+	budget.ScopeType = OrgCostBudgetScopeType(fields["scope_type"])
+	budget.ScopeID = fields["scope_id"]
+	budget.TotalBudget = Money(parseRedisInt64(fields["total"], 0))
+	budget.UsedBudget = Money(parseRedisInt64(fields["used"], 0))
+	budget.ReservedBudget = Money(parseRedisInt64(fields["reserved"], 0))
+	budget.LimitMode = OrgCostBudgetLimitMode(fields["limit_mode"])
+	budget.Currency = Currency(fields["currency"])
+	budget.Period = OrgCostBudgetPeriod(fields["period"])
+	budget.PeriodStart = parseRedisInt64(fields["period_start"], 0)
+	budget.PeriodEnd = parseRedisInt64(fields["period_end"], 0)
+	budget.AlertedThreshold = int(parseRedisInt64(fields["alerted_threshold"], 0))
+	budget.UpdatedAt = parseRedisInt64(fields["updated_at"], budget.UpdatedAt)
+	budget.UpdatedBy = fields["updated_by"]
+	if fields["parent_scope"] != "" {
+		parentScope := parseOrgCostBudgetScopeKey(fields["parent_scope"])
+		budget.ParentScope = &parentScope
+	}
+	if fields["rounding"] != "" {
+		rounding := NewMoneyRounding()
+		if err := json.Unmarshal([]byte(fields["rounding"]), &rounding); err == nil {
+			budget.Rounding = &rounding
+		}
+	}
+	if fields["alert_thresholds"] != "" {
+		_ = json.Unmarshal([]byte(fields["alert_thresholds"]), &budget.AlertThresholds)
+	}
+	budget.UpdateRemainingBudget()
+	return budget, nil
+}
+
+func (ledger *RedisOrgCostBudgetLedger) ListBudgets(ctx context.Context, orgID string) (budgets []*OrgCostBudget, err error) {
+	budgets = make([]*OrgCostBudget, 0)
+	budget, err := ledger.GetBudget(ctx, orgID)
+	if err != nil {
+		return budgets, err
+	}
+	budgets = append(budgets, budget)
+	scopeKeys, err := ledger.client.SMembers(ctx, ledger.orgKey(orgID)+":scopes").Result()
+	if err != nil {
+		return budgets, err
+	}
+	for _, scopeKey := range scopeKeys {
+		scoped, err := ledger.GetScopedBudget(ctx, orgID, parseOrgCostBudgetScopeKey(scopeKey))
+		if err != nil {
+			continue
+		}
+		budgets = append(budgets, scoped)
+	}
+	return budgets, nil
+}
+
+func (ledger *RedisOrgCostBudgetLedger) SetBudget(ctx context.Context, budget *OrgCostBudget) (err error) {
+	err = ValidateOrgCostBudget(budget)
+	if err != nil {
+		return err
+	}
+	rounding := ""
+	if budget.Rounding != nil {
+		roundingJson, _ := json.Marshal(budget.Rounding)
+		rounding = string(roundingJson)
+	}
+	alertThresholds := ""
+	if len(budget.AlertThresholds) > 0 {
+		alertThresholdsJson, _ := json.Marshal(budget.AlertThresholds)
+		alertThresholds = string(alertThresholdsJson)
+	}
+	parentScope := ""
+	if budget.ParentScope != nil {
+		parentScope = budget.ParentScope.Key()
+	}
+	scope := budget.GetScope()
+	// reserved, the period bounds and alerted_threshold are owned by the scripts and never overwritten here
+	_, err = ledger.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, ledger.budgetKey(budget.OrgID, scope), map[string]any{
+			"scope_type":       string(budget.ScopeType),
+			"scope_id":         budget.ScopeID,
+			"total":            int64(budget.TotalBudget),
+			"used":             int64(budget.UsedBudget),
+			"limit_mode":       string(budget.LimitMode),
+			"currency":         string(budget.Currency),
+			"rounding":         rounding,
+			"period":           string(budget.Period),
+			"alert_thresholds": alertThresholds,
+			"parent_scope":     parentScope,
+			"updated_at":       budget.UpdatedAt,
+			"updated_by":       budget.UpdatedBy,
+		})
+		if !budget.IsOrgScope() {
+			pipe.SAdd(ctx, ledger.orgKey(budget.OrgID)+":scopes", scope.Key())
+		}
+		return nil
+	})
+	return err
+}
+
+func (ledger *RedisOrgCostBudgetLedger) Check(ctx context.Context, orgID string, amount Money, scopes ...OrgCostBudgetScope) (check OrgCostBudgetCheck, err error) {
+	budget, err := ledger.GetBudget(ctx, orgID)
+	if err != nil {
+		return OrgCostBudgetCheck{OrgID: orgID}, err
+	}
+	budgets := []*OrgCostBudget{budget}
+	for _, scope := range ledger.withParentScopes(ctx, orgID, scopes) {
+		if scoped, err := ledger.GetScopedBudget(ctx, orgID, scope); err == nil {
+			budgets = append(budgets, scoped)
+		}
+	}
+	return NewOrgCostBudgetCheck(amount, budgets...), nil
+}
+
+func (ledger *RedisOrgCostBudgetLedger) Reserve(ctx context.Context, orgID string, amount Money, reference string, ttl time.Duration, scopes ...OrgCostBudgetScope) (reservation *OrgCostBudgetReservation, err error) {
+	if amount < 0 {
+		return nil, ErrInvalidBudgetAmount
+	}
+	// the script returns indexes into the budget keys, which only hold the unique scopes
+	scopes = ledger.withParentScopes(ctx, orgID, scopes)
+	reservation = NewOrgCostBudgetReservation(orgID, amount, reference, ttl)
+	result, err := redisBudgetReserveScript.Run(ctx, ledger.client, ledger.keys(orgID, scopes...), ledger.args(reservation.ID, int64(amount), reservation.ExpiresAt)...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	switch result[0] {
+	case -1:
+		return nil, fmt.Errorf("%w: %s", ErrOrgCostBudgetNotFound, orgID)
+	case 0:
+		budgetName := string(OrgCostBudgetScopeTypeOrg)
+		if result[1] > 0 {
+			budgetName = scopes[result[1]-1].Key()
+		}
+		return nil, fmt.Errorf("%w: budget(%s) of org(%s) needs (%s)", ErrInsufficientBudget, budgetName, orgID, amount)
+	}
+	reservation.ExceedsBudget = result[1] == 1
+	for _, index := range result[2:] {
+		if index > 0 {
+			reservation.Scopes = append(reservation.Scopes, scopes[index-1])
+		}
+	}
+	return reservation, nil
+}
+
+func (ledger *RedisOrgCostBudgetLedger) Settle(ctx context.Context, reservation *OrgCostBudgetReservation, actualCost Money) (budget *OrgCostBudget, err error) {
+	if actualCost < 0 {
+		return nil, ErrInvalidBudgetAmount
+	}
+	if reservation == nil || !IsBudgetReservationID(reservation.ID) {
+		return nil, ErrInvalidBudgetReservationID
+	}
+	costs, err := ledger.roundCost(ctx, reservation.OrgID, actualCost, reservation.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	return ledger.closeReservation(ctx, reservation, actualCost, costs)
+}
+
+func (ledger *RedisOrgCostBudgetLedger) Release(ctx context.Context, reservation *OrgCostBudgetReservation) (budget *OrgCostBudget, err error) {
+	if reservation == nil || !IsBudgetReservationID(reservation.ID) {
+		return nil, ErrInvalidBudgetReservationID
+	}
+	return ledger.closeReservation(ctx, reservation, -1, nil)
+}
+
+func (ledger *RedisOrgCostBudgetLedger) Charge(ctx context.Context, orgID string, cost Money, scopes ...OrgCostBudgetScope) (budget *OrgCostBudget, err error) {
+	if cost < 0 {
+		return nil, ErrInvalidBudgetAmount
+	}
+	scopes = ledger.withParentScopes(ctx, orgID, scopes)
+	costs, err := ledger.roundCost(ctx, orgID, cost, scopes)
+	if err != nil {
+		return nil, err
+	}
+	keys := ledger.keys(orgID, scopes...)
+	scriptArgs := make([]any, 0, len(keys))
+	for _, key := range keys[2:] {
+		scriptArgs = append(scriptArgs, int64(costs[key]))
+	}
+	chargedKeys, err := redisBudgetChargeScript.Run(ctx, ledger.client, keys, ledger.args(scriptArgs...)...).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	if len(chargedKeys) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrOrgCostBudgetNotFound, orgID)
+	}
+	ledger.alertReachedThresholds(ctx, orgID, scopes)
+	return ledger.GetBudget(ctx, orgID)
+}
+
+func (ledger *RedisOrgCostBudgetLedger) GetStatusReport(ctx context.Context, orgID string) (report *OrgCostBudgetStatusReport, err error) {
+	budgets, err := ledger.ListBudgets(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return NewOrgCostBudgetStatusReport(orgID, budgets), nil
+}
+
+// roundCost rounds cost with the rounding of each budget, like OrgCostBudget.AddUsedCost does, keyed by budget key.
+// sub-budgets that do not exist are left out.
+func (ledger *RedisOrgCostBudgetLedger) roundCost(ctx context.Context, orgID string, cost Money, scopes []OrgCostBudgetScope) (costs map[string]Money, err error) {
+	budget, err := ledger.GetBudget(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	costs = map[string]Money{ledger.orgKey(orgID): budget.RoundAmount(cost)}
+	for _, scope := range uniqueSubBudgetScopes(scopes) {
+		if scoped, err := ledger.GetScopedBudget(ctx, orgID, scope); err == nil {
+			costs[ledger.budgetKey(orgID, scope)] = scoped.RoundAmount(cost)
+		}
+	}
+	return costs, nil
+}
+
+// withParentScopes adds the team budgets the sub-budgets of scopes roll up into.
+// the parents are read before the scripts run, a parent changed in between applies from the next booking on.
+func (ledger *RedisOrgCostBudgetLedger) withParentScopes(ctx context.Context, orgID string, scopes []OrgCostBudgetScope) []OrgCostBudgetScope {
+	return withParentScopes(scopes, func(scope OrgCostBudgetScope) *OrgCostBudgetScope {
+		if budget, err := ledger.GetScopedBudget(ctx, orgID, scope); err == nil {
+			return budget.ParentScope
+		}
+		return nil
+	})
+}
+
+// closeReservation settles the reservation with actualCost rounded per budget by costs, or releases it if actualCost is negative
+func (ledger *RedisOrgCostBudgetLedger) closeReservation(ctx context.Context, reservation *OrgCostBudgetReservation, actualCost Money, costs map[string]Money) (budget *OrgCostBudget, err error) {
+	scriptArgs := []any{reservation.ID, strconv.FormatInt(int64(actualCost), 10)}
+	for key, cost := range costs {
+		scriptArgs = append(scriptArgs, key, int64(cost))
+	}
+	budgetKeys, err := redisBudgetCloseScript.Run(ctx, ledger.client, ledger.keys(reservation.OrgID), ledger.args(scriptArgs...)...).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	if len(budgetKeys) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrBudgetReservationNotFound, reservation.ID)
+	}
+	if actualCost >= 0 {
+		ledger.alertReachedThresholds(ctx, reservation.OrgID, reservation.Scopes)
+	}
+	return ledger.GetBudget(ctx, reservation.OrgID)
+}
+
+// alertReachedThresholds emits alerts for the org budget and the sub-budgets that reached a new threshold.
+// the threshold is marked in redis first, so every alert is sent by one replica only.
+func (ledger *RedisOrgCostBudgetLedger) alertReachedThresholds(ctx context.Context, orgID string, scopes []OrgCostBudgetScope) {
+	alerts := make([]OrgCostBudgetAlert, 0)
+	defer emitOrgCostBudgetAlerts(&alerts)
+	for _, scope := range append([]OrgCostBudgetScope{{Type: OrgCostBudgetScopeTypeOrg}}, uniqueSubBudgetScopes(scopes)...) {
+		budget, err := ledger.GetScopedBudget(ctx, orgID, scope)
+		if err != nil {
+			continue
+		}
+		budgetKey := ledger.budgetKey(orgID, scope)
+		alerts = append(alerts, checkReachedThresholds(budget, func(threshold int) (alerted int, marked bool) {
+			previous, err := redisBudgetMarkAlertedScript.Run(ctx, ledger.client, []string{budgetKey}, threshold).Int64()
+			return int(previous), err == nil && previous >= 0
+		})...)
+	}
+}
+
+func parseRedisInt64(value string, fallbackVal int64) int64 {
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fallbackVal
+	}
+	return parsed
+}
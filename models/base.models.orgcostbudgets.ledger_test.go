@@ -0,0 +1,171 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func newTestOrgCostBudget(t *testing.T, orgID string, scope OrgCostBudgetScope, total string, rounding *MoneyRounding) *OrgCostBudget {
+	t.Helper()
+	budget := NewScopedOrgCostBudget(orgID, scope)
+	budget.TotalBudget = mustParseMoney(t, total)
+	budget.Rounding = rounding
+	return budget
+}
+
+func TestRedisOrgCostBudgetLedgerKeysSkipRepeatedBudgets(t *testing.T) {
+	ledger := NewRedisOrgCostBudgetLedger(nil)
+	user := NewOrgCostBudgetScope(OrgCostBudgetScopeTypeUser, "u1")
+	team := NewOrgCostBudgetScope(OrgCostBudgetScopeTypeTeam, "t1")
+
+	keys := ledger.keys("org1", OrgCostBudgetScope{Type: OrgCostBudgetScopeTypeOrg}, user, team, user)
+	want := []string{
+		"orgcostbudget:{org1}:reservations",
+		"orgcostbudget:{org1}:expiries",
+		"orgcostbudget:{org1}",
+		"orgcostbudget:{org1}:scope:user:u1",
+		"orgcostbudget:{org1}:scope:team:t1",
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("keys() = %v, want %v", keys, want)
+	}
+}
+
+func TestMemoryOrgCostBudgetLedgerBooksEachBudgetOnceWithItsRounding(t *testing.T) {
+	ctx := context.Background()
+	user := NewOrgCostBudgetScope(OrgCostBudgetScopeTypeUser, "u1")
+	scopes := []OrgCostBudgetScope{{Type: OrgCostBudgetScopeTypeOrg}, user, user}
+	cost := mustParseMoney(t, "0.001")
+
+	ledger := NewMemoryOrgCostBudgetLedger()
+	for _, budget := range []*OrgCostBudget{
+		newTestOrgCostBudget(t, "org1", OrgCostBudgetScope{Type: OrgCostBudgetScopeTypeOrg}, "10", &MoneyRounding{Decimals: 2, Mode: MoneyRoundingModeUp}),
+		newTestOrgCostBudget(t, "org1", user, "5", nil),
+	} {
+		if err := ledger.SetBudget(ctx, budget); err != nil {
+			t.Fatalf("SetBudget() error = %v", err)
+		}
+	}
+
+	if _, err := ledger.Charge(ctx, "org1", cost, scopes...); err != nil {
+		t.Fatalf("Charge() error = %v", err)
+	}
+	reservation, err := ledger.Reserve(ctx, "org1", mustParseMoney(t, "1"), "run", 0, scopes...)
+	if err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	if len(reservation.Scopes) != 1 {
+		t.Fatalf("reservation scopes = %v, want the user budget once", reservation.Scopes)
+	}
+	if _, err := ledger.Settle(ctx, reservation, cost); err != nil {
+		t.Fatalf("Settle() error = %v", err)
+	}
+
+	orgBudget, _ := ledger.GetBudget(ctx, "org1")
+	if want := mustParseMoney(t, "0.02"); orgBudget.UsedBudget != want || orgBudget.ReservedBudget != 0 {
+		t.Errorf("org budget used %v reserved %v, want used %v rounded up twice and nothing reserved", orgBudget.UsedBudget, orgBudget.ReservedBudget, want)
+	}
+	userBudget, _ := ledger.GetScopedBudget(ctx, "org1", user)
+	if want := mustParseMoney(t, "0.002"); userBudget.UsedBudget != want || userBudget.ReservedBudget != 0 {
+		t.Errorf("user budget used %v reserved %v, want used %v unrounded and nothing reserved", userBudget.UsedBudget, userBudget.ReservedBudget, want)
+	}
+}
+
+func TestMemoryOrgCostBudgetLedgerAlertsEveryCrossedThreshold(t *testing.T) {
+	ctx := context.Background()
+	alerted := make([]int, 0)
+	OrgCostBudgetEvents.On(ORGCOSTBUDGET_EVENT_THRESHOLD_REACHED, t.Name(), func(alert OrgCostBudgetAlert) {
+		alerted = append(alerted, alert.Threshold)
+	})
+	defer OrgCostBudgetEvents.Off(ORGCOSTBUDGET_EVENT_THRESHOLD_REACHED, t.Name())
+
+	ledger := NewMemoryOrgCostBudgetLedger()
+	if err := ledger.SetBudget(ctx, newTestOrgCostBudget(t, "org1", OrgCostBudgetScope{Type: OrgCostBudgetScopeTypeOrg}, "10", nil)); err != nil {
+		t.Fatalf("SetBudget() error = %v", err)
+	}
+	for _, charge := range []struct {
+		cost        string
+		wantAlerted []int
+	}{
+		{cost: "4", wantAlerted: []int{}},
+		{cost: "6", wantAlerted: []int{50, 80, 100}},
+		{cost: "1", wantAlerted: []int{50, 80, 100}},
+	} {
+		if _, err := ledger.Charge(ctx, "org1", mustParseMoney(t, charge.cost)); err != nil {
+			t.Fatalf("Charge(%s) error = %v", charge.cost, err)
+		}
+		if !reflect.DeepEqual(alerted, charge.wantAlerted) {
+			t.Fatalf("after charging %s alerted %v, want %v", charge.cost, alerted, charge.wantAlerted)
+		}
+	}
+}
+
+func TestMemoryOrgCostBudgetLedgerRollsSubBudgetsUpIntoTheirTeam(t *testing.T) {
+	ctx := context.Background()
+	team := NewOrgCostBudgetScope(OrgCostBudgetScopeTypeTeam, "t1")
+	user := NewOrgCostBudgetScope(OrgCostBudgetScopeTypeUser, "u1")
+	userBudget := newTestOrgCostBudget(t, "org1", user, "100", nil)
+	userBudget.ParentScope = &team
+
+	ledger := NewMemoryOrgCostBudgetLedger()
+	for _, budget := range []*OrgCostBudget{
+		newTestOrgCostBudget(t, "org1", OrgCostBudgetScope{Type: OrgCostBudgetScopeTypeOrg}, "100", nil),
+		newTestOrgCostBudget(t, "org1", team, "5", nil),
+		userBudget,
+	} {
+		if err := ledger.SetBudget(ctx, budget); err != nil {
+			t.Fatalf("SetBudget() error = %v", err)
+		}
+	}
+
+	if _, err := ledger.Reserve(ctx, "org1", mustParseMoney(t, "6"), "run", 0, user); !errors.Is(err, ErrInsufficientBudget) {
+		t.Fatalf("Reserve() beyond the team budget error = %v, want %v", err, ErrInsufficientBudget)
+	}
+	reservation, err := ledger.Reserve(ctx, "org1", mustParseMoney(t, "3"), "run", 0, user)
+	if err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	if !reflect.DeepEqual(reservation.Scopes, []OrgCostBudgetScope{user, team}) {
+		t.Fatalf("reservation scopes = %v, want the user and its team", reservation.Scopes)
+	}
+	if _, err := ledger.Settle(ctx, reservation, mustParseMoney(t, "2")); err != nil {
+		t.Fatalf("Settle() error = %v", err)
+	}
+	if _, err := ledger.Charge(ctx, "org1", mustParseMoney(t, "1"), user); err != nil {
+		t.Fatalf("Charge() error = %v", err)
+	}
+	for _, scope := range []OrgCostBudgetScope{{Type: OrgCostBudgetScopeTypeOrg}, team, user} {
+		budget, _ := ledger.GetScopedBudget(ctx, "org1", scope)
+		if want := mustParseMoney(t, "3"); budget.UsedBudget != want || budget.ReservedBudget != 0 {
+			t.Errorf("budget(%s) used %v reserved %v, want used %v and nothing reserved", scope.Key(), budget.UsedBudget, budget.ReservedBudget, want)
+		}
+	}
+}
+
+func TestValidateOrgCostBudgetParentScope(t *testing.T) {
+	team := NewOrgCostBudgetScope(OrgCostBudgetScopeTypeTeam, "t1")
+	agent := NewOrgCostBudgetScope(OrgCostBudgetScopeTypeAgent, "a1")
+	tests := []struct {
+		name   string
+		scope  OrgCostBudgetScope
+		parent OrgCostBudgetScope
+		valid  bool
+	}{
+		{name: "agent in team", scope: agent, parent: team, valid: true},
+		{name: "api key in team", scope: NewOrgCostBudgetScope(OrgCostBudgetScopeTypeApiKey, "k1"), parent: team, valid: true},
+		{name: "parent is no team", scope: NewOrgCostBudgetScope(OrgCostBudgetScopeTypeUser, "u1"), parent: agent},
+		{name: "team in team", scope: NewOrgCostBudgetScope(OrgCostBudgetScopeTypeTeam, "t2"), parent: team},
+		{name: "org in team", scope: OrgCostBudgetScope{Type: OrgCostBudgetScopeTypeOrg}, parent: team},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			budget := newTestOrgCostBudget(t, "org1", tt.scope, "1", nil)
+			budget.ParentScope = &tt.parent
+			if err := ValidateOrgCostBudget(budget); (err == nil) != tt.valid {
+				t.Fatalf("ValidateOrgCostBudget() error = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package models
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	nuts "github.com/vaudience/go-nuts"
+)
+
+const (
+	ORGCOSTBUDGET_EVENT_THRESHOLD_REACHED = "orgcostbudget.threshold_reached"
+)
+
+// ORGCOSTBUDGET_DEFAULT_ALERT_THRESHOLDS are used for budgets without own AlertThresholds, in percent of the TotalBudget
+var ORGCOSTBUDGET_DEFAULT_ALERT_THRESHOLDS = []int{50, 80, 100}
+
+// OrgCostBudgetEvents emits ORGCOSTBUDGET_EVENT_THRESHOLD_REACHED with an OrgCostBudgetAlert,
+// register listeners with On(ORGCOSTBUDGET_EVENT_THRESHOLD_REACHED, name, func(alert OrgCostBudgetAlert))
+var OrgCostBudgetEvents = nuts.NewEventEmitter()
+
+type OrgCostBudgetPeriod string //@name OrgCostBudgetPeriod
+
+const (
+	OrgCostBudgetPeriodNone    OrgCostBudgetPeriod = "none"
+	OrgCostBudgetPeriodDaily   OrgCostBudgetPeriod = "daily"
+	OrgCostBudgetPeriodWeekly  OrgCostBudgetPeriod = "weekly"
+	OrgCostBudgetPeriodMonthly OrgCostBudgetPeriod = "monthly"
+	OrgCostBudgetPeriodYearly  OrgCostBudgetPeriod = "yearly"
+)
+
+var OrgCostBudgetPeriods = []OrgCostBudgetPeriod{OrgCostBudgetPeriodDaily, OrgCostBudgetPeriodWeekly, OrgCostBudgetPeriodMonthly, OrgCostBudgetPeriodYearly}
+
+type OrgCostBudgetScopeType string //@name OrgCostBudgetScopeType
+
+const (
+	OrgCostBudgetScopeTypeOrg    OrgCostBudgetScopeType = "org"
+	OrgCostBudgetScopeTypeTeam   OrgCostBudgetScopeType = "team"
+	OrgCostBudgetScopeTypeAgent  OrgCostBudgetScopeType = "agent"
+	OrgCostBudgetScopeTypeUser   OrgCostBudgetScopeType = "user"
+	OrgCostBudgetScopeTypeApiKey OrgCostBudgetScopeType = "apikey"
+)
+
+// OrgCostBudgetScope addresses a sub-budget of an org, e.g. the budget of one user.
+// agent, user and api key budgets can roll up into a team budget, see OrgCostBudget.ParentScope
+type OrgCostBudgetScope struct {
+	Type OrgCostBudgetScopeType `json:"type" validate:"required,oneof=team agent user apikey"`
+	ID   string                 `json:"id" validate:"required,min=1,max=64"`
+} //@name OrgCostBudgetScope
+
+// OrgCostBudgetAlert is emitted once per period when the used budget reaches a threshold
+type OrgCostBudgetAlert struct {
+	OrgID       string                 `json:"org_id"`
+	ScopeType   OrgCostBudgetScopeType `json:"scope_type"`
+	ScopeID     string                 `json:"scope_id"`
+	Threshold   int                    `json:"threshold"`
+	UsedPercent float64                `json:"used_percent"`
+	UsedBudget  Money                  `json:"used_budget"`
+	TotalBudget Money                  `json:"total_budget"`
+	Currency    Currency               `json:"currency"`
+	PeriodStart int64                  `json:"period_start"`
+	PeriodEnd   int64                  `json:"period_end"`
+	CreatedAt   int64                  `json:"created_at"`
+} //@name OrgCostBudgetAlert
+
+// OrgCostBudgetStatus is the state of one (sub-)budget in a status report
+type OrgCostBudgetStatus struct {
+	Budget           *OrgCostBudget `json:"budget"`
+	UsedPercent      float64        `json:"used_percent"`
+	ReachedThreshold int            `json:"reached_threshold"` // highest alert threshold reached, 0 if none
+	Exhausted        bool           `json:"exhausted"`
+} //@name OrgCostBudgetStatus
+
+// OrgCostBudgetStatusReport lists the org budget and all its sub-budgets
+type OrgCostBudgetStatusReport struct {
+	OrgID      string                `json:"org_id"`
+	Org        *OrgCostBudgetStatus  `json:"org"`
+	SubBudgets []OrgCostBudgetStatus `json:"sub_budgets"`
+	CreatedAt  int64                 `json:"created_at"`
+} //@name OrgCostBudgetStatusReport
+
+func NewOrgCostBudgetScope(scopeType OrgCostBudgetScopeType, id string) OrgCostBudgetScope {
+	return OrgCostBudgetScope{
+		Type: scopeType,
+		ID:   id,
+	}
+}
+
+// NewScopedOrgCostBudget creates a sub-budget of the org for the given scope
+func NewScopedOrgCostBudget(orgID string, scope OrgCostBudgetScope) *OrgCostBudget {
+	budget := NewOrgCostBudget(orgID)
+	budget.ScopeType = scope.Type
+	budget.ScopeID = scope.ID
+	return budget
+}
+
+// Key identifies the scope within its org
+func (scope OrgCostBudgetScope) Key() string {
+	if scope.Type == "" || scope.Type == OrgCostBudgetScopeTypeOrg {
+		return string(OrgCostBudgetScopeTypeOrg)
+	}
+	return string(scope.Type) + ":" + scope.ID
+}
+
+// parseOrgCostBudgetScopeKey is the reverse of OrgCostBudgetScope.Key
+func parseOrgCostBudgetScopeKey(key string) OrgCostBudgetScope {
+	scopeType, scopeID, _ := strings.Cut(key, ":")
+	return NewOrgCostBudgetScope(OrgCostBudgetScopeType(scopeType), scopeID)
+}
+
+// uniqueSubBudgetScopes drops org scopes and repeated scopes, so no budget is booked twice
+func uniqueSubBudgetScopes(scopes []OrgCostBudgetScope) []OrgCostBudgetScope {
+	unique := make([]OrgCostBudgetScope, 0, len(scopes))
+	seen := make(map[string]bool)
+	for _, scope := range scopes {
+		key := scope.Key()
+		if key == string(OrgCostBudgetScopeTypeOrg) || seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, scope)
+	}
+	return unique
+}
+
+// withParentScopes returns the unique sub-budget scopes followed by the parent scopes of their budgets,
+// so a booking on a user budget also books on its team budget. getParent returns nil for scopes without budget or parent.
+func withParentScopes(scopes []OrgCostBudgetScope, getParent func(scope OrgCostBudgetScope) *OrgCostBudgetScope) []OrgCostBudgetScope {
+	unique := uniqueSubBudgetScopes(scopes)
+	expanded := append([]OrgCostBudgetScope{}, unique...)
+	for _, scope := range unique {
+		if parent := getParent(scope); parent != nil {
+			expanded = append(expanded, *parent)
+		}
+	}
+	return uniqueSubBudgetScopes(expanded)
+}
+
+func (budget *OrgCostBudget) IsOrgScope() bool {
+	return budget.ScopeType == "" || budget.ScopeType == OrgCostBudgetScopeTypeOrg
+}
+
+func (budget *OrgCostBudget) GetScope() OrgCostBudgetScope {
+	if budget.IsOrgScope() {
+		return OrgCostBudgetScope{Type: OrgCostBudgetScopeTypeOrg}
+	}
+	return NewOrgCostBudgetScope(budget.ScopeType, budget.ScopeID)
+}
+
+// GetBudgetPeriodBounds returns the calendar period (UTC) containing at, both zero for budgets without period
+func GetBudgetPeriodBounds(period OrgCostBudgetPeriod, at time.Time) (start time.Time, end time.Time) {
+	at = at.UTC()
+	day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case OrgCostBudgetPeriodDaily:
+		return day, day.AddDate(0, 0, 1)
+	case OrgCostBudgetPeriodWeekly:
+		// weeks start on monday
+		start = day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		return start, start.AddDate(0, 0, 7)
+	case OrgCostBudgetPeriodMonthly:
+		start = time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	case OrgCostBudgetPeriodYearly:
+		start = time.Date(at.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(1, 0, 0)
+	}
+	return time.Time{}, time.Time{}
+}
+
+func (budget *OrgCostBudget) HasPeriod() bool {
+	return budget.Period != "" && budget.Period != OrgCostBudgetPeriodNone
+}
+
+// StartPeriod sets the bounds of the period containing now, without touching the used budget
+func (budget *OrgCostBudget) StartPeriod(now time.Time) {
+	if !budget.HasPeriod() {
+		budget.PeriodStart, budget.PeriodEnd = 0, 0
+		return
+	}
+	start, end := GetBudgetPeriodBounds(budget.Period, now)
+	budget.PeriodStart, budget.PeriodEnd = nuts.TimeToJSTimestamp(start), nuts.TimeToJSTimestamp(end)
+}
+
+// ResetPeriodIfDue resets the used budget and the alerts when the current period ended
+func (budget *OrgCostBudget) ResetPeriodIfDue(now time.Time) (reset bool) {
+	if !budget.HasPeriod() {
+		return false
+	}
+	if budget.PeriodEnd == 0 {
+		budget.StartPeriod(now)
+		return false
+	}
+	if nuts.TimeToJSTimestamp(now) < budget.PeriodEnd {
+		return false
+	}
+	budget.StartPeriod(now)
+	budget.UsedBudget = 0
+	budget.AlertedThreshold = 0
+	budget.UpdateRemainingBudget()
+	budget.UpdatedAt = nuts.TimeToJSTimestamp(now)
+	return true
+}
+
+func (budget *OrgCostBudget) GetAlertThresholds() []int {
+	thresholds := budget.AlertThresholds
+	if len(thresholds) == 0 {
+		thresholds = ORGCOSTBUDGET_DEFAULT_ALERT_THRESHOLDS
+	}
+	sorted := make([]int, len(thresholds))
+	copy(sorted, thresholds)
+	sort.Ints(sorted)
+	return sorted
+}
+
+// UsedPercent is the used budget in percent of the total budget, 100 for exhausted budgets without total
+func (budget *OrgCostBudget) UsedPercent() float64 {
+	if budget.TotalBudget <= 0 {
+		if budget.UsedBudget > 0 {
+			return 100
+		}
+		return 0
+	}
+	return float64(budget.UsedBudget) / float64(budget.TotalBudget) * 100
+}
+
+// GetReachedThreshold returns the highest alert threshold reached by the used budget, 0 if none
+func (budget *OrgCostBudget) GetReachedThreshold() (threshold int) {
+	usedPercent := budget.UsedPercent()
+	for _, candidate := range budget.GetAlertThresholds() {
+		if usedPercent >= float64(candidate) {
+			threshold = candidate
+		}
+	}
+	return threshold
+}
+
+// GetReachedThresholdsAbove returns all reached alert thresholds above alerted in ascending order,
+// e.g. 50, 80 and 100 when one charge took the budget from 40% to 100%
+func (budget *OrgCostBudget) GetReachedThresholdsAbove(alerted int) (thresholds []int) {
+	usedPercent := budget.UsedPercent()
+	for _, candidate := range budget.GetAlertThresholds() {
+		if candidate > alerted && usedPercent >= float64(candidate) && !slices.Contains(thresholds, candidate) {
+			thresholds = append(thresholds, candidate)
+		}
+	}
+	return thresholds
+}
+
+func (budget *OrgCostBudget) NewAlert(threshold int) OrgCostBudgetAlert {
+	return OrgCostBudgetAlert{
+		OrgID:       budget.OrgID,
+		ScopeType:   budget.GetScope().Type,
+		ScopeID:     budget.ScopeID,
+		Threshold:   threshold,
+		UsedPercent: budget.UsedPercent(),
+		UsedBudget:  budget.UsedBudget,
+		TotalBudget: budget.TotalBudget,
+		Currency:    budget.Currency.OrDefault(),
+		PeriodStart: budget.PeriodStart,
+		PeriodEnd:   budget.PeriodEnd,
+		CreatedAt:   nuts.TimeToJSTimestamp(time.Now()),
+	}
+}
+
+// EmitOrgCostBudgetAlert publishes the alert on OrgCostBudgetEvents
+func EmitOrgCostBudgetAlert(alert OrgCostBudgetAlert) {
+	nuts.L.Infof("[EmitOrgCostBudgetAlert] budget(%s/%s:%s) reached (%d%%) of its total", alert.OrgID, alert.ScopeType, alert.ScopeID, alert.Threshold)
+	err := OrgCostBudgetEvents.Emit(ORGCOSTBUDGET_EVENT_THRESHOLD_REACHED, alert)
+	if err != nil {
+		nuts.L.Errorf("[EmitOrgCostBudgetAlert] failed to emit alert for org(%s): %v", alert.OrgID, err)
+	}
+}
+
+func NewOrgCostBudgetStatus(budget *OrgCostBudget) OrgCostBudgetStatus {
+	return OrgCostBudgetStatus{
+		Budget:           budget,
+		UsedPercent:      budget.UsedPercent(),
+		ReachedThreshold: budget.GetReachedThreshold(),
+		Exhausted:        budget.RemainingBudget <= 0,
+	}
+}
+
+// NewOrgCostBudgetStatusReport builds the report from all budgets of one org
+func NewOrgCostBudgetStatusReport(orgID string, budgets []*OrgCostBudget) *OrgCostBudgetStatusReport {
+	report := &OrgCostBudgetStatusReport{
+		OrgID:      orgID,
+		SubBudgets: make([]OrgCostBudgetStatus, 0),
+		CreatedAt:  nuts.TimeToJSTimestamp(time.Now()),
+	}
+	for _, budget := range budgets {
+		status := NewOrgCostBudgetStatus(budget)
+		if budget.IsOrgScope() {
+			report.Org = &status
+			continue
+		}
+		report.SubBudgets = append(report.SubBudgets, status)
+	}
+	sort.SliceStable(report.SubBudgets, func(i, j int) bool {
+		return report.SubBudgets[i].Budget.GetScope().Key() < report.SubBudgets[j].Budget.GetScope().Key()
+	})
+	return report
+}
@@ -0,0 +1,252 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	nuts "github.com/vaudience/go-nuts"
+)
+
+var (
+	ErrInvalidOrgResidencyPolicy = errors.New("invalid organization residency policy data")
+	ErrNoCompliantEndpoint       = errors.New("no service endpoint of the model complies with the residency policy")
+	ErrServiceDoesNotMatchModel  = errors.New("service does not serve the model")
+)
+
+type OrgResidencyEnforcement string //@name OrgResidencyEnforcement
+
+const (
+	// requests without a compliant endpoint are rejected
+	OrgResidencyEnforcementHard OrgResidencyEnforcement = "hard"
+	// requests without a compliant endpoint are served by the first endpoint and flagged as not compliant
+	OrgResidencyEnforcementAudit OrgResidencyEnforcement = "audit"
+)
+
+type OrgResidencyPolicyWriteDto struct {
+	AllowedLocations *[]HostingLocation       `json:"allowed_locations" validate:"omitempty,min=1,dive,oneof=usa europe germany uk swiss any" writexs:"system:struct,admin:struct,owner:struct" readxs:"system:struct,admin:struct,owner:struct"`
+	Enforcement      *OrgResidencyEnforcement `json:"enforcement" validate:"omitempty,oneof=hard audit" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,owner:struct"`
+} //@name OrgResidencyPolicyWriteDto
+
+// OrgResidencyPolicy restricts where the data of an organization may be processed. AllowedLocations are in order of preference,
+// an endpoint is compliant if its location is within one of them (germany is within europe, nothing but any is within any).
+type OrgResidencyPolicy struct {
+	OrgID            string                  `json:"org_id" validate:"required,min=1,max=64" writexs:"system:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`
+	AllowedLocations []HostingLocation       `json:"allowed_locations" validate:"required,min=1,dive,oneof=usa europe germany uk swiss any" writexs:"system:struct,admin:struct,owner:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`
+	Enforcement      OrgResidencyEnforcement `json:"enforcement,omitempty" validate:"omitempty,oneof=hard audit" writexs:"system:struct,admin:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"` // hard if empty
+	UpdatedAt        int64                   `json:"updated_at" writexs:"system:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`
+	UpdatedBy        string                  `json:"updated_by" writexs:"system:struct" readxs:"system:struct,admin:struct,owner:struct,org-owner:struct"`
+} //@name OrgResidencyPolicy
+
+// ResidencyEndpointRejection is an endpoint of the service the request must not go to
+type ResidencyEndpointRejection struct {
+	Endpoint string          `json:"endpoint"`
+	Location HostingLocation `json:"location"`
+	Reason   string          `json:"reason"`
+} //@name ResidencyEndpointRejection
+
+// ResidencyDecision records which endpoint serves a request of an org and why, for the audit trail
+type ResidencyDecision struct {
+	OrgID            string                       `json:"org_id"`
+	ModelID          string                       `json:"model_id"`
+	ServiceID        string                       `json:"service_id"`
+	Endpoint         string                       `json:"endpoint"` // the key in AIModelServiceObject.HostingLocations, empty for the ServiceHostLocations of the model (the configured endpoint of the provider)
+	Location         HostingLocation              `json:"location"`
+	AllowedLocations []HostingLocation            `json:"allowed_locations"` // of the policy and the agent, empty if nothing is restricted
+	Compliant        bool                         `json:"compliant"`
+	Rejections       []ResidencyEndpointRejection `json:"rejections"`
+	Explanation      string                       `json:"explanation"`
+	CreatedAt        int64                        `json:"created_at"`
+} //@name ResidencyDecision
+
+func NewOrgResidencyPolicy(orgID string, allowedLocations ...HostingLocation) *OrgResidencyPolicy {
+	now := nuts.TimeToJSTimestamp(time.Now())
+	entity := OrgResidencyPolicy{
+		OrgID:            orgID,
+		AllowedLocations: allowedLocations,
+		UpdatedAt:        now,
+	}
+	return &entity
+}
+
+func ValidateOrgResidencyPolicy(policy *OrgResidencyPolicy) error {
+	validate := validator.New()
+	err := validate.Struct(policy)
+	if err != nil {
+		nuts.L.Debugf("[ValidateOrgResidencyPolicy] Validation error: %v", err)
+		return ErrInvalidOrgResidencyPolicy
+	}
+	return nil
+}
+
+func (policy *OrgResidencyPolicy) IsHardEnforced() bool {
+	return policy.Enforcement != OrgResidencyEnforcementAudit
+}
+
+// Allows tells if data may be processed at location
+func (policy *OrgResidencyPolicy) Allows(location HostingLocation) bool {
+	return isLocationAllowed(location, policy.AllowedLocations)
+}
+
+// ApplyWriteDto copies the set fields of the dto onto the policy
+func (policy *OrgResidencyPolicy) ApplyWriteDto(dto *OrgResidencyPolicyWriteDto) {
+	if dto.AllowedLocations != nil {
+		policy.AllowedLocations = *dto.AllowedLocations
+	}
+	if dto.Enforcement != nil {
+		policy.Enforcement = *dto.Enforcement
+	}
+	policy.UpdatedAt = nuts.TimeToJSTimestamp(time.Now())
+}
+
+// ResolveCompliantEndpoint picks the endpoint of the service to send a request for the model to. the endpoint location
+// has to be within the policy of the org and the ModelHostLocation of the agent; policy and agent may be nil.
+// endpoints are tried in the order of the allowed locations of the policy, then by endpoint name.
+// a service without HostingLocations is located by the ServiceHostLocations of the model: the provider sends to its
+// configured url, which may serve from any of them, so it complies only if all of them are allowed.
+// without a compliant endpoint a hard policy returns ErrNoCompliantEndpoint with the explanation,
+// an audit policy the first endpoint with Compliant false.
+// the request has to go to the decision's Endpoint: NewProviderForService(service, decision.Endpoint).
+func ResolveCompliantEndpoint(policy *OrgResidencyPolicy, agent *Agent, aiModel *AIModel, service *AIModelServiceObject) (decision *ResidencyDecision, err error) {
+	var logName string = "[ResolveCompliantEndpoint] "
+	if service != nil && aiModel.ServiceID != "" && aiModel.ServiceID != service.ID {
+		return nil, fmt.Errorf("%w: model(%s) belongs to service(%s), not (%s)", ErrServiceDoesNotMatchModel, aiModel.ID, aiModel.ServiceID, service.ID)
+	}
+	decision = &ResidencyDecision{
+		ModelID:          aiModel.ID,
+		ServiceID:        aiModel.ServiceID,
+		AllowedLocations: make([]HostingLocation, 0),
+		Rejections:       make([]ResidencyEndpointRejection, 0),
+		CreatedAt:        nuts.TimeToJSTimestamp(time.Now()),
+	}
+	restrictions := make([]string, 0)
+	if policy != nil {
+		decision.OrgID = policy.OrgID
+		decision.AllowedLocations = append(decision.AllowedLocations, policy.AllowedLocations...)
+		restrictions = append(restrictions, fmt.Sprintf("org(%s) allows (%s)", policy.OrgID, joinHostingLocations(policy.AllowedLocations)))
+	}
+	var agentLocation HostingLocation
+	if agent != nil && agent.ModelHostLocation != "" && agent.ModelHostLocation != HostingLocationANY {
+		agentLocation = agent.ModelHostLocation
+		restrictions = append(restrictions, fmt.Sprintf("agent(%s) requires (%s)", agent.ID, agentLocation))
+		if policy == nil {
+			decision.AllowedLocations = append(decision.AllowedLocations, agentLocation)
+		}
+	}
+
+	endpoints, locations := getServiceEndpoints(aiModel, service)
+	candidates := make([]int, 0, len(endpoints))
+	for n, endpoint := range endpoints {
+		location := locations[n]
+		switch {
+		case policy != nil && !policy.Allows(location):
+			decision.Rejections = append(decision.Rejections, ResidencyEndpointRejection{Endpoint: endpoint, Location: location, Reason: fmt.Sprintf("not within the policy of org(%s)", policy.OrgID)})
+		case agentLocation != "" && !location.IsWithin(agentLocation):
+			decision.Rejections = append(decision.Rejections, ResidencyEndpointRejection{Endpoint: endpoint, Location: location, Reason: fmt.Sprintf("not within the location of agent(%s)", agent.ID)})
+		default:
+			candidates = append(candidates, n)
+		}
+	}
+	isModelLocated := service == nil || len(service.HostingLocations) == 0
+	if isModelLocated && len(candidates) > 0 && len(candidates) < len(endpoints) {
+		for _, candidate := range candidates {
+			decision.Rejections = append(decision.Rejections, ResidencyEndpointRejection{Endpoint: endpoints[candidate], Location: locations[candidate], Reason: fmt.Sprintf("the configured endpoint of service(%s) may serve from the other locations of model(%s)", aiModel.ServiceID, aiModel.ID)})
+		}
+		candidates = candidates[:0]
+	}
+	if len(candidates) > 0 {
+		selected := candidates[0]
+		if policy != nil {
+			selected = getPreferredEndpoint(candidates, locations, policy.AllowedLocations)
+		}
+		decision.Endpoint = endpoints[selected]
+		decision.Location = locations[selected]
+		decision.Compliant = true
+		decision.Explanation = fmt.Sprintf("endpoint (%s) in (%s) complies", decision.Endpoint, decision.Location)
+		if len(restrictions) > 0 {
+			decision.Explanation += ": " + strings.Join(restrictions, ", ")
+		}
+		return decision, nil
+	}
+
+	hostedIn := make([]string, 0, len(endpoints))
+	for n, endpoint := range endpoints {
+		hostedIn = append(hostedIn, fmt.Sprintf("%s: %s", endpoint, locations[n]))
+	}
+	decision.Explanation = fmt.Sprintf("model(%s) of service(%s) is hosted in (%s) but %s", aiModel.ID, aiModel.ServiceID, strings.Join(hostedIn, ", "), strings.Join(restrictions, " and "))
+	if len(endpoints) == 0 {
+		decision.Explanation = fmt.Sprintf("model(%s) of service(%s) has no hosting location", aiModel.ID, aiModel.ServiceID)
+	}
+	if policy != nil && !policy.IsHardEnforced() && len(endpoints) > 0 {
+		decision.Endpoint = endpoints[0]
+		decision.Location = locations[0]
+		nuts.L.Warnf("%sserving a request of org(%s) outside its residency policy (audit only): %s", logName, policy.OrgID, decision.Explanation)
+		return decision, nil
+	}
+	nuts.L.Infof("%srejected: %s", logName, decision.Explanation)
+	return decision, fmt.Errorf("%w: %s", ErrNoCompliantEndpoint, decision.Explanation)
+}
+
+// RecordResidency stores the endpoint and location that served the execution
+func (result *ExecutionResult) RecordResidency(decision *ResidencyDecision) {
+	if decision == nil {
+		return
+	}
+	result.HostingEndpoint = decision.Endpoint
+	result.HostingLocation = decision.Location
+	compliant := decision.Compliant
+	result.ResidencyCompliant = &compliant
+}
+
+// getServiceEndpoints returns the endpoints sorted by name with their locations, the model locations if the service has none
+func getServiceEndpoints(aiModel *AIModel, service *AIModelServiceObject) (endpoints []string, locations []HostingLocation) {
+	if service != nil && len(service.HostingLocations) > 0 {
+		endpoints = make([]string, 0, len(service.HostingLocations))
+		for endpoint := range service.HostingLocations {
+			endpoints = append(endpoints, endpoint)
+		}
+		sort.Strings(endpoints)
+		locations = make([]HostingLocation, 0, len(endpoints))
+		for _, endpoint := range endpoints {
+			locations = append(locations, service.HostingLocations[endpoint])
+		}
+		return endpoints, locations
+	}
+	for _, location := range aiModel.ServiceHostLocations {
+		endpoints = append(endpoints, "")
+		locations = append(locations, location)
+	}
+	return endpoints, locations
+}
+
+// getPreferredEndpoint returns the first candidate within the first allowed location that has one
+func getPreferredEndpoint(candidates []int, locations []HostingLocation, allowedLocations []HostingLocation) int {
+	for _, allowed := range allowedLocations {
+		for _, candidate := range candidates {
+			if locations[candidate].IsWithin(allowed) {
+				return candidate
+			}
+		}
+	}
+	return candidates[0]
+}
+
+func isLocationAllowed(location HostingLocation, allowedLocations []HostingLocation) bool {
+	for _, allowed := range allowedLocations {
+		if location.IsWithin(allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func joinHostingLocations(locations []HostingLocation) string {
+	names := make([]string, 0, len(locations))
+	for _, location := range locations {
+		names = append(names, string(location))
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,149 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// startResidencyTestEndpoint serves chat completions and counts the requests it got
+func startResidencyTestEndpoint(t *testing.T) (url string, hits *atomic.Int32) {
+	t.Helper()
+	hits = &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		hits.Add(1)
+		writer.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(writer, `{"id":"chatcmpl-1","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"hallo"}}],"usage":{"prompt_tokens":1,"completion_tokens":1}}`)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, hits
+}
+
+func TestProviderSendsToTheResolvedEndpoint(t *testing.T) {
+	germanyURL, germanyHits := startResidencyTestEndpoint(t)
+	usaURL, usaHits := startResidencyTestEndpoint(t)
+	configuredURL, configuredHits := startResidencyTestEndpoint(t)
+	viper.Set(OPENAI_API_KEY_CONFIG_KEY, "test")
+	viper.Set(OPENAI_BASE_URL_CONFIG_KEY, configuredURL)
+	RegisterProvider(OPENAI_PROVIDER_SERVICE_IMPL, NewOpenAIProviderFromConfig)
+	t.Cleanup(func() {
+		viper.Set(OPENAI_API_KEY_CONFIG_KEY, "")
+		viper.Set(OPENAI_BASE_URL_CONFIG_KEY, "")
+		UnregisterProvider(OPENAI_PROVIDER_SERVICE_IMPL)
+	})
+
+	service := &AIModelServiceObject{ID: "service", ServiceImpl: OPENAI_PROVIDER_SERVICE_IMPL, HostingLocations: map[string]HostingLocation{
+		usaURL:     HostingLocationUSA,
+		germanyURL: HostingLocationGERMANY,
+	}}
+	aiModel := &AIModel{ID: "model", ModelID: "gpt-test", ServiceID: service.ID}
+	decision, err := ResolveCompliantEndpoint(NewOrgResidencyPolicy("org1", HostingLocationEU), nil, aiModel, service)
+	if err != nil {
+		t.Fatalf("ResolveCompliantEndpoint() error = %v", err)
+	}
+	provider, err := NewProviderForService(service, decision.Endpoint)
+	if err != nil {
+		t.Fatalf("NewProviderForService() error = %v", err)
+	}
+	result, err := provider.ChatCompletion(context.Background(), ProviderChatRequest{Model: aiModel, Messages: OpenAIMessagesToAIgencyMessageList(newTestOpenAIConversation()[:2])})
+	if err != nil {
+		t.Fatalf("ChatCompletion() error = %v", err)
+	}
+	result.RecordResidency(decision)
+
+	if germanyHits.Load() != 1 || usaHits.Load() != 0 || configuredHits.Load() != 0 {
+		t.Errorf("requests to germany %d, usa %d, configured %d, want the one request in germany", germanyHits.Load(), usaHits.Load(), configuredHits.Load())
+	}
+	if result.HostingEndpoint != germanyURL || result.HostingLocation != HostingLocationGERMANY || result.ResidencyCompliant == nil || !*result.ResidencyCompliant {
+		t.Errorf("recorded endpoint %s in %s, want the compliant %s in germany", result.HostingEndpoint, result.HostingLocation, germanyURL)
+	}
+
+	if _, err := NewProviderForService(service, "eu-central"); !errors.Is(err, ErrProviderEndpointNotURL) {
+		t.Errorf("NewProviderForService() for an endpoint name error = %v, want %v", err, ErrProviderEndpointNotURL)
+	}
+}
+
+func TestResolveCompliantEndpointByModelLocations(t *testing.T) {
+	service := &AIModelServiceObject{ID: "service", ServiceImpl: OPENAI_PROVIDER_SERVICE_IMPL}
+	auditPolicy := NewOrgResidencyPolicy("org1", HostingLocationEU)
+	auditPolicy.Enforcement = OrgResidencyEnforcementAudit
+
+	tests := []struct {
+		name           string
+		policy         *OrgResidencyPolicy
+		agent          *Agent
+		modelLocations []HostingLocation
+		wantCompliant  bool
+		wantLocation   HostingLocation
+		wantRejections int
+		wantErr        error
+	}{
+		{name: "single allowed location", policy: NewOrgResidencyPolicy("org1", HostingLocationEU), modelLocations: []HostingLocation{HostingLocationGERMANY}, wantCompliant: true, wantLocation: HostingLocationGERMANY},
+		{name: "every location allowed", policy: NewOrgResidencyPolicy("org1", HostingLocationEU), modelLocations: []HostingLocation{HostingLocationEU, HostingLocationGERMANY}, wantCompliant: true, wantLocation: HostingLocationEU},
+		{name: "one location not allowed", policy: NewOrgResidencyPolicy("org1", HostingLocationEU), modelLocations: []HostingLocation{HostingLocationGERMANY, HostingLocationUSA}, wantRejections: 2, wantErr: ErrNoCompliantEndpoint},
+		{name: "one location not allowed in audit", policy: auditPolicy, modelLocations: []HostingLocation{HostingLocationGERMANY, HostingLocationUSA}, wantLocation: HostingLocationGERMANY, wantRejections: 2},
+		{name: "one location outside the agent location", agent: &Agent{ID: "agent", ModelHostLocation: HostingLocationGERMANY}, modelLocations: []HostingLocation{HostingLocationGERMANY, HostingLocationEU}, wantRejections: 2, wantErr: ErrNoCompliantEndpoint},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aiModel := &AIModel{ID: "model", ServiceID: service.ID, ServiceHostLocations: tt.modelLocations}
+			decision, err := ResolveCompliantEndpoint(tt.policy, tt.agent, aiModel, service)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ResolveCompliantEndpoint() error = %v, want %v", err, tt.wantErr)
+			}
+			if decision.Compliant != tt.wantCompliant || decision.Endpoint != "" || len(decision.Rejections) != tt.wantRejections {
+				t.Fatalf("decision = %+v, want compliant %v for the configured endpoint with %d rejections", decision, tt.wantCompliant, tt.wantRejections)
+			}
+			if err == nil && decision.Location != tt.wantLocation {
+				t.Errorf("location = %s, want %s", decision.Location, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestProviderSendsToTheConfiguredEndpointOfAModelLocatedService(t *testing.T) {
+	configuredURL, configuredHits := startResidencyTestEndpoint(t)
+	viper.Set(OPENAI_API_KEY_CONFIG_KEY, "test")
+	viper.Set(OPENAI_BASE_URL_CONFIG_KEY, configuredURL)
+	RegisterProvider(OPENAI_PROVIDER_SERVICE_IMPL, NewOpenAIProviderFromConfig)
+	t.Cleanup(func() {
+		viper.Set(OPENAI_API_KEY_CONFIG_KEY, "")
+		viper.Set(OPENAI_BASE_URL_CONFIG_KEY, "")
+		UnregisterProvider(OPENAI_PROVIDER_SERVICE_IMPL)
+	})
+
+	service := &AIModelServiceObject{ID: "service", ServiceImpl: OPENAI_PROVIDER_SERVICE_IMPL}
+	aiModel := &AIModel{ID: "model", ModelID: "gpt-test", ServiceID: service.ID, ServiceHostLocations: []HostingLocation{HostingLocationGERMANY, HostingLocationUSA}}
+	policy := NewOrgResidencyPolicy("org1", HostingLocationEU)
+	if _, err := ResolveCompliantEndpoint(policy, nil, aiModel, service); !errors.Is(err, ErrNoCompliantEndpoint) {
+		t.Fatalf("ResolveCompliantEndpoint() of a hard policy error = %v, want %v as the configured endpoint may serve from usa", err, ErrNoCompliantEndpoint)
+	}
+
+	policy.Enforcement = OrgResidencyEnforcementAudit
+	decision, err := ResolveCompliantEndpoint(policy, nil, aiModel, service)
+	if err != nil {
+		t.Fatalf("ResolveCompliantEndpoint() of an audit policy error = %v", err)
+	}
+	provider, err := NewProviderForService(service, decision.Endpoint)
+	if err != nil {
+		t.Fatalf("NewProviderForService() error = %v", err)
+	}
+	result, err := provider.ChatCompletion(context.Background(), ProviderChatRequest{Model: aiModel, Messages: OpenAIMessagesToAIgencyMessageList(newTestOpenAIConversation()[:2])})
+	if err != nil {
+		t.Fatalf("ChatCompletion() error = %v", err)
+	}
+	result.RecordResidency(decision)
+
+	if configuredHits.Load() != 1 {
+		t.Errorf("requests to the configured endpoint %d, want 1", configuredHits.Load())
+	}
+	if result.HostingEndpoint != "" || result.ResidencyCompliant == nil || *result.ResidencyCompliant {
+		t.Errorf("recorded endpoint %q compliant %v, want the configured endpoint recorded as not compliant", result.HostingEndpoint, result.ResidencyCompliant)
+	}
+}
@@ -0,0 +1,635 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	nuts "github.com/vaudience/go-nuts"
+)
+
+const (
+	ANTHROPIC_PROVIDER_SERVICE_IMPL = "anthropic"
+	// config keys of NewAnthropicProviderFromConfig
+	ANTHROPIC_API_KEY_CONFIG_KEY  = "ANTHROPIC_API_KEY"
+	ANTHROPIC_BASE_URL_CONFIG_KEY = "ANTHROPIC_BASE_URL" // the anthropic api if empty
+
+	ANTHROPIC_DEFAULT_BASE_URL   = "https://api.anthropic.com"
+	ANTHROPIC_API_VERSION        = "2023-06-01"
+	ANTHROPIC_DEFAULT_MAX_TOKENS = 4096 // max_tokens is required by the api
+	// sent as first user message if the conversation starts with the assistant
+	ANTHROPIC_CONVERSATION_START_TEXT = "(conversation continues)"
+)
+
+const (
+	AnthropicRoleUser      = "user"
+	AnthropicRoleAssistant = "assistant"
+
+	AnthropicContentBlockText       = "text"
+	AnthropicContentBlockImage      = "image"
+	AnthropicContentBlockDocument   = "document"
+	AnthropicContentBlockToolUse    = "tool_use"
+	AnthropicContentBlockToolResult = "tool_result"
+)
+
+var (
+	ErrAnthropicMissingAPIKey         = errors.New("anthropic provider needs an api key")
+	ErrAnthropicUnsupportedAttachment = errors.New("file type can not be sent to the anthropic messages api")
+	ErrAnthropicAPI                   = errors.New("anthropic api error")
+	ErrAnthropicIncompleteStream      = errors.New("anthropic stream ended before message_stop")
+)
+
+// DEFAULT_ANTHROPIC_ROLE_SEQUENCE_OPTIONS merge consecutive messages of the same role, the messages api requires alternating roles
+var DEFAULT_ANTHROPIC_ROLE_SEQUENCE_OPTIONS = RoleSequenceViolationResolveStrategyOptions{
+	ResolveStrategy: RoleSequenceViolationResolveMerge,
+	KeepParameter:   RoleSequenceViolationResolveKeepOldest,
+}
+
+type AnthropicContentSource struct {
+	Type      string `json:"type"` // base64, url or text
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type AnthropicContentBlock struct {
+	Type      string                  `json:"type"`
+	Text      string                  `json:"text,omitempty"`
+	Source    *AnthropicContentSource `json:"source,omitempty"`
+	ID        string                  `json:"id,omitempty"`
+	Name      string                  `json:"name,omitempty"`
+	Input     json.RawMessage         `json:"input,omitempty"`
+	ToolUseID string                  `json:"tool_use_id,omitempty"`
+	Content   string                  `json:"content,omitempty"`
+	IsError   bool                    `json:"is_error,omitempty"`
+}
+
+type AnthropicMessage struct {
+	Role    string                  `json:"role"`
+	Content []AnthropicContentBlock `json:"content"`
+}
+
+type AnthropicTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema any    `json:"input_schema"`
+}
+
+type AnthropicToolChoice struct {
+	Type string `json:"type"` // auto, any, tool or none
+	Name string `json:"name,omitempty"`
+}
+
+type AnthropicMessagesRequest struct {
+	Model         string               `json:"model"`
+	System        string               `json:"system,omitempty"`
+	Messages      []AnthropicMessage   `json:"messages"`
+	MaxTokens     int                  `json:"max_tokens"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	TopP          *float64             `json:"top_p,omitempty"`
+	StopSequences []string             `json:"stop_sequences,omitempty"`
+	Tools         []AnthropicTool      `json:"tools,omitempty"`
+	ToolChoice    *AnthropicToolChoice `json:"tool_choice,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+}
+
+type AnthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+type AnthropicMessagesResponse struct {
+	ID         string                  `json:"id"`
+	Model      string                  `json:"model"`
+	Role       string                  `json:"role"`
+	Content    []AnthropicContentBlock `json:"content"`
+	StopReason string                  `json:"stop_reason"`
+	Usage      AnthropicUsage          `json:"usage"`
+}
+
+type anthropicErrorResponse struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// anthropicStreamEvent holds the fields of all server sent events of a messages stream
+type anthropicStreamEvent struct {
+	Type         string                     `json:"type"`
+	Index        int                        `json:"index"`
+	Message      *AnthropicMessagesResponse `json:"message"`
+	ContentBlock *AnthropicContentBlock     `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *AnthropicUsage `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// AIgencyMessageListToAnthropicMessages converts the list for the messages api. system messages are hoisted into system,
+// the remaining messages are brought into strict user/assistant alternation starting with the user: runs of plain messages
+// of the same role are resolved with ResolveRoleSequenceViolations (on a copy, the list is not changed), messages next to
+// tool calls and tool responses are joined block by block so every tool_result directly answers its tool_use.
+func AIgencyMessageListToAnthropicMessages(list *AIgencyMessageList, roleSequenceOptions RoleSequenceViolationResolveStrategyOptions) (system string, messages []AnthropicMessage, err error) {
+	messages = make([]AnthropicMessage, 0)
+	if list == nil {
+		return "", messages, nil
+	}
+	systemTexts := make([]string, 0)
+	resolved := make([]*AIgencyMessage, 0)
+	segment := NewAIgencyMessageList()
+	resolveSegment := func() {
+		segment.ResolveRoleSequenceViolations(roleSequenceOptions)
+		resolved = append(resolved, segment.GetMessages()...)
+		segment = NewAIgencyMessageList()
+	}
+	for _, msg := range list.GetMessages() {
+		if msg == nil || msg.Type == AIgencyMessageTypeDelta || msg.Type == AIgencyMessageTypeStateUpdate {
+			continue
+		}
+		if msg.SenderConversationRole == ConversationRoleSystem && msg.Type != AIgencyMessageTypeToolResponse {
+			if msg.Content != nil {
+				if text := msg.Content.GetConcatenatedTextContents(); text != "" {
+					systemTexts = append(systemTexts, text)
+				}
+			}
+			continue
+		}
+		if isAnthropicToolMessage(msg) {
+			resolveSegment()
+			resolved = append(resolved, msg)
+			continue
+		}
+		segment.AddMessages([]*AIgencyMessage{msg})
+	}
+	resolveSegment()
+
+	for _, msg := range resolved {
+		// separators inserted by RoleSequenceViolationResolveInsertSeparators have the system role and no place in the messages api
+		if msg.SenderConversationRole == ConversationRoleSystem && msg.Type != AIgencyMessageTypeToolResponse {
+			continue
+		}
+		message, err := AIgencyMessageToAnthropicMessage(msg)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(message.Content) == 0 {
+			continue
+		}
+		last := len(messages) - 1
+		if last >= 0 && messages[last].Role == message.Role {
+			messages[last].Content = append(messages[last].Content, message.Content...)
+			continue
+		}
+		messages = append(messages, message)
+	}
+	for n := range messages {
+		if messages[n].Role == AnthropicRoleUser {
+			sortAnthropicToolResultsFirst(messages[n].Content)
+		}
+	}
+	if len(messages) > 0 && messages[0].Role != AnthropicRoleUser {
+		start := AnthropicMessage{Role: AnthropicRoleUser, Content: []AnthropicContentBlock{{Type: AnthropicContentBlockText, Text: ANTHROPIC_CONVERSATION_START_TEXT}}}
+		messages = append([]AnthropicMessage{start}, messages...)
+	}
+	return strings.Join(systemTexts, "\n\n"), messages, nil
+}
+
+// AIgencyMessageToAnthropicMessage converts one message into content blocks: text, image and document blocks for the files,
+// tool_use blocks for the tool calls of assistant messages and a tool_result block for tool responses
+func AIgencyMessageToAnthropicMessage(msg *AIgencyMessage) (message AnthropicMessage, err error) {
+	text := ""
+	if msg.Content != nil {
+		text = msg.Content.GetConcatenatedTextContents()
+	}
+	message.Content = make([]AnthropicContentBlock, 0)
+	if msg.Type == AIgencyMessageTypeToolResponse {
+		message.Role = AnthropicRoleUser
+		isError := fmt.Sprint(msg.MetaData[TOOL_RESPONSE_METADATA_FINAL_STATE]) == string(AdapterToolExecutionState_Failed)
+		message.Content = append(message.Content, AnthropicContentBlock{Type: AnthropicContentBlockToolResult, ToolUseID: getToolResponseCallID(msg), Content: text, IsError: isError})
+		return message, nil
+	}
+	if msg.SenderConversationRole == ConversationRoleAssistant || msg.Type == AIgencyMessageTypeAnthropicToolUse {
+		message.Role = AnthropicRoleAssistant
+		if strings.TrimSpace(text) != "" {
+			message.Content = append(message.Content, AnthropicContentBlock{Type: AnthropicContentBlockText, Text: text})
+		}
+		for _, toolCall := range msg.GetToolCalls() {
+			input := json.RawMessage(toolCall.Arguments)
+			if strings.TrimSpace(toolCall.Arguments) == "" {
+				input = json.RawMessage("{}")
+			}
+			message.Content = append(message.Content, AnthropicContentBlock{Type: AnthropicContentBlockToolUse, ID: toolCall.ID, Name: toolCall.Name, Input: input})
+		}
+		return message, nil
+	}
+	message.Role = AnthropicRoleUser
+	for _, file := range getAIgencyMessageFiles(msg) {
+		block, err := aigencyMessageFileToAnthropicBlock(file)
+		if err != nil {
+			return message, err
+		}
+		message.Content = append(message.Content, block)
+	}
+	if strings.TrimSpace(text) != "" {
+		message.Content = append(message.Content, AnthropicContentBlock{Type: AnthropicContentBlockText, Text: text})
+	}
+	return message, nil
+}
+
+// aigencyMessageFileToAnthropicBlock sends images and pdfs by url if they are remote, otherwise base64 encoded. text files become text documents,
+// see getAnthropicTextFileContent.
+func aigencyMessageFileToAnthropicBlock(file *AIgencyMessageFile) (block AnthropicContentBlock, err error) {
+	mimeType := strings.ToLower(file.MimeType)
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		block.Type = AnthropicContentBlockImage
+	case mimeType == "application/pdf":
+		block.Type = AnthropicContentBlockDocument
+	case strings.HasPrefix(mimeType, "text/"):
+		fileContent, err := getAnthropicTextFileContent(file)
+		if err != nil {
+			return block, err
+		}
+		return AnthropicContentBlock{Type: AnthropicContentBlockDocument, Source: &AnthropicContentSource{Type: "text", MediaType: "text/plain", Data: string(fileContent)}}, nil
+	default:
+		return block, fmt.Errorf("%w: file (%s) of type (%s)", ErrAnthropicUnsupportedAttachment, file.FileName, file.MimeType)
+	}
+	if file.IsRemote() {
+		block.Source = &AnthropicContentSource{Type: "url", URL: file.URL}
+		return block, nil
+	}
+	dataURL, err := file.GetDataURL()
+	if err != nil {
+		return block, fmt.Errorf("file (%s): %w", file.FileName, err)
+	}
+	encoded := dataURL[strings.Index(dataURL, ",")+1:]
+	block.Source = &AnthropicContentSource{Type: "base64", MediaType: file.MimeType, Data: encoded}
+	return block, nil
+}
+
+// getAnthropicTextFileContent reads a text file from its LocalFilePath or data url. the api can not fetch text documents by url,
+// so a remote text file without a local copy is rejected with ErrAnthropicUnsupportedAttachment.
+func getAnthropicTextFileContent(file *AIgencyMessageFile) (fileContent []byte, err error) {
+	if file.LocalFilePath == "" {
+		if _, fileContent, isDataURL := ParseDataURL(file.URL); isDataURL {
+			return fileContent, nil
+		}
+		if file.IsRemote() {
+			return nil, fmt.Errorf("%w: remote text file (%s) must be downloaded first, the api only fetches images and pdfs by url", ErrAnthropicUnsupportedAttachment, file.FileName)
+		}
+	}
+	fileContent, err = file.ReadFileContentFromLocal()
+	if err != nil {
+		return nil, fmt.Errorf("file (%s): %w", file.FileName, err)
+	}
+	return fileContent, nil
+}
+
+func isAnthropicToolMessage(msg *AIgencyMessage) bool {
+	return msg.Type == AIgencyMessageTypeToolResponse || msg.Type == AIgencyMessageTypeAnthropicToolUse || len(msg.GetToolCalls()) > 0
+}
+
+// sortAnthropicToolResultsFirst moves the tool_result blocks to the front, the api expects them before other content
+func sortAnthropicToolResultsFirst(blocks []AnthropicContentBlock) {
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].Type == AnthropicContentBlockToolResult && blocks[j].Type != AnthropicContentBlockToolResult
+	})
+}
+
+// GetAnthropicFinishReason maps the stop_reason of the messages api onto the finish reasons of the other providers
+func GetAnthropicFinishReason(stopReason string) string {
+	switch stopReason {
+	case "end_turn", "stop_sequence":
+		return "stop"
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	}
+	return stopReason
+}
+
+// AnthropicProviderConfig configures an AnthropicProvider, a BaseURL points it to another endpoint (or a test server)
+type AnthropicProviderConfig struct {
+	APIKey              string
+	BaseURL             string
+	HTTPClient          *http.Client
+	RoleSequenceOptions *RoleSequenceViolationResolveStrategyOptions // DEFAULT_ANTHROPIC_ROLE_SEQUENCE_OPTIONS if nil
+}
+
+// AnthropicProvider runs chat completions against the anthropic messages api
+type AnthropicProvider struct {
+	UnsupportedProviderCapabilities
+	apiKey              string
+	baseURL             string
+	httpClient          *http.Client
+	roleSequenceOptions RoleSequenceViolationResolveStrategyOptions
+}
+
+func NewAnthropicProvider(config AnthropicProviderConfig) *AnthropicProvider {
+	provider := &AnthropicProvider{
+		apiKey:              config.APIKey,
+		baseURL:             strings.TrimSuffix(config.BaseURL, "/"),
+		httpClient:          config.HTTPClient,
+		roleSequenceOptions: DEFAULT_ANTHROPIC_ROLE_SEQUENCE_OPTIONS,
+	}
+	if provider.baseURL == "" {
+		provider.baseURL = ANTHROPIC_DEFAULT_BASE_URL
+	}
+	if provider.httpClient == nil {
+		provider.httpClient = &http.Client{}
+	}
+	if config.RoleSequenceOptions != nil {
+		provider.roleSequenceOptions = *config.RoleSequenceOptions
+	}
+	return provider
+}
+
+// NewAnthropicProviderFromConfig is the ProviderFactory reading ANTHROPIC_API_KEY and ANTHROPIC_BASE_URL from the config,
+// a given endpoint replaces ANTHROPIC_BASE_URL: RegisterProvider(ANTHROPIC_PROVIDER_SERVICE_IMPL, NewAnthropicProviderFromConfig)
+func NewAnthropicProviderFromConfig(service *AIModelServiceObject, endpoint string) (provider Provider, err error) {
+	apiKey := viper.GetString(ANTHROPIC_API_KEY_CONFIG_KEY)
+	if apiKey == "" {
+		return nil, fmt.Errorf("%w: %s is not configured", ErrAnthropicMissingAPIKey, ANTHROPIC_API_KEY_CONFIG_KEY)
+	}
+	baseURL, err := getProviderBaseURL(endpoint, viper.GetString(ANTHROPIC_BASE_URL_CONFIG_KEY))
+	if err != nil {
+		return nil, err
+	}
+	return NewAnthropicProvider(AnthropicProviderConfig{
+		APIKey:  apiKey,
+		BaseURL: baseURL,
+	}), nil
+}
+
+func (provider *AnthropicProvider) GetServiceImpl() string {
+	return ANTHROPIC_PROVIDER_SERVICE_IMPL
+}
+
+func (provider *AnthropicProvider) ChatCompletion(ctx context.Context, request ProviderChatRequest) (result *ExecutionResultText2Text, err error) {
+	var logName string = "[AnthropicProvider.ChatCompletion] "
+	messagesRequest, err := provider.newMessagesRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	startedAt := time.Now()
+	response, err := provider.send(ctx, messagesRequest)
+	if err != nil {
+		nuts.L.Errorf("%smodel(%s) failed: %v", logName, request.Model.ID, err)
+		return nil, err
+	}
+	defer response.Body.Close()
+	messagesResponse := AnthropicMessagesResponse{}
+	if err = json.NewDecoder(response.Body).Decode(&messagesResponse); err != nil {
+		return nil, NewExecutionError(ExecutionErrorClassServerError, response.StatusCode, fmt.Errorf("%w: invalid response: %w", ErrAnthropicAPI, err))
+	}
+	content := strings.Builder{}
+	toolCalls := make([]ExecutionToolCall, 0)
+	for _, block := range messagesResponse.Content {
+		switch block.Type {
+		case AnthropicContentBlockText:
+			content.WriteString(block.Text)
+		case AnthropicContentBlockToolUse:
+			toolCalls = append(toolCalls, ExecutionToolCall{ID: block.ID, Name: block.Name, Arguments: string(block.Input)})
+		}
+	}
+	result = NewExecutionResultText2Text(messagesResponse.ID, request.Model, content.String(), toolCalls, GetAnthropicFinishReason(messagesResponse.StopReason), messagesResponse.Usage.InputTokens, messagesResponse.Usage.OutputTokens, startedAt)
+	addAnthropicCacheUsages(result, messagesResponse.Usage)
+	return result, nil
+}
+
+// ChatCompletionStream reads the server sent events of the messages api, text and tool input parts are passed on as they arrive.
+// a stream ending before message_stop was cut off and fails with ErrAnthropicIncompleteStream.
+func (provider *AnthropicProvider) ChatCompletionStream(ctx context.Context, request ProviderChatRequest, onDelta ProviderDeltaHandler) (result *ExecutionResultText2Text, err error) {
+	var logName string = "[AnthropicProvider.ChatCompletionStream] "
+	messagesRequest, err := provider.newMessagesRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	messagesRequest.Stream = true
+	startedAt := time.Now()
+	response, err := provider.send(ctx, messagesRequest)
+	if err != nil {
+		nuts.L.Errorf("%smodel(%s) failed: %v", logName, request.Model.ID, err)
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	executionID := request.ExecutionID
+	content := strings.Builder{}
+	usage := AnthropicUsage{}
+	stopReason := ""
+	isStopped := false
+	toolCalls := make([]ExecutionToolCall, 0)
+	toolIndexByBlock := make(map[int]int)
+	scanner := bufio.NewScanner(response.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		event := anthropicStreamEvent{}
+		if err = json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &event); err != nil {
+			return nil, NewExecutionError(ExecutionErrorClassServerError, response.StatusCode, fmt.Errorf("%w: invalid stream event: %w", ErrAnthropicAPI, err))
+		}
+		var delta *ProviderChatDelta
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				if executionID == "" {
+					executionID = event.Message.ID
+				}
+				usage = event.Message.Usage
+			}
+		case "content_block_start":
+			if event.ContentBlock != nil && event.ContentBlock.Type == AnthropicContentBlockToolUse {
+				toolIndexByBlock[event.Index] = len(toolCalls)
+				toolCalls = append(toolCalls, ExecutionToolCall{ID: event.ContentBlock.ID, Name: event.ContentBlock.Name})
+				delta = &ProviderChatDelta{ToolCall: &ExecutionToolCallDelta{Index: toolIndexByBlock[event.Index], ID: event.ContentBlock.ID, Name: event.ContentBlock.Name}}
+			}
+		case "content_block_delta":
+			switch event.Delta.Type {
+			case "text_delta":
+				content.WriteString(event.Delta.Text)
+				delta = &ProviderChatDelta{Content: event.Delta.Text}
+			case "input_json_delta":
+				toolIndex, isToolBlock := toolIndexByBlock[event.Index]
+				if !isToolBlock || event.Delta.PartialJSON == "" {
+					continue
+				}
+				toolCalls[toolIndex].Arguments += event.Delta.PartialJSON
+				delta = &ProviderChatDelta{ToolCall: &ExecutionToolCallDelta{Index: toolIndex, ArgumentsDelta: event.Delta.PartialJSON}}
+			}
+		case "message_delta":
+			if event.Delta.StopReason != "" {
+				stopReason = event.Delta.StopReason
+			}
+			if event.Usage != nil {
+				usage.OutputTokens = event.Usage.OutputTokens
+			}
+		case "message_stop":
+			isStopped = true
+		case "error":
+			apiError := fmt.Errorf("%w: stream error", ErrAnthropicAPI)
+			class := ExecutionErrorClassServerError
+			if event.Error != nil {
+				apiError = fmt.Errorf("%w: %s: %s", ErrAnthropicAPI, event.Error.Type, event.Error.Message)
+				class = classifyAnthropicErrorType(event.Error.Type, event.Error.Message, class)
+			}
+			nuts.L.Errorf("%smodel(%s) stream failed: %v", logName, request.Model.ID, apiError)
+			return nil, NewExecutionError(class, response.StatusCode, apiError)
+		}
+		if delta == nil {
+			continue
+		}
+		delta.ExecutionID = executionID
+		if err = onDelta(*delta); err != nil {
+			return nil, err
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		nuts.L.Errorf("%smodel(%s) stream failed: %v", logName, request.Model.ID, err)
+		return nil, NewExecutionError(ClassifyExecutionError(err), response.StatusCode, err)
+	}
+	if !isStopped {
+		nuts.L.Errorf("%smodel(%s) stream failed: %v", logName, request.Model.ID, ErrAnthropicIncompleteStream)
+		return nil, NewExecutionError(ExecutionErrorClassServerError, response.StatusCode, ErrAnthropicIncompleteStream)
+	}
+	finishReason := GetAnthropicFinishReason(stopReason)
+	if err = onDelta(ProviderChatDelta{ExecutionID: executionID, FinishReason: finishReason}); err != nil {
+		return nil, err
+	}
+	result = NewExecutionResultText2Text(executionID, request.Model, content.String(), toolCalls, finishReason, usage.InputTokens, usage.OutputTokens, startedAt)
+	addAnthropicCacheUsages(result, usage)
+	return result, nil
+}
+
+func (provider *AnthropicProvider) newMessagesRequest(request ProviderChatRequest) (messagesRequest AnthropicMessagesRequest, err error) {
+	if err = request.Validate(); err != nil {
+		return messagesRequest, err
+	}
+	system, messages, err := AIgencyMessageListToAnthropicMessages(request.Messages, provider.roleSequenceOptions)
+	if err != nil {
+		return messagesRequest, NewExecutionError(ExecutionErrorClassInvalidRequest, 0, err)
+	}
+	messagesRequest = AnthropicMessagesRequest{
+		Model:     request.Model.ModelID,
+		System:    system,
+		Messages:  messages,
+		MaxTokens: request.GetMaxTokens(),
+	}
+	if messagesRequest.MaxTokens <= 0 {
+		messagesRequest.MaxTokens = request.Model.GetOutputTokenLimit()
+	}
+	if messagesRequest.MaxTokens <= 0 {
+		messagesRequest.MaxTokens = ANTHROPIC_DEFAULT_MAX_TOKENS
+	}
+	if temperature, isNumber := toFloat64(request.Parameters[COMPLETION_PARAMETER_TEMPERATURE]); isNumber {
+		messagesRequest.Temperature = &temperature
+	}
+	if topP, isNumber := toFloat64(request.Parameters[COMPLETION_PARAMETER_TOP_P]); isNumber {
+		messagesRequest.TopP = &topP
+	}
+	switch stop := request.Parameters["stop"].(type) {
+	case string:
+		messagesRequest.StopSequences = []string{stop}
+	case []string:
+		messagesRequest.StopSequences = stop
+	case []any:
+		for _, value := range stop {
+			messagesRequest.StopSequences = append(messagesRequest.StopSequences, fmt.Sprint(value))
+		}
+	}
+	for _, tool := range request.Tools {
+		messagesRequest.Tools = append(messagesRequest.Tools, AnthropicTool{Name: tool.Function.Name, Description: tool.Function.Description, InputSchema: tool.Function.Parameters})
+	}
+	switch request.ToolChoice {
+	case "":
+	case "auto":
+		messagesRequest.ToolChoice = &AnthropicToolChoice{Type: "auto"}
+	case "required":
+		messagesRequest.ToolChoice = &AnthropicToolChoice{Type: "any"}
+	case "none":
+		// the tools are sent all the same, the conversation may contain calls of them
+		messagesRequest.ToolChoice = &AnthropicToolChoice{Type: "none"}
+	default:
+		messagesRequest.ToolChoice = &AnthropicToolChoice{Type: "tool", Name: request.ToolChoice}
+	}
+	return messagesRequest, nil
+}
+
+// send posts the request and returns the response of a successful call, api errors are returned as ExecutionError
+func (provider *AnthropicProvider) send(ctx context.Context, messagesRequest AnthropicMessagesRequest) (response *http.Response, err error) {
+	body, err := json.Marshal(messagesRequest)
+	if err != nil {
+		return nil, NewExecutionError(ExecutionErrorClassInvalidRequest, 0, err)
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.baseURL+"/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, NewExecutionError(ExecutionErrorClassInvalidRequest, 0, err)
+	}
+	httpRequest.Header.Set("content-type", "application/json")
+	httpRequest.Header.Set("x-api-key", provider.apiKey)
+	httpRequest.Header.Set("anthropic-version", ANTHROPIC_API_VERSION)
+	response, err = provider.httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, NewExecutionError(ClassifyExecutionError(err), 0, err)
+	}
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return response, nil
+	}
+	defer response.Body.Close()
+	responseBody, _ := io.ReadAll(response.Body)
+	errorResponse := anthropicErrorResponse{}
+	class := ClassifyHTTPStatusCode(response.StatusCode)
+	apiError := fmt.Errorf("%w: status (%d): %s", ErrAnthropicAPI, response.StatusCode, strings.TrimSpace(string(responseBody)))
+	if json.Unmarshal(responseBody, &errorResponse) == nil && errorResponse.Error.Type != "" {
+		apiError = fmt.Errorf("%w: status (%d): %s: %s", ErrAnthropicAPI, response.StatusCode, errorResponse.Error.Type, errorResponse.Error.Message)
+		class = classifyAnthropicErrorType(errorResponse.Error.Type, errorResponse.Error.Message, class)
+	}
+	return nil, NewExecutionError(class, response.StatusCode, apiError)
+}
+
+func classifyAnthropicErrorType(errorType string, message string, defaultClass ExecutionErrorClass) ExecutionErrorClass {
+	switch {
+	case errorType == "overloaded_error":
+		return ExecutionErrorClassUnavailable
+	case errorType == "rate_limit_error":
+		return ExecutionErrorClassRateLimited
+	case errorType == "authentication_error" || errorType == "permission_error":
+		return ExecutionErrorClassAuthentication
+	case errorType == "invalid_request_error" && strings.Contains(strings.ToLower(message), "prompt is too long"):
+		return ExecutionErrorClassContextLength
+	}
+	return defaultClass
+}
+
+// addAnthropicCacheUsages adds the cache reads and writes, they are not part of input_tokens
+func addAnthropicCacheUsages(result *ExecutionResultText2Text, usage AnthropicUsage) {
+	if usage.CacheReadInputTokens > 0 {
+		result.Usages = append(result.Usages, NewExecutionUsage(AIModelCapabilityTextToText, AIModelCostUnitCachedInputPerMillion, float64(usage.CacheReadInputTokens)))
+	}
+	if usage.CacheCreationInputTokens > 0 {
+		result.Usages = append(result.Usages, NewExecutionUsage(AIModelCapabilityTextToText, AIModelCostUnitCacheWritePerMillion, float64(usage.CacheCreationInputTokens)))
+	}
+}
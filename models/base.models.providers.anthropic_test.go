@@ -0,0 +1,326 @@
+package models
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestAnthropicMessage creates the n-th message of a conversation, the list is ordered by CreatedAt
+func newTestAnthropicMessage(n int, role ConversationRole, text string) *AIgencyMessage {
+	msg := NewAIgencyMessage()
+	msg.Type = AIgencyMessageTypeMessage
+	msg.SenderConversationRole = role
+	msg.CreatedAt = int64(n + 1)
+	msg.UpdatedAt = msg.CreatedAt
+	if text != "" {
+		msg.Content.AddContent(NewAIgencyMessageContent(AIgencyMessageContentTypeText, &text, nil))
+	}
+	return msg
+}
+
+func newTestAnthropicConversation() *AIgencyMessageList {
+	toolCall := newTestAnthropicMessage(5, ConversationRoleAssistant, "")
+	toolCall.SetToolCalls([]ExecutionToolCall{{ID: "call_1", Name: "describe_image"}})
+	toolResponse := newTestAnthropicMessage(6, ConversationRoleUser, "no image found")
+	toolResponse.Type = AIgencyMessageTypeToolResponse
+	toolResponse.MetaData[TOOL_RESPONSE_METADATA_JOB_ID] = "call_1"
+	toolResponse.MetaData[TOOL_RESPONSE_METADATA_FINAL_STATE] = string(AdapterToolExecutionState_Failed)
+	followUp := newTestAnthropicMessage(7, ConversationRoleUser, "try this one")
+	followUp.Attachments.AddFile(NewAIgencyMessageFile("cat.png", "", "image/png", "https://example.com/cat.png"))
+
+	list := NewAIgencyMessageList()
+	list.AddMessages([]*AIgencyMessage{
+		newTestAnthropicMessage(0, ConversationRoleSystem, "be brief"),
+		newTestAnthropicMessage(1, ConversationRoleAssistant, "hi"),
+		newTestAnthropicMessage(2, ConversationRoleSystem, "answer in english"),
+		newTestAnthropicMessage(3, ConversationRoleUser, "first"),
+		newTestAnthropicMessage(4, ConversationRoleUser, "second"),
+		toolCall,
+		toolResponse,
+		followUp,
+	})
+	return list
+}
+
+func TestAIgencyMessageListToAnthropicMessages(t *testing.T) {
+	system, messages, err := AIgencyMessageListToAnthropicMessages(newTestAnthropicConversation(), DEFAULT_ANTHROPIC_ROLE_SEQUENCE_OPTIONS)
+	if err != nil {
+		t.Fatalf("AIgencyMessageListToAnthropicMessages() error = %v", err)
+	}
+	if system != "be brief\n\nanswer in english" {
+		t.Errorf("system = %q, want both system messages", system)
+	}
+	roles := make([]string, 0)
+	for _, message := range messages {
+		roles = append(roles, message.Role)
+	}
+	if want := []string{AnthropicRoleUser, AnthropicRoleAssistant, AnthropicRoleUser, AnthropicRoleAssistant, AnthropicRoleUser}; !reflect.DeepEqual(roles, want) {
+		t.Fatalf("roles = %v, want %v", roles, want)
+	}
+	if messages[0].Content[0].Text != ANTHROPIC_CONVERSATION_START_TEXT {
+		t.Errorf("first message = %+v, want the conversation start text before the assistant", messages[0])
+	}
+	merged, _ := json.Marshal(messages[2].Content)
+	if !strings.Contains(string(merged), "first") || !strings.Contains(string(merged), "second") {
+		t.Errorf("merged user message = %s, want both user texts", merged)
+	}
+	if want := []AnthropicContentBlock{{Type: AnthropicContentBlockToolUse, ID: "call_1", Name: "describe_image", Input: json.RawMessage("{}")}}; !reflect.DeepEqual(messages[3].Content, want) {
+		t.Errorf("tool call message = %+v, want %+v", messages[3].Content, want)
+	}
+	want := []AnthropicContentBlock{
+		{Type: AnthropicContentBlockToolResult, ToolUseID: "call_1", Content: "no image found", IsError: true},
+		{Type: AnthropicContentBlockImage, Source: &AnthropicContentSource{Type: "url", URL: "https://example.com/cat.png"}},
+		{Type: AnthropicContentBlockText, Text: "try this one"},
+	}
+	if !reflect.DeepEqual(messages[4].Content, want) {
+		t.Errorf("tool response message = %+v, want the tool result first and the follow up joined: %+v", messages[4].Content, want)
+	}
+}
+
+func TestAigencyMessageFileToAnthropicBlockTextFiles(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(localPath, []byte("local notes"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	local := NewAIgencyMessageFile("notes.txt", localPath, "text/plain", "https://example.com/notes.txt")
+	inline := NewAIgencyMessageFile("inline.md", "", "text/markdown", "data:text/markdown;base64,"+base64.StdEncoding.EncodeToString([]byte("# inline")))
+	remote := NewAIgencyMessageFile("remote.csv", "", "text/csv", "https://example.com/remote.csv")
+
+	tests := []struct {
+		name     string
+		file     *AIgencyMessageFile
+		wantData string
+		wantErr  error
+	}{
+		{name: "local copy of a remote file", file: local, wantData: "local notes"},
+		{name: "data url", file: inline, wantData: "# inline"},
+		{name: "remote only", file: remote, wantErr: ErrAnthropicUnsupportedAttachment},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block, err := aigencyMessageFileToAnthropicBlock(tt.file)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || !strings.Contains(err.Error(), tt.file.FileName) {
+					t.Fatalf("aigencyMessageFileToAnthropicBlock() error = %v, want %v naming the file", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("aigencyMessageFileToAnthropicBlock() error = %v", err)
+			}
+			want := AnthropicContentBlock{Type: AnthropicContentBlockDocument, Source: &AnthropicContentSource{Type: "text", MediaType: "text/plain", Data: tt.wantData}}
+			if !reflect.DeepEqual(block, want) {
+				t.Errorf("block = %+v, want %+v", block, want)
+			}
+		})
+	}
+}
+
+func TestAnthropicProviderNewMessagesRequestToolChoice(t *testing.T) {
+	tests := []struct {
+		toolChoice string
+		want       *AnthropicToolChoice
+	}{
+		{toolChoice: ""},
+		{toolChoice: "auto", want: &AnthropicToolChoice{Type: "auto"}},
+		{toolChoice: "required", want: &AnthropicToolChoice{Type: "any"}},
+		{toolChoice: "none", want: &AnthropicToolChoice{Type: "none"}},
+		{toolChoice: "describe_image", want: &AnthropicToolChoice{Type: "tool", Name: "describe_image"}},
+	}
+	provider := NewAnthropicProvider(AnthropicProviderConfig{APIKey: "test"})
+	for _, tt := range tests {
+		t.Run(tt.toolChoice, func(t *testing.T) {
+			request := newTestAnthropicChatRequest()
+			request.ToolChoice = tt.toolChoice
+			messagesRequest, err := provider.newMessagesRequest(request)
+			if err != nil {
+				t.Fatalf("newMessagesRequest() error = %v", err)
+			}
+			if !reflect.DeepEqual(messagesRequest.ToolChoice, tt.want) {
+				t.Errorf("tool choice = %+v, want %+v", messagesRequest.ToolChoice, tt.want)
+			}
+			if len(messagesRequest.Tools) != 1 || messagesRequest.Tools[0].Name != "describe_image" {
+				t.Errorf("tools = %+v, want describe_image sent with every tool choice", messagesRequest.Tools)
+			}
+		})
+	}
+}
+
+// startAnthropicTestServer serves /v1/messages with handle and records the request bodies
+func startAnthropicTestServer(t *testing.T, handle func(writer http.ResponseWriter)) (provider *AnthropicProvider, bodies *[]AnthropicMessagesRequest) {
+	t.Helper()
+	bodies = &[]AnthropicMessagesRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/v1/messages" || request.Header.Get("x-api-key") != "test" || request.Header.Get("anthropic-version") != ANTHROPIC_API_VERSION {
+			http.NotFound(writer, request)
+			return
+		}
+		rawBody, _ := io.ReadAll(request.Body)
+		body := AnthropicMessagesRequest{}
+		if err := json.Unmarshal(rawBody, &body); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		*bodies = append(*bodies, body)
+		handle(writer)
+	}))
+	t.Cleanup(server.Close)
+	return NewAnthropicProvider(AnthropicProviderConfig{APIKey: "test", BaseURL: server.URL}), bodies
+}
+
+func newTestAnthropicChatRequest() ProviderChatRequest {
+	return ProviderChatRequest{
+		Model:      &AIModel{ID: "anthropic-test", ModelID: "claude-test"},
+		Messages:   newTestAnthropicConversation(),
+		Tools:      []OpenaiFunction{{Type: "function", Function: OpenaiFunctionDefinition{Name: "describe_image"}}},
+		ToolChoice: "required",
+		Parameters: map[string]any{COMPLETION_PARAMETER_TEMPERATURE: 0},
+	}
+}
+
+func getTestUsageAmount(usages []ExecutionUsage, costUnit AIModelCostUnit) float64 {
+	for _, usage := range usages {
+		if usage.CostUnit == costUnit {
+			return usage.Amount
+		}
+	}
+	return 0
+}
+
+func TestAnthropicProviderChatCompletion(t *testing.T) {
+	provider, bodies := startAnthropicTestServer(t, func(writer http.ResponseWriter) {
+		writer.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(writer, `{"id":"msg_1","model":"claude-test","role":"assistant","content":[{"type":"text","text":"let me look"},{"type":"tool_use","id":"toolu_1","name":"describe_image","input":{"detail":1}}],"stop_reason":"tool_use","usage":{"input_tokens":30,"output_tokens":8,"cache_creation_input_tokens":5,"cache_read_input_tokens":10}}`)
+	})
+
+	result, err := provider.ChatCompletion(context.Background(), newTestAnthropicChatRequest())
+	if err != nil {
+		t.Fatalf("ChatCompletion() error = %v", err)
+	}
+	if result.ExecutionID != "msg_1" || result.Content != "let me look" || result.FinishReason != "tool_calls" || result.InputTokens != 30 || result.OutputTokens != 8 {
+		t.Errorf("result = %+v, want msg_1 finishing with tool_calls after 30 input and 8 output tokens", result)
+	}
+	if want := []ExecutionToolCall{{ID: "toolu_1", Name: "describe_image", Arguments: `{"detail":1}`}}; !reflect.DeepEqual(result.ToolCalls, want) {
+		t.Errorf("tool calls = %+v, want %+v", result.ToolCalls, want)
+	}
+	if cached, written := getTestUsageAmount(result.Usages, AIModelCostUnitCachedInputPerMillion), getTestUsageAmount(result.Usages, AIModelCostUnitCacheWritePerMillion); cached != 10 || written != 5 {
+		t.Errorf("cache usages read %v written %v, want 10 and 5", cached, written)
+	}
+
+	body := (*bodies)[0]
+	if body.Model != "claude-test" || body.System != "be brief\n\nanswer in english" || len(body.Messages) != 5 || body.MaxTokens != ANTHROPIC_DEFAULT_MAX_TOKENS {
+		t.Errorf("sent body = %+v, want the converted conversation with the default max_tokens", body)
+	}
+	if body.Temperature == nil || *body.Temperature != 0 || body.ToolChoice == nil || body.ToolChoice.Type != "any" || len(body.Tools) != 1 {
+		t.Errorf("sent temperature %v, tool choice %+v and %d tools, want 0, any and 1 tool", body.Temperature, body.ToolChoice, len(body.Tools))
+	}
+}
+
+var testAnthropicStreamEvents = []string{
+	`{"type":"message_start","message":{"id":"msg_2","role":"assistant","content":[],"usage":{"input_tokens":25,"output_tokens":1,"cache_read_input_tokens":10}}}`,
+	`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hel"}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"lo"}}`,
+	`{"type":"content_block_stop","index":0}`,
+	`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_2","name":"describe_image","input":{}}}`,
+	`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":""}}`,
+	`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"detail\""}}`,
+	`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":":1}"}}`,
+	`{"type":"content_block_stop","index":1}`,
+	`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":9}}`,
+}
+
+func writeTestAnthropicStream(writer http.ResponseWriter, events []string) {
+	writer.Header().Set("Content-Type", "text/event-stream")
+	for _, event := range events {
+		eventType := struct {
+			Type string `json:"type"`
+		}{}
+		json.Unmarshal([]byte(event), &eventType)
+		fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", eventType.Type, event)
+	}
+}
+
+func TestAnthropicProviderChatCompletionStream(t *testing.T) {
+	provider, bodies := startAnthropicTestServer(t, func(writer http.ResponseWriter) {
+		writeTestAnthropicStream(writer, append(testAnthropicStreamEvents, `{"type":"message_stop"}`))
+	})
+
+	deltas := make([]ProviderChatDelta, 0)
+	result, err := provider.ChatCompletionStream(context.Background(), newTestAnthropicChatRequest(), func(delta ProviderChatDelta) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ChatCompletionStream() error = %v", err)
+	}
+	if result.ExecutionID != "msg_2" || result.Content != "Hello" || result.FinishReason != "tool_calls" || result.InputTokens != 25 || result.OutputTokens != 9 {
+		t.Errorf("result = %+v, want msg_2 finishing with tool_calls after 25 input and 9 output tokens", result)
+	}
+	if want := []ExecutionToolCall{{ID: "toolu_2", Name: "describe_image", Arguments: `{"detail":1}`}}; !reflect.DeepEqual(result.ToolCalls, want) {
+		t.Errorf("tool calls = %+v, want %+v", result.ToolCalls, want)
+	}
+	if cached := getTestUsageAmount(result.Usages, AIModelCostUnitCachedInputPerMillion); cached != 10 {
+		t.Errorf("cached input usage = %v, want 10", cached)
+	}
+	wantDeltas := []ProviderChatDelta{
+		{ExecutionID: "msg_2", Content: "Hel"},
+		{ExecutionID: "msg_2", Content: "lo"},
+		{ExecutionID: "msg_2", ToolCall: &ExecutionToolCallDelta{Index: 0, ID: "toolu_2", Name: "describe_image"}},
+		{ExecutionID: "msg_2", ToolCall: &ExecutionToolCallDelta{Index: 0, ArgumentsDelta: `{"detail"`}},
+		{ExecutionID: "msg_2", ToolCall: &ExecutionToolCallDelta{Index: 0, ArgumentsDelta: ":1}"}},
+		{ExecutionID: "msg_2", FinishReason: "tool_calls"},
+	}
+	if !reflect.DeepEqual(deltas, wantDeltas) {
+		t.Errorf("deltas = %+v, want %+v", deltas, wantDeltas)
+	}
+	if !(*bodies)[0].Stream {
+		t.Errorf("sent body = %+v, want a stream", (*bodies)[0])
+	}
+}
+
+func TestAnthropicProviderChatCompletionStreamFailsWithoutMessageStop(t *testing.T) {
+	provider, _ := startAnthropicTestServer(t, func(writer http.ResponseWriter) {
+		writeTestAnthropicStream(writer, testAnthropicStreamEvents)
+	})
+
+	finishReasons := make([]string, 0)
+	_, err := provider.ChatCompletionStream(context.Background(), newTestAnthropicChatRequest(), func(delta ProviderChatDelta) error {
+		if delta.FinishReason != "" {
+			finishReasons = append(finishReasons, delta.FinishReason)
+		}
+		return nil
+	})
+	var executionError *ExecutionError
+	if !errors.Is(err, ErrAnthropicIncompleteStream) || !errors.As(err, &executionError) || executionError.Class != ExecutionErrorClassServerError {
+		t.Fatalf("ChatCompletionStream() error = %v, want an ExecutionError of class %s for %v", err, ExecutionErrorClassServerError, ErrAnthropicIncompleteStream)
+	}
+	if len(finishReasons) != 0 {
+		t.Errorf("finish deltas %v of a cut off stream, want none", finishReasons)
+	}
+}
+
+func TestAnthropicProviderClassifiesAPIErrors(t *testing.T) {
+	provider, _ := startAnthropicTestServer(t, func(writer http.ResponseWriter) {
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(529)
+		fmt.Fprint(writer, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+	})
+
+	_, err := provider.ChatCompletion(context.Background(), newTestAnthropicChatRequest())
+	var executionError *ExecutionError
+	if !errors.As(err, &executionError) || executionError.Class != ExecutionErrorClassUnavailable {
+		t.Fatalf("ChatCompletion() error = %v, want an ExecutionError of class %s", err, ExecutionErrorClassUnavailable)
+	}
+}
@@ -0,0 +1,387 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	nuts "github.com/vaudience/go-nuts"
+)
+
+const (
+	// the tool calls of an assistant message, a list of ExecutionToolCall
+	AIGENCYMESSAGE_METADATA_TOOL_CALLS    = "tool_calls"
+	AIGENCYMESSAGE_METADATA_FINISH_REASON = "finish_reason"
+)
+
+var (
+	ErrProviderNotRegistered          = errors.New("no provider registered for the service implementation")
+	ErrProviderCapabilityNotSupported = errors.New("capability is not supported by the provider")
+	ErrProviderRequestIncomplete      = errors.New("provider request is incomplete")
+	ErrProviderEndpointNotURL         = errors.New("provider endpoint is not an http(s) base url")
+)
+
+// ExecutionToolCall is a call of a tool the model asks for. the ID is sent back with the tool response (TOOL_RESPONSE_METADATA_JOB_ID).
+type ExecutionToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // raw json object as returned by the model
+} //@name ExecutionToolCall
+
+// GetArguments decodes the raw json Arguments, empty arguments are an empty map
+func (toolCall ExecutionToolCall) GetArguments() (arguments map[string]any, err error) {
+	arguments = make(map[string]any)
+	if toolCall.Arguments == "" {
+		return arguments, nil
+	}
+	err = json.Unmarshal([]byte(toolCall.Arguments), &arguments)
+	return arguments, err
+}
+
+// ExecutionToolCallDelta is a streamed part of a tool call, parts with the same Index belong to the same call
+type ExecutionToolCallDelta struct {
+	Index          int    `json:"index"`
+	ID             string `json:"id,omitempty"`
+	Name           string `json:"name,omitempty"`
+	ArgumentsDelta string `json:"arguments_delta,omitempty"`
+} //@name ExecutionToolCallDelta
+
+type ExecutionResultEmbeddings struct {
+	ExecutionResult
+	Embeddings  [][]float32 `json:"embeddings"` // one vector per input, in the order of the inputs
+	InputTokens int         `json:"input_tokens"`
+}
+
+type ProviderGeneratedImage struct {
+	URL           string `json:"url,omitempty"`
+	Data          []byte `json:"data,omitempty"`
+	MimeType      string `json:"mimetype"`
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+} //@name ProviderGeneratedImage
+
+type ExecutionResultImages struct {
+	ExecutionResult
+	Images []ProviderGeneratedImage `json:"images"`
+}
+
+type ExecutionResultSpeech struct {
+	ExecutionResult
+	Audio      []byte `json:"audio"`
+	MimeType   string `json:"mimetype"`
+	Characters int    `json:"characters"`
+}
+
+// ProviderChatRequest is a chat completion for one model. Parameters are the effective parameters, see ResolveCompletionParameters.
+type ProviderChatRequest struct {
+	ExecutionID string              `json:"execution_id"`
+	Model       *AIModel            `json:"model"`
+	Messages    *AIgencyMessageList `json:"messages"`
+	Tools       []OpenaiFunction    `json:"tools"`
+	ToolChoice  string              `json:"tool_choice"` // auto, none, required or the name of a tool, empty for the provider default
+	Parameters  map[string]any      `json:"parameters"`
+} //@name ProviderChatRequest
+
+// ProviderChatDelta is one streamed part of a chat completion, the last one carries the FinishReason
+type ProviderChatDelta struct {
+	ExecutionID  string                  `json:"execution_id"`
+	Content      string                  `json:"content,omitempty"`
+	ToolCall     *ExecutionToolCallDelta `json:"tool_call,omitempty"`
+	FinishReason string                  `json:"finish_reason,omitempty"`
+} //@name ProviderChatDelta
+
+// ProviderDeltaHandler receives the deltas of a stream in order, an error stops the stream
+type ProviderDeltaHandler func(delta ProviderChatDelta) error
+
+type ProviderEmbeddingRequest struct {
+	ExecutionID string   `json:"execution_id"`
+	Model       *AIModel `json:"model"`
+	Inputs      []string `json:"inputs"`
+	Dimensions  int      `json:"dimensions"` // 0 for the model default
+} //@name ProviderEmbeddingRequest
+
+type ProviderImageRequest struct {
+	ExecutionID string   `json:"execution_id"`
+	Model       *AIModel `json:"model"`
+	Prompt      string   `json:"prompt"`
+	Count       int      `json:"count"` // 0 for one image
+	Width       int      `json:"width"`
+	Height      int      `json:"height"`
+	Quality     string   `json:"quality"`
+} //@name ProviderImageRequest
+
+type ProviderSpeechRequest struct {
+	ExecutionID string   `json:"execution_id"`
+	Model       *AIModel `json:"model"`
+	Input       string   `json:"input"`
+	Voice       string   `json:"voice"`
+	Format      string   `json:"format"` // e.g. mp3, opus, wav
+	Speed       float64  `json:"speed"`  // 0 for the provider default
+} //@name ProviderSpeechRequest
+
+// Provider runs requests against the api of an AI model service. results carry ModelID, ServiceID and the Usages
+// of the execution, so they can be priced with ExecutionResult.CalculateCost. capabilities a provider does not have
+// return ErrProviderCapabilityNotSupported.
+type Provider interface {
+	GetServiceImpl() string
+	ChatCompletion(ctx context.Context, request ProviderChatRequest) (result *ExecutionResultText2Text, err error)
+	// ChatCompletionStream calls onDelta for every part of the answer and returns the complete result at the end of the stream
+	ChatCompletionStream(ctx context.Context, request ProviderChatRequest, onDelta ProviderDeltaHandler) (result *ExecutionResultText2Text, err error)
+	CreateEmbeddings(ctx context.Context, request ProviderEmbeddingRequest) (result *ExecutionResultEmbeddings, err error)
+	GenerateImages(ctx context.Context, request ProviderImageRequest) (result *ExecutionResultImages, err error)
+	GenerateSpeech(ctx context.Context, request ProviderSpeechRequest) (result *ExecutionResultSpeech, err error)
+}
+
+// ProviderFactory creates the provider for a service, e.g. with its credentials. endpoint is the base url the provider
+// has to send its requests to (the ResidencyDecision.Endpoint), the configured one of the provider if empty.
+type ProviderFactory func(service *AIModelServiceObject, endpoint string) (provider Provider, err error)
+
+var (
+	providerFactories       = make(map[string]ProviderFactory)
+	providerFactoriesSafety sync.RWMutex
+)
+
+// RegisterProvider makes factory the provider of the services with serviceImpl, replacing a registered one
+func RegisterProvider(serviceImpl string, factory ProviderFactory) {
+	providerFactoriesSafety.Lock()
+	defer providerFactoriesSafety.Unlock()
+	providerFactories[serviceImpl] = factory
+}
+
+// UnregisterProvider removes the provider of serviceImpl
+func UnregisterProvider(serviceImpl string) {
+	providerFactoriesSafety.Lock()
+	defer providerFactoriesSafety.Unlock()
+	delete(providerFactories, serviceImpl)
+}
+
+// GetRegisteredProviderImpls returns the sorted service implementations with a provider
+func GetRegisteredProviderImpls() (serviceImpls []string) {
+	providerFactoriesSafety.RLock()
+	defer providerFactoriesSafety.RUnlock()
+	serviceImpls = make([]string, 0, len(providerFactories))
+	for serviceImpl := range providerFactories {
+		serviceImpls = append(serviceImpls, serviceImpl)
+	}
+	sort.Strings(serviceImpls)
+	return serviceImpls
+}
+
+// NewProviderForService creates the provider registered for the ServiceImpl of the service, sending to the endpoint
+// picked by ResolveCompliantEndpoint (empty for the configured endpoint of the provider)
+func NewProviderForService(service *AIModelServiceObject, endpoint string) (provider Provider, err error) {
+	var logName string = "[NewProviderForService] "
+	if service == nil {
+		return nil, fmt.Errorf("%w: no service", ErrProviderNotRegistered)
+	}
+	providerFactoriesSafety.RLock()
+	factory, isRegistered := providerFactories[service.ServiceImpl]
+	providerFactoriesSafety.RUnlock()
+	if !isRegistered {
+		return nil, fmt.Errorf("%w: service(%s) impl(%s)", ErrProviderNotRegistered, service.ID, service.ServiceImpl)
+	}
+	provider, err = factory(service, endpoint)
+	if err != nil {
+		nuts.L.Errorf("%sfailed to create provider(%s) for service(%s) endpoint(%s): %v", logName, service.ServiceImpl, service.ID, endpoint, err)
+		return nil, err
+	}
+	return provider, nil
+}
+
+// getProviderBaseURL returns the endpoint a factory was given, configuredBaseURL if it is empty
+func getProviderBaseURL(endpoint string, configuredBaseURL string) (baseURL string, err error) {
+	if endpoint == "" {
+		return configuredBaseURL, nil
+	}
+	if !IsProviderEndpointURL(endpoint) {
+		return "", fmt.Errorf("%w: (%s)", ErrProviderEndpointNotURL, endpoint)
+	}
+	return endpoint, nil
+}
+
+// IsProviderEndpointURL tells if a key of AIModelServiceObject.HostingLocations is a base url providers can send to
+func IsProviderEndpointURL(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "https://") || strings.HasPrefix(endpoint, "http://")
+}
+
+// Validate checks the fields every provider needs
+func (request ProviderChatRequest) Validate() (err error) {
+	if request.Model == nil {
+		return fmt.Errorf("%w: no model", ErrProviderRequestIncomplete)
+	}
+	if request.Messages == nil || len(request.Messages.GetMessages()) == 0 {
+		return fmt.Errorf("%w: no messages", ErrProviderRequestIncomplete)
+	}
+	return nil
+}
+
+// GetMaxTokens returns the max_tokens parameter, 0 if it is not set
+func (request ProviderChatRequest) GetMaxTokens() int {
+	maxTokens, _ := toFloat64(request.Parameters[COMPLETION_PARAMETER_MAX_TOKENS])
+	return int(maxTokens)
+}
+
+// NewExecutionResultText2Text creates the result of a chat completion with the text2text usages set from the counts
+func NewExecutionResultText2Text(executionID string, aiModel *AIModel, content string, toolCalls []ExecutionToolCall, finishReason string, inputTokens int, outputTokens int, startedAt time.Time) *ExecutionResultText2Text {
+	result := &ExecutionResultText2Text{
+		ExecutionResult: ExecutionResult{
+			ExecutionID:  executionID,
+			Timestamp:    nuts.TimeToJSTimestamp(startedAt),
+			TimeNeeded:   time.Since(startedAt).Milliseconds(),
+			FinishReason: finishReason,
+			Usages:       NewText2TextExecutionUsages(len(toolCalls), inputTokens, outputTokens),
+		},
+		Content:      content,
+		FinishReason: finishReason,
+		InputTokens:  inputTokens,
+		OutputTokens: outputTokens,
+		ToolCalls:    toolCalls,
+	}
+	if aiModel != nil {
+		result.ModelID = aiModel.ID
+		result.ServiceID = aiModel.ServiceID
+	}
+	return result
+}
+
+// SetToolCalls stores the tool calls of an assistant message in its MetaData
+func (msg *AIgencyMessage) SetToolCalls(toolCalls []ExecutionToolCall) {
+	if msg.MetaData == nil {
+		msg.MetaData = make(map[string]any)
+	}
+	if len(toolCalls) == 0 {
+		delete(msg.MetaData, AIGENCYMESSAGE_METADATA_TOOL_CALLS)
+		return
+	}
+	msg.MetaData[AIGENCYMESSAGE_METADATA_TOOL_CALLS] = toolCalls
+}
+
+// GetToolCalls returns the tool calls of an assistant message, also after the MetaData went through json
+func (msg *AIgencyMessage) GetToolCalls() (toolCalls []ExecutionToolCall) {
+	if msg.MetaData == nil {
+		return nil
+	}
+	switch value := msg.MetaData[AIGENCYMESSAGE_METADATA_TOOL_CALLS].(type) {
+	case nil:
+		return nil
+	case []ExecutionToolCall:
+		return value
+	default:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil
+		}
+		if err = json.Unmarshal(data, &toolCalls); err != nil {
+			nuts.L.Errorf("[AIgencyMessage.GetToolCalls] message(%s) has invalid tool calls: %v", msg.ID, err)
+			return nil
+		}
+		return toolCalls
+	}
+}
+
+// NewAIgencyMessageFromText2TextResult creates the assistant message of a chat completion result in the thread of responseTo (may be nil)
+func NewAIgencyMessageFromText2TextResult(result *ExecutionResultText2Text, responseTo *AIgencyMessage) (msg *AIgencyMessage) {
+	msg = newProviderAssistantMessage(AIgencyMessageTypeMessage, result.ModelID, result.ServiceID, responseTo)
+	if result.Content != "" {
+		text := result.Content
+		msg.Content.AddContent(NewAIgencyMessageContent(AIgencyMessageContentTypeText, &text, nil))
+	}
+	msg.SetToolCalls(result.ToolCalls)
+	msg.TokenCount = result.OutputTokens
+	msg.ErrorMessage = result.ErrorMessage
+	return msg
+}
+
+// NewAIgencyMessageFromDelta creates the delta message of a streamed part in the thread of responseTo (may be nil)
+func NewAIgencyMessageFromDelta(delta ProviderChatDelta, aiModel *AIModel, responseTo *AIgencyMessage) (msg *AIgencyMessage) {
+	modelID, serviceID := "", ""
+	if aiModel != nil {
+		modelID, serviceID = aiModel.ID, aiModel.ServiceID
+	}
+	msg = newProviderAssistantMessage(AIgencyMessageTypeDelta, modelID, serviceID, responseTo)
+	msg.ReferenceID = delta.ExecutionID
+	if delta.Content != "" {
+		text := delta.Content
+		msg.Content.AddContent(NewAIgencyMessageContent(AIgencyMessageContentTypeText, &text, nil))
+	}
+	if delta.ToolCall != nil {
+		msg.MetaData[AIGENCYMESSAGE_METADATA_TOOL_CALLS] = []ExecutionToolCallDelta{*delta.ToolCall}
+	}
+	if delta.FinishReason != "" {
+		msg.MetaData[AIGENCYMESSAGE_METADATA_FINISH_REASON] = delta.FinishReason
+	}
+	return msg
+}
+
+// NewAIgencyDeltaMessageHandler returns a ProviderDeltaHandler that passes every streamed part on as a delta message
+func NewAIgencyDeltaMessageHandler(aiModel *AIModel, responseTo *AIgencyMessage, onMessage func(msg *AIgencyMessage) error) ProviderDeltaHandler {
+	return func(delta ProviderChatDelta) error {
+		return onMessage(NewAIgencyMessageFromDelta(delta, aiModel, responseTo))
+	}
+}
+
+func newProviderAssistantMessage(messageType AIgencyMessageType, modelID string, serviceID string, responseTo *AIgencyMessage) (msg *AIgencyMessage) {
+	msg = NewAIgencyMessage()
+	msg.Type = messageType
+	msg.SenderID = modelID
+	msg.SenderName = modelID
+	msg.SenderConversationRole = ConversationRoleAssistant
+	msg.TokenDirection = OutputToken
+	msg.AIModelID = modelID
+	msg.AIServiceID = serviceID
+	if responseTo != nil {
+		msg.ResponseToID = responseTo.ID
+		msg.MissionID = responseTo.MissionID
+		msg.ChannelID = responseTo.ChannelID
+		msg.ChannelName = responseTo.ChannelName
+		msg.AIgentThreadID = responseTo.AIgentThreadID
+		msg.OwnerOrganizationId = responseTo.OwnerOrganizationId
+	}
+	return msg
+}
+
+// UnsupportedProviderCapabilities is embedded by providers to return ErrProviderCapabilityNotSupported for the capabilities they do not implement
+type UnsupportedProviderCapabilities struct{}
+
+func (UnsupportedProviderCapabilities) ChatCompletion(ctx context.Context, request ProviderChatRequest) (result *ExecutionResultText2Text, err error) {
+	return nil, fmt.Errorf("%w: %s", ErrProviderCapabilityNotSupported, AIModelCapabilityTextToText)
+}
+
+func (UnsupportedProviderCapabilities) ChatCompletionStream(ctx context.Context, request ProviderChatRequest, onDelta ProviderDeltaHandler) (result *ExecutionResultText2Text, err error) {
+	return nil, fmt.Errorf("%w: %s", ErrProviderCapabilityNotSupported, AIModelCapabilityTextToTextStreaming)
+}
+
+func (UnsupportedProviderCapabilities) CreateEmbeddings(ctx context.Context, request ProviderEmbeddingRequest) (result *ExecutionResultEmbeddings, err error) {
+	return nil, fmt.Errorf("%w: %s", ErrProviderCapabilityNotSupported, AIModelCapabilityEmbeddings)
+}
+
+func (UnsupportedProviderCapabilities) GenerateImages(ctx context.Context, request ProviderImageRequest) (result *ExecutionResultImages, err error) {
+	return nil, fmt.Errorf("%w: %s", ErrProviderCapabilityNotSupported, AIModelCapabilityTextToImage)
+}
+
+func (UnsupportedProviderCapabilities) GenerateSpeech(ctx context.Context, request ProviderSpeechRequest) (result *ExecutionResultSpeech, err error) {
+	return nil, fmt.Errorf("%w: %s", ErrProviderCapabilityNotSupported, AIModelCapabilityTextToSpeech)
+}
+
+// ClassifyHTTPStatusCode returns the ExecutionErrorClass of an http error status of a provider api
+func ClassifyHTTPStatusCode(statusCode int) ExecutionErrorClass {
+	switch {
+	case statusCode == 429:
+		return ExecutionErrorClassRateLimited
+	case statusCode == 401 || statusCode == 403:
+		return ExecutionErrorClassAuthentication
+	case statusCode == 408 || statusCode == 504:
+		return ExecutionErrorClassTimeout
+	case statusCode == 503 || statusCode == 529:
+		return ExecutionErrorClassUnavailable
+	case statusCode >= 500:
+		return ExecutionErrorClassServerError
+	case statusCode >= 400:
+		return ExecutionErrorClassInvalidRequest
+	}
+	return ExecutionErrorClassUnknown
+}
@@ -0,0 +1,329 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	nuts "github.com/vaudience/go-nuts"
+)
+
+const (
+	MOCK_PROVIDER_SERVICE_IMPL        = "mock"
+	MOCK_PROVIDER_EMBEDDING_DIMENSION = 8
+)
+
+const (
+	MockProviderOperationChat       = "chat"
+	MockProviderOperationChatStream = "chat-stream"
+	MockProviderOperationEmbeddings = "embeddings"
+	MockProviderOperationImages     = "images"
+	MockProviderOperationSpeech     = "speech"
+)
+
+var (
+	ErrMockProviderScriptExhausted = errors.New("mock provider has no scripted response left")
+)
+
+// MOCK_PROVIDER_TIME is the Timestamp of all mock results unless MockProvider.Now is set
+var MOCK_PROVIDER_TIME = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// MockProviderResponse is one scripted answer of the MockProvider. empty fields are derived from the request,
+// so the same script and requests always give the same results.
+type MockProviderResponse struct {
+	Content      string
+	ToolCalls    []ExecutionToolCall
+	FinishReason string // stop, or tool_calls if there are ToolCalls, when empty
+	InputTokens  int    // the words of the request when 0
+	OutputTokens int    // the words of Content when 0
+	Err          error  // returned instead of a result, e.g. an ExecutionError to test failover
+	Embeddings   [][]float32
+	Images       []ProviderGeneratedImage
+	Audio        []byte
+}
+
+// MockProviderCall is a request the MockProvider received
+type MockProviderCall struct {
+	Operation string
+	Request   any
+}
+
+// MockProvider is a deterministic Provider for tests. every call of any operation replays the next scripted response
+// and is recorded, an exhausted script returns ErrMockProviderScriptExhausted. invalid chat requests are rejected
+// like a real provider would, without taking a response of the script.
+type MockProvider struct {
+	ServiceImpl      string
+	StreamChunkWords int              // words per streamed content delta, 1 if 0
+	Now              func() time.Time // clock of the results, MOCK_PROVIDER_TIME if nil
+	safety           sync.Mutex
+	responses        []MockProviderResponse
+	calls            []MockProviderCall
+}
+
+func NewMockProvider(responses ...MockProviderResponse) *MockProvider {
+	return &MockProvider{
+		ServiceImpl: MOCK_PROVIDER_SERVICE_IMPL,
+		responses:   responses,
+		calls:       make([]MockProviderCall, 0),
+	}
+}
+
+// NewMockProviderFactory returns a factory that hands out provider for every service, to be used with RegisterProvider
+func NewMockProviderFactory(provider *MockProvider) ProviderFactory {
+	return func(service *AIModelServiceObject, endpoint string) (Provider, error) {
+		return provider, nil
+	}
+}
+
+func (provider *MockProvider) GetServiceImpl() string {
+	return provider.ServiceImpl
+}
+
+// AddResponses appends responses to the script
+func (provider *MockProvider) AddResponses(responses ...MockProviderResponse) {
+	provider.safety.Lock()
+	defer provider.safety.Unlock()
+	provider.responses = append(provider.responses, responses...)
+}
+
+// GetCalls returns the recorded requests in the order they were received
+func (provider *MockProvider) GetCalls() []MockProviderCall {
+	provider.safety.Lock()
+	defer provider.safety.Unlock()
+	return append([]MockProviderCall{}, provider.calls...)
+}
+
+// GetRemainingResponses returns the number of scripted responses not replayed yet
+func (provider *MockProvider) GetRemainingResponses() int {
+	provider.safety.Lock()
+	defer provider.safety.Unlock()
+	return len(provider.responses)
+}
+
+func (provider *MockProvider) ChatCompletion(ctx context.Context, request ProviderChatRequest) (result *ExecutionResultText2Text, err error) {
+	if err = request.Validate(); err != nil {
+		return nil, err
+	}
+	response, executionID, err := provider.next(ctx, MockProviderOperationChat, request, request.ExecutionID)
+	if err != nil {
+		return nil, err
+	}
+	return provider.newText2TextResult(request, response, executionID), nil
+}
+
+// ChatCompletionStream streams the content in chunks of StreamChunkWords words, then every tool call as a delta with
+// its id and name followed by two argument halves, then the finish reason
+func (provider *MockProvider) ChatCompletionStream(ctx context.Context, request ProviderChatRequest, onDelta ProviderDeltaHandler) (result *ExecutionResultText2Text, err error) {
+	if err = request.Validate(); err != nil {
+		return nil, err
+	}
+	response, executionID, err := provider.next(ctx, MockProviderOperationChatStream, request, request.ExecutionID)
+	if err != nil {
+		return nil, err
+	}
+	result = provider.newText2TextResult(request, response, executionID)
+	deltas := make([]ProviderChatDelta, 0)
+	chunkWords := provider.StreamChunkWords
+	if chunkWords <= 0 {
+		chunkWords = 1
+	}
+	words := strings.SplitAfter(result.Content, " ")
+	for n := 0; n < len(words) && result.Content != ""; n += chunkWords {
+		end := n + chunkWords
+		if end > len(words) {
+			end = len(words)
+		}
+		deltas = append(deltas, ProviderChatDelta{ExecutionID: executionID, Content: strings.Join(words[n:end], "")})
+	}
+	for index, toolCall := range result.ToolCalls {
+		half := len(toolCall.Arguments) / 2
+		deltas = append(deltas,
+			ProviderChatDelta{ExecutionID: executionID, ToolCall: &ExecutionToolCallDelta{Index: index, ID: toolCall.ID, Name: toolCall.Name}},
+			ProviderChatDelta{ExecutionID: executionID, ToolCall: &ExecutionToolCallDelta{Index: index, ArgumentsDelta: toolCall.Arguments[:half]}},
+			ProviderChatDelta{ExecutionID: executionID, ToolCall: &ExecutionToolCallDelta{Index: index, ArgumentsDelta: toolCall.Arguments[half:]}},
+		)
+	}
+	deltas = append(deltas, ProviderChatDelta{ExecutionID: executionID, FinishReason: result.FinishReason})
+	for _, delta := range deltas {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err = onDelta(delta); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// CreateEmbeddings returns the scripted embeddings or vectors derived from a hash of every input
+func (provider *MockProvider) CreateEmbeddings(ctx context.Context, request ProviderEmbeddingRequest) (result *ExecutionResultEmbeddings, err error) {
+	response, executionID, err := provider.next(ctx, MockProviderOperationEmbeddings, request, request.ExecutionID)
+	if err != nil {
+		return nil, err
+	}
+	result = &ExecutionResultEmbeddings{
+		ExecutionResult: provider.newExecutionResult(request.Model, executionID),
+		Embeddings:      response.Embeddings,
+		InputTokens:     response.InputTokens,
+	}
+	if result.Embeddings == nil {
+		dimensions := request.Dimensions
+		if dimensions <= 0 {
+			dimensions = MOCK_PROVIDER_EMBEDDING_DIMENSION
+		}
+		result.Embeddings = make([][]float32, 0, len(request.Inputs))
+		for _, input := range request.Inputs {
+			result.Embeddings = append(result.Embeddings, newMockEmbedding(input, dimensions))
+		}
+	}
+	if result.InputTokens == 0 {
+		result.InputTokens = countMockWords(request.Inputs...)
+	}
+	result.Usages = []ExecutionUsage{NewExecutionUsage(AIModelCapabilityEmbeddings, AIModelCostUnitInputPerMillionTokens, float64(result.InputTokens))}
+	return result, nil
+}
+
+// GenerateImages returns the scripted images or request.Count png placeholders carrying the prompt as data
+func (provider *MockProvider) GenerateImages(ctx context.Context, request ProviderImageRequest) (result *ExecutionResultImages, err error) {
+	response, executionID, err := provider.next(ctx, MockProviderOperationImages, request, request.ExecutionID)
+	if err != nil {
+		return nil, err
+	}
+	result = &ExecutionResultImages{
+		ExecutionResult: provider.newExecutionResult(request.Model, executionID),
+		Images:          response.Images,
+	}
+	if result.Images == nil {
+		count := request.Count
+		if count <= 0 {
+			count = 1
+		}
+		result.Images = make([]ProviderGeneratedImage, 0, count)
+		for n := 0; n < count; n++ {
+			result.Images = append(result.Images, ProviderGeneratedImage{Data: []byte(request.Prompt), MimeType: "image/png", RevisedPrompt: request.Prompt})
+		}
+	}
+	result.Usages = []ExecutionUsage{NewExecutionUsage(AIModelCapabilityTextToImage, AIModelCostUnitImageGenerationPerImage, float64(len(result.Images)))}
+	if request.Width > 0 && request.Height > 0 {
+		result.Usages = append(result.Usages, NewImageGenerationPixelUsage(request.Width, request.Height, len(result.Images)))
+	}
+	return result, nil
+}
+
+// GenerateSpeech returns the scripted audio or the input as audio data
+func (provider *MockProvider) GenerateSpeech(ctx context.Context, request ProviderSpeechRequest) (result *ExecutionResultSpeech, err error) {
+	response, executionID, err := provider.next(ctx, MockProviderOperationSpeech, request, request.ExecutionID)
+	if err != nil {
+		return nil, err
+	}
+	format := request.Format
+	if format == "" {
+		format = "mp3"
+	}
+	result = &ExecutionResultSpeech{
+		ExecutionResult: provider.newExecutionResult(request.Model, executionID),
+		Audio:           response.Audio,
+		MimeType:        "audio/" + format,
+		Characters:      len([]rune(request.Input)),
+	}
+	if result.Audio == nil {
+		result.Audio = []byte(request.Input)
+	}
+	result.Usages = []ExecutionUsage{NewExecutionUsage(AIModelCapabilityTextToSpeech, AIModelCostUnitInputPerMillionCharacters, float64(result.Characters))}
+	return result, nil
+}
+
+// next records the call and takes the next scripted response, a scripted Err is returned as the error
+func (provider *MockProvider) next(ctx context.Context, operation string, request any, executionID string) (response MockProviderResponse, nextExecutionID string, err error) {
+	var logName string = "[MockProvider.next] "
+	provider.safety.Lock()
+	defer provider.safety.Unlock()
+	provider.calls = append(provider.calls, MockProviderCall{Operation: operation, Request: request})
+	if executionID == "" {
+		executionID = fmt.Sprintf("mock-%d", len(provider.calls))
+	}
+	if err = ctx.Err(); err != nil {
+		return response, executionID, err
+	}
+	if len(provider.responses) == 0 {
+		nuts.L.Errorf("%s%s call (%d) has no scripted response", logName, operation, len(provider.calls))
+		return response, executionID, fmt.Errorf("%w: %s call (%d)", ErrMockProviderScriptExhausted, operation, len(provider.calls))
+	}
+	response = provider.responses[0]
+	provider.responses = provider.responses[1:]
+	if response.Err != nil {
+		return response, executionID, response.Err
+	}
+	return response, executionID, nil
+}
+
+func (provider *MockProvider) newExecutionResult(aiModel *AIModel, executionID string) ExecutionResult {
+	now := MOCK_PROVIDER_TIME
+	if provider.Now != nil {
+		now = provider.Now()
+	}
+	result := ExecutionResult{
+		ExecutionID: executionID,
+		Timestamp:   nuts.TimeToJSTimestamp(now),
+	}
+	if aiModel != nil {
+		result.ModelID = aiModel.ID
+		result.ServiceID = aiModel.ServiceID
+	}
+	return result
+}
+
+func (provider *MockProvider) newText2TextResult(request ProviderChatRequest, response MockProviderResponse, executionID string) *ExecutionResultText2Text {
+	finishReason := response.FinishReason
+	if finishReason == "" {
+		finishReason = "stop"
+		if len(response.ToolCalls) > 0 {
+			finishReason = "tool_calls"
+		}
+	}
+	inputTokens := response.InputTokens
+	if inputTokens == 0 {
+		for _, msg := range request.Messages.GetMessages() {
+			if msg != nil && msg.Content != nil {
+				inputTokens += countMockWords(msg.Content.GetConcatenatedText(false, false))
+			}
+		}
+	}
+	outputTokens := response.OutputTokens
+	if outputTokens == 0 {
+		outputTokens = countMockWords(response.Content)
+	}
+	result := &ExecutionResultText2Text{
+		ExecutionResult: provider.newExecutionResult(request.Model, executionID),
+		Content:         response.Content,
+		FinishReason:    finishReason,
+		InputTokens:     inputTokens,
+		OutputTokens:    outputTokens,
+		ToolCalls:       append([]ExecutionToolCall{}, response.ToolCalls...),
+	}
+	result.ExecutionResult.FinishReason = finishReason
+	result.Usages = NewText2TextExecutionUsages(len(result.ToolCalls), inputTokens, outputTokens)
+	return result
+}
+
+// countMockWords counts words instead of tokens, the tokenizer would make the mock depend on downloaded encodings
+func countMockWords(texts ...string) (words int) {
+	for _, text := range texts {
+		words += len(strings.Fields(text))
+	}
+	return words
+}
+
+func newMockEmbedding(input string, dimensions int) (embedding []float32) {
+	embedding = make([]float32, dimensions)
+	for n := range embedding {
+		hash := fnv.New32a()
+		hash.Write([]byte(fmt.Sprintf("%d:%s", n, input)))
+		embedding[n] = float32(hash.Sum32()%2000)/1000 - 1
+	}
+	return embedding
+}
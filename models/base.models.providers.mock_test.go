@@ -0,0 +1,156 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func newTestMockChatRequest(text string) ProviderChatRequest {
+	msg := NewAIgencyMessage()
+	msg.SenderConversationRole = ConversationRoleUser
+	msg.Content.AddContent(NewAIgencyMessageContent(AIgencyMessageContentTypeText, &text, nil))
+	messages := NewAIgencyMessageList()
+	messages.AddMessage(msg)
+	return ProviderChatRequest{Model: &AIModel{ID: "mock-model", ServiceID: "mock-service"}, Messages: messages}
+}
+
+func getMockProviderOperations(calls []MockProviderCall) (operations []string) {
+	operations = make([]string, 0)
+	for _, call := range calls {
+		operations = append(operations, call.Operation)
+	}
+	return operations
+}
+
+func TestMockProviderReplaysTheScriptInOrder(t *testing.T) {
+	ctx := context.Background()
+	provider := NewMockProvider(
+		MockProviderResponse{Content: "first answer"},
+		MockProviderResponse{Embeddings: [][]float32{{1, 2}}},
+	)
+	provider.AddResponses(MockProviderResponse{Content: "second", InputTokens: 42})
+
+	first, err := provider.ChatCompletion(ctx, newTestMockChatRequest("one two three"))
+	if err != nil {
+		t.Fatalf("first ChatCompletion() error = %v", err)
+	}
+	embeddings, err := provider.CreateEmbeddings(ctx, ProviderEmbeddingRequest{Inputs: []string{"a b"}})
+	if err != nil {
+		t.Fatalf("CreateEmbeddings() error = %v", err)
+	}
+	second, err := provider.ChatCompletion(ctx, newTestMockChatRequest("four"))
+	if err != nil {
+		t.Fatalf("second ChatCompletion() error = %v", err)
+	}
+
+	if first.ExecutionID != "mock-1" || first.Content != "first answer" || first.InputTokens != 3 || first.OutputTokens != 2 || first.FinishReason != "stop" {
+		t.Errorf("first result = %+v, want the first response with 3 input and 2 output words", first)
+	}
+	if !reflect.DeepEqual(embeddings.Embeddings, [][]float32{{1, 2}}) || embeddings.ExecutionID != "mock-2" {
+		t.Errorf("embeddings = %+v, want the scripted vectors", embeddings)
+	}
+	if second.ExecutionID != "mock-3" || second.Content != "second" || second.InputTokens != 42 || second.ModelID != "mock-model" || second.Timestamp != MOCK_PROVIDER_TIME.UnixMilli() {
+		t.Errorf("second result = %+v, want the added response with the scripted 42 input tokens", second)
+	}
+	if got, want := getMockProviderOperations(provider.GetCalls()), []string{MockProviderOperationChat, MockProviderOperationEmbeddings, MockProviderOperationChat}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+	if remaining := provider.GetRemainingResponses(); remaining != 0 {
+		t.Errorf("GetRemainingResponses() = %d, want 0", remaining)
+	}
+}
+
+func TestMockProviderStreamReassemblesToTheSameResult(t *testing.T) {
+	response := MockProviderResponse{
+		Content: "the weather in berlin",
+		ToolCalls: []ExecutionToolCall{
+			{ID: "call_1", Name: "get_weather", Arguments: `{"city":"berlin","unit":"celsius"}`},
+			{ID: "call_2", Name: "get_time", Arguments: `{}`},
+		},
+	}
+	provider := NewMockProvider(response, response)
+	provider.StreamChunkWords = 3
+
+	completed, err := provider.ChatCompletion(context.Background(), newTestMockChatRequest("weather?"))
+	if err != nil {
+		t.Fatalf("ChatCompletion() error = %v", err)
+	}
+	content := ""
+	contentDeltas := 0
+	finishReasons := make([]string, 0)
+	toolCalls := make([]ExecutionToolCall, 0)
+	streamed, err := provider.ChatCompletionStream(context.Background(), newTestMockChatRequest("weather?"), func(delta ProviderChatDelta) error {
+		if delta.Content != "" {
+			content += delta.Content
+			contentDeltas++
+		}
+		if delta.ToolCall != nil {
+			for len(toolCalls) <= delta.ToolCall.Index {
+				toolCalls = append(toolCalls, ExecutionToolCall{})
+			}
+			toolCall := &toolCalls[delta.ToolCall.Index]
+			if delta.ToolCall.ID != "" {
+				toolCall.ID, toolCall.Name = delta.ToolCall.ID, delta.ToolCall.Name
+			}
+			toolCall.Arguments += delta.ToolCall.ArgumentsDelta
+		}
+		if delta.FinishReason != "" {
+			finishReasons = append(finishReasons, delta.FinishReason)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ChatCompletionStream() error = %v", err)
+	}
+
+	if content != completed.Content || contentDeltas != 2 {
+		t.Errorf("streamed content %q in %d deltas, want %q in 2 deltas of 3 words", content, contentDeltas, completed.Content)
+	}
+	if !reflect.DeepEqual(toolCalls, completed.ToolCalls) || !reflect.DeepEqual(streamed.ToolCalls, completed.ToolCalls) {
+		t.Errorf("reassembled tool calls = %+v, want %+v", toolCalls, completed.ToolCalls)
+	}
+	if !reflect.DeepEqual(finishReasons, []string{"tool_calls"}) || streamed.FinishReason != "tool_calls" {
+		t.Errorf("finish reasons = %v, want one tool_calls as the last delta", finishReasons)
+	}
+	if streamed.OutputTokens != completed.OutputTokens || !reflect.DeepEqual(streamed.Usages, completed.Usages) {
+		t.Errorf("streamed usages = %+v, want the usages of the completion %+v", streamed.Usages, completed.Usages)
+	}
+}
+
+func TestMockProviderErrors(t *testing.T) {
+	ctx := context.Background()
+	scripted := NewExecutionError(ExecutionErrorClassRateLimited, 429, errors.New("slow down"))
+	provider := NewMockProvider(MockProviderResponse{Err: scripted})
+
+	invalid := newTestMockChatRequest("hello")
+	invalid.Model = nil
+	if _, err := provider.ChatCompletion(ctx, invalid); !errors.Is(err, ErrProviderRequestIncomplete) {
+		t.Fatalf("ChatCompletion() of an invalid request error = %v, want %v", err, ErrProviderRequestIncomplete)
+	}
+	if _, err := provider.ChatCompletionStream(ctx, ProviderChatRequest{Model: invalid.Model}, func(ProviderChatDelta) error { return nil }); !errors.Is(err, ErrProviderRequestIncomplete) {
+		t.Fatalf("ChatCompletionStream() of an invalid request error = %v, want %v", err, ErrProviderRequestIncomplete)
+	}
+	if remaining, calls := provider.GetRemainingResponses(), provider.GetCalls(); remaining != 1 || len(calls) != 0 {
+		t.Fatalf("invalid requests left %d responses and recorded %d calls, want the script untouched", remaining, len(calls))
+	}
+
+	var executionError *ExecutionError
+	if _, err := provider.ChatCompletion(ctx, newTestMockChatRequest("hello")); !errors.As(err, &executionError) || executionError != scripted {
+		t.Fatalf("ChatCompletion() error = %v, want the scripted %v", err, scripted)
+	}
+	_, err := provider.ChatCompletionStream(ctx, newTestMockChatRequest("hello"), func(ProviderChatDelta) error {
+		t.Fatalf("delta of an exhausted script")
+		return nil
+	})
+	if !errors.Is(err, ErrMockProviderScriptExhausted) {
+		t.Fatalf("ChatCompletionStream() error = %v, want %v", err, ErrMockProviderScriptExhausted)
+	}
+	if _, err = provider.GenerateSpeech(ctx, ProviderSpeechRequest{Input: "hi"}); !errors.Is(err, ErrMockProviderScriptExhausted) {
+		t.Fatalf("GenerateSpeech() error = %v, want %v", err, ErrMockProviderScriptExhausted)
+	}
+	if got, want := getMockProviderOperations(provider.GetCalls()), []string{MockProviderOperationChat, MockProviderOperationChatStream, MockProviderOperationSpeech}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want the failed calls recorded %v", got, want)
+	}
+}
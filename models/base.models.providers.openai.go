@@ -0,0 +1,476 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	goopenai "github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+	nuts "github.com/vaudience/go-nuts"
+)
+
+const (
+	OPENAI_PROVIDER_SERVICE_IMPL = "openai"
+	// config keys of NewOpenAIProviderFromConfig
+	OPENAI_API_KEY_CONFIG_KEY  = "OPENAI_API_KEY"
+	OPENAI_BASE_URL_CONFIG_KEY = "OPENAI_BASE_URL" // for openai compatible apis, the openai api if empty
+	OPENAI_ORG_ID_CONFIG_KEY   = "OPENAI_ORG_ID"
+
+	OPENAI_ROLE_TOOL = "tool"
+
+	// sent for a temperature or top_p of 0, which go-openai would leave out. it is greedy sampling all the same.
+	OPENAI_EXPLICIT_ZERO_SAMPLING_VALUE = math.SmallestNonzeroFloat32
+)
+
+var (
+	ErrOpenAIMissingAPIKey         = errors.New("openai provider needs an api key")
+	ErrOpenAIUnsupportedAttachment = errors.New("file type can not be sent to an openai chat completion")
+	ErrOpenAINoChoice              = errors.New("openai response has no choice")
+)
+
+// OpenAIMessageConversionOptions controls how AIgencyMessage files become openai message parts
+type OpenAIMessageConversionOptions struct {
+	ImageDetail goopenai.ImageURLDetail // auto if empty
+	// InlineRemoteImages sends images with an http url as data urls, too. otherwise the provider fetches them.
+	InlineRemoteImages bool
+}
+
+// AIgencyMessageListToOpenAIMessages converts the conversation messages of the list, delta and stateUpdate messages are left out
+func AIgencyMessageListToOpenAIMessages(list *AIgencyMessageList, options OpenAIMessageConversionOptions) (messages []goopenai.ChatCompletionMessage, err error) {
+	messages = make([]goopenai.ChatCompletionMessage, 0)
+	if list == nil {
+		return messages, nil
+	}
+	for _, msg := range list.GetMessages() {
+		if msg == nil || msg.Type == AIgencyMessageTypeDelta || msg.Type == AIgencyMessageTypeStateUpdate {
+			continue
+		}
+		message, err := AIgencyMessageToOpenAIMessage(msg, options)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// AIgencyMessageToOpenAIMessage converts one message. tool responses become tool messages answering the call in
+// TOOL_RESPONSE_METADATA_JOB_ID, the tool calls of assistant messages are kept. user messages with files are sent
+// as parts: images as image_url (data urls for local files), text files as text.
+func AIgencyMessageToOpenAIMessage(msg *AIgencyMessage, options OpenAIMessageConversionOptions) (message goopenai.ChatCompletionMessage, err error) {
+	text := ""
+	if msg.Content != nil {
+		text = msg.Content.GetConcatenatedTextContents()
+	}
+	switch {
+	case msg.Type == AIgencyMessageTypeToolResponse:
+		message.Role = OPENAI_ROLE_TOOL
+		message.Content = text
+		message.ToolCallID = getToolResponseCallID(msg)
+		return message, nil
+	case msg.SenderConversationRole == ConversationRoleSystem:
+		message.Role = goopenai.ChatMessageRoleSystem
+		message.Content = text
+		return message, nil
+	case msg.SenderConversationRole == ConversationRoleAssistant:
+		message.Role = goopenai.ChatMessageRoleAssistant
+		message.Content = text
+		for _, toolCall := range msg.GetToolCalls() {
+			message.ToolCalls = append(message.ToolCalls, goopenai.ToolCall{
+				ID:       toolCall.ID,
+				Type:     goopenai.ToolTypeFunction,
+				Function: goopenai.FunctionCall{Name: toolCall.Name, Arguments: toolCall.Arguments},
+			})
+		}
+		return message, nil
+	}
+	message.Role = goopenai.ChatMessageRoleUser
+	files := getAIgencyMessageFiles(msg)
+	if len(files) == 0 {
+		message.Content = text
+		return message, nil
+	}
+	message.MultiContent = make([]goopenai.ChatMessagePart, 0, len(files)+1)
+	if text != "" {
+		message.MultiContent = append(message.MultiContent, goopenai.ChatMessagePart{Type: goopenai.ChatMessagePartTypeText, Text: text})
+	}
+	for _, file := range files {
+		part, err := aigencyMessageFileToOpenAIPart(file, options)
+		if err != nil {
+			return message, err
+		}
+		message.MultiContent = append(message.MultiContent, part)
+	}
+	return message, nil
+}
+
+func aigencyMessageFileToOpenAIPart(file *AIgencyMessageFile, options OpenAIMessageConversionOptions) (part goopenai.ChatMessagePart, err error) {
+	mimeType := strings.ToLower(file.MimeType)
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		url := file.URL
+		if !file.IsRemote() || options.InlineRemoteImages {
+			if url, err = file.GetDataURL(); err != nil {
+				return part, fmt.Errorf("image (%s): %w", file.FileName, err)
+			}
+		}
+		detail := options.ImageDetail
+		if detail == "" {
+			detail = goopenai.ImageURLDetailAuto
+		}
+		return goopenai.ChatMessagePart{Type: goopenai.ChatMessagePartTypeImageURL, ImageURL: &goopenai.ChatMessageImageURL{URL: url, Detail: detail}}, nil
+	case strings.HasPrefix(mimeType, "text/") || mimeType == "application/json":
+		fileContent, err := file.ReadFileContentFromLocal()
+		if err != nil {
+			return part, fmt.Errorf("file (%s): %w", file.FileName, err)
+		}
+		return goopenai.ChatMessagePart{Type: goopenai.ChatMessagePartTypeText, Text: fmt.Sprintf("file (%s):\n%s", file.FileName, fileContent)}, nil
+	}
+	return part, fmt.Errorf("%w: file (%s) of type (%s)", ErrOpenAIUnsupportedAttachment, file.FileName, file.MimeType)
+}
+
+// OpenAIMessagesToAIgencyMessageList converts openai messages into AIgencyMessages, keeping their order.
+// image parts become file contents with the image url (data urls keep their mimetype).
+func OpenAIMessagesToAIgencyMessageList(messages []goopenai.ChatCompletionMessage) (list *AIgencyMessageList) {
+	list = NewAIgencyMessageList()
+	createdAt := nuts.TimeToJSTimestamp(time.Now())
+	for n, message := range messages {
+		msg := OpenAIMessageToAIgencyMessage(message)
+		// the list is ordered by CreatedAt
+		msg.CreatedAt = createdAt + int64(n)
+		msg.UpdatedAt = msg.CreatedAt
+		list.AddMessage(msg)
+	}
+	return list
+}
+
+func OpenAIMessageToAIgencyMessage(message goopenai.ChatCompletionMessage) (msg *AIgencyMessage) {
+	msg = NewAIgencyMessage()
+	msg.Type = AIgencyMessageTypeMessage
+	msg.TokenDirection = InputToken
+	switch message.Role {
+	case goopenai.ChatMessageRoleSystem:
+		msg.SenderConversationRole = ConversationRoleSystem
+	case goopenai.ChatMessageRoleAssistant:
+		msg.SenderConversationRole = ConversationRoleAssistant
+		msg.TokenDirection = OutputToken
+	case OPENAI_ROLE_TOOL, goopenai.ChatMessageRoleFunction:
+		msg.Type = AIgencyMessageTypeToolResponse
+		msg.SenderConversationRole = ConversationRoleUser
+		msg.ResponseToID = message.ToolCallID
+		msg.MetaData[TOOL_RESPONSE_METADATA_JOB_ID] = message.ToolCallID
+		if message.Name != "" {
+			msg.MetaData[TOOL_RESPONSE_METADATA_TOOL_NAME] = message.Name
+		}
+	default:
+		msg.SenderConversationRole = ConversationRoleUser
+	}
+	msg.SenderName = message.Name
+	if message.Content != "" {
+		text := message.Content
+		msg.Content.AddContent(NewAIgencyMessageContent(AIgencyMessageContentTypeText, &text, nil))
+	}
+	for _, part := range message.MultiContent {
+		switch {
+		case part.Type == goopenai.ChatMessagePartTypeText:
+			text := part.Text
+			msg.Content.AddContent(NewAIgencyMessageContent(AIgencyMessageContentTypeText, &text, nil))
+		case part.Type == goopenai.ChatMessagePartTypeImageURL && part.ImageURL != nil:
+			file := NewAIgencyMessageFile("image", "", "image/*", part.ImageURL.URL)
+			if mimeType, fileContent, isDataURL := ParseDataURL(part.ImageURL.URL); isDataURL {
+				file.MimeType = mimeType
+				file.FileSize = int64(len(fileContent))
+			}
+			msg.Content.AddContent(NewAIgencyMessageContent(AIgencyMessageContentTypeFile, nil, file))
+		}
+	}
+	toolCalls := make([]ExecutionToolCall, 0, len(message.ToolCalls))
+	for _, toolCall := range message.ToolCalls {
+		toolCalls = append(toolCalls, ExecutionToolCall{ID: toolCall.ID, Name: toolCall.Function.Name, Arguments: toolCall.Function.Arguments})
+	}
+	msg.SetToolCalls(toolCalls)
+	return msg
+}
+
+// getToolResponseCallID returns the id of the tool call a tool response answers
+func getToolResponseCallID(msg *AIgencyMessage) string {
+	if callID, isText := msg.MetaData[TOOL_RESPONSE_METADATA_JOB_ID].(string); isText && callID != "" {
+		return callID
+	}
+	return msg.ResponseToID
+}
+
+// getAIgencyMessageFiles returns the file contents and attachments of the message, each file once
+func getAIgencyMessageFiles(msg *AIgencyMessage) []*AIgencyMessageFile {
+	list := NewAIgencyMessageList()
+	list.AddMessage(msg)
+	return collectRequestFiles(list, nil)
+}
+
+// OpenAIProviderConfig configures the client of an OpenAIProvider, a BaseURL points it to an openai compatible api (or a test server)
+type OpenAIProviderConfig struct {
+	APIKey            string
+	BaseURL           string
+	OrgID             string
+	HTTPClient        *http.Client
+	ConversionOptions OpenAIMessageConversionOptions
+}
+
+// OpenAIProvider runs chat completions against the openai api or an openai compatible one
+type OpenAIProvider struct {
+	UnsupportedProviderCapabilities
+	client            *goopenai.Client
+	conversionOptions OpenAIMessageConversionOptions
+}
+
+func NewOpenAIProvider(config OpenAIProviderConfig) *OpenAIProvider {
+	clientConfig := goopenai.DefaultConfig(config.APIKey)
+	if config.BaseURL != "" {
+		clientConfig.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+	}
+	if config.OrgID != "" {
+		clientConfig.OrgID = config.OrgID
+	}
+	if config.HTTPClient != nil {
+		clientConfig.HTTPClient = config.HTTPClient
+	}
+	return &OpenAIProvider{
+		client:            goopenai.NewClientWithConfig(clientConfig),
+		conversionOptions: config.ConversionOptions,
+	}
+}
+
+// NewOpenAIProviderFromConfig is the ProviderFactory reading OPENAI_API_KEY, OPENAI_BASE_URL and OPENAI_ORG_ID from the config,
+// a given endpoint replaces OPENAI_BASE_URL: RegisterProvider(OPENAI_PROVIDER_SERVICE_IMPL, NewOpenAIProviderFromConfig)
+func NewOpenAIProviderFromConfig(service *AIModelServiceObject, endpoint string) (provider Provider, err error) {
+	apiKey := viper.GetString(OPENAI_API_KEY_CONFIG_KEY)
+	if apiKey == "" {
+		return nil, fmt.Errorf("%w: %s is not configured", ErrOpenAIMissingAPIKey, OPENAI_API_KEY_CONFIG_KEY)
+	}
+	baseURL, err := getProviderBaseURL(endpoint, viper.GetString(OPENAI_BASE_URL_CONFIG_KEY))
+	if err != nil {
+		return nil, err
+	}
+	return NewOpenAIProvider(OpenAIProviderConfig{
+		APIKey:  apiKey,
+		BaseURL: baseURL,
+		OrgID:   viper.GetString(OPENAI_ORG_ID_CONFIG_KEY),
+	}), nil
+}
+
+func (provider *OpenAIProvider) GetServiceImpl() string {
+	return OPENAI_PROVIDER_SERVICE_IMPL
+}
+
+func (provider *OpenAIProvider) ChatCompletion(ctx context.Context, request ProviderChatRequest) (result *ExecutionResultText2Text, err error) {
+	var logName string = "[OpenAIProvider.ChatCompletion] "
+	chatRequest, err := provider.newChatCompletionRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	startedAt := time.Now()
+	response, err := provider.client.CreateChatCompletion(ctx, chatRequest)
+	if err != nil {
+		nuts.L.Errorf("%smodel(%s) failed: %v", logName, request.Model.ID, err)
+		return nil, newOpenAIExecutionError(err)
+	}
+	if len(response.Choices) == 0 {
+		return nil, NewExecutionError(ExecutionErrorClassServerError, 0, ErrOpenAINoChoice)
+	}
+	choice := response.Choices[0]
+	toolCalls := make([]ExecutionToolCall, 0, len(choice.Message.ToolCalls))
+	for _, toolCall := range choice.Message.ToolCalls {
+		toolCalls = append(toolCalls, ExecutionToolCall{ID: toolCall.ID, Name: toolCall.Function.Name, Arguments: toolCall.Function.Arguments})
+	}
+	result = NewExecutionResultText2Text(response.ID, request.Model, choice.Message.Content, toolCalls, string(choice.FinishReason), response.Usage.PromptTokens, response.Usage.CompletionTokens, startedAt)
+	return result, nil
+}
+
+// ChatCompletionStream streams the content and tool call parts as they arrive. the usage is requested with the last chunk,
+// apis that do not send it get estimated token counts.
+func (provider *OpenAIProvider) ChatCompletionStream(ctx context.Context, request ProviderChatRequest, onDelta ProviderDeltaHandler) (result *ExecutionResultText2Text, err error) {
+	var logName string = "[OpenAIProvider.ChatCompletionStream] "
+	chatRequest, err := provider.newChatCompletionRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	chatRequest.StreamOptions = &goopenai.StreamOptions{IncludeUsage: true}
+	startedAt := time.Now()
+	stream, err := provider.client.CreateChatCompletionStream(ctx, chatRequest)
+	if err != nil {
+		nuts.L.Errorf("%smodel(%s) failed: %v", logName, request.Model.ID, err)
+		return nil, newOpenAIExecutionError(err)
+	}
+	defer stream.Close()
+
+	executionID := request.ExecutionID
+	content := strings.Builder{}
+	finishReason := ""
+	toolCalls := make(map[int]*ExecutionToolCall)
+	var usage *goopenai.Usage
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			nuts.L.Errorf("%smodel(%s) stream failed: %v", logName, request.Model.ID, err)
+			return nil, newOpenAIExecutionError(err)
+		}
+		if executionID == "" {
+			executionID = chunk.ID
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		choice := chunk.Choices[0]
+		if choice.Delta.Content != "" {
+			content.WriteString(choice.Delta.Content)
+			if err = onDelta(ProviderChatDelta{ExecutionID: executionID, Content: choice.Delta.Content}); err != nil {
+				return nil, err
+			}
+		}
+		for n, toolCallChunk := range choice.Delta.ToolCalls {
+			index := n
+			if toolCallChunk.Index != nil {
+				index = *toolCallChunk.Index
+			}
+			toolCall, isKnown := toolCalls[index]
+			if !isKnown {
+				toolCall = &ExecutionToolCall{}
+				toolCalls[index] = toolCall
+			}
+			if toolCallChunk.ID != "" {
+				toolCall.ID = toolCallChunk.ID
+			}
+			toolCall.Name += toolCallChunk.Function.Name
+			toolCall.Arguments += toolCallChunk.Function.Arguments
+			delta := &ExecutionToolCallDelta{Index: index, ID: toolCallChunk.ID, Name: toolCallChunk.Function.Name, ArgumentsDelta: toolCallChunk.Function.Arguments}
+			if err = onDelta(ProviderChatDelta{ExecutionID: executionID, ToolCall: delta}); err != nil {
+				return nil, err
+			}
+		}
+		if choice.FinishReason != "" && choice.FinishReason != goopenai.FinishReasonNull {
+			finishReason = string(choice.FinishReason)
+		}
+	}
+	if err = onDelta(ProviderChatDelta{ExecutionID: executionID, FinishReason: finishReason}); err != nil {
+		return nil, err
+	}
+
+	indexes := make([]int, 0, len(toolCalls))
+	for index := range toolCalls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	completedToolCalls := make([]ExecutionToolCall, 0, len(indexes))
+	for _, index := range indexes {
+		completedToolCalls = append(completedToolCalls, *toolCalls[index])
+	}
+	inputTokens, outputTokens := 0, 0
+	if usage != nil {
+		inputTokens, outputTokens = usage.PromptTokens, usage.CompletionTokens
+	} else {
+		inputTokens, _ = countEstimateInputs(request.Model, request.Messages)
+		outputTokens = CalculateTokenCountForModel(request.Model, content.String())
+	}
+	result = NewExecutionResultText2Text(executionID, request.Model, content.String(), completedToolCalls, finishReason, inputTokens, outputTokens, startedAt)
+	return result, nil
+}
+
+// newChatCompletionRequest maps the request onto the openai api, known parameters go into their fields.
+// go-openai leaves out a temperature or top_p of 0 (omitempty) and the api would use its default of 1,
+// so a requested 0 is sent as OPENAI_EXPLICIT_ZERO_SAMPLING_VALUE.
+func (provider *OpenAIProvider) newChatCompletionRequest(request ProviderChatRequest) (chatRequest goopenai.ChatCompletionRequest, err error) {
+	if err = request.Validate(); err != nil {
+		return chatRequest, err
+	}
+	messages, err := AIgencyMessageListToOpenAIMessages(request.Messages, provider.conversionOptions)
+	if err != nil {
+		return chatRequest, NewExecutionError(ExecutionErrorClassInvalidRequest, 0, err)
+	}
+	chatRequest = goopenai.ChatCompletionRequest{
+		Model:     request.Model.ModelID,
+		Messages:  messages,
+		MaxTokens: request.GetMaxTokens(),
+	}
+	if temperature, isNumber := toFloat64(request.Parameters[COMPLETION_PARAMETER_TEMPERATURE]); isNumber {
+		chatRequest.Temperature = getOpenAISamplingValue(temperature)
+	}
+	if topP, isNumber := toFloat64(request.Parameters[COMPLETION_PARAMETER_TOP_P]); isNumber {
+		chatRequest.TopP = getOpenAISamplingValue(topP)
+	}
+	if presencePenalty, isNumber := toFloat64(request.Parameters["presence_penalty"]); isNumber {
+		chatRequest.PresencePenalty = float32(presencePenalty)
+	}
+	if frequencyPenalty, isNumber := toFloat64(request.Parameters["frequency_penalty"]); isNumber {
+		chatRequest.FrequencyPenalty = float32(frequencyPenalty)
+	}
+	if seed, isNumber := toFloat64(request.Parameters["seed"]); isNumber {
+		seedValue := int(seed)
+		chatRequest.Seed = &seedValue
+	}
+	switch stop := request.Parameters["stop"].(type) {
+	case string:
+		chatRequest.Stop = []string{stop}
+	case []string:
+		chatRequest.Stop = stop
+	case []any:
+		for _, value := range stop {
+			chatRequest.Stop = append(chatRequest.Stop, fmt.Sprint(value))
+		}
+	}
+	for _, tool := range request.Tools {
+		chatRequest.Tools = append(chatRequest.Tools, goopenai.Tool{
+			Type: goopenai.ToolTypeFunction,
+			Function: &goopenai.FunctionDefinition{
+				Name:        tool.Function.Name,
+				Description: tool.Function.Description,
+				Parameters:  tool.Function.Parameters,
+			},
+		})
+	}
+	switch request.ToolChoice {
+	case "":
+	case "auto", "none", "required":
+		chatRequest.ToolChoice = request.ToolChoice
+	default:
+		chatRequest.ToolChoice = goopenai.ToolChoice{Type: goopenai.ToolTypeFunction, Function: goopenai.ToolFunction{Name: request.ToolChoice}}
+	}
+	return chatRequest, nil
+}
+
+// getOpenAISamplingValue returns the temperature or top_p to send, 0 as OPENAI_EXPLICIT_ZERO_SAMPLING_VALUE
+func getOpenAISamplingValue(value float64) float32 {
+	if float32(value) == 0 {
+		return OPENAI_EXPLICIT_ZERO_SAMPLING_VALUE
+	}
+	return float32(value)
+}
+
+// newOpenAIExecutionError classifies api errors by their http status, others by ClassifyExecutionError
+func newOpenAIExecutionError(err error) error {
+	var apiError *goopenai.APIError
+	if errors.As(err, &apiError) {
+		class := ClassifyHTTPStatusCode(apiError.HTTPStatusCode)
+		if code, isText := apiError.Code.(string); isText && code == "context_length_exceeded" {
+			class = ExecutionErrorClassContextLength
+		}
+		return NewExecutionError(class, apiError.HTTPStatusCode, err)
+	}
+	var requestError *goopenai.RequestError
+	if errors.As(err, &requestError) {
+		return NewExecutionError(ClassifyHTTPStatusCode(requestError.HTTPStatusCode), requestError.HTTPStatusCode, err)
+	}
+	return NewExecutionError(ClassifyExecutionError(err), 0, err)
+}
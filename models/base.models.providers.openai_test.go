@@ -0,0 +1,179 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	goopenai "github.com/sashabaranov/go-openai"
+)
+
+func newTestOpenAIConversation() []goopenai.ChatCompletionMessage {
+	return []goopenai.ChatCompletionMessage{
+		{Role: goopenai.ChatMessageRoleSystem, Content: "be brief"},
+		{Role: goopenai.ChatMessageRoleUser, MultiContent: []goopenai.ChatMessagePart{
+			{Type: goopenai.ChatMessagePartTypeText, Text: "what is on the picture?"},
+			{Type: goopenai.ChatMessagePartTypeImageURL, ImageURL: &goopenai.ChatMessageImageURL{URL: "data:image/png;base64,iVBORw0KGgo=", Detail: goopenai.ImageURLDetailAuto}},
+		}},
+		{Role: goopenai.ChatMessageRoleAssistant, ToolCalls: []goopenai.ToolCall{
+			{ID: "call_1", Type: goopenai.ToolTypeFunction, Function: goopenai.FunctionCall{Name: "describe_image", Arguments: `{"detail":"high"}`}},
+		}},
+		{Role: OPENAI_ROLE_TOOL, Content: "a cat", ToolCallID: "call_1"},
+	}
+}
+
+// startOpenAITestServer serves /chat/completions with handle and records the request bodies
+func startOpenAITestServer(t *testing.T, handle func(writer http.ResponseWriter, body map[string]any)) (provider *OpenAIProvider, bodies *[]map[string]any) {
+	t.Helper()
+	bodies = &[]map[string]any{}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/chat/completions" {
+			http.NotFound(writer, request)
+			return
+		}
+		rawBody, _ := io.ReadAll(request.Body)
+		body := make(map[string]any)
+		if err := json.Unmarshal(rawBody, &body); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		*bodies = append(*bodies, body)
+		handle(writer, body)
+	}))
+	t.Cleanup(server.Close)
+	return NewOpenAIProvider(OpenAIProviderConfig{APIKey: "test", BaseURL: server.URL}), bodies
+}
+
+func newTestOpenAIChatRequest(parameters map[string]any) ProviderChatRequest {
+	return ProviderChatRequest{
+		Model:      &AIModel{ID: "openai-test", ModelID: "gpt-test"},
+		Messages:   OpenAIMessagesToAIgencyMessageList(newTestOpenAIConversation()),
+		Tools:      []OpenaiFunction{{Type: "function", Function: OpenaiFunctionDefinition{Name: "describe_image", Description: "describes an image"}}},
+		Parameters: parameters,
+	}
+}
+
+func TestOpenAIMessagesRoundTrip(t *testing.T) {
+	conversation := newTestOpenAIConversation()
+	list := OpenAIMessagesToAIgencyMessageList(conversation)
+
+	messages, err := AIgencyMessageListToOpenAIMessages(list, OpenAIMessageConversionOptions{})
+	if err != nil {
+		t.Fatalf("AIgencyMessageListToOpenAIMessages() error = %v", err)
+	}
+	if !reflect.DeepEqual(messages, conversation) {
+		t.Fatalf("round trip = %+v, want %+v", messages, conversation)
+	}
+	toolResponse := list.GetMessages()[3]
+	if toolResponse.Type != AIgencyMessageTypeToolResponse || getToolResponseCallID(toolResponse) != "call_1" {
+		t.Errorf("tool message became type %s answering %q, want a tool response answering call_1", toolResponse.Type, getToolResponseCallID(toolResponse))
+	}
+}
+
+func TestAIgencyMessageToOpenAIMessageRejectsUnsupportedFiles(t *testing.T) {
+	msg := NewAIgencyMessage()
+	msg.SenderConversationRole = ConversationRoleUser
+	msg.Attachments.AddFile(NewAIgencyMessageFile("movie.mp4", "", "video/mp4", "https://example.com/movie.mp4"))
+	if _, err := AIgencyMessageToOpenAIMessage(msg, OpenAIMessageConversionOptions{}); !errors.Is(err, ErrOpenAIUnsupportedAttachment) {
+		t.Fatalf("AIgencyMessageToOpenAIMessage() error = %v, want %v", err, ErrOpenAIUnsupportedAttachment)
+	}
+}
+
+// isOpenAIExplicitZero tells if a decoded json value is OPENAI_EXPLICIT_ZERO_SAMPLING_VALUE, which go encodes as the shortest float32 text
+func isOpenAIExplicitZero(value any) bool {
+	number, isNumber := value.(float64)
+	return isNumber && float32(number) == OPENAI_EXPLICIT_ZERO_SAMPLING_VALUE
+}
+
+func TestOpenAIProviderChatCompletion(t *testing.T) {
+	provider, bodies := startOpenAITestServer(t, func(writer http.ResponseWriter, body map[string]any) {
+		writer.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(writer, `{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-test","choices":[{"index":0,"finish_reason":"tool_calls","message":{"role":"assistant","content":"","tool_calls":[{"id":"call_2","type":"function","function":{"name":"describe_image","arguments":"{}"}}]}}],"usage":{"prompt_tokens":12,"completion_tokens":5,"total_tokens":17}}`)
+	})
+
+	result, err := provider.ChatCompletion(context.Background(), newTestOpenAIChatRequest(map[string]any{COMPLETION_PARAMETER_TEMPERATURE: 0, COMPLETION_PARAMETER_MAX_TOKENS: 100}))
+	if err != nil {
+		t.Fatalf("ChatCompletion() error = %v", err)
+	}
+	if result.ExecutionID != "chatcmpl-1" || result.FinishReason != "tool_calls" || result.InputTokens != 12 || result.OutputTokens != 5 {
+		t.Errorf("result = %+v, want chatcmpl-1 finishing with tool_calls after 12 input and 5 output tokens", result)
+	}
+	if want := []ExecutionToolCall{{ID: "call_2", Name: "describe_image", Arguments: "{}"}}; !reflect.DeepEqual(result.ToolCalls, want) {
+		t.Errorf("tool calls = %+v, want %+v", result.ToolCalls, want)
+	}
+
+	body := (*bodies)[0]
+	if temperature, isSet := body["temperature"]; !isSet || !isOpenAIExplicitZero(temperature) {
+		t.Errorf("sent temperature = %v (set %v), want the explicit zero %v", temperature, isSet, OPENAI_EXPLICIT_ZERO_SAMPLING_VALUE)
+	}
+	if _, isSet := body["top_p"]; isSet {
+		t.Errorf("sent top_p %v, want it left out as it was not requested", body["top_p"])
+	}
+	if body["model"] != "gpt-test" || body["max_tokens"] != float64(100) || len(body["messages"].([]any)) != 4 || len(body["tools"].([]any)) != 1 {
+		t.Errorf("sent body = %v, want model gpt-test, max_tokens 100, 4 messages and 1 tool", body)
+	}
+}
+
+func TestOpenAIProviderChatCompletionStream(t *testing.T) {
+	chunks := []string{
+		`{"id":"chatcmpl-2","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}`,
+		`{"id":"chatcmpl-2","choices":[{"index":0,"delta":{"content":"lo"}}]}`,
+		`{"id":"chatcmpl-2","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_3","type":"function","function":{"name":"describe_image","arguments":"{\"de"}}]}}]}`,
+		`{"id":"chatcmpl-2","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"tail\":1}"}}]}}]}`,
+		`{"id":"chatcmpl-2","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		`{"id":"chatcmpl-2","choices":[],"usage":{"prompt_tokens":20,"completion_tokens":7,"total_tokens":27}}`,
+	}
+	provider, bodies := startOpenAITestServer(t, func(writer http.ResponseWriter, body map[string]any) {
+		writer.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			fmt.Fprintf(writer, "data: %s\n\n", chunk)
+		}
+		fmt.Fprint(writer, "data: [DONE]\n\n")
+	})
+
+	deltas := make([]ProviderChatDelta, 0)
+	result, err := provider.ChatCompletionStream(context.Background(), newTestOpenAIChatRequest(map[string]any{COMPLETION_PARAMETER_TOP_P: 0.0}), func(delta ProviderChatDelta) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ChatCompletionStream() error = %v", err)
+	}
+	if result.Content != "Hello" || result.FinishReason != "tool_calls" || result.InputTokens != 20 || result.OutputTokens != 7 {
+		t.Errorf("result = %+v, want Hello finishing with tool_calls after 20 input and 7 output tokens", result)
+	}
+	if want := []ExecutionToolCall{{ID: "call_3", Name: "describe_image", Arguments: `{"detail":1}`}}; !reflect.DeepEqual(result.ToolCalls, want) {
+		t.Errorf("tool calls = %+v, want %+v", result.ToolCalls, want)
+	}
+	if len(deltas) != 5 || deltas[0].Content != "Hel" || deltas[2].ToolCall == nil || deltas[2].ToolCall.ID != "call_3" || deltas[4].FinishReason != "tool_calls" {
+		t.Errorf("deltas = %+v, want 2 content, 2 tool call and the finish delta", deltas)
+	}
+
+	body := (*bodies)[0]
+	if topP, isSet := body["top_p"]; !isSet || !isOpenAIExplicitZero(topP) {
+		t.Errorf("sent top_p = %v (set %v), want the explicit zero %v", topP, isSet, OPENAI_EXPLICIT_ZERO_SAMPLING_VALUE)
+	}
+	if body["stream"] != true || body["stream_options"] == nil {
+		t.Errorf("sent body = %v, want a stream including the usage", body)
+	}
+}
+
+func TestOpenAIProviderClassifiesAPIErrors(t *testing.T) {
+	provider, _ := startOpenAITestServer(t, func(writer http.ResponseWriter, body map[string]any) {
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, `{"error":{"message":"too long","type":"invalid_request_error","code":"context_length_exceeded"}}`)
+	})
+
+	_, err := provider.ChatCompletion(context.Background(), newTestOpenAIChatRequest(nil))
+	var executionError *ExecutionError
+	if !errors.As(err, &executionError) || executionError.Class != ExecutionErrorClassContextLength {
+		t.Fatalf("ChatCompletion() error = %v, want an ExecutionError of class %s", err, ExecutionErrorClassContextLength)
+	}
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+)
+
+const (
+	READXS_TAG        = "readxs"
+	READXS_JSON_TAG   = "readxsjson" // name in filtered views of a field hidden from json.Marshal with json:"-"
+	READXS_ALL_ROLES  = "*"
+	READXS_OPT_STRUCT = "struct" // nested structs are filtered field by field for the role, too
+
+	READXS_ROLE_SYSTEM    = "system"
+	READXS_ROLE_ADMIN     = "admin"
+	READXS_ROLE_OWNER     = "owner"
+	READXS_ROLE_ORG_ADMIN = "org-admin"
+	READXS_ROLE_ORG_OWNER = "org-owner"
+)
+
+// readxsAccess is the access of one role to a field as declared in its readxs tag, e.g. `readxs:"system:struct,admin:struct,org-admin"`
+type readxsAccess struct {
+	readable bool
+	recurse  bool
+}
+
+func getReadxsAccess(tag string, role string) (access readxsAccess) {
+	for _, entry := range strings.Split(tag, ",") {
+		entryRole, option, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		if entryRole != role && entryRole != READXS_ALL_ROLES {
+			continue
+		}
+		access.readable = true
+		access.recurse = access.recurse || option == READXS_OPT_STRUCT
+	}
+	return access
+}
+
+// CanRead tells if role may read a field with the given readxs tag
+func CanRead(tag string, role string) bool {
+	return getReadxsAccess(tag, role).readable
+}
+
+// FilterReadableFields returns value as role may see it: structs become maps of their readable fields keyed by their json names,
+// slices and maps are filtered element by element. fields without readxs tag are hidden, untagged embedded structs are flattened like encoding/json does.
+// secret fields are hidden from json.Marshal with json:"-" and only show up here, under their readxsjson name, for roles that may read them.
+// the result is meant for json.Marshal, it never contains fields of a struct the role cannot read.
+func FilterReadableFields(value any, role string) any {
+	return filterReadableValue(reflect.ValueOf(value), role)
+}
+
+func filterReadableValue(value reflect.Value, role string) any {
+	if !value.IsValid() {
+		return nil
+	}
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if value.IsNil() {
+			return nil
+		}
+		return filterReadableValue(value.Elem(), role)
+	case reflect.Struct:
+		fields := make(map[string]any)
+		filterReadableStructFields(value, role, fields)
+		return fields
+	case reflect.Slice, reflect.Array:
+		if value.Kind() == reflect.Slice && value.IsNil() {
+			return nil
+		}
+		if !isFilterableType(value.Type().Elem()) {
+			return value.Interface()
+		}
+		elements := make([]any, 0, value.Len())
+		for n := 0; n < value.Len(); n++ {
+			elements = append(elements, filterReadableValue(value.Index(n), role))
+		}
+		return elements
+	case reflect.Map:
+		if value.IsNil() || !isFilterableType(value.Type().Elem()) {
+			return value.Interface()
+		}
+		elements := make(map[string]any, value.Len())
+		iterator := value.MapRange()
+		for iterator.Next() {
+			elements[iterator.Key().String()] = filterReadableValue(iterator.Value(), role)
+		}
+		return elements
+	}
+	return value.Interface()
+}
+
+func filterReadableStructFields(value reflect.Value, role string, fields map[string]any) {
+	valueType := value.Type()
+	for n := 0; n < valueType.NumField(); n++ {
+		field := valueType.Field(n)
+		if !field.IsExported() {
+			continue
+		}
+		jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if jsonName == "-" {
+			jsonName = field.Tag.Get(READXS_JSON_TAG)
+			if jsonName == "" {
+				continue
+			}
+		}
+		readxsTag, hasReadxs := field.Tag.Lookup(READXS_TAG)
+		if field.Anonymous && jsonName == "" && !hasReadxs && field.Type.Kind() == reflect.Struct {
+			filterReadableStructFields(value.Field(n), role, fields)
+			continue
+		}
+		access := getReadxsAccess(readxsTag, role)
+		if !access.readable {
+			continue
+		}
+		if jsonName == "" {
+			jsonName = field.Name
+		}
+		fieldValue := value.Field(n)
+		if access.recurse && isFilterableType(field.Type) {
+			fields[jsonName] = filterReadableValue(fieldValue, role)
+			continue
+		}
+		fields[jsonName] = fieldValue.Interface()
+	}
+}
+
+// isFilterableType tells if the type contains structs that have to be filtered, types with own json marshalling are kept as they are
+func isFilterableType(valueType reflect.Type) bool {
+	for valueType.Kind() == reflect.Pointer || valueType.Kind() == reflect.Slice || valueType.Kind() == reflect.Array || valueType.Kind() == reflect.Map {
+		valueType = valueType.Elem()
+	}
+	return valueType.Kind() == reflect.Struct && !valueType.Implements(reflectJSONMarshaler) && !reflect.PointerTo(valueType).Implements(reflectJSONMarshaler)
+}
+
+var reflectJSONMarshaler = reflect.TypeOf((*interface{ MarshalJSON() ([]byte, error) })(nil)).Elem()
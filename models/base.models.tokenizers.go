@@ -0,0 +1,344 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/tiktoken-go/tokenizer"
+	nuts "github.com/vaudience/go-nuts"
+)
+
+var (
+	ErrTokenizerNotRegistered     = errors.New("no tokenizer registered for the strategy")
+	ErrTokenizerEncodingNotLoaded = errors.New("tokenizer encoding could not be loaded")
+)
+
+var (
+	// Deprecated: not read anymore, the default tokenizer is TokenizerStrategyCl100k (the gpt-4 encoding), see SetDefaultTokenizerStrategy.
+	DefaultTextTokenEncoderModel = string(tokenizer.GPT4)
+	// Deprecated: use RegisterTokenizer and SetDefaultTokenizerStrategy. CalculateTokenCount still counts with it if it is set.
+	DefaultTextTokenEncoder *tiktoken.Tiktoken
+)
+
+type TokenizerStrategy string //@name TokenizerStrategy
+
+const (
+	// openai cl100k_base (gpt-4, gpt-3.5, ada-002 embeddings), the vocabulary is compiled in
+	TokenizerStrategyCl100k TokenizerStrategy = "cl100k"
+	// openai o200k_base (gpt-4o, gpt-4.1, o-series), the vocabulary is downloaded on first use, cl100k if that fails
+	TokenizerStrategyO200k TokenizerStrategy = "o200k"
+	// one token per word and per non-word character, overestimates rather than underestimates
+	TokenizerStrategyApproximateWords TokenizerStrategy = "approximate-words"
+	// one token per TOKENIZER_CHARS_PER_TOKEN characters
+	TokenizerStrategyApproximateChars TokenizerStrategy = "approximate-chars"
+	// one token per TOKENIZER_CLAUDE_CHARS_PER_TOKEN characters, anthropic does not publish the claude 3+ tokenizer
+	TokenizerStrategyApproximateClaude TokenizerStrategy = "approximate-claude"
+)
+
+const (
+	TOKENIZER_CHARS_PER_TOKEN        = 4.0
+	TOKENIZER_CLAUDE_CHARS_PER_TOKEN = 3.5
+)
+
+// Tokenizer counts the tokens a model sees for a text, implementations must be safe for concurrent use
+type Tokenizer interface {
+	GetStrategy() TokenizerStrategy
+	CountTokens(text string) (tokenCount int)
+}
+
+// TokenizerPreloader is a Tokenizer with a vocabulary to load before the first count, see PreloadTokenizers
+type TokenizerPreloader interface {
+	Preload() (err error)
+}
+
+// TokenizerModelRule maps the ModelIDs starting with Prefix (compared in lowercase, after the last "/") to a strategy
+type TokenizerModelRule struct {
+	Prefix   string
+	Strategy TokenizerStrategy
+}
+
+// DefaultTokenizerModelRules are checked in order, the first matching prefix wins
+var DefaultTokenizerModelRules = []TokenizerModelRule{
+	{Prefix: "gpt-4o", Strategy: TokenizerStrategyO200k},
+	{Prefix: "chatgpt-4o", Strategy: TokenizerStrategyO200k},
+	{Prefix: "gpt-4.1", Strategy: TokenizerStrategyO200k},
+	{Prefix: "gpt-4.5", Strategy: TokenizerStrategyO200k},
+	{Prefix: "gpt-5", Strategy: TokenizerStrategyO200k},
+	{Prefix: "o1", Strategy: TokenizerStrategyO200k},
+	{Prefix: "o3", Strategy: TokenizerStrategyO200k},
+	{Prefix: "o4", Strategy: TokenizerStrategyO200k},
+	{Prefix: "gpt-4", Strategy: TokenizerStrategyCl100k},
+	{Prefix: "gpt-3.5", Strategy: TokenizerStrategyCl100k},
+	{Prefix: "text-embedding-", Strategy: TokenizerStrategyCl100k},
+	{Prefix: "claude", Strategy: TokenizerStrategyApproximateClaude},
+	{Prefix: "anthropic.claude", Strategy: TokenizerStrategyApproximateClaude},
+	{Prefix: "llama", Strategy: TokenizerStrategyApproximateWords},
+	{Prefix: "meta-llama", Strategy: TokenizerStrategyApproximateWords},
+	{Prefix: "mistral", Strategy: TokenizerStrategyApproximateWords},
+	{Prefix: "mixtral", Strategy: TokenizerStrategyApproximateWords},
+	{Prefix: "codestral", Strategy: TokenizerStrategyApproximateWords},
+	{Prefix: "gemini", Strategy: TokenizerStrategyApproximateChars},
+}
+
+var (
+	tokenizers = map[TokenizerStrategy]Tokenizer{
+		TokenizerStrategyCl100k:            newCl100kTokenizer(),
+		TokenizerStrategyO200k:             newO200kTokenizer(),
+		TokenizerStrategyApproximateWords:  &approximateWordsTokenizer{},
+		TokenizerStrategyApproximateChars:  &approximateCharsTokenizer{strategy: TokenizerStrategyApproximateChars, charsPerToken: TOKENIZER_CHARS_PER_TOKEN},
+		TokenizerStrategyApproximateClaude: &approximateCharsTokenizer{strategy: TokenizerStrategyApproximateClaude, charsPerToken: TOKENIZER_CLAUDE_CHARS_PER_TOKEN},
+	}
+	modelTokenizerStrategies = make(map[string]TokenizerStrategy) // by AIModel.ID
+	defaultTokenizerStrategy = TokenizerStrategyCl100k
+	tokenizersSafety         sync.RWMutex
+)
+
+// RegisterTokenizer makes tokenizer the one of its strategy, replacing a registered one
+func RegisterTokenizer(tok Tokenizer) {
+	tokenizersSafety.Lock()
+	defer tokenizersSafety.Unlock()
+	tokenizers[tok.GetStrategy()] = tok
+}
+
+// GetTokenizer returns the tokenizer registered for strategy
+func GetTokenizer(strategy TokenizerStrategy) (tok Tokenizer, err error) {
+	tokenizersSafety.RLock()
+	defer tokenizersSafety.RUnlock()
+	tok, isRegistered := tokenizers[strategy]
+	if !isRegistered {
+		return nil, fmt.Errorf("%w: (%s)", ErrTokenizerNotRegistered, strategy)
+	}
+	return tok, nil
+}
+
+// GetRegisteredTokenizerStrategies returns the sorted strategies with a tokenizer
+func GetRegisteredTokenizerStrategies() (strategies []TokenizerStrategy) {
+	tokenizersSafety.RLock()
+	defer tokenizersSafety.RUnlock()
+	return getSortedTokenizerStrategies()
+}
+
+// getSortedTokenizerStrategies needs the tokenizersSafety lock
+func getSortedTokenizerStrategies() (strategies []TokenizerStrategy) {
+	strategies = make([]TokenizerStrategy, 0, len(tokenizers))
+	for strategy := range tokenizers {
+		strategies = append(strategies, strategy)
+	}
+	sort.Slice(strategies, func(i, j int) bool { return strategies[i] < strategies[j] })
+	return strategies
+}
+
+// SetModelTokenizerStrategy makes the model with aiModelID count its tokens with strategy, overriding the DefaultTokenizerModelRules
+func SetModelTokenizerStrategy(aiModelID string, strategy TokenizerStrategy) (err error) {
+	tokenizersSafety.Lock()
+	defer tokenizersSafety.Unlock()
+	if _, isRegistered := tokenizers[strategy]; !isRegistered {
+		return fmt.Errorf("%w: (%s) for model(%s)", ErrTokenizerNotRegistered, strategy, aiModelID)
+	}
+	modelTokenizerStrategies[aiModelID] = strategy
+	return nil
+}
+
+// RemoveModelTokenizerStrategy lets the model with aiModelID fall back to the DefaultTokenizerModelRules
+func RemoveModelTokenizerStrategy(aiModelID string) {
+	tokenizersSafety.Lock()
+	defer tokenizersSafety.Unlock()
+	delete(modelTokenizerStrategies, aiModelID)
+}
+
+// SetDefaultTokenizerStrategy sets the strategy for models without a rule and for counting without a model
+func SetDefaultTokenizerStrategy(strategy TokenizerStrategy) (err error) {
+	tokenizersSafety.Lock()
+	defer tokenizersSafety.Unlock()
+	if _, isRegistered := tokenizers[strategy]; !isRegistered {
+		return fmt.Errorf("%w: (%s)", ErrTokenizerNotRegistered, strategy)
+	}
+	defaultTokenizerStrategy = strategy
+	return nil
+}
+
+// GetDefaultTokenizer returns the tokenizer of the default strategy
+func GetDefaultTokenizer() Tokenizer {
+	tokenizersSafety.RLock()
+	defer tokenizersSafety.RUnlock()
+	return tokenizers[defaultTokenizerStrategy]
+}
+
+// GetTokenizerForModel returns the tokenizer set for the model, else the one of the first DefaultTokenizerModelRules matching
+// its ModelID, else the default. a nil model gets the default.
+func GetTokenizerForModel(aiModel *AIModel) (tok Tokenizer) {
+	tokenizersSafety.RLock()
+	defer tokenizersSafety.RUnlock()
+	if aiModel == nil {
+		return tokenizers[defaultTokenizerStrategy]
+	}
+	if strategy, isSet := modelTokenizerStrategies[aiModel.ID]; isSet {
+		return tokenizers[strategy]
+	}
+	if strategy, isMatched := matchTokenizerModelRules(aiModel.ModelID); isMatched {
+		if tok, isRegistered := tokenizers[strategy]; isRegistered {
+			return tok
+		}
+	}
+	return tokenizers[defaultTokenizerStrategy]
+}
+
+// PreloadTokenizers loads the vocabularies of the registered tokenizers, which would otherwise be loaded (o200k: downloaded)
+// during the first count. call it at startup: the returned ErrTokenizerEncodingNotLoaded errors name the tokenizers that
+// count with their fallback.
+func PreloadTokenizers() (err error) {
+	tokenizersSafety.RLock()
+	preloaders := make([]TokenizerPreloader, 0)
+	for _, strategy := range getSortedTokenizerStrategies() {
+		if preloader, isPreloader := tokenizers[strategy].(TokenizerPreloader); isPreloader {
+			preloaders = append(preloaders, preloader)
+		}
+	}
+	tokenizersSafety.RUnlock()
+	preloadErrs := make([]error, 0)
+	for _, preloader := range preloaders {
+		preloadErrs = append(preloadErrs, preloader.Preload())
+	}
+	return errors.Join(preloadErrs...)
+}
+
+// CalculateTokenCountForModel counts the tokens of content with the tokenizer of the model
+func CalculateTokenCountForModel(aiModel *AIModel, content string) (tokenCount int) {
+	if content == "" {
+		return 0
+	}
+	return GetTokenizerForModel(aiModel).CountTokens(content)
+}
+
+func matchTokenizerModelRules(modelID string) (strategy TokenizerStrategy, isMatched bool) {
+	name := strings.ToLower(modelID)
+	if n := strings.LastIndex(name, "/"); n >= 0 {
+		name = name[n+1:]
+	}
+	if name == "" {
+		return "", false
+	}
+	for _, rule := range DefaultTokenizerModelRules {
+		if strings.HasPrefix(name, rule.Prefix) {
+			return rule.Strategy, true
+		}
+	}
+	return "", false
+}
+
+// encodingTokenizer counts with a bpe encoding that is loaded once by Preload or the first count, with fallback if loading fails
+type encodingTokenizer struct {
+	strategy TokenizerStrategy
+	load     func() (encode func(text string) int, err error)
+	fallback Tokenizer
+	once     sync.Once
+	encode   func(text string) int
+	loadErr  error
+}
+
+func newCl100kTokenizer() *encodingTokenizer {
+	return &encodingTokenizer{
+		strategy: TokenizerStrategyCl100k,
+		load: func() (encode func(text string) int, err error) {
+			codec, err := tokenizer.Get(tokenizer.Cl100kBase)
+			if err != nil {
+				return nil, err
+			}
+			return func(text string) int {
+				ids, _, err := codec.Encode(text)
+				if err != nil {
+					return -1
+				}
+				return len(ids)
+			}, nil
+		},
+		fallback: &approximateWordsTokenizer{},
+	}
+}
+
+func newO200kTokenizer() *encodingTokenizer {
+	return &encodingTokenizer{
+		strategy: TokenizerStrategyO200k,
+		load: func() (encode func(text string) int, err error) {
+			encoding, err := tiktoken.GetEncoding(tiktoken.MODEL_O200K_BASE)
+			if err != nil {
+				return nil, err
+			}
+			return func(text string) int {
+				return len(encoding.Encode(text, nil, nil))
+			}, nil
+		},
+		fallback: newCl100kTokenizer(),
+	}
+}
+
+func (tok *encodingTokenizer) GetStrategy() TokenizerStrategy {
+	return tok.strategy
+}
+
+// Preload loads the encoding, and the one of the fallback. an ErrTokenizerEncodingNotLoaded error tells that the fallback counts.
+func (tok *encodingTokenizer) Preload() (err error) {
+	var logName string = "[encodingTokenizer.Preload] "
+	tok.once.Do(func() {
+		encode, loadErr := tok.load()
+		if loadErr != nil {
+			tok.loadErr = fmt.Errorf("%w: (%s) counts with (%s): %v", ErrTokenizerEncodingNotLoaded, tok.strategy, tok.fallback.GetStrategy(), loadErr)
+			nuts.L.Warnf("%s%v", logName, tok.loadErr)
+			return
+		}
+		tok.encode = encode
+	})
+	if tok.loadErr == nil {
+		return nil
+	}
+	if preloader, isPreloader := tok.fallback.(TokenizerPreloader); isPreloader {
+		return errors.Join(tok.loadErr, preloader.Preload())
+	}
+	return tok.loadErr
+}
+
+func (tok *encodingTokenizer) CountTokens(text string) (tokenCount int) {
+	if text == "" {
+		return 0
+	}
+	tok.Preload() // a failed load is logged once, the fallback counts
+	if tok.encode != nil {
+		if tokenCount = tok.encode(text); tokenCount >= 0 {
+			return tokenCount
+		}
+	}
+	return tok.fallback.CountTokens(text)
+}
+
+type approximateWordsTokenizer struct{}
+
+func (tok *approximateWordsTokenizer) GetStrategy() TokenizerStrategy {
+	return TokenizerStrategyApproximateWords
+}
+
+func (tok *approximateWordsTokenizer) CountTokens(text string) (tokenCount int) {
+	wordCount, nonWordCharCount, err := countWordsAndNonWordChars(text)
+	if err != nil {
+		return int(math.Round(float64(len(text)) / TOKENIZER_CHARS_PER_TOKEN))
+	}
+	return wordCount + nonWordCharCount
+}
+
+type approximateCharsTokenizer struct {
+	strategy      TokenizerStrategy
+	charsPerToken float64
+}
+
+func (tok *approximateCharsTokenizer) GetStrategy() TokenizerStrategy {
+	return tok.strategy
+}
+
+func (tok *approximateCharsTokenizer) CountTokens(text string) (tokenCount int) {
+	return int(math.Ceil(float64(utf8.RuneCountInString(text)) / tok.charsPerToken))
+}
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetTokenizerForModel(t *testing.T) {
+	tests := []struct {
+		name    string
+		aiModel *AIModel
+		want    TokenizerStrategy
+	}{
+		{name: "no model", want: TokenizerStrategyCl100k},
+		{name: "o200k prefix", aiModel: &AIModel{ID: "m", ModelID: "gpt-4o-mini"}, want: TokenizerStrategyO200k},
+		{name: "case and vendor path", aiModel: &AIModel{ID: "m", ModelID: "openai/GPT-4.1-nano"}, want: TokenizerStrategyO200k},
+		{name: "first matching prefix wins", aiModel: &AIModel{ID: "m", ModelID: "gpt-4-turbo"}, want: TokenizerStrategyCl100k},
+		{name: "claude", aiModel: &AIModel{ID: "m", ModelID: "anthropic.claude-3-5-sonnet"}, want: TokenizerStrategyApproximateClaude},
+		{name: "llama", aiModel: &AIModel{ID: "m", ModelID: "meta-llama/Llama-3.1-8B"}, want: TokenizerStrategyApproximateWords},
+		{name: "gemini", aiModel: &AIModel{ID: "m", ModelID: "gemini-2.0-flash"}, want: TokenizerStrategyApproximateChars},
+		{name: "prefix only at the start", aiModel: &AIModel{ID: "m", ModelID: "my-gpt-4o"}, want: TokenizerStrategyCl100k},
+		{name: "unknown model", aiModel: &AIModel{ID: "m", ModelID: "command-r"}, want: TokenizerStrategyCl100k},
+		{name: "trailing slash", aiModel: &AIModel{ID: "m", ModelID: "gpt-4o/"}, want: TokenizerStrategyCl100k},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetTokenizerForModel(tt.aiModel).GetStrategy(); got != tt.want {
+				t.Fatalf("GetTokenizerForModel() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetModelTokenizerStrategy(t *testing.T) {
+	aiModel := &AIModel{ID: "tokenizer-test", ModelID: "gpt-4o"}
+	t.Cleanup(func() { RemoveModelTokenizerStrategy(aiModel.ID) })
+
+	if err := SetModelTokenizerStrategy(aiModel.ID, "unknown"); !errors.Is(err, ErrTokenizerNotRegistered) {
+		t.Fatalf("SetModelTokenizerStrategy() of an unknown strategy error = %v, want %v", err, ErrTokenizerNotRegistered)
+	}
+	if err := SetModelTokenizerStrategy(aiModel.ID, TokenizerStrategyApproximateWords); err != nil {
+		t.Fatalf("SetModelTokenizerStrategy() error = %v", err)
+	}
+	if got := GetTokenizerForModel(aiModel).GetStrategy(); got != TokenizerStrategyApproximateWords {
+		t.Errorf("GetTokenizerForModel() = %s, want the strategy set for the id to override the rules", got)
+	}
+	if got := GetTokenizerForModel(&AIModel{ID: "other", ModelID: aiModel.ModelID}).GetStrategy(); got != TokenizerStrategyO200k {
+		t.Errorf("GetTokenizerForModel() of another model = %s, want the rule %s", got, TokenizerStrategyO200k)
+	}
+	RemoveModelTokenizerStrategy(aiModel.ID)
+	if got := GetTokenizerForModel(aiModel).GetStrategy(); got != TokenizerStrategyO200k {
+		t.Errorf("GetTokenizerForModel() after RemoveModelTokenizerStrategy() = %s, want the rule %s", got, TokenizerStrategyO200k)
+	}
+}
+
+func TestApproximateTokenizers(t *testing.T) {
+	tests := []struct {
+		strategy TokenizerStrategy
+		text     string
+		want     int
+	}{
+		{strategy: TokenizerStrategyApproximateWords, text: "hello, world!", want: 5},
+		{strategy: TokenizerStrategyApproximateWords, text: "", want: 0},
+		{strategy: TokenizerStrategyApproximateChars, text: "abcdefghi", want: 3},
+		{strategy: TokenizerStrategyApproximateChars, text: "äöüß", want: 1},
+		{strategy: TokenizerStrategyApproximateClaude, text: "abcdefg", want: 2},
+		{strategy: TokenizerStrategyApproximateClaude, text: "abcdefgh", want: 3},
+	}
+	for _, tt := range tests {
+		tok, err := GetTokenizer(tt.strategy)
+		if err != nil {
+			t.Fatalf("GetTokenizer(%s) error = %v", tt.strategy, err)
+		}
+		if got := tok.CountTokens(tt.text); got != tt.want {
+			t.Errorf("%s CountTokens(%q) = %d, want %d", tt.strategy, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestEncodingTokenizerLoadsOnceConcurrently(t *testing.T) {
+	loads := atomic.Int32{}
+	tok := &encodingTokenizer{
+		strategy: "test-encoding",
+		load: func() (encode func(text string) int, err error) {
+			loads.Add(1)
+			time.Sleep(10 * time.Millisecond)
+			return func(text string) int { return len(strings.Fields(text)) }, nil
+		},
+		fallback: &approximateCharsTokenizer{strategy: TokenizerStrategyApproximateChars, charsPerToken: 1},
+	}
+	counts := make([]int, 50)
+	wg := sync.WaitGroup{}
+	for n := range counts {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			counts[n] = tok.CountTokens("one two three")
+		}(n)
+	}
+	wg.Wait()
+	if loads.Load() != 1 {
+		t.Errorf("loaded %d times, want once", loads.Load())
+	}
+	for n, count := range counts {
+		if count != 3 {
+			t.Fatalf("count (%d) = %d, want 3 from the loaded encoding", n, count)
+		}
+	}
+	if err := tok.Preload(); err != nil || loads.Load() != 1 {
+		t.Errorf("Preload() after counting = %v with %d loads, want no error and no second load", err, loads.Load())
+	}
+}
+
+func TestEncodingTokenizerFallsBackExplicitly(t *testing.T) {
+	loads := atomic.Int32{}
+	tok := &encodingTokenizer{
+		strategy: "test-encoding",
+		load: func() (encode func(text string) int, err error) {
+			loads.Add(1)
+			return nil, errors.New("no network")
+		},
+		fallback: &approximateCharsTokenizer{strategy: TokenizerStrategyApproximateChars, charsPerToken: 1},
+	}
+	err := tok.Preload()
+	if !errors.Is(err, ErrTokenizerEncodingNotLoaded) || !strings.Contains(err.Error(), string(TokenizerStrategyApproximateChars)) {
+		t.Fatalf("Preload() error = %v, want %v naming the fallback", err, ErrTokenizerEncodingNotLoaded)
+	}
+	if got := tok.CountTokens("abcd"); got != 4 || loads.Load() != 1 {
+		t.Errorf("CountTokens() = %d after %d loads, want 4 from the fallback and no second load", got, loads.Load())
+	}
+	if err = tok.Preload(); !errors.Is(err, ErrTokenizerEncodingNotLoaded) {
+		t.Errorf("second Preload() error = %v, want the load error kept", err)
+	}
+}
+
+func TestPreloadTokenizers(t *testing.T) {
+	failing := &encodingTokenizer{
+		strategy: "test-preload",
+		load: func() (encode func(text string) int, err error) {
+			return nil, errors.New("no network")
+		},
+		fallback: &approximateWordsTokenizer{},
+	}
+	RegisterTokenizer(failing)
+	t.Cleanup(func() {
+		tokenizersSafety.Lock()
+		delete(tokenizers, failing.strategy)
+		tokenizersSafety.Unlock()
+	})
+
+	err := PreloadTokenizers()
+	if !errors.Is(err, ErrTokenizerEncodingNotLoaded) || !strings.Contains(err.Error(), "(test-preload) counts with (approximate-words)") {
+		t.Fatalf("PreloadTokenizers() error = %v, want %v for test-preload", err, ErrTokenizerEncodingNotLoaded)
+	}
+	cl100k, _ := GetTokenizer(TokenizerStrategyCl100k)
+	if preloadErr := cl100k.(TokenizerPreloader).Preload(); preloadErr != nil {
+		t.Errorf("cl100k Preload() error = %v, want the compiled in vocabulary loaded", preloadErr)
+	}
+}
@@ -0,0 +1,315 @@
+package models
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	nuts "github.com/vaudience/go-nuts"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	USAGEREPORT_XLSX_SHEET_ENTRIES    = "Usage"
+	USAGEREPORT_XLSX_SHEET_AGGREGATES = "Summary"
+)
+
+var (
+	ErrUnsupportedUsageExportFormat = errors.New("unsupported usage export format")
+)
+
+type UsageExportFormat string //@name UsageExportFormat
+
+const (
+	UsageExportFormatCSV  UsageExportFormat = "csv"
+	UsageExportFormatJSON UsageExportFormat = "json"
+	UsageExportFormatXLSX UsageExportFormat = "xlsx"
+)
+
+// UsageReport is the export of the ledger entries matching a filter together with their aggregates, for invoices and customer reports
+type UsageReport struct {
+	Filter     UsageLedgerFilter    `json:"filter"`
+	GroupBy    []UsageLedgerGroupBy `json:"group_by"`
+	Entries    []UsageLedgerEntry   `json:"entries"`
+	Aggregates []UsageAggregate     `json:"aggregates"`
+	CreatedAt  int64                `json:"created_at"`
+	// the role the report is exported for, source costs and margins are only exported for roles that may read UsageLedgerEntry.SourceCost
+	Role string `json:"role,omitempty"`
+} //@name UsageReport
+
+// usageEntryExport and usageAggregateExport add the source cost and margin to the json export for admins
+type usageEntryExport struct {
+	UsageLedgerEntry
+	SourceCost Money `json:"source_cost"`
+	Margin     Money `json:"margin"`
+}
+
+type usageAggregateExport struct {
+	UsageAggregate
+	SourceCost Money `json:"source_cost"`
+	Margin     Money `json:"margin"`
+}
+
+// NewUsageReport queries the ledger and aggregates the entries by groupBy
+func NewUsageReport(ctx context.Context, ledger UsageLedger, filter UsageLedgerFilter, groupBy ...UsageLedgerGroupBy) (report *UsageReport, err error) {
+	entries, err := ledger.Query(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	aggregates, err := AggregateUsageLedgerEntries(entries, groupBy...)
+	if err != nil {
+		return nil, err
+	}
+	return &UsageReport{
+		Filter:     filter,
+		GroupBy:    groupBy,
+		Entries:    entries,
+		Aggregates: aggregates,
+		CreatedAt:  nuts.TimeToJSTimestamp(time.Now()),
+	}, nil
+}
+
+// Export writes the report in format. csv has a single table, so it contains the entries only, see ExportAggregatesCSV.
+func (report *UsageReport) Export(w io.Writer, format UsageExportFormat) (err error) {
+	switch format {
+	case UsageExportFormatCSV:
+		return report.ExportEntriesCSV(w)
+	case UsageExportFormatJSON:
+		return report.ExportJSON(w)
+	case UsageExportFormatXLSX:
+		return report.ExportXLSX(w)
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupportedUsageExportFormat, format)
+}
+
+func (report *UsageReport) ExportJSON(w io.Writer) (err error) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if !report.includesSourceCost() {
+		return encoder.Encode(report)
+	}
+	entries := make([]usageEntryExport, 0, len(report.Entries))
+	for _, entry := range report.Entries {
+		entries = append(entries, usageEntryExport{UsageLedgerEntry: entry, SourceCost: entry.SourceCost, Margin: entry.Cost - entry.SourceCost})
+	}
+	aggregates := make([]usageAggregateExport, 0, len(report.Aggregates))
+	for _, aggregate := range report.Aggregates {
+		aggregates = append(aggregates, usageAggregateExport{UsageAggregate: aggregate, SourceCost: aggregate.SourceCost, Margin: aggregate.Cost - aggregate.SourceCost})
+	}
+	return encoder.Encode(struct {
+		*UsageReport
+		Entries    []usageEntryExport     `json:"entries"`
+		Aggregates []usageAggregateExport `json:"aggregates"`
+	}{report, entries, aggregates})
+}
+
+// includesSourceCost tells if the report role may read the source costs of the ledger
+func (report *UsageReport) includesSourceCost() bool {
+	sourceCostField, _ := reflect.TypeOf(UsageLedgerEntry{}).FieldByName("SourceCost")
+	return CanRead(sourceCostField.Tag.Get(READXS_TAG), report.Role)
+}
+
+func (report *UsageReport) ExportEntriesCSV(w io.Writer) (err error) {
+	return writeUsageCSV(w, report.getEntryHeader(), report.getEntryRows())
+}
+
+func (report *UsageReport) ExportAggregatesCSV(w io.Writer) (err error) {
+	return writeUsageCSV(w, report.getAggregateHeader(), report.getAggregateRows())
+}
+
+// ExportXLSX writes a workbook with the entries and the aggregates on separate sheets
+func (report *UsageReport) ExportXLSX(w io.Writer) (err error) {
+	file := excelize.NewFile()
+	defer file.Close()
+	err = file.SetSheetName(file.GetSheetName(0), USAGEREPORT_XLSX_SHEET_ENTRIES)
+	if err != nil {
+		return err
+	}
+	_, err = file.NewSheet(USAGEREPORT_XLSX_SHEET_AGGREGATES)
+	if err != nil {
+		return err
+	}
+	err = writeUsageXLSXSheet(file, USAGEREPORT_XLSX_SHEET_ENTRIES, report.getEntryHeader(), report.getEntryRows())
+	if err != nil {
+		return err
+	}
+	err = writeUsageXLSXSheet(file, USAGEREPORT_XLSX_SHEET_AGGREGATES, report.getAggregateHeader(), report.getAggregateRows())
+	if err != nil {
+		return err
+	}
+	return file.Write(w)
+}
+
+var usageEntryHeader = []string{"id", "timestamp", "org_id", "user_id", "agent_id", "model_id", "service_id", "mission_id", "run_id", "execution_id", "capability", "cost_unit", "used_units", "currency", "cost"}
+
+// getEntryHeader is usageEntryHeader, followed by the source cost and margin for admins
+func (report *UsageReport) getEntryHeader() []string {
+	header := append([]string{}, usageEntryHeader...)
+	if report.includesSourceCost() {
+		header = append(header, "source_cost", "margin")
+	}
+	return header
+}
+
+// rows hold strings, float64 and Money, so the xlsx export can write numbers
+func (report *UsageReport) getEntryRows() (rows [][]any) {
+	includeSourceCost := report.includesSourceCost()
+	rows = make([][]any, 0, len(report.Entries))
+	for _, entry := range report.Entries {
+		row := []any{
+			entry.ID,
+			time.UnixMilli(entry.Timestamp).UTC().Format(time.RFC3339),
+			entry.OrgID,
+			entry.UserID,
+			entry.AgentID,
+			entry.ModelID,
+			entry.ServiceID,
+			entry.MissionID,
+			entry.RunID,
+			entry.ExecutionID,
+			string(entry.Capability),
+			string(entry.CostUnit),
+			entry.UsedUnits,
+			string(entry.Currency),
+			entry.Cost,
+		}
+		if includeSourceCost {
+			row = append(row, entry.SourceCost, entry.Cost-entry.SourceCost)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// getAggregateHeader has the grouped fields followed by the sums and one column per used cost unit
+func (report *UsageReport) getAggregateHeader() []string {
+	header := make([]string, 0)
+	for _, group := range report.GroupBy {
+		header = append(header, string(group))
+	}
+	header = append(header, "currency", "cost")
+	if report.includesSourceCost() {
+		header = append(header, "source_cost", "margin")
+	}
+	header = append(header, "executions", "entries")
+	for _, costUnit := range report.getAggregateCostUnits() {
+		header = append(header, string(costUnit))
+	}
+	return header
+}
+
+func (report *UsageReport) getAggregateRows() (rows [][]any) {
+	includeSourceCost := report.includesSourceCost()
+	costUnits := report.getAggregateCostUnits()
+	rows = make([][]any, 0, len(report.Aggregates))
+	for _, aggregate := range report.Aggregates {
+		row := make([]any, 0)
+		for _, group := range report.GroupBy {
+			switch group {
+			case UsageLedgerGroupByDay:
+				row = append(row, aggregate.Day)
+			case UsageLedgerGroupByModel:
+				row = append(row, aggregate.ModelID)
+			case UsageLedgerGroupByAgent:
+				row = append(row, aggregate.AgentID)
+			case UsageLedgerGroupByUser:
+				row = append(row, aggregate.UserID)
+			case UsageLedgerGroupByMission:
+				row = append(row, aggregate.MissionID)
+			}
+		}
+		row = append(row, string(aggregate.Currency), aggregate.Cost)
+		if includeSourceCost {
+			row = append(row, aggregate.SourceCost, aggregate.Cost-aggregate.SourceCost)
+		}
+		row = append(row, float64(aggregate.Executions), float64(aggregate.Entries))
+		for _, costUnit := range costUnits {
+			row = append(row, aggregate.UsedUnits[costUnit])
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func (report *UsageReport) getAggregateCostUnits() (costUnits []AIModelCostUnit) {
+	known := make(map[AIModelCostUnit]bool)
+	for _, aggregate := range report.Aggregates {
+		for costUnit := range aggregate.UsedUnits {
+			if !known[costUnit] {
+				known[costUnit] = true
+				costUnits = append(costUnits, costUnit)
+			}
+		}
+	}
+	sort.Slice(costUnits, func(i, j int) bool {
+		return costUnits[i] < costUnits[j]
+	})
+	return costUnits
+}
+
+func writeUsageCSV(w io.Writer, header []string, rows [][]any) (err error) {
+	writer := csv.NewWriter(w)
+	err = writer.Write(header)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := make([]string, len(row))
+		for n, value := range row {
+			switch typed := value.(type) {
+			case string:
+				record[n] = typed
+			case float64:
+				record[n] = strconv.FormatFloat(typed, 'f', -1, 64)
+			case Money:
+				record[n] = typed.String()
+			}
+		}
+		err = writer.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeUsageXLSXSheet(file *excelize.File, sheet string, header []string, rows [][]any) (err error) {
+	writer, err := file.NewStreamWriter(sheet)
+	if err != nil {
+		return err
+	}
+	headerRow := make([]any, len(header))
+	for n, title := range header {
+		headerRow[n] = title
+	}
+	err = writer.SetRow("A1", headerRow)
+	if err != nil {
+		return err
+	}
+	for rowIndex, row := range rows {
+		cells := make([]any, len(row))
+		for n, value := range row {
+			if money, isMoney := value.(Money); isMoney {
+				value = money.Float64()
+			}
+			cells[n] = value
+		}
+		cell, err := excelize.CoordinatesToCellName(1, rowIndex+2)
+		if err != nil {
+			return err
+		}
+		err = writer.SetRow(cell, cells)
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
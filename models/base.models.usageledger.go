@@ -0,0 +1,329 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	nuts "github.com/vaudience/go-nuts"
+)
+
+const (
+	IDPREFIX_USAGELEDGERENTRY = "usg"
+	IDLENGTH_USAGELEDGERENTRY = 16
+
+	USAGELEDGER_DAY_FORMAT = "2006-01-02"
+)
+
+var (
+	ErrInvalidUsageLedgerEntry    = errors.New("invalid usage ledger entry")
+	ErrUsageLedgerEntryExists     = errors.New("usage ledger entry already exists, entries are append only")
+	ErrInvalidUsageLedgerFilter   = errors.New("invalid usage ledger filter, an org id is required")
+	ErrUnknownUsageLedgerGroupBy  = errors.New("unknown usage ledger grouping")
+	ErrNoExecutionResultForLedger = errors.New("no execution result to record")
+	ErrNoMissionResultsForLedger  = errors.New("no mission results to record")
+)
+
+// UsageLedgerKeys identify who and what caused a usage, all entries of one execution share them
+type UsageLedgerKeys struct {
+	OrgID       string `json:"org_id" validate:"required,min=1,max=64"`
+	UserID      string `json:"user_id" validate:"omitempty,max=64"`
+	AgentID     string `json:"agent_id" validate:"omitempty,max=64"`
+	ModelID     string `json:"model_id" validate:"omitempty,max=64"`
+	ServiceID   string `json:"service_id" validate:"omitempty,max=64"`
+	MissionID   string `json:"mission_id" validate:"omitempty,max=64"`
+	RunID       string `json:"run_id" validate:"omitempty,max=64"`
+	ExecutionID string `json:"execution_id" validate:"omitempty,max=128"`
+} //@name UsageLedgerKeys
+
+// UsageLedgerEntry is one billed cost item of an execution, it is never changed once appended
+type UsageLedgerEntry struct {
+	ID string `json:"id" validate:"required,min=1,max=64" writexs:"system" readxs:"admin,owner,org-admin"`
+	UsageLedgerKeys
+	Capability AIModelCapability `json:"capability" validate:"required" writexs:"system" readxs:"admin,owner,org-admin"`
+	CostUnit   AIModelCostUnit   `json:"cost_unit" validate:"required" writexs:"system" readxs:"admin,owner,org-admin"`
+	UsedUnits  float64           `json:"used_units" writexs:"system" readxs:"admin,owner,org-admin"` // in priced units, e.g. million tokens
+	Currency   Currency          `json:"currency" validate:"required,oneof=EUR USD GBP CHF" writexs:"system" readxs:"admin,owner,org-admin"`
+	Cost       Money             `json:"cost" writexs:"system" readxs:"admin,owner,org-admin"`
+	SourceCost Money             `json:"-" readxsjson:"source_cost" writexs:"system" readxs:"admin"`                    // without multiplier, only exported for admins
+	Timestamp  int64             `json:"timestamp" validate:"required" writexs:"system" readxs:"admin,owner,org-admin"` // of the execution, in ms
+	CreatedAt  int64             `json:"created_at" writexs:"system" readxs:"admin,owner,org-admin"`
+} //@name UsageLedgerEntry
+
+// UsageLedgerFilter selects the entries of one org, empty fields match everything. From is inclusive, To exclusive (ms).
+type UsageLedgerFilter struct {
+	OrgID     string `json:"org_id" validate:"required,min=1,max=64"`
+	UserID    string `json:"user_id"`
+	AgentID   string `json:"agent_id"`
+	ModelID   string `json:"model_id"`
+	MissionID string `json:"mission_id"`
+	RunID     string `json:"run_id"`
+	From      int64  `json:"from"`
+	To        int64  `json:"to"`
+} //@name UsageLedgerFilter
+
+type UsageLedgerGroupBy string //@name UsageLedgerGroupBy
+
+const (
+	UsageLedgerGroupByDay     UsageLedgerGroupBy = "day"
+	UsageLedgerGroupByModel   UsageLedgerGroupBy = "model"
+	UsageLedgerGroupByAgent   UsageLedgerGroupBy = "agent"
+	UsageLedgerGroupByUser    UsageLedgerGroupBy = "user"
+	UsageLedgerGroupByMission UsageLedgerGroupBy = "mission"
+)
+
+var UsageLedgerGroupBys = []UsageLedgerGroupBy{UsageLedgerGroupByDay, UsageLedgerGroupByModel, UsageLedgerGroupByAgent, UsageLedgerGroupByUser, UsageLedgerGroupByMission}
+
+// UsageAggregate sums up the entries of one group, fields that are not grouped by stay empty.
+// costs are never summed across currencies, the currency is always part of the group.
+type UsageAggregate struct {
+	Day        string                      `json:"day,omitempty"` // UTC, USAGELEDGER_DAY_FORMAT
+	ModelID    string                      `json:"model_id,omitempty"`
+	AgentID    string                      `json:"agent_id,omitempty"`
+	UserID     string                      `json:"user_id,omitempty"`
+	MissionID  string                      `json:"mission_id,omitempty"`
+	Currency   Currency                    `json:"currency"`
+	Cost       Money                       `json:"cost"`
+	SourceCost Money                       `json:"-" readxsjson:"source_cost" readxs:"admin"`
+	UsedUnits  map[AIModelCostUnit]float64 `json:"used_units"`
+	Executions int                         `json:"executions"`
+	Entries    int                         `json:"entries"`
+} //@name UsageAggregate
+
+// UsageLedger records the costs of all executions for billing and reports. it is append only, corrections are booked as new entries.
+type UsageLedger interface {
+	Append(ctx context.Context, entries ...UsageLedgerEntry) (err error)
+	// Query returns the matching entries ordered by timestamp
+	Query(ctx context.Context, filter UsageLedgerFilter) (entries []UsageLedgerEntry, err error)
+	Aggregate(ctx context.Context, filter UsageLedgerFilter, groupBy ...UsageLedgerGroupBy) (aggregates []UsageAggregate, err error)
+}
+
+func CreateUsageLedgerEntryID() string {
+	return nuts.NID(IDPREFIX_USAGELEDGERENTRY, IDLENGTH_USAGELEDGERENTRY)
+}
+
+func IsUsageLedgerEntryID(id string) (isEntryID bool) {
+	isEntryID = (len(id) == IDLENGTH_USAGELEDGERENTRY+len(IDPREFIX_USAGELEDGERENTRY)+1) && strings.HasPrefix(id, IDPREFIX_USAGELEDGERENTRY)
+	return isEntryID
+}
+
+func NewUsageLedgerEntry(keys UsageLedgerKeys, capability AIModelCapability, costItem ExecutionUsageCost, timestamp int64) UsageLedgerEntry {
+	cost, sourceCost, currency := costItem.GetBilledCost()
+	return UsageLedgerEntry{
+		ID:              CreateUsageLedgerEntryID(),
+		UsageLedgerKeys: keys,
+		Capability:      capability,
+		CostUnit:        costItem.CostUnit,
+		UsedUnits:       costItem.UsedUnits,
+		Currency:        currency,
+		Cost:            cost,
+		SourceCost:      sourceCost,
+		Timestamp:       timestamp,
+		CreatedAt:       nuts.TimeToJSTimestamp(time.Now()),
+	}
+}
+
+// NewUsageLedgerEntries creates one entry per cost item of the used features
+func NewUsageLedgerEntries(keys UsageLedgerKeys, featuresUsed []AIModelFeature, timestamp int64) (entries []UsageLedgerEntry) {
+	entries = make([]UsageLedgerEntry, 0)
+	if timestamp == 0 {
+		timestamp = nuts.TimeToJSTimestamp(time.Now())
+	}
+	for _, feature := range featuresUsed {
+		for _, costItem := range feature.CostItems {
+			entries = append(entries, NewUsageLedgerEntry(keys, feature.Capability, costItem, timestamp))
+		}
+	}
+	return entries
+}
+
+// NewUsageLedgerEntriesFromExecutionResult takes model, service and execution from the result, the other keys from keys
+func NewUsageLedgerEntriesFromExecutionResult(keys UsageLedgerKeys, result *ExecutionResult) (entries []UsageLedgerEntry, err error) {
+	if result == nil {
+		return nil, ErrNoExecutionResultForLedger
+	}
+	keys.ModelID = result.ModelID
+	keys.ServiceID = result.ServiceID
+	keys.ExecutionID = result.ExecutionID
+	return NewUsageLedgerEntries(keys, result.FeaturesUsed, result.Timestamp), nil
+}
+
+// NewUsageLedgerEntriesFromMissionResults takes mission and execution from the results, the other keys from keys
+func NewUsageLedgerEntriesFromMissionResults(keys UsageLedgerKeys, results *MissionResultsDto) (entries []UsageLedgerEntry, err error) {
+	if results == nil {
+		return nil, ErrNoMissionResultsForLedger
+	}
+	keys.MissionID = results.MissionID
+	keys.ExecutionID = results.ExecutionID
+	return NewUsageLedgerEntries(keys, results.FeaturesUsed, results.Timestamp), nil
+}
+
+func ValidateUsageLedgerEntry(entry *UsageLedgerEntry) error {
+	validate := validator.New()
+	err := validate.Struct(entry)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidUsageLedgerEntry, err)
+	}
+	return nil
+}
+
+// GetDay returns the UTC day of the entry, see USAGELEDGER_DAY_FORMAT
+func (entry *UsageLedgerEntry) GetDay() string {
+	return time.UnixMilli(entry.Timestamp).UTC().Format(USAGELEDGER_DAY_FORMAT)
+}
+
+func (filter *UsageLedgerFilter) Validate() error {
+	if filter.OrgID == "" {
+		return ErrInvalidUsageLedgerFilter
+	}
+	return nil
+}
+
+func (filter *UsageLedgerFilter) Matches(entry *UsageLedgerEntry) bool {
+	return entry.OrgID == filter.OrgID &&
+		(filter.UserID == "" || entry.UserID == filter.UserID) &&
+		(filter.AgentID == "" || entry.AgentID == filter.AgentID) &&
+		(filter.ModelID == "" || entry.ModelID == filter.ModelID) &&
+		(filter.MissionID == "" || entry.MissionID == filter.MissionID) &&
+		(filter.RunID == "" || entry.RunID == filter.RunID) &&
+		(filter.From == 0 || entry.Timestamp >= filter.From) &&
+		(filter.To == 0 || entry.Timestamp < filter.To)
+}
+
+func (groupBy UsageLedgerGroupBy) IsValid() bool {
+	for _, known := range UsageLedgerGroupBys {
+		if groupBy == known {
+			return true
+		}
+	}
+	return false
+}
+
+// AggregateUsageLedgerEntries groups the entries by the given fields and their currency, sorted by the group fields
+func AggregateUsageLedgerEntries(entries []UsageLedgerEntry, groupBy ...UsageLedgerGroupBy) (aggregates []UsageAggregate, err error) {
+	for _, group := range groupBy {
+		if !group.IsValid() {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownUsageLedgerGroupBy, group)
+		}
+	}
+	groups := make(map[string]*UsageAggregate)
+	executions := make(map[string]map[string]bool)
+	for n := range entries {
+		entry := &entries[n]
+		aggregate := UsageAggregate{Currency: entry.Currency}
+		for _, group := range groupBy {
+			switch group {
+			case UsageLedgerGroupByDay:
+				aggregate.Day = entry.GetDay()
+			case UsageLedgerGroupByModel:
+				aggregate.ModelID = entry.ModelID
+			case UsageLedgerGroupByAgent:
+				aggregate.AgentID = entry.AgentID
+			case UsageLedgerGroupByUser:
+				aggregate.UserID = entry.UserID
+			case UsageLedgerGroupByMission:
+				aggregate.MissionID = entry.MissionID
+			}
+		}
+		key := aggregate.key()
+		if groups[key] == nil {
+			aggregate.UsedUnits = make(map[AIModelCostUnit]float64)
+			groups[key] = &aggregate
+			executions[key] = make(map[string]bool)
+		}
+		stored := groups[key]
+		stored.Cost += entry.Cost
+		stored.SourceCost += entry.SourceCost
+		stored.UsedUnits[entry.CostUnit] += entry.UsedUnits
+		stored.Entries++
+		executionKey := entry.ExecutionID
+		if executionKey == "" {
+			executionKey = entry.ID
+		}
+		executions[key][executionKey] = true
+	}
+	aggregates = make([]UsageAggregate, 0, len(groups))
+	for key, aggregate := range groups {
+		aggregate.Executions = len(executions[key])
+		aggregates = append(aggregates, *aggregate)
+	}
+	sort.Slice(aggregates, func(i, j int) bool {
+		return aggregates[i].key() < aggregates[j].key()
+	})
+	return aggregates, nil
+}
+
+func (aggregate *UsageAggregate) key() string {
+	return strings.Join([]string{aggregate.Day, aggregate.ModelID, aggregate.AgentID, aggregate.UserID, aggregate.MissionID, string(aggregate.Currency)}, "\x00")
+}
+
+// -- in-memory ledger, for tests and single instance setups --
+
+type MemoryUsageLedger struct {
+	entries []UsageLedgerEntry
+	ids     map[string]bool
+	safety  sync.RWMutex
+}
+
+func NewMemoryUsageLedger() *MemoryUsageLedger {
+	return &MemoryUsageLedger{
+		entries: make([]UsageLedgerEntry, 0),
+		ids:     make(map[string]bool),
+	}
+}
+
+// Append stores all entries or none of them
+func (ledger *MemoryUsageLedger) Append(ctx context.Context, entries ...UsageLedgerEntry) (err error) {
+	for n := range entries {
+		err = ValidateUsageLedgerEntry(&entries[n])
+		if err != nil {
+			return err
+		}
+	}
+	ledger.safety.Lock()
+	defer ledger.safety.Unlock()
+	appended := make(map[string]bool)
+	for _, entry := range entries {
+		if ledger.ids[entry.ID] || appended[entry.ID] {
+			return fmt.Errorf("%w: %s", ErrUsageLedgerEntryExists, entry.ID)
+		}
+		appended[entry.ID] = true
+	}
+	for _, entry := range entries {
+		ledger.ids[entry.ID] = true
+		ledger.entries = append(ledger.entries, entry)
+	}
+	return nil
+}
+
+func (ledger *MemoryUsageLedger) Query(ctx context.Context, filter UsageLedgerFilter) (entries []UsageLedgerEntry, err error) {
+	err = filter.Validate()
+	if err != nil {
+		return nil, err
+	}
+	ledger.safety.RLock()
+	entries = make([]UsageLedgerEntry, 0)
+	for n := range ledger.entries {
+		if filter.Matches(&ledger.entries[n]) {
+			entries = append(entries, ledger.entries[n])
+		}
+	}
+	ledger.safety.RUnlock()
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp < entries[j].Timestamp
+	})
+	return entries, nil
+}
+
+func (ledger *MemoryUsageLedger) Aggregate(ctx context.Context, filter UsageLedgerFilter, groupBy ...UsageLedgerGroupBy) (aggregates []UsageAggregate, err error) {
+	entries, err := ledger.Query(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return AggregateUsageLedgerEntries(entries, groupBy...)
+}
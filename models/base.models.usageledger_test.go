@@ -0,0 +1,141 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newTestUsageLedgerEntry(id string) UsageLedgerEntry {
+	return UsageLedgerEntry{
+		ID:              id,
+		UsageLedgerKeys: UsageLedgerKeys{OrgID: "org1"},
+		Capability:      AIModelCapabilityTextToText,
+		CostUnit:        AIModelCostUnitInputPerMillionTokens,
+		Currency:        CurrencyEUR,
+		Cost:            MONEY_UNITS_PER_EURO,
+		Timestamp:       1,
+	}
+}
+
+func TestMemoryUsageLedgerAppendRejectsRepeatedIDs(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		stored  []string
+		append  []string
+		wantErr error
+	}{
+		{name: "new ids", append: []string{"e1", "e2"}},
+		{name: "id already stored", stored: []string{"e1"}, append: []string{"e2", "e1"}, wantErr: ErrUsageLedgerEntryExists},
+		{name: "id twice in one call", append: []string{"e1", "e2", "e1"}, wantErr: ErrUsageLedgerEntryExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ledger := NewMemoryUsageLedger()
+			for _, id := range tt.stored {
+				if err := ledger.Append(ctx, newTestUsageLedgerEntry(id)); err != nil {
+					t.Fatalf("Append(%s) error = %v", id, err)
+				}
+			}
+			entries := make([]UsageLedgerEntry, 0)
+			for _, id := range tt.append {
+				entries = append(entries, newTestUsageLedgerEntry(id))
+			}
+			err := ledger.Append(ctx, entries...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Append() error = %v, want %v", err, tt.wantErr)
+			}
+			stored, _ := ledger.Query(ctx, UsageLedgerFilter{OrgID: "org1"})
+			wantStored := len(tt.stored)
+			if tt.wantErr == nil {
+				wantStored += len(tt.append)
+			}
+			if len(stored) != wantStored {
+				t.Fatalf("stored %d entries, want %d, a failing append stores none", len(stored), wantStored)
+			}
+		})
+	}
+}
+
+func TestUsageReportExportsSourceCostForAdminsOnly(t *testing.T) {
+	entry := newTestUsageLedgerEntry("e1")
+	entry.SourceCost = MONEY_UNITS_PER_EURO / 4
+	report := &UsageReport{
+		Filter:     UsageLedgerFilter{OrgID: "org1"},
+		Entries:    []UsageLedgerEntry{entry},
+		Aggregates: []UsageAggregate{{Currency: CurrencyEUR, Cost: entry.Cost, SourceCost: entry.SourceCost, UsedUnits: map[AIModelCostUnit]float64{}, Executions: 1, Entries: 1}},
+	}
+	tests := []struct {
+		role           string
+		wantSourceCost bool
+	}{
+		{role: READXS_ROLE_ADMIN, wantSourceCost: true},
+		{role: READXS_ROLE_ORG_ADMIN},
+		{role: READXS_ROLE_OWNER},
+		{role: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			report.Role = tt.role
+
+			var entriesCSV, aggregatesCSV bytes.Buffer
+			if err := report.ExportEntriesCSV(&entriesCSV); err != nil {
+				t.Fatalf("ExportEntriesCSV() error = %v", err)
+			}
+			if err := report.ExportAggregatesCSV(&aggregatesCSV); err != nil {
+				t.Fatalf("ExportAggregatesCSV() error = %v", err)
+			}
+			for name, export := range map[string]*bytes.Buffer{"entries": &entriesCSV, "aggregates": &aggregatesCSV} {
+				records, err := csv.NewReader(export).ReadAll()
+				if err != nil {
+					t.Fatalf("reading the %s csv: %v", name, err)
+				}
+				columns := make(map[string]string)
+				for n, title := range records[0] {
+					columns[title] = records[1][n]
+				}
+				sourceCost, hasSourceCost := columns["source_cost"]
+				if hasSourceCost != tt.wantSourceCost {
+					t.Fatalf("%s csv has a source_cost column: %v, want %v", name, hasSourceCost, tt.wantSourceCost)
+				}
+				if hasSourceCost && (sourceCost != "0.25" || columns["margin"] != "0.75") {
+					t.Errorf("%s csv source cost %s and margin %s, want 0.25 and 0.75", name, sourceCost, columns["margin"])
+				}
+			}
+
+			var exported bytes.Buffer
+			if err := report.ExportJSON(&exported); err != nil {
+				t.Fatalf("ExportJSON() error = %v", err)
+			}
+			var decoded struct {
+				Entries    []map[string]any `json:"entries"`
+				Aggregates []map[string]any `json:"aggregates"`
+			}
+			if err := json.Unmarshal(exported.Bytes(), &decoded); err != nil {
+				t.Fatalf("decoding the json export: %v", err)
+			}
+			for name, exported := range map[string]map[string]any{"entry": decoded.Entries[0], "aggregate": decoded.Aggregates[0]} {
+				if _, hasSourceCost := exported["source_cost"]; hasSourceCost != tt.wantSourceCost {
+					t.Errorf("json %s has source_cost: %v, want %v", name, hasSourceCost, tt.wantSourceCost)
+				}
+				if exported["cost"] != float64(1) {
+					t.Errorf("json %s cost = %v, want 1", name, exported["cost"])
+				}
+			}
+
+			filtered := FilterReadableFields(entry, tt.role).(map[string]any)
+			if _, hasSourceCost := filtered["source_cost"]; hasSourceCost != tt.wantSourceCost {
+				t.Errorf("filtered entry has source_cost: %v, want %v", hasSourceCost, tt.wantSourceCost)
+			}
+		})
+	}
+
+	if data, _ := json.Marshal(entry); strings.Contains(string(data), "source_cost") {
+		t.Errorf("json.Marshal() of an entry = %s, want the source cost left out", data)
+	}
+}
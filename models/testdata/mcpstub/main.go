@@ -0,0 +1,163 @@
+package main
+
+// mcpstub is a minimal MCP server for the tests of MCPStdioClient and MCPBridge.
+// it speaks newline delimited JSON-RPC 2.0 on stdio and serves two pages of tools:
+//
+//	echo: returns the text argument (or the arguments as json) as text content
+//	fail: returns isError with the reason argument
+//	image: returns a 1x1 png as image content
+//	ping: pings the client with a string id first and returns its answer as text
+//	string_id: answers with the id of the request as a string
+//	exit: answers and exits right away
+//
+// any other tool or method is answered with a JSON-RPC error
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const onePixelPNG = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAQAAAC1HAwCAAAAC0lEQVR42mNkYAAAAAYAAjCB0C8AAAAASUVORK5CYII="
+
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+var pages = map[string]map[string]any{
+	"": {
+		"tools": []map[string]any{
+			{
+				"name":        "echo",
+				"description": "echoes the text",
+				"inputSchema": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"text":  map[string]any{"type": "string", "description": "the text to echo"},
+						"times": map[string]any{"type": []any{"integer", "null"}, "default": 1},
+						"mode":  map[string]any{"type": "string", "enum": []any{"plain", "upper"}},
+					},
+					"required": []any{"text"},
+				},
+			},
+		},
+		"nextCursor": "page-2",
+	},
+	"page-2": {
+		"tools": []map[string]any{
+			{
+				"name":        "fail",
+				"description": "always fails",
+				"inputSchema": map[string]any{"type": "object", "properties": map[string]any{"reason": map[string]any{"type": "string"}}},
+			},
+			{
+				"name":        "image",
+				"description": "returns an image",
+				"inputSchema": map[string]any{"type": "object"},
+			},
+		},
+	},
+}
+
+var (
+	scanner = bufio.NewScanner(os.Stdin)
+	encoder = json.NewEncoder(os.Stdout)
+)
+
+func main() {
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var request rpcMessage
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			fmt.Fprintf(os.Stderr, "mcpstub: invalid message: %v\n", err)
+			continue
+		}
+		if len(request.ID) == 0 {
+			// notifications need no answer
+			continue
+		}
+		response := rpcMessage{JSONRPC: "2.0", ID: request.ID}
+		response.Result, response.Error = handle(request)
+		tool := ""
+		if request.Method == "tools/call" {
+			params := map[string]any{}
+			json.Unmarshal(request.Params, &params)
+			tool, _ = params["name"].(string)
+		}
+		if tool == "string_id" {
+			response.ID, _ = json.Marshal(string(request.ID))
+		}
+		if err := encoder.Encode(response); err != nil {
+			fmt.Fprintf(os.Stderr, "mcpstub: write failed: %v\n", err)
+			return
+		}
+		if tool == "exit" {
+			os.Exit(0)
+		}
+	}
+}
+
+func handle(request rpcMessage) (result any, rpcErr *rpcError) {
+	params := map[string]any{}
+	if len(request.Params) > 0 {
+		if err := json.Unmarshal(request.Params, &params); err != nil {
+			return nil, &rpcError{Code: -32602, Message: err.Error()}
+		}
+	}
+	switch request.Method {
+	case "initialize":
+		return map[string]any{
+			"protocolVersion": params["protocolVersion"],
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]any{"name": "mcpstub", "version": "1.2.3"},
+		}, nil
+	case "tools/list":
+		cursor, _ := params["cursor"].(string)
+		page, ok := pages[cursor]
+		if !ok {
+			return nil, &rpcError{Code: -32602, Message: "unknown cursor: " + cursor}
+		}
+		return page, nil
+	case "tools/call":
+		arguments, _ := params["arguments"].(map[string]any)
+		switch params["name"] {
+		case "echo":
+			text, ok := arguments["text"].(string)
+			if !ok {
+				argumentBytes, _ := json.Marshal(arguments)
+				text = string(argumentBytes)
+			}
+			return map[string]any{"content": []map[string]any{{"type": "text", "text": text}}}, nil
+		case "fail":
+			return map[string]any{"content": []map[string]any{{"type": "text", "text": fmt.Sprint(arguments["reason"])}}, "isError": true}, nil
+		case "image":
+			return map[string]any{"content": []map[string]any{{"type": "image", "data": onePixelPNG, "mimeType": "image/png"}}}, nil
+		case "ping":
+			return map[string]any{"content": []map[string]any{{"type": "text", "text": pingClient()}}}, nil
+		case "string_id", "exit":
+			return map[string]any{"content": []map[string]any{{"type": "text", "text": fmt.Sprint(params["name"])}}}, nil
+		}
+		return nil, &rpcError{Code: -32602, Message: fmt.Sprintf("unknown tool: %v", params["name"])}
+	}
+	return nil, &rpcError{Code: -32601, Message: "method not found: " + request.Method}
+}
+
+// pingClient sends a ping with a string id and returns the answer of the client
+func pingClient() string {
+	encoder.Encode(rpcMessage{JSONRPC: "2.0", ID: json.RawMessage(`"server-ping-1"`), Method: "ping"})
+	if !scanner.Scan() {
+		return "no answer"
+	}
+	return scanner.Text()
+}